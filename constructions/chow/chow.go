@@ -0,0 +1,89 @@
+// Package chow implements a table-based white-box AES-128 in the style of Chow et al., to give white-box attacks a
+// target with a known key.
+//
+// Each of the first nine rounds is split into per-byte tables that merge the T-box (SubBytes with the round key added
+// before it) with one column of MixColumns and a random 32-by-32 mixing bijection, followed by tables that undo the
+// mixing bijection and apply a random 8-by-8 mixing bijection to each byte of the next round's input. Everything that
+// passes between tables is encoded with random nibble bijections, which XOR tables decode, combine, and re-encode. The
+// final round is a single table per byte.
+//
+// "White-Box Cryptography and an AES Implementation" by Stanley Chow, Philip Eisen, Harold Johnson, and Paul C. Van
+// Oorschot, http://link.springer.com/chapter/10.1007%2F3-540-36492-7_17
+package chow
+
+// wordTable maps a byte to a 32-bit word.
+type wordTable [256][4]byte
+
+// xorTable maps two nibbles, packed into a byte with the first one high, to one nibble.
+type xorTable [256]byte
+
+// Construction is a white-box AES-128 encryption. It can't decrypt.
+type Construction struct {
+	TyiTable       [9][16]wordTable   // T-boxes, MixColumns and the mixing bijection, indexed by position after ShiftRows.
+	HighXORTable   [9][32][3]xorTable // XOR tables combining TyiTable's outputs, indexed by nibble of the state.
+	MBInverseTable [9][16]wordTable   // Inverse of the mixing bijection and the next round's byte mixing bijections.
+	LowXORTable    [9][32][3]xorTable // XOR tables combining MBInverseTable's outputs.
+	TBox           [16][256]byte      // The final round, including the last round key.
+}
+
+// BlockSize returns the block size of the cipher. (Necessary to implement cipher.Block.)
+func (constr *Construction) BlockSize() int { return 16 }
+
+// shiftRows returns the position of the state that moves to position i under ShiftRows.
+func shiftRows(i int) int {
+	row, col := i%4, i/4
+	return row + 4*((col+row)%4)
+}
+
+// xorWords combines four encoded words into one with the XOR tables of a column.
+func xorWords(tables *[32][3]xorTable, col int, words [4][4]byte) (out [4]byte) {
+	for n := 0; n < 8; n++ {
+		nibble := func(w [4]byte) byte { return (w[n/2] >> (4 * uint(n%2))) & 0x0f }
+		t := &tables[8*col+n]
+
+		a := t[0][nibble(words[0])<<4|nibble(words[1])]
+		b := t[1][nibble(words[2])<<4|nibble(words[3])]
+		out[n/2] |= t[2][a<<4|b] << (4 * uint(n%2))
+	}
+
+	return
+}
+
+// Encrypt encrypts the first block in src into dst. Dst and src may point at the same memory.
+func (constr *Construction) Encrypt(dst, src []byte) {
+	state := [16]byte{}
+	copy(state[:], src)
+
+	for round := 0; round < 9; round++ {
+		next := [16]byte{}
+
+		for col := 0; col < 4; col++ {
+			words := [4][4]byte{}
+			for row := 0; row < 4; row++ {
+				words[row] = constr.TyiTable[round][4*col+row][state[shiftRows(4*col+row)]]
+			}
+			mixed := xorWords(&constr.HighXORTable[round], col, words)
+
+			for row := 0; row < 4; row++ {
+				words[row] = constr.MBInverseTable[round][4*col+row][mixed[row]]
+			}
+			out := xorWords(&constr.LowXORTable[round], col, words)
+
+			copy(next[4*col:], out[:])
+		}
+
+		state = next
+	}
+
+	out := [16]byte{}
+	for i := range out {
+		out[i] = constr.TBox[i][state[shiftRows(i)]]
+	}
+
+	copy(dst, out[:])
+}
+
+// Decrypt panics, because the construction only implements encryption.
+func (constr *Construction) Decrypt(dst, src []byte) {
+	panic("constructions/chow.Construction.Decrypt isn't implemented!")
+}
@@ -0,0 +1,30 @@
+package chow
+
+import (
+	"testing"
+
+	"bytes"
+	"crypto/aes"
+	"crypto/rand"
+)
+
+func TestEncrypt(t *testing.T) {
+	key := [16]byte{}
+	rand.Read(key[:])
+
+	constr := GenerateKeys(key, rand.Reader)
+	c, _ := aes.NewCipher(key[:])
+
+	for i := 0; i < 16; i++ {
+		in := make([]byte, 16)
+		rand.Read(in)
+
+		out, out2 := make([]byte, 16), make([]byte, 16)
+		constr.Encrypt(out, in)
+		c.Encrypt(out2, in)
+
+		if !bytes.Equal(out, out2) {
+			t.Fatalf("White-box encryption is wrong: %x, not %x.", out, out2)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package chow
+
+import (
+	"io"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/matrix"
+
+	"github.com/OpenWhiteBox/Generic/internal/aes"
+)
+
+// roundKeys returns the eleven round keys of AES-128 under key.
+func roundKeys(key [16]byte, s [256]byte) (out [11][16]byte) {
+	out[0] = key
+	rcon := byte(1)
+
+	for r := 1; r < 11; r++ {
+		prev := out[r-1]
+		w := [4]byte{s[prev[13]] ^ rcon, s[prev[14]], s[prev[15]], s[prev[12]]}
+
+		for i := 0; i < 16; i++ {
+			out[r][i] = prev[i] ^ w[i%4]
+			w[i%4] = out[r][i]
+		}
+
+		rcon = aes.Mul(rcon, 2)
+	}
+
+	return
+}
+
+// mixColumns is the matrix of MixColumns over GF(2^8).
+var mixColumns = [4][4]byte{
+	{2, 3, 1, 1},
+	{1, 2, 3, 1},
+	{1, 1, 2, 3},
+	{3, 1, 1, 2},
+}
+
+// nibbleEncoding encodes a byte with a separate random bijection on each nibble.
+type nibbleEncoding struct{ lo, hi encoding.Shuffle }
+
+func (ne nibbleEncoding) Encode(x byte) byte { return ne.lo.Encode(x&0x0f) | ne.hi.Encode(x>>4)<<4 }
+func (ne nibbleEncoding) Decode(x byte) byte { return ne.lo.Decode(x&0x0f) | ne.hi.Decode(x>>4)<<4 }
+
+// encodeWord encodes each nibble of w with the corresponding bijection.
+func encodeWord(w [4]byte, encs *[8]encoding.Shuffle) (out [4]byte) {
+	for i := range out {
+		out[i] = nibbleEncoding{encs[2*i], encs[2*i+1]}.Encode(w[i])
+	}
+
+	return
+}
+
+// generateXORTables returns the three XOR tables that combine one nibble of four words encoded with in, along with
+// encodings of the intermediate results and a final output encoding of out.
+func generateXORTables(rand io.Reader, in [4]encoding.Shuffle, out encoding.Shuffle) (tables [3]xorTable) {
+	mid := [2]encoding.Shuffle{encoding.GenerateShuffle(rand), encoding.GenerateShuffle(rand)}
+
+	for x := 0; x < 256; x++ {
+		a, b := byte(x>>4), byte(x&0x0f)
+
+		tables[0][x] = mid[0].Encode(in[0].Decode(a) ^ in[1].Decode(b))
+		tables[1][x] = mid[1].Encode(in[2].Decode(a) ^ in[3].Decode(b))
+		tables[2][x] = out.Encode(mid[0].Decode(a) ^ mid[1].Decode(b))
+	}
+
+	return
+}
+
+// GenerateKeys creates a white-boxed version of AES-128 with the given key, using the random source rand for its
+// internal encodings and mixing bijections.
+func GenerateKeys(key [16]byte, rand io.Reader) (constr Construction) {
+	s := aes.SBox()
+	keys := roundKeys(key, s)
+
+	// The encoding and byte mixing bijection of each position of the state at the start of the round.
+	encs, mixes := [16]encoding.Byte{}, [16]matrix.Matrix{}
+	for p := range encs {
+		encs[p], mixes[p] = encoding.IdentityByte{}, matrix.GenerateIdentity(8)
+	}
+
+	// decode removes the encoding and byte mixing bijection from position p of the state.
+	decode := func(p int, x byte) byte {
+		inv, _ := mixes[p].Invert()
+		return inv.Mul(matrix.Row{encs[p].Decode(x)})[0]
+	}
+
+	for round := 0; round < 9; round++ {
+		nextEncs, nextMixes := [16]encoding.Byte{}, [16]matrix.Matrix{}
+		nextNibbles := [16][2]encoding.Shuffle{}
+		for p := range nextEncs {
+			nextNibbles[p] = [2]encoding.Shuffle{encoding.GenerateShuffle(rand), encoding.GenerateShuffle(rand)}
+			nextEncs[p] = nibbleEncoding{nextNibbles[p][0], nextNibbles[p][1]}
+			nextMixes[p] = matrix.GenerateRandom(rand, 8)
+		}
+
+		for col := 0; col < 4; col++ {
+			mb := matrix.GenerateRandom(rand, 32)
+			mbInv, _ := mb.Invert()
+
+			// T-boxes, MixColumns, and the mixing bijection.
+			tyiOut := [4][8]encoding.Shuffle{}
+			for row := 0; row < 4; row++ {
+				i, q := 4*col+row, shiftRows(4*col+row)
+				for n := range tyiOut[row] {
+					tyiOut[row][n] = encoding.GenerateShuffle(rand)
+				}
+
+				for x := 0; x < 256; x++ {
+					t := s[decode(q, byte(x))^keys[round][q]]
+
+					w := [4]byte{}
+					for j := range w {
+						w[j] = aes.Mul(mixColumns[j][row], t)
+					}
+
+					copy(w[:], mb.Mul(matrix.Row(w[:])))
+					constr.TyiTable[round][i][x] = encodeWord(w, &tyiOut[row])
+				}
+			}
+
+			mixed := [8]encoding.Shuffle{}
+			for n := range mixed {
+				mixed[n] = encoding.GenerateShuffle(rand)
+				in := [4]encoding.Shuffle{tyiOut[0][n], tyiOut[1][n], tyiOut[2][n], tyiOut[3][n]}
+				constr.HighXORTable[round][8*col+n] = generateXORTables(rand, in, mixed[n])
+			}
+
+			// The inverse of the mixing bijection, and the byte mixing bijections of the next round.
+			mbOut := [4][8]encoding.Shuffle{}
+			for row := 0; row < 4; row++ {
+				for n := range mbOut[row] {
+					mbOut[row][n] = encoding.GenerateShuffle(rand)
+				}
+
+				for x := 0; x < 256; x++ {
+					w := [4]byte{}
+					w[row] = nibbleEncoding{mixed[2*row], mixed[2*row+1]}.Decode(byte(x))
+					copy(w[:], mbInv.Mul(matrix.Row(w[:])))
+
+					for j := range w {
+						w[j] = nextMixes[4*col+j].Mul(matrix.Row{w[j]})[0]
+					}
+
+					constr.MBInverseTable[round][4*col+row][x] = encodeWord(w, &mbOut[row])
+				}
+			}
+
+			for n := 0; n < 8; n++ {
+				in := [4]encoding.Shuffle{mbOut[0][n], mbOut[1][n], mbOut[2][n], mbOut[3][n]}
+				constr.LowXORTable[round][8*col+n] = generateXORTables(rand, in, nextNibbles[4*col+n/2][n%2])
+			}
+		}
+
+		encs, mixes = nextEncs, nextMixes
+	}
+
+	for i := range constr.TBox {
+		q := shiftRows(i)
+
+		for x := 0; x < 256; x++ {
+			constr.TBox[i][x] = s[decode(q, byte(x))^keys[9][q]] ^ keys[10][i]
+		}
+	}
+
+	return
+}
@@ -0,0 +1,169 @@
+package spn
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/matrix"
+)
+
+const codegenHeader = `// Code generated from a constructions/spn.Construction. DO NOT EDIT.
+
+// Package %[1]v implements a fixed SPN block cipher with 128-bit blocks. It has no dependencies.
+package %[1]v
+
+// layer is one layer of the cipher.
+type layer interface {
+	encode(in [16]byte) [16]byte
+	decode(in [16]byte) [16]byte
+}
+
+// sboxLayer applies a different 8-bit S-box to each byte.
+type sboxLayer struct {
+	enc, dec [16][256]byte
+}
+
+func (l *sboxLayer) encode(in [16]byte) (out [16]byte) {
+	for pos, x := range in {
+		out[pos] = l.enc[pos][x]
+	}
+
+	return
+}
+
+func (l *sboxLayer) decode(in [16]byte) (out [16]byte) {
+	for pos, x := range in {
+		out[pos] = l.dec[pos][x]
+	}
+
+	return
+}
+
+// affineLayer multiplies by a 128-by-128 matrix over GF(2) and adds a constant. Bit i of a block is bit i%%8 of byte
+// i/8, counting from the least significant bit.
+type affineLayer struct {
+	forwards, backwards [128][16]byte
+	constant            [16]byte
+}
+
+func mul(m *[128][16]byte, in [16]byte) (out [16]byte) {
+	for i, row := range m {
+		parity := byte(0)
+		for j := range row {
+			parity ^= row[j] & in[j]
+		}
+
+		parity ^= parity >> 4
+		parity ^= parity >> 2
+		parity ^= parity >> 1
+
+		out[i/8] |= (parity & 1) << uint(i%%8)
+	}
+
+	return
+}
+
+func (l *affineLayer) encode(in [16]byte) (out [16]byte) {
+	out = mul(&l.forwards, in)
+	for i := range out {
+		out[i] ^= l.constant[i]
+	}
+
+	return
+}
+
+func (l *affineLayer) decode(in [16]byte) [16]byte {
+	for i := range in {
+		in[i] ^= l.constant[i]
+	}
+
+	return mul(&l.backwards, in)
+}
+
+// Cipher is the block cipher. It implements crypto/cipher.Block.
+type Cipher struct{}
+
+// BlockSize returns the block size of the cipher.
+func (Cipher) BlockSize() int { return 16 }
+
+// Encrypt encrypts the first block in src into dst. Dst and src may point at the same memory.
+func (Cipher) Encrypt(dst, src []byte) {
+	block := [16]byte{}
+	copy(block[:], src)
+
+	for _, l := range layers {
+		block = l.encode(block)
+	}
+
+	copy(dst, block[:])
+}
+
+// Decrypt decrypts the first block in src into dst. Dst and src may point at the same memory.
+func (Cipher) Decrypt(dst, src []byte) {
+	block := [16]byte{}
+	copy(block[:], src)
+
+	for i := len(layers) - 1; i >= 0; i-- {
+		block = layers[i].decode(block)
+	}
+
+	copy(dst, block[:])
+}
+
+// layers are the layers of the cipher, in the order they're applied in when encrypting.
+var layers = []layer{
+`
+
+// matrixLiteral returns m as a Go literal of type [128][16]byte.
+func matrixLiteral(m matrix.Matrix) string {
+	out := [128][16]byte{}
+	for i, row := range m {
+		copy(out[i][:], row)
+	}
+
+	return fmt.Sprintf("%#v", out)
+}
+
+// GenerateGo writes the source code of a standalone Go package named pkg that implements the construction, as lookup
+// tables and matrix multiplications, so that it can be shipped and benchmarked without depending on this package or
+// on primitives. The package's Cipher type implements crypto/cipher.Block. It returns an error if some layer is
+// neither an S-box layer nor an affine layer.
+func (constr Construction) GenerateGo(w io.Writer, pkg string) error {
+	src := &bytes.Buffer{}
+	fmt.Fprintf(src, codegenHeader, pkg)
+
+	for i, layer := range constr {
+		switch layer := layer.(type) {
+		case encoding.ConcatenatedBlock:
+			enc, dec := [16][256]byte{}, [16][256]byte{}
+			for pos := 0; pos < 16; pos++ {
+				for x := 0; x < 256; x++ {
+					enc[pos][x] = layer[pos].Encode(byte(x))
+					dec[pos][x] = layer[pos].Decode(byte(x))
+				}
+			}
+
+			fmt.Fprintf(src, "&sboxLayer{enc: %#v, dec: %#v},\n", enc, dec)
+
+		case encoding.BlockAffine:
+			fmt.Fprintf(src, "&affineLayer{forwards: %v, backwards: %v, constant: %#v},\n",
+				matrixLiteral(layer.BlockLinear.Forwards), matrixLiteral(layer.BlockLinear.Backwards), [16]byte(layer.BlockAdditive))
+
+		default:
+			return fmt.Errorf("layer %v has unsupported type %T", i, layer)
+		}
+	}
+
+	src.WriteString("}\n")
+
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(formatted)
+	return err
+}
@@ -0,0 +1,42 @@
+package spn
+
+import (
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/matrix"
+)
+
+// NewCustomSPN assembles a construction from given layers, checking that each is either an S-box layer or an affine
+// layer, so that the attacks in cryptanalysis/spn and Serialize can handle it. Layers are applied in the order they're
+// given. It panics if a layer is of any other type.
+func NewCustomSPN(layers ...encoding.Block) Construction {
+	for _, layer := range layers {
+		switch layer.(type) {
+		case encoding.ConcatenatedBlock, encoding.BlockAffine:
+		default:
+			panic("Layers must be S-box layers or affine layers!")
+		}
+	}
+
+	return Construction(append(encoding.ComposedBlocks{}, layers...))
+}
+
+// NewRoundSPN builds an iterated SPN, like a simplified AES: each round applies sbox to every byte, multiplies by the
+// linear layer, and adds a round key. The first round key is added to the plaintext before the first round, so
+// len(keys)-1 rounds are applied in total and the structure is A(SA)^r. It panics if linear is singular.
+func NewRoundSPN(sbox encoding.Byte, linear matrix.Matrix, keys [][16]byte) Construction {
+	if len(keys) == 0 {
+		panic("An SPN needs at least one round key!")
+	}
+
+	sboxes := encoding.ConcatenatedBlock{}
+	for pos := 0; pos < 16; pos++ {
+		sboxes[pos] = sbox
+	}
+
+	layers := []encoding.Block{encoding.NewBlockAffine(matrix.GenerateIdentity(128), keys[0])}
+	for _, key := range keys[1:] {
+		layers = append(layers, sboxes, encoding.NewBlockAffine(linear, key))
+	}
+
+	return NewCustomSPN(layers...)
+}
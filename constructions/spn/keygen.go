@@ -63,3 +63,28 @@ func NewSPN(rand io.Reader, structure Structure) Construction {
 		panic("Unknown SPN structure!")
 	}
 }
+
+// NewAffineEquivalentSBox generates a random S-box affine-equivalent to s, using the random source rand. The S-box is
+// out(s(in(x))) for random invertible affine transformations in and out, which are also returned. It gives targets that
+// disguise a known S-box, and inputs for testing whether an attack can see through them.
+func NewAffineEquivalentSBox(rand io.Reader, s encoding.Byte) (sbox encoding.SBox, in, out encoding.ByteAffine) {
+	in, out = newSmallAffineLayer(rand), newSmallAffineLayer(rand)
+	composed := encoding.ComposedBytes{in, s, out}
+
+	for x := 0; x < 256; x++ {
+		y := composed.Encode(byte(x))
+		sbox.EncKey[x], sbox.DecKey[y] = y, byte(x)
+	}
+
+	return
+}
+
+// NewAffineEquivalentSBoxLayer generates an S-box layer of independent random S-boxes, each affine-equivalent to s,
+// using the random source rand.
+func NewAffineEquivalentSBoxLayer(rand io.Reader, s encoding.Byte) (layer encoding.ConcatenatedBlock) {
+	for pos := range layer {
+		layer[pos], _, _ = NewAffineEquivalentSBox(rand, s)
+	}
+
+	return
+}
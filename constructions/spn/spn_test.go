@@ -5,6 +5,14 @@ import (
 
 	"bytes"
 	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/matrix"
 )
 
 func Example_encrypt() {
@@ -48,3 +56,99 @@ func TestPersistence(t *testing.T) {
 		t.Fatalf("Parse/Serialize are wrong.")
 	}
 }
+
+func TestGenerateGo(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("Go toolchain isn't available.")
+	}
+
+	constr := NewSPN(rand.Reader, ASAS)
+
+	src := &bytes.Buffer{}
+	if err := constr.GenerateGo(src, "main"); err != nil {
+		t.Fatal(err)
+	}
+
+	in := make([]byte, 16)
+	rand.Read(in)
+
+	out := make([]byte, 16)
+	constr.Encrypt(out, in)
+
+	main := `package main
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+func main() {
+	in, _ := hex.DecodeString("` + hex.EncodeToString(in) + `")
+	out := make([]byte, 16)
+
+	Cipher{}.Encrypt(out, in)
+	Cipher{}.Decrypt(in, out)
+
+	fmt.Println(hex.EncodeToString(out), hex.EncodeToString(in))
+}
+`
+
+	dir := t.TempDir()
+	files := map[string]string{"cipher.go": src.String(), "main.go": main, "go.mod": "module generated\n"}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command("go", "run", ".")
+	cmd.Dir = dir
+	res, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Generated code doesn't run: %v\n%s", err, res)
+	}
+
+	if got, want := strings.TrimSpace(string(res)), hex.EncodeToString(out)+" "+hex.EncodeToString(in); got != want {
+		t.Fatalf("Generated code computes %v, not %v.", got, want)
+	}
+}
+
+func TestRoundSPN(t *testing.T) {
+	keys := make([][16]byte, 4)
+	for i := range keys {
+		rand.Read(keys[i][:])
+	}
+
+	constr := NewRoundSPN(encoding.GenerateSBox(rand.Reader), matrix.GenerateRandom(rand.Reader, 128), keys)
+	if len(constr) != 7 {
+		t.Fatalf("Three rounds gave %v layers, not 7.", len(constr))
+	}
+
+	in := make([]byte, 16)
+	rand.Read(in)
+
+	out, out2 := make([]byte, 16), make([]byte, 16)
+	constr.Encrypt(out, in)
+	constr.Decrypt(out2, out)
+
+	if !bytes.Equal(in, out2) {
+		t.Fatalf("Correctness property is not satisfied.")
+	}
+}
+
+func TestAffineEquivalentSBox(t *testing.T) {
+	s := encoding.GenerateSBox(rand.Reader)
+	sbox, in, out := NewAffineEquivalentSBox(rand.Reader, s)
+
+	for x := 0; x < 256; x++ {
+		if y := sbox.Encode(byte(x)); y != out.Encode(s.Encode(in.Encode(byte(x)))) || sbox.Decode(y) != byte(x) {
+			t.Fatalf("S-box isn't out(s(in(x))) at %v.", x)
+		}
+	}
+
+	// Each S-box of a layer is disguised independently.
+	layer := NewAffineEquivalentSBoxLayer(rand.Reader, s)
+	if layer[0].(encoding.SBox) == layer[1].(encoding.SBox) {
+		t.Fatal("Two S-boxes of the layer are the same.")
+	}
+}
@@ -0,0 +1,27 @@
+// Package dca recovers keys from white-box implementations through their computation traces: records of the values an
+// implementation reads and writes while it encrypts. Attacks on the traces predict some intermediate value of the cipher
+// under each guess of a key byte and look for samples that explain it; the implementation's encodings only hide the
+// intermediate values if they aren't leaked by a simple enough function of the samples.
+//
+// "Differential Computation Analysis: Hiding your White-Box Designs is Not Enough" by Joppe W. Bos, Charles Hubain,
+// Wil Michiels, and Philippe Teuwen, https://eprint.iacr.org/2015/753.pdf
+package dca
+
+// Trace is one computation trace: the input and output of an encryption, and one byte for each value the implementation
+// touched, in order.
+type Trace struct {
+	Input, Output []byte
+	Samples       []byte
+}
+
+// TraceSet is a collection of traces with the same number of samples, read as they're needed.
+type TraceSet interface {
+	Len() int
+	Trace(i int) (Trace, error)
+}
+
+// Traces is a TraceSet held in memory.
+type Traces []Trace
+
+func (ts Traces) Len() int                   { return len(ts) }
+func (ts Traces) Trace(i int) (Trace, error) { return ts[i], nil }
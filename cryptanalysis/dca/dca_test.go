@@ -0,0 +1,312 @@
+package dca
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"crypto/rand"
+)
+
+// randomTraces returns n traces with 16-byte inputs and outputs and the given number of random samples.
+func randomTraces(n, samples int) (ts Traces) {
+	for i := 0; i < n; i++ {
+		t := Trace{Input: make([]byte, 16), Output: make([]byte, 16), Samples: make([]byte, samples)}
+		rand.Read(t.Input)
+		rand.Read(t.Output)
+		rand.Read(t.Samples)
+
+		ts = append(ts, t)
+	}
+
+	return
+}
+
+// npy returns a two-dimensional NumPy array of bytes.
+func npy(rows [][]byte) []byte {
+	header := fmt.Sprintf("{'descr': '|u1', 'fortran_order': False, 'shape': (%v, %v), }", len(rows), len(rows[0]))
+	for (10+len(header)+1)%64 != 0 {
+		header += " "
+	}
+	header += "\n"
+
+	out := append([]byte("\x93NUMPY\x01\x00"), byte(len(header)), byte(len(header)>>8))
+	out = append(out, header...)
+	for _, row := range rows {
+		out = append(out, row...)
+	}
+
+	return out
+}
+
+func TestTRS(t *testing.T) {
+	ts := randomTraces(10, 100)
+
+	buf := &bytes.Buffer{}
+	if err := WriteTRS(buf, ts); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadTRS(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	} else if read.Len() != len(ts) {
+		t.Fatalf("Read %v traces, not %v.", read.Len(), len(ts))
+	}
+
+	for i := range ts {
+		if got, err := read.Trace(i); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(got, ts[i]) {
+			t.Fatalf("Trace %v is %v, not %v.", i, got, ts[i])
+		}
+	}
+
+	if _, err := read.Trace(len(ts)); err == nil {
+		t.Fatal("Read a trace past the end.")
+	}
+
+	// Headers that describe more than the file holds are rejected before anything is allocated for them.
+	for _, bad := range [][]byte{
+		buf.Bytes()[:buf.Len()-1],
+		{0x41, 0xff},
+		{0x41, 0x84, 0xff, 0xff, 0xff, 0xff},
+		{0x41, 0x88, 0, 0, 0, 0, 0, 0, 0, 0x80},
+		{0x41, 0x04, 0xff, 0xff, 0xff, 0x7f, 0x42, 0x01, 0x01, 0x5f, 0x00},
+		{0x44, 0x04, 0xfe, 0xff, 0xff, 0xff, 0x41, 0x01, 0x01, 0x5f, 0x00},
+	} {
+		if _, err := ReadTRS(bytes.NewReader(bad), int64(len(bad))); err == nil {
+			t.Fatalf("Read the malformed TRS file %x.", bad)
+		}
+	}
+}
+
+func TestDaredevil(t *testing.T) {
+	ts := randomTraces(5, 40)
+
+	samples, inputs := []byte{}, []byte{}
+	for _, tr := range ts {
+		samples, inputs = append(samples, tr.Samples...), append(inputs, tr.Input...)
+	}
+
+	cfg, err := ParseDaredevilConfig(strings.NewReader(`[Traces]
+files=1
+trace_type=u
+transpose=true
+index=0
+nsamples=40
+trace=traces.bin 5 40
+
+[Guesses]
+files=1
+guess_type=u
+transpose=true
+guess=inputs.bin 5 16
+`))
+	if err != nil {
+		t.Fatal(err)
+	} else if cfg.Traces != "traces.bin" || cfg.Guesses != "inputs.bin" || cfg.NTraces != 5 || cfg.NSamples != 40 || cfg.GuessSize != 16 {
+		t.Fatalf("Parsed configuration %+v.", cfg)
+	}
+
+	read, err := ReadDaredevil(cfg, bytes.NewReader(samples), bytes.NewReader(inputs))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range ts {
+		if got, err := read.Trace(i); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(got.Samples, ts[i].Samples) || !bytes.Equal(got.Input, ts[i].Input) {
+			t.Fatalf("Trace %v is %v, not %v.", i, got, ts[i])
+		}
+	}
+
+	if _, err := ParseDaredevilConfig(strings.NewReader("trace_type=f\n")); err == nil {
+		t.Fatal("Parsed traces of floats.")
+	}
+}
+
+func TestNpy(t *testing.T) {
+	ts := randomTraces(7, 33)
+
+	samples, inputs := [][]byte{}, [][]byte{}
+	for _, tr := range ts {
+		samples, inputs = append(samples, tr.Samples), append(inputs, tr.Input)
+	}
+
+	read, err := ReadNpy(bytes.NewReader(npy(samples)), bytes.NewReader(npy(inputs)))
+	if err != nil {
+		t.Fatal(err)
+	} else if read.Len() != len(ts) {
+		t.Fatalf("Read %v traces, not %v.", read.Len(), len(ts))
+	}
+
+	for i := range ts {
+		if got, err := read.Trace(i); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(got.Samples, ts[i].Samples) || !bytes.Equal(got.Input, ts[i].Input) {
+			t.Fatalf("Trace %v is %v, not %v.", i, got, ts[i])
+		}
+	}
+
+	if _, err := ReadNpy(bytes.NewReader(npy(samples)), bytes.NewReader(npy(inputs[:3]))); err == nil {
+		t.Fatal("Read arrays with different numbers of rows.")
+	}
+
+	huge := []byte("\x93NUMPY\x02\x00\xff\xff\xff\xff")
+	if _, err := ReadNpy(bytes.NewReader(huge), bytes.NewReader(npy(inputs))); err == nil {
+		t.Fatal("Read an array with a 4 GiB header.")
+	}
+}
+
+// maskedTraces returns n traces of a first round of AES under key, where each byte of the S-box's output is written as
+// two shares--a random mask and the output plus a linear function of the mask--between noise.
+func maskedTraces(n int, key [16]byte) (ts Traces) {
+	mask := func(x byte) byte { return x<<1 ^ x>>7 ^ x>>3 }
+
+	for i := 0; i < n; i++ {
+		t := Trace{Input: make([]byte, 16), Samples: make([]byte, 64)}
+		rand.Read(t.Input)
+		rand.Read(t.Samples)
+
+		for pos := 0; pos < 16; pos++ {
+			r := t.Samples[2*pos]
+			t.Samples[2*pos+1] = sbox[t.Input[pos]^key[pos]] ^ mask(r)
+		}
+
+		ts = append(ts, t)
+	}
+
+	return
+}
+
+func TestLDA(t *testing.T) {
+	key := [16]byte{}
+	rand.Read(key[:])
+
+	ts := maskedTraces(8*4+1+ldaMargin, key)
+	for _, pos := range []int{0, 7, 15} {
+		found, err := LDA(ts, SubBytes{pos}, 4)
+		if err != nil {
+			t.Fatal(err)
+		} else if found.Key != key[pos] {
+			t.Fatalf("Recovered key byte %x at position %v, not %x.", found.Key, pos, key[pos])
+		}
+	}
+
+	if _, err := LDA(ts[:40], SubBytes{0}, 4); err == nil {
+		t.Fatal("Ran LDA with too few traces.")
+	}
+
+	if _, err := LDA(randomTraces(len(ts), 64), SubBytes{0}, 4); err != ErrNoDecoder {
+		t.Fatalf("LDA on random traces returned %v.", err)
+	}
+}
+
+func TestDecodingAnalysis(t *testing.T) {
+	key := [16]byte{}
+	rand.Read(key[:])
+
+	// Each byte of the S-box's output is written as three shares a, b, and c, with a & b ^ c equal to it.
+	ts := Traces{}
+	for i := 0; i < monomials(24, 2)+ldaMargin; i++ {
+		tr := Trace{Input: make([]byte, 16), Samples: make([]byte, 48)}
+		rand.Read(tr.Input)
+		rand.Read(tr.Samples)
+
+		for pos := 0; pos < 16; pos++ {
+			a, b := tr.Samples[3*pos], tr.Samples[3*pos+1]
+			tr.Samples[3*pos+2] = sbox[tr.Input[pos]^key[pos]] ^ a&b
+		}
+
+		ts = append(ts, tr)
+	}
+
+	if _, err := LDA(ts, SubBytes{0}, 3); err != ErrNoDecoder {
+		t.Fatalf("LDA on quadratically masked traces returned %v.", err)
+	}
+
+	found, err := DecodingAnalysis(ts, SubBytes{5}, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	} else if found.Key != key[5] || found.Window != 15 {
+		t.Fatalf("Recovered key byte %x in window %v, not %x in window 15.", found.Key, found.Window, key[5])
+	}
+}
+
+func TestMIA(t *testing.T) {
+	key := [16]byte{}
+	rand.Read(key[:])
+
+	// The high nibble of each byte of the S-box's output is written under a random encoding, between noise. All of
+	// the byte can't be, because then the sample would determine the input and explain the predictions of every guess.
+	encs := [4][16]byte{}
+	for i := range encs {
+		p := make([]byte, 16)
+		rand.Read(p)
+		for x := range encs[i] {
+			encs[i][x] = byte(x)
+		}
+		for x := 15; x > 0; x-- {
+			y := int(p[x]) % (x + 1)
+			encs[i][x], encs[i][y] = encs[i][y], encs[i][x]
+		}
+	}
+
+	ts := Traces{}
+	for i := 0; i < 512; i++ {
+		tr := Trace{Input: make([]byte, 16), Samples: make([]byte, 8)}
+		rand.Read(tr.Input)
+		rand.Read(tr.Samples)
+
+		for pos := range encs {
+			tr.Samples[2*pos+1] = encs[pos][sbox[tr.Input[pos]^key[pos]]>>4]
+		}
+
+		ts = append(ts, tr)
+	}
+
+	found, scores, err := MIA(ts, SubBytes{2})
+	if err != nil {
+		t.Fatal(err)
+	} else if found.Key != key[2] || found.Window != 5 || found.Bit < 4 {
+		t.Fatalf("Recovered %+v, not key byte %x at sample 5.", found, key[2])
+	} else if scores[key[2]] < 0.99 || scores[key[2]^1] > 0.5 {
+		t.Fatalf("Right guess has %v bits of mutual information and a wrong one %v.", scores[key[2]], scores[key[2]^1])
+	}
+}
+
+func TestModels(t *testing.T) {
+	key := [16]byte{}
+	rand.Read(key[:])
+
+	// A T-table implementation that leaks the Hamming weight of each word it looks up, and the low bit of each byte of
+	// the S-box's output.
+	ts := Traces{}
+	for i := 0; i < 256; i++ {
+		tr := Trace{Input: make([]byte, 16), Samples: make([]byte, 32)}
+		rand.Read(tr.Input)
+
+		for pos := 0; pos < 16; pos++ {
+			tr.Samples[2*pos] = byte(TTableWeight{pos}.Predict(tr, key[pos]))
+			tr.Samples[2*pos+1] = byte(Bit{SubBytes{pos}, 0}.Predict(tr, key[pos]))
+		}
+
+		ts = append(ts, tr)
+	}
+
+	if found, _, err := MIA(ts, TTableWeight{6}); err != nil {
+		t.Fatal(err)
+	} else if found.Key != key[6] || found.Window != 12 {
+		t.Fatalf("Recovered %+v with the T-table model, not key byte %x at sample 12.", found, key[6])
+	}
+
+	if found, err := LDA(ts[:8*2+1+ldaMargin], Bit{SubBytes{9}, 0}, 2); err != nil {
+		t.Fatal(err)
+	} else if found.Key != key[9] || found.Window != 18 {
+		t.Fatalf("Recovered %+v with the bit model, not key byte %x at sample 18.", found, key[9])
+	}
+}
@@ -0,0 +1,140 @@
+package dca
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/OpenWhiteBox/primitives/matrix"
+)
+
+// ldaMargin is how many more traces than a window has monomials the decoding analyses use, so that a wrong guess's
+// predictions are in the span of a window's monomials with probability about 2^-ldaMargin.
+const ldaMargin = 40
+
+// ErrNoDecoder is returned by the decoding analyses when no guess of the key byte is explained by any window of samples.
+var ErrNoDecoder = errors.New("no window of samples decodes the predictions of any key byte")
+
+// Decoding is the result of an analysis: the key byte, the first sample of the window that decodes its predictions, and
+// the bit of the intermediate value that was decoded.
+type Decoding struct {
+	Key    byte
+	Window int
+	Bit    int
+}
+
+// readAll reads every trace of a set.
+func readAll(ts TraceSet) (out Traces, err error) {
+	out = make(Traces, ts.Len())
+	for i := range out {
+		if out[i], err = ts.Trace(i); err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
+
+// monomials returns the number of monomials of degree at most degree in n variables.
+func monomials(n, degree int) (count int) {
+	binomial := 1
+	for d := 0; d <= degree && d <= n; d++ {
+		count += binomial
+		binomial = binomial * (n - d) / (d + 1)
+	}
+
+	return
+}
+
+// windowSpan returns the span over GF(2) of the vectors, over every trace, of each monomial of degree at most degree in
+// the bits of the samples in [start, start+width). The monomial of degree zero is the constant vector.
+func windowSpan(ts Traces, start, width, degree int) matrix.IncrementalMatrix {
+	span := matrix.NewIncrementalMatrix(len(ts))
+
+	bits := []matrix.Row{}
+	for j := start; j < start+width; j++ {
+		for b := uint(0); b < 8; b++ {
+			col := matrix.NewRow(len(ts))
+			for i, t := range ts {
+				col = col.SetBit(i, (t.Samples[j]>>b)&1 == 1)
+			}
+			bits = append(bits, col)
+		}
+	}
+
+	// Extend each monomial by the variables after its last one, so that each is generated once.
+	var expand func(m matrix.Row, next, d int)
+	expand = func(m matrix.Row, next, d int) {
+		span.Add(m)
+		if d == degree {
+			return
+		}
+
+		for v := next; v < len(bits); v++ {
+			expand(m.Mul(bits[v]), v+1, d+1)
+		}
+	}
+
+	one := matrix.NewRow(len(ts))
+	for i := range ts {
+		one = one.SetBit(i, true)
+	}
+	expand(one, 0, 0)
+
+	return span
+}
+
+// LDA runs linear decoding analysis for a byte of the key. For each guess of the key byte, it predicts each bit of the
+// model's intermediate value and looks for a window of width consecutive samples
+// with an affine function of their bits that equals the predictions on every trace. Linear masking spreads an
+// intermediate value over several samples, but only an affine function of them, so LDA recovers keys from
+// implementations that defeat correlation-based DCA. Windows start every width/2 samples, so shares at most width/2
+// samples apart are always in one window.
+//
+// It needs at least 8*width + 41 traces, and uses all of them.
+//
+// "Attacks and Countermeasures for White-box Designs" by Alex Biryukov and Aleksei Udovenko,
+// https://eprint.iacr.org/2018/049.pdf
+func LDA(ts TraceSet, model Model, width int) (Decoding, error) {
+	return DecodingAnalysis(ts, model, width, 1)
+}
+
+// DecodingAnalysis is LDA with decoders that are polynomials of the given degree in the bits of a window, instead of
+// affine functions. Masking of degree less than degree--like a Boolean masking where one share is the product of two
+// others--is undone by one of these polynomials.
+//
+// The number of monomials grows as (8*width)^degree, and it needs 40 more traces than there are monomials, so windows
+// have to be narrow for degrees past two.
+func DecodingAnalysis(ts TraceSet, model Model, width, degree int) (Decoding, error) {
+	if need := monomials(8*width, degree) + ldaMargin; ts.Len() < need {
+		return Decoding{}, fmt.Errorf("degree-%v decoding with windows of %v samples needs %v traces, not %v", degree, width, need, ts.Len())
+	}
+
+	traces, err := readAll(ts)
+	if err != nil {
+		return Decoding{}, err
+	}
+
+	preds := make([][256]matrix.Row, model.Bits())
+	for b := range preds {
+		preds[b] = predictions(traces, model, b)
+	}
+
+	step := width / 2
+	if step == 0 {
+		step = 1
+	}
+
+	for start := 0; start+width <= len(traces[0].Samples); start += step {
+		span := windowSpan(traces, start, width, degree)
+
+		for b := range preds {
+			for k, p := range preds[b] {
+				if span.IsIn(p) {
+					return Decoding{Key: byte(k), Window: start, Bit: b}, nil
+				}
+			}
+		}
+	}
+
+	return Decoding{}, ErrNoDecoder
+}
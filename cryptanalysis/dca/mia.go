@@ -0,0 +1,73 @@
+package dca
+
+import (
+	"errors"
+	"math"
+)
+
+// entropy returns the entropy in bits of a distribution given by counts summing to n.
+func entropy(counts []int, n int) (h float64) {
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / float64(n)
+			h -= p * math.Log2(p)
+		}
+	}
+
+	return
+}
+
+// MIA runs mutual information analysis for a byte of the key. For each guess of the key byte, it predicts each bit of
+// the model's intermediate value and estimates the mutual information between the
+// predictions and each sample. It makes no assumption about how a sample leaks an intermediate value: a sample that's
+// an encoding of part of the S-box's output determines the bits in that part, whatever the encoding, so the right guess
+// has a full bit of mutual information with it. Wrong guesses have at most the bias of estimating from few traces,
+// which is about 184/(number of traces) bits.
+//
+// It returns the guess, sample, and bit with the most mutual information, and the most that each guess had with any
+// sample.
+//
+// "Mutual Information Analysis" by Benedikt Gierlichs, Lejla Batina, Pim Tuyls, and Bart Preneel,
+// https://eprint.iacr.org/2007/198.pdf
+func MIA(ts TraceSet, model Model) (best Decoding, scores [256]float64, err error) {
+	if ts.Len() == 0 {
+		return best, scores, errors.New("MIA needs at least one trace")
+	}
+
+	traces, err := readAll(ts)
+	if err != nil {
+		return best, scores, err
+	}
+	n, bestScore := len(traces), -1.0
+
+	for b := 0; b < model.Bits(); b++ {
+		preds := predictions(traces, model, b)
+
+		for j := range traces[0].Samples {
+			marginal := make([]int, 256)
+			for _, t := range traces {
+				marginal[t.Samples[j]]++
+			}
+			hSample := entropy(marginal, n)
+
+			for k, p := range preds {
+				joint, bits := make([]int, 512), make([]int, 2)
+				for i, t := range traces {
+					bit := int(p.GetBit(i))
+					joint[bit<<8|int(t.Samples[j])]++
+					bits[bit]++
+				}
+
+				mi := entropy(bits, n) + hSample - entropy(joint, n)
+				if mi > scores[k] {
+					scores[k] = mi
+				}
+				if mi > bestScore {
+					best, bestScore = Decoding{Key: byte(k), Window: j, Bit: b}, mi
+				}
+			}
+		}
+	}
+
+	return best, scores, nil
+}
@@ -0,0 +1,68 @@
+package dca
+
+import (
+	"github.com/OpenWhiteBox/primitives/matrix"
+
+	"github.com/OpenWhiteBox/Generic/internal/aes"
+)
+
+// Model predicts an intermediate value of an encryption from its trace and a guess of a byte of the key. The analyses
+// recover the key byte by finding the guess whose predictions some samples explain.
+type Model interface {
+	// Predict returns the intermediate value of the encryption in t, if guess is the key byte.
+	Predict(t Trace, guess byte) uint32
+
+	// Bits returns the number of low bits of Predict's values that the analyses look at.
+	Bits() int
+}
+
+// mixColumns is the first column of the matrix of MixColumns over GF(2^8).
+var mixColumns = [4]byte{2, 1, 1, 3}
+
+// SubBytes predicts the output of the S-box at position Pos of the first round of AES: S(input[Pos] ^ guess).
+type SubBytes struct{ Pos int }
+
+func (sb SubBytes) Predict(t Trace, guess byte) uint32 { return uint32(sbox[t.Input[sb.Pos]^guess]) }
+func (sb SubBytes) Bits() int                          { return 8 }
+
+// TTableWeight predicts the Hamming weight of the word the T-table at position Pos of the first round of AES looks up:
+// the S-box's output, times the column of MixColumns for Pos's row.
+type TTableWeight struct{ Pos int }
+
+func (tt TTableWeight) Predict(t Trace, guess byte) (w uint32) {
+	s := sbox[t.Input[tt.Pos]^guess]
+
+	for i := range mixColumns {
+		for x := aes.Mul(mixColumns[(i-tt.Pos%4+4)%4], s); x > 0; x &= x - 1 {
+			w++
+		}
+	}
+
+	return
+}
+
+func (tt TTableWeight) Bits() int { return 6 }
+
+// Bit selects one bit of another model's intermediate value.
+type Bit struct {
+	Model
+	Index uint
+}
+
+func (b Bit) Predict(t Trace, guess byte) uint32 { return (b.Model.Predict(t, guess) >> b.Index) & 1 }
+func (b Bit) Bits() int                          { return 1 }
+
+// sbox is the AES S-box.
+var sbox = aes.SBox()
+
+// predictions returns, for each guess of the key byte, the vector of bit b of the model's predictions over every trace.
+func predictions(ts Traces, model Model, b int) (out [256]matrix.Row) {
+	for k := range out {
+		out[k] = matrix.NewRow(len(ts))
+		for i, t := range ts {
+			out[k] = out[k].SetBit(i, (model.Predict(t, byte(k))>>uint(b))&1 == 1)
+		}
+	}
+
+	return
+}
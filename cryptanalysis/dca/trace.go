@@ -0,0 +1,360 @@
+package dca
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// fixedTraces is a TraceSet stored in a file as fixed-size records, which are read only when they're asked for. Passing
+// an *os.File keeps a set of traces much larger than memory usable.
+type fixedTraces struct {
+	r io.ReaderAt
+
+	n                  int
+	offset, stride     int64
+	input, output      int64 // The offsets of the input and output in a record.
+	inputSize          int
+	samples, samplesAt int64 // The number of samples and their offset in a record.
+}
+
+func (ft *fixedTraces) Len() int { return ft.n }
+
+func (ft *fixedTraces) Trace(i int) (Trace, error) {
+	if i < 0 || i >= ft.n {
+		return Trace{}, fmt.Errorf("trace %v is out of range [0, %v)", i, ft.n)
+	}
+
+	start := ft.offset + int64(i)*ft.stride
+	t := Trace{
+		Input:   make([]byte, ft.inputSize),
+		Output:  make([]byte, ft.inputSize),
+		Samples: make([]byte, ft.samples),
+	}
+
+	for _, part := range []struct {
+		dst []byte
+		off int64
+	}{{t.Input, ft.input}, {t.Output, ft.output}, {t.Samples, ft.samplesAt}} {
+		if _, err := ft.r.ReadAt(part.dst, start+part.off); err != nil {
+			return Trace{}, err
+		}
+	}
+
+	return t, nil
+}
+
+// TRS tags. Each is followed by a length and then a little-endian value.
+const (
+	trsTraces     = 0x41 // NT: the number of traces.
+	trsSamples    = 0x42 // NS: the number of samples in each trace.
+	trsCoding     = 0x43 // SC: the coding of samples; only single bytes are supported.
+	trsData       = 0x44 // DS: the length of the data in each trace.
+	trsTitleSpace = 0x45 // TS: the length of the title of each trace.
+	trsEnd        = 0x5f // TB: the end of the header.
+)
+
+// ReadTRS reads traces in Riscure's TRS format, as written by Deadpool's tracers: a header of tagged fields, followed by
+// one record per trace of a title, the data, and the samples. The data is the input followed by the output, so it must
+// have even length. Only single-byte samples are supported. size is the size of the file, which the header is checked
+// against before anything it describes is read.
+func ReadTRS(r io.ReaderAt, size int64) (TraceSet, error) {
+	header := map[byte]int64{trsCoding: 1}
+	offset := int64(0)
+
+	read := func(n int64) ([]byte, error) {
+		if n < 0 || n > size-offset {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		buf := make([]byte, n)
+		_, err := r.ReadAt(buf, offset)
+		offset += n
+		return buf, err
+	}
+
+	for {
+		tl, err := read(2)
+		if err != nil {
+			return nil, fmt.Errorf("reading TRS header: %v", err)
+		}
+
+		tag, length := tl[0], int64(tl[1])
+		if length&0x80 != 0 {
+			if length&0x7f > 4 {
+				return nil, fmt.Errorf("TRS field %#x has a %v-byte length", tag, length&0x7f)
+			}
+
+			ext, err := read(length & 0x7f)
+			if err != nil {
+				return nil, fmt.Errorf("reading TRS header: %v", err)
+			}
+
+			length = 0
+			for i := len(ext) - 1; i >= 0; i-- {
+				length = length<<8 | int64(ext[i])
+			}
+		}
+
+		value, err := read(length)
+		if err != nil {
+			return nil, fmt.Errorf("reading TRS header: %v", err)
+		}
+
+		if tag == trsEnd {
+			break
+		}
+
+		switch tag {
+		case trsTraces, trsSamples, trsCoding, trsData, trsTitleSpace:
+			if len(value) > 4 {
+				return nil, fmt.Errorf("TRS field %#x has a %v-byte value", tag, len(value))
+			}
+		default:
+			continue
+		}
+
+		v := int64(0)
+		for i := len(value) - 1; i >= 0; i-- {
+			v = v<<8 | int64(value[i])
+		}
+		header[tag] = v
+	}
+
+	if header[trsCoding] != 1 {
+		return nil, fmt.Errorf("TRS sample coding %#x isn't single bytes", header[trsCoding])
+	} else if header[trsData]%2 != 0 {
+		return nil, fmt.Errorf("TRS data length %v isn't even", header[trsData])
+	}
+
+	// Each field is at most 32 bits, so the stride can't overflow.
+	title, data, n := header[trsTitleSpace], header[trsData], header[trsTraces]
+	stride := title + data + header[trsSamples]
+	if stride > 0 && n > (size-offset)/stride {
+		return nil, fmt.Errorf("TRS file of %v bytes is too short for %v traces of %v bytes", size, n, stride)
+	}
+
+	return &fixedTraces{
+		r:         r,
+		n:         int(n),
+		offset:    offset,
+		stride:    stride,
+		input:     title,
+		output:    title + data/2,
+		inputSize: int(data / 2),
+		samples:   header[trsSamples],
+		samplesAt: title + data,
+	}, nil
+}
+
+// WriteTRS writes traces in Riscure's TRS format, so that ReadTRS can read them.
+func WriteTRS(w io.Writer, ts TraceSet) error {
+	if ts.Len() == 0 {
+		return errors.New("can't write an empty set of traces")
+	}
+
+	first, err := ts.Trace(0)
+	if err != nil {
+		return err
+	}
+
+	header := []byte{}
+	field := func(tag byte, v uint32, size int) {
+		header = append(header, tag, byte(size))
+		for i := 0; i < size; i++ {
+			header = append(header, byte(v>>(8*uint(i))))
+		}
+	}
+	field(trsTraces, uint32(ts.Len()), 4)
+	field(trsSamples, uint32(len(first.Samples)), 4)
+	field(trsCoding, 1, 1)
+	field(trsData, uint32(len(first.Input)+len(first.Output)), 2)
+	field(trsTitleSpace, 0, 1)
+	header = append(header, trsEnd, 0)
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	for i := 0; i < ts.Len(); i++ {
+		t, err := ts.Trace(i)
+		if err != nil {
+			return err
+		} else if len(t.Samples) != len(first.Samples) || len(t.Input) != len(first.Input) || len(t.Output) != len(first.Output) {
+			return fmt.Errorf("trace %v has a different shape than the first", i)
+		}
+
+		for _, part := range [][]byte{t.Input, t.Output, t.Samples} {
+			if _, err := w.Write(part); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// DaredevilConfig is the part of a Daredevil configuration file that describes its traces and guesses. Daredevil keeps
+// samples and inputs in separate raw files, ntraces rows each.
+type DaredevilConfig struct {
+	Traces, Guesses      string // The paths of the files of samples and of inputs.
+	NTraces, NSamples    int
+	GuessSize            int
+	TraceType, GuessType byte // 'u' or 'i' for unsigned or signed bytes. Floats aren't supported.
+}
+
+var daredevilFile = regexp.MustCompile(`^(\S+)\s+(\d+)\s+(\d+)$`)
+
+// ParseDaredevilConfig parses the trace and guess lines of a Daredevil configuration file.
+func ParseDaredevilConfig(r io.Reader) (cfg DaredevilConfig, err error) {
+	cfg.TraceType, cfg.GuessType = 'u', 'u'
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == '[' {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+
+		switch key {
+		case "trace", "guess":
+			m := daredevilFile.FindStringSubmatch(value)
+			if m == nil {
+				return cfg, fmt.Errorf("can't parse Daredevil line %q", line)
+			}
+			rows, _ := strconv.Atoi(m[2])
+			cols, _ := strconv.Atoi(m[3])
+
+			if key == "trace" {
+				cfg.Traces, cfg.NTraces, cfg.NSamples = m[1], rows, cols
+			} else {
+				cfg.Guesses, cfg.GuessSize = m[1], cols
+			}
+		case "trace_type", "guess_type":
+			if value != "u" && value != "i" {
+				return cfg, fmt.Errorf("Daredevil %v %q isn't bytes", key, value)
+			} else if key == "trace_type" {
+				cfg.TraceType = value[0]
+			} else {
+				cfg.GuessType = value[0]
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return cfg, err
+	} else if cfg.Traces == "" || cfg.Guesses == "" {
+		return cfg, errors.New("Daredevil configuration is missing its trace or guess file")
+	}
+
+	return cfg, nil
+}
+
+// pairedTraces is a TraceSet stored as two files of fixed-size rows: one of samples and one of inputs.
+type pairedTraces struct {
+	samples, inputs *fixedTraces
+}
+
+func (pt pairedTraces) Len() int { return pt.samples.n }
+
+func (pt pairedTraces) Trace(i int) (Trace, error) {
+	t, err := pt.samples.Trace(i)
+	if err != nil {
+		return Trace{}, err
+	}
+
+	in, err := pt.inputs.Trace(i)
+	if err != nil {
+		return Trace{}, err
+	}
+	t.Input, t.Output = in.Samples, nil
+
+	return t, nil
+}
+
+// ReadDaredevil reads the traces a Daredevil configuration describes, from its files of samples and of inputs. Daredevil
+// doesn't keep outputs, so they're left empty.
+func ReadDaredevil(cfg DaredevilConfig, samples, inputs io.ReaderAt) (TraceSet, error) {
+	return pairedTraces{
+		samples: &fixedTraces{r: samples, n: cfg.NTraces, stride: int64(cfg.NSamples), samples: int64(cfg.NSamples)},
+		inputs:  &fixedTraces{r: inputs, n: cfg.NTraces, stride: int64(cfg.GuessSize), samples: int64(cfg.GuessSize)},
+	}, nil
+}
+
+// maxNpyHeader is the longest header readNpy accepts. NumPy pads headers to a multiple of 64 bytes, and the header of a
+// two-dimensional array of bytes is well under a kilobyte.
+const maxNpyHeader = 1 << 16
+
+var npyShape = regexp.MustCompile(`'shape':\s*\((\d+),\s*(\d+)\)`)
+
+// readNpy parses the header of a two-dimensional NumPy array of bytes and returns it as rows of samples.
+func readNpy(r io.ReaderAt) (*fixedTraces, error) {
+	prefix := make([]byte, 10)
+	if _, err := r.ReadAt(prefix, 0); err != nil {
+		return nil, fmt.Errorf("reading npy header: %v", err)
+	} else if string(prefix[:6]) != "\x93NUMPY" {
+		return nil, errors.New("not an npy file")
+	}
+
+	offset, length := int64(10), int64(binary.LittleEndian.Uint16(prefix[8:]))
+	if prefix[6] >= 2 {
+		ext := make([]byte, 2)
+		if _, err := r.ReadAt(ext, 10); err != nil {
+			return nil, fmt.Errorf("reading npy header: %v", err)
+		}
+		offset, length = 12, length|int64(binary.LittleEndian.Uint16(ext))<<16
+	}
+
+	if length > maxNpyHeader {
+		return nil, fmt.Errorf("npy header of %v bytes is longer than %v", length, maxNpyHeader)
+	}
+
+	header := make([]byte, length)
+	if _, err := r.ReadAt(header, offset); err != nil {
+		return nil, fmt.Errorf("reading npy header: %v", err)
+	}
+
+	h := string(header)
+	if !strings.Contains(h, "'|u1'") && !strings.Contains(h, "'|i1'") {
+		return nil, fmt.Errorf("npy array %v isn't of bytes", h)
+	} else if strings.Contains(h, "'fortran_order': True") {
+		return nil, errors.New("npy array is in Fortran order")
+	}
+
+	m := npyShape.FindStringSubmatch(h)
+	if m == nil {
+		return nil, fmt.Errorf("npy array %v isn't two-dimensional", h)
+	}
+	rows, _ := strconv.Atoi(m[1])
+	cols, _ := strconv.Atoi(m[2])
+
+	return &fixedTraces{r: r, n: rows, offset: offset + length, stride: int64(cols), samples: int64(cols)}, nil
+}
+
+// ReadNpy reads traces from two NumPy arrays of bytes, one of samples and one of inputs, with a row for each trace.
+func ReadNpy(samples, inputs io.ReaderAt) (TraceSet, error) {
+	s, err := readNpy(samples)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := readNpy(inputs)
+	if err != nil {
+		return nil, err
+	} else if in.n != s.n {
+		return nil, fmt.Errorf("%v rows of samples, but %v rows of inputs", s.n, in.n)
+	}
+
+	return pairedTraces{samples: s, inputs: in}, nil
+}
@@ -0,0 +1,90 @@
+// Package dfa simulates faults in block ciphers, so that differential fault analyses can be developed and tested
+// without fault injection equipment.
+//
+// A cipher is given as the layers it applies in order--an spn.Construction, for example--and an Injector replaces one
+// layer's output with a faulty one. Faults model the effects that are common in practice: a random byte of the state
+// changing, a byte stuck at a value, and an instruction skip, which is approximated by skipping the whole layer.
+//
+// "Differential Fault Analysis of Secret Key Cryptosystems" by Eli Biham and Adi Shamir,
+// http://link.springer.com/chapter/10.1007%2FBFb0052259
+package dfa
+
+import (
+	"io"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+)
+
+// Fault is a way for a layer of a cipher to go wrong.
+type Fault interface {
+	// Apply returns the output of layer on in, with the fault injected.
+	Apply(layer encoding.Block, in [16]byte) [16]byte
+}
+
+// RandomByte XORs a random nonzero byte into position Pos of the layer's output, or into a random position if Pos is
+// negative. Randomness is read from Rand.
+type RandomByte struct {
+	Pos  int
+	Rand io.Reader
+}
+
+func (rb RandomByte) Apply(layer encoding.Block, in [16]byte) [16]byte {
+	out, buf := layer.Encode(in), [2]byte{}
+
+	for buf[1] == 0 {
+		rb.Rand.Read(buf[:])
+	}
+
+	pos := rb.Pos
+	if pos < 0 {
+		pos = int(buf[0] % 16)
+	}
+	out[pos] ^= buf[1]
+
+	return out
+}
+
+// StuckAt sets position Pos of the layer's output to Value.
+type StuckAt struct {
+	Pos   int
+	Value byte
+}
+
+func (sa StuckAt) Apply(layer encoding.Block, in [16]byte) [16]byte {
+	out := layer.Encode(in)
+	out[sa.Pos] = sa.Value
+
+	return out
+}
+
+// Skip skips the layer, passing its input through unchanged.
+type Skip struct{}
+
+func (Skip) Apply(layer encoding.Block, in [16]byte) [16]byte { return in }
+
+// Injector implements encoding.Block over the layers of a cipher, injecting Fault into the layer with index Layer every
+// time it encrypts. Decode doesn't inject faults. In an iterated SPN from constructions/spn.NewRoundSPN, the S-box layer
+// of round r has index 2r-1 and its affine layer index 2r.
+type Injector struct {
+	Layers encoding.ComposedBlocks
+	Layer  int
+	Fault  Fault
+}
+
+// Encode implements encoding.Block.
+func (inj Injector) Encode(in [16]byte) [16]byte {
+	for i, layer := range inj.Layers {
+		if i == inj.Layer {
+			in = inj.Fault.Apply(layer, in)
+		} else {
+			in = layer.Encode(in)
+		}
+	}
+
+	return in
+}
+
+// Decode implements encoding.Block.
+func (inj Injector) Decode(in [16]byte) [16]byte {
+	return inj.Layers.Decode(in)
+}
@@ -0,0 +1,63 @@
+package dfa
+
+import (
+	"testing"
+
+	"crypto/rand"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+
+	"github.com/OpenWhiteBox/Generic/constructions/spn"
+)
+
+func diff(a, b [16]byte) (positions []int) {
+	for i := range a {
+		if a[i] != b[i] {
+			positions = append(positions, i)
+		}
+	}
+
+	return
+}
+
+func TestInjector(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SASAS)
+	layers := encoding.ComposedBlocks(constr)
+	last := len(layers) - 1
+
+	pt := [16]byte{}
+	rand.Read(pt[:])
+	ct := layers.Encode(pt)
+
+	// A fault in the last layer only changes the byte it hits.
+	faulty := Injector{Layers: layers, Layer: last, Fault: RandomByte{Pos: 3, Rand: rand.Reader}}
+	if d := diff(faulty.Encode(pt), ct); len(d) != 1 || d[0] != 3 {
+		t.Fatalf("Random byte fault changed positions %v, not 3.", d)
+	} else if faulty.Decode(ct) != pt {
+		t.Fatal("Decryption was faulted.")
+	}
+
+	faulty.Fault = RandomByte{Pos: -1, Rand: rand.Reader}
+	if d := diff(faulty.Encode(pt), ct); len(d) != 1 {
+		t.Fatalf("Random byte fault at a random position changed positions %v.", d)
+	}
+
+	faulty.Fault = StuckAt{Pos: 7, Value: ct[7] ^ 1}
+	if out := faulty.Encode(pt); out[7] != ct[7]^1 || len(diff(out, ct)) != 1 {
+		t.Fatalf("Stuck-at fault gave %x from %x.", out, ct)
+	}
+
+	// Skipping a layer is the same as leaving it out.
+	faulty = Injector{Layers: layers, Layer: 2, Fault: Skip{}}
+	skipped := append(encoding.ComposedBlocks{}, layers[:2]...)
+	skipped = append(skipped, layers[3:]...)
+	if faulty.Encode(pt) != skipped.Encode(pt) {
+		t.Fatal("Skipping a layer didn't leave it out.")
+	}
+
+	// A fault in an earlier layer spreads through the affine layer after it.
+	faulty = Injector{Layers: layers, Layer: last - 2, Fault: RandomByte{Pos: 0, Rand: rand.Reader}}
+	if d := diff(faulty.Encode(pt), ct); len(d) < 12 {
+		t.Fatalf("Early fault only changed positions %v.", d)
+	}
+}
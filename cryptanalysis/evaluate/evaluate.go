@@ -0,0 +1,241 @@
+// Package evaluate assesses how well a white-box implementation resists the attacks in this repository. It runs every
+// attack the evaluator has the access for--structural attacks through an encryption oracle, trace analyses through
+// computation traces, and fault analyses through the implementation's layers--and reports which succeeded and what
+// they cost.
+package evaluate
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+
+	"github.com/OpenWhiteBox/Generic/constructions/spn"
+	"github.com/OpenWhiteBox/Generic/cryptanalysis/dca"
+	"github.com/OpenWhiteBox/Generic/cryptanalysis/dfa"
+	cspn "github.com/OpenWhiteBox/Generic/cryptanalysis/spn"
+)
+
+// traceWidth is the width of the windows the decoding analyses look at.
+const traceWidth = 4
+
+// faultTrials is the number of faulty encryptions made for each layer.
+const faultTrials = 8
+
+// Target is a white-box implementation under evaluation, with the access to it that the evaluator has. Attacks that
+// need access that isn't given are skipped.
+type Target struct {
+	// Cipher is the implementation, as an oracle. Decode is only called if Capabilities includes ChosenCiphertext.
+	Cipher       encoding.Block
+	Capabilities cspn.Capability
+
+	// Structure is the implementation's structure, or cryptanalysis/spn.UnknownStructure.
+	Structure spn.Structure
+
+	// Traces are computation traces of encryptions by the implementation, or nil.
+	Traces dca.TraceSet
+
+	// Layers are the implementation's layers in the order they're applied, for simulating faults in them, or nil.
+	Layers encoding.ComposedBlocks
+}
+
+// Finding is the outcome of one attack.
+type Finding struct {
+	Attack    string
+	Succeeded bool
+	Cost      int // Queries made to the oracle, traces analyzed, or faulty encryptions.
+	Duration  time.Duration
+	Detail    string
+	Err       error
+}
+
+// Report is the outcome of every attack run against an implementation.
+type Report []Finding
+
+// Resistant returns true if no attack succeeded.
+func (r Report) Resistant() bool {
+	for _, f := range r {
+		if f.Succeeded {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String implements fmt.Stringer with one line per attack.
+func (r Report) String() string {
+	out := &strings.Builder{}
+
+	for _, f := range r {
+		outcome := "resisted"
+		if f.Succeeded {
+			outcome = "SUCCEEDED"
+		}
+
+		fmt.Fprintf(out, "%-40v %-9v cost %-9v %v", f.Attack, outcome, f.Cost, f.Duration.Round(time.Millisecond))
+		if f.Detail != "" {
+			fmt.Fprintf(out, "; %v", f.Detail)
+		}
+		if f.Err != nil {
+			fmt.Fprintf(out, "; %v", f.Err)
+		}
+		fmt.Fprintln(out)
+	}
+
+	return out.String()
+}
+
+// countingBlock counts the queries made to a cipher.
+type countingBlock struct {
+	encoding.Block
+	queries *int64
+}
+
+func (cb countingBlock) Encode(in [16]byte) [16]byte {
+	atomic.AddInt64(cb.queries, 1)
+	return cb.Block.Encode(in)
+}
+
+func (cb countingBlock) Decode(in [16]byte) [16]byte {
+	atomic.AddInt64(cb.queries, 1)
+	return cb.Block.Decode(in)
+}
+
+// Evaluate runs every applicable attack against target, one after the other, and reports on each. It stops early with
+// the findings so far if ctx is done.
+func Evaluate(ctx context.Context, target Target) (report Report) {
+	if target.Cipher != nil {
+		for _, r := range cspn.Applicable(target.Structure, target.Capabilities) {
+			if ctx.Err() != nil {
+				return
+			}
+			report = append(report, structural(ctx, target.Cipher, r))
+		}
+	}
+
+	if target.Traces != nil && ctx.Err() == nil {
+		report = append(report, traces(target.Traces)...)
+	}
+
+	if target.Layers != nil && ctx.Err() == nil {
+		report = append(report, faults(target.Layers))
+	}
+
+	return
+}
+
+// structural runs a structural attack through the oracle. It succeeds if it removes any layer of the cipher.
+func structural(ctx context.Context, cipher encoding.Block, r cspn.Registration) Finding {
+	queries, start := int64(0), time.Now()
+	res, err := r.New().Run(ctx, countingBlock{cipher, &queries})
+
+	f := Finding{Attack: r.Name, Cost: int(queries), Duration: time.Since(start), Err: err}
+	if err == nil {
+		f.Succeeded = true
+		f.Detail = fmt.Sprintf("removed %v layers", len(res.Layers))
+	}
+
+	return f
+}
+
+// traces runs LDA and MIA against the first round's S-boxes. LDA succeeds at a position if it finds a decoder; MIA if
+// its best guess has at least twice the mutual information of any other.
+func traces(ts dca.TraceSet) (out []Finding) {
+	lda, mia := Finding{Attack: "linear decoding analysis"}, Finding{Attack: "mutual information analysis"}
+	ldaKey, miaKey, ldaFound, miaFound := [16]byte{}, [16]byte{}, 0, 0
+
+	start := time.Now()
+	for pos := 0; pos < 16; pos++ {
+		d, err := dca.LDA(ts, dca.SubBytes{Pos: pos}, traceWidth)
+		if err == nil {
+			ldaKey[pos], ldaFound = d.Key, ldaFound+1
+		} else if err != dca.ErrNoDecoder {
+			lda.Err = err
+			break
+		}
+	}
+	lda.Duration, lda.Cost = time.Since(start), ts.Len()
+
+	start = time.Now()
+	for pos := 0; pos < 16; pos++ {
+		d, scores, err := dca.MIA(ts, dca.SubBytes{Pos: pos})
+		if err != nil {
+			mia.Err = err
+			break
+		}
+
+		distinct := true
+		for k, s := range scores {
+			distinct = distinct && (byte(k) == d.Key || 2*s <= scores[d.Key])
+		}
+		if distinct {
+			miaKey[pos], miaFound = d.Key, miaFound+1
+		}
+	}
+	mia.Duration, mia.Cost = time.Since(start), ts.Len()
+
+	for _, c := range []struct {
+		f     *Finding
+		key   [16]byte
+		found int
+	}{{&lda, ldaKey, ldaFound}, {&mia, miaKey, miaFound}} {
+		c.f.Succeeded = c.found > 0
+		c.f.Detail = fmt.Sprintf("recovered %v of 16 key bytes", c.found)
+		if c.found == 16 {
+			c.f.Detail += fmt.Sprintf(" (%x)", c.key)
+		}
+	}
+
+	return []Finding{lda, mia}
+}
+
+// faults injects random byte faults into the output of each layer that's followed by an S-box layer, and counts the
+// output bytes they change. Differential fault analysis needs a fault to reach the last S-box layer in only some of its
+// positions, so the attack succeeds if any layer's faults change fewer than every output byte.
+func faults(layers encoding.ComposedBlocks) Finding {
+	f, start := Finding{Attack: "fault propagation"}, time.Now()
+	fewest := 17
+
+	for i := range layers {
+		followed := false
+		for _, later := range layers[i+1:] {
+			_, ok := later.(encoding.ConcatenatedBlock)
+			followed = followed || ok
+		}
+		if !followed {
+			continue
+		}
+
+		faulty := dfa.Injector{Layers: layers, Layer: i, Fault: dfa.RandomByte{Pos: -1, Rand: rand.Reader}}
+		for t := 0; t < faultTrials; t++ {
+			pt := [16]byte{}
+			rand.Reader.Read(pt[:])
+
+			good, bad, changed := layers.Encode(pt), faulty.Encode(pt), 0
+			for j := range good {
+				if good[j] != bad[j] {
+					changed++
+				}
+			}
+			if changed < fewest {
+				fewest = changed
+			}
+			f.Cost++
+		}
+	}
+
+	f.Duration = time.Since(start)
+	if fewest <= 16 {
+		f.Succeeded = fewest < 16
+		f.Detail = fmt.Sprintf("a single byte fault changed as few as %v output bytes", fewest)
+	} else {
+		f.Detail = "no layer is followed by an S-box layer"
+	}
+
+	return f
+}
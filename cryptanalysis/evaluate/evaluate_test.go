@@ -0,0 +1,68 @@
+package evaluate
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"crypto/rand"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+
+	"github.com/OpenWhiteBox/Generic/constructions/spn"
+	"github.com/OpenWhiteBox/Generic/cryptanalysis/dca"
+	cspn "github.com/OpenWhiteBox/Generic/cryptanalysis/spn"
+)
+
+func TestEvaluate(t *testing.T) {
+	if report := Evaluate(context.Background(), Target{}); len(report) != 0 || !report.Resistant() {
+		t.Fatalf("Evaluated a target without access as %v.", report)
+	}
+
+	// Traces that write out the first round's S-box outputs directly.
+	key, s := [16]byte{}, dca.SubBytes{}
+	rand.Read(key[:])
+
+	ts := dca.Traces{}
+	for i := 0; i < 8*traceWidth+41; i++ {
+		tr := dca.Trace{Input: make([]byte, 16), Samples: make([]byte, 16)}
+		rand.Read(tr.Input)
+
+		for pos := range tr.Samples {
+			s.Pos = pos
+			tr.Samples[pos] = byte(s.Predict(tr, key[pos]))
+		}
+
+		ts = append(ts, tr)
+	}
+
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+	report := Evaluate(context.Background(), Target{
+		Cipher:       encoding.ComposedBlocks(constr),
+		Capabilities: cspn.ChosenPlaintext,
+		Structure:    spn.SA,
+		Traces:       ts,
+		Layers:       encoding.ComposedBlocks(spn.NewSPN(rand.Reader, spn.SASAS)),
+	})
+
+	if report.Resistant() {
+		t.Fatalf("Evaluated a weak target as resistant: %v", report)
+	}
+
+	found := map[string]Finding{}
+	for _, f := range report {
+		found[f.Attack] = f
+	}
+
+	if f := found["SA decomposition"]; !f.Succeeded || f.Cost == 0 {
+		t.Fatalf("SA decomposition: %+v", f)
+	} else if f := found["linear decoding analysis"]; !f.Succeeded || !strings.Contains(f.Detail, "16 of 16") {
+		t.Fatalf("LDA: %+v", f)
+	} else if f := found["fault propagation"]; !f.Succeeded {
+		t.Fatalf("Fault propagation: %+v", f)
+	}
+
+	if !strings.Contains(report.String(), "SUCCEEDED") {
+		t.Fatalf("Report doesn't show successes:\n%v", report)
+	}
+}
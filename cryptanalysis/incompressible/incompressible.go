@@ -0,0 +1,66 @@
+// Package incompressible measures how well incompressible white-box designs, like SPACE and SPNbox, resist code
+// lifting.
+//
+// These designs make every encryption look up entries of a large table derived from the key, so that an attacker who
+// lifts the implementation needs the whole table to encrypt arbitrary plaintexts. The table is built from a block
+// cipher like AES, so recovering the key from it is as hard as breaking that block cipher, and isn't attempted here.
+// What can be measured is how much of the table an attacker has to lift to encrypt a given fraction of plaintexts.
+//
+// "White-Box Cryptography Revisited: Space-Hard Ciphers" by Andrey Bogdanov and Takanori Isobe,
+// https://eprint.iacr.org/2015/1099.pdf
+//
+// "Efficient and Provable White-Box Primitives" by Pierre-Alain Fouque, Pierre Karpman, Paul Kirchner, and Brice
+// Minaud, https://eprint.iacr.org/2016/642.pdf
+package incompressible
+
+import (
+	"io"
+	"math"
+)
+
+// Hardness returns the base-2 logarithm of the probability that an attacker who has lifted lifted of a table's entries
+// can encrypt a random plaintext, when each encryption makes lookups independent, uniformly distributed lookups into
+// it. A design is (M, Z)-space hard when Hardness(entries, M, lookups) is at most -Z.
+func Hardness(entries, lifted, lookups int) float64 {
+	if lifted >= entries {
+		return 0
+	} else if lifted <= 0 {
+		return math.Inf(-1)
+	}
+
+	return float64(lookups) * math.Log2(float64(lifted)/float64(entries))
+}
+
+// RequiredLifting returns the number of a table's entries an attacker has to lift to encrypt a 2^-z fraction of
+// plaintexts, when each encryption makes lookups independent, uniformly distributed lookups into it.
+func RequiredLifting(entries, lookups int, z float64) int {
+	fraction := math.Exp2(-z / float64(lookups))
+	return int(math.Ceil(fraction * float64(entries)))
+}
+
+// MeasureLifting estimates the fraction of plaintexts a lifted implementation can encrypt, by running encrypt on
+// samples random plaintexts from rand and counting those whose lookups are all in lifted. encrypt should report the
+// index of every table entry it looks up through lookup.
+func MeasureLifting(
+	encrypt func(pt []byte, lookup func(int)), blockSize int, lifted map[int]bool, samples int, rand io.Reader,
+) float64 {
+	ok := 0
+
+	for i := 0; i < samples; i++ {
+		pt := make([]byte, blockSize)
+		rand.Read(pt)
+
+		hit := true
+		encrypt(pt, func(entry int) {
+			if !lifted[entry] {
+				hit = false
+			}
+		})
+
+		if hit {
+			ok++
+		}
+	}
+
+	return float64(ok) / float64(samples)
+}
@@ -0,0 +1,37 @@
+package incompressible
+
+import (
+	"testing"
+
+	"crypto/rand"
+	"math"
+)
+
+func TestHardness(t *testing.T) {
+	// SPACE-8 makes 300 lookups into a table of 2^8 entries. Lifting a quarter of them encrypts a 2^-600 fraction.
+	if h := Hardness(256, 64, 300); h != -600 {
+		t.Fatalf("Hardness is %v, not -600.", h)
+	}
+
+	if m := RequiredLifting(256, 300, 600); m != 64 {
+		t.Fatalf("Required lifting is %v entries, not 64.", m)
+	}
+}
+
+func TestMeasureLifting(t *testing.T) {
+	// A toy design making two lookups into a table of 16 entries, indexed by the low nibble of the first two bytes.
+	encrypt := func(pt []byte, lookup func(int)) {
+		lookup(int(pt[0] & 0x0f))
+		lookup(int(pt[1] & 0x0f))
+	}
+
+	lifted := map[int]bool{}
+	for i := 0; i < 8; i++ {
+		lifted[i] = true
+	}
+
+	got := MeasureLifting(encrypt, 16, lifted, 4096, rand.Reader)
+	if want := math.Exp2(Hardness(16, 8, 2)); math.Abs(got-want) > 0.05 {
+		t.Fatalf("Measured %v of plaintexts encrypting, expected about %v.", got, want)
+	}
+}
@@ -0,0 +1,90 @@
+package spn
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+
+	"github.com/OpenWhiteBox/primitives/matrix"
+)
+
+// AES is AES as an encoding.Block, for checking recovered keys against and as a quick synthetic target. It's crypto/aes
+// underneath, so it runs on the CPU's AES instructions--AES-NI on amd64, the ARMv8 Cryptography Extensions on
+// arm64--where there are some, and on a constant-time software implementation elsewhere.
+type AES struct{ block cipher.Block }
+
+// NewAES returns AES with the given 16-, 24-, or 32-byte key.
+func NewAES(key []byte) (AES, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return AES{}, err
+	}
+
+	return AES{block}, nil
+}
+
+func (a AES) Encode(in [16]byte) (out [16]byte) {
+	a.block.Encrypt(out[:], in[:])
+	return
+}
+
+func (a AES) Decode(in [16]byte) (out [16]byte) {
+	a.block.Decrypt(out[:], in[:])
+	return
+}
+
+// aesShiftRows returns the position of the state that moves to position i under ShiftRows. Positions are numbered
+// column by column, like the bytes of an AES block.
+func aesShiftRows(i int) int {
+	row, col := i%4, i/4
+	return row + 4*((col+row)%4)
+}
+
+// aesMixColumn multiplies one column of the state by AES's MixColumns matrix.
+func aesMixColumn(in [4]byte) (out [4]byte) {
+	for row := range out {
+		out[row] = gfMul(2, in[row], aesPolynomial) ^ gfMul(3, in[(row+1)%4], aesPolynomial) ^ in[(row+2)%4] ^ in[(row+3)%4]
+	}
+
+	return
+}
+
+// aesLinear applies ShiftRows and then MixColumns to the state.
+func aesLinear(in [16]byte) (out [16]byte) {
+	for col := 0; col < 4; col++ {
+		column := [4]byte{}
+		for row := range column {
+			column[row] = in[aesShiftRows(4*col+row)]
+		}
+
+		column = aesMixColumn(column)
+		copy(out[4*col:], column[:])
+	}
+
+	return
+}
+
+// blockMatrix returns the 128-by-128 binary matrix of the linear map f.
+func blockMatrix(f func([16]byte) [16]byte) matrix.Matrix {
+	m := matrix.GenerateEmpty(128, 128)
+
+	for j := 0; j < 128; j++ {
+		in := [16]byte{}
+		in[j/8] = 1 << uint(j%8)
+		out := f(in)
+
+		for i := 0; i < 128; i++ {
+			if (out[i/8]>>uint(i%8))&1 == 1 {
+				m[i][j/8] |= 1 << uint(j%8)
+			}
+		}
+	}
+
+	return m
+}
+
+// AESLinearLayer returns the linear layer of an AES round, ShiftRows followed by MixColumns, as a 128-by-128 matrix
+// over GF(2). With the AES S-box, it makes constructions/spn.NewRoundSPN an AES-like cipher, and it's the diffusion
+// layer TargetedPermutationPlaintexts needs to attack one S-box of the second round of AES.
+func AESLinearLayer() matrix.Matrix {
+	return blockMatrix(aesLinear)
+}
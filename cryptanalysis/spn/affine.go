@@ -2,6 +2,8 @@ package spn
 
 import (
 	"crypto/rand"
+	"errors"
+	"fmt"
 
 	"github.com/OpenWhiteBox/primitives/encoding"
 	"github.com/OpenWhiteBox/primitives/matrix"
@@ -49,8 +51,18 @@ func findIntersection(aT, bT matrix.IncrementalMatrix) matrix.IncrementalMatrix
 	return out
 }
 
+// ErrNotEnoughSubspaces is returned when an attack on an affine layer can't find a subspace for every position, which
+// usually means the cipher doesn't have the structure it was attacked as.
+var ErrNotEnoughSubspaces = errors.New("failed to recover enough subspaces")
+
+// ErrSingularLayer is returned when an affine layer is recovered but isn't invertible, which means the subspaces it was
+// recovered from weren't the ones the attack was looking for.
+var ErrSingularLayer = errors.New("recovered affine layer isn't invertible")
+
 // trivialSubspaces generates subspaces by fixing one input and letting the rest vary.
-func trivialSubspaces(cipher encoding.Block) (subspaces []matrix.IncrementalMatrix) {
+func trivialSubspaces(cipher encoding.Block) (subspaces []matrix.IncrementalMatrix, err error) {
+	o := optionsOf(cipher)
+
 	for pos := 0; pos < 16; pos++ {
 		subspace := matrix.NewIncrementalMatrix(128)
 
@@ -65,11 +77,15 @@ func trivialSubspaces(cipher encoding.Block) (subspaces []matrix.IncrementalMatr
 			subspace.Add(matrix.Row(x[:]).Add(matrix.Row(y[:])))
 		}
 
+		o.metrics.Batch()
+
 		if subspace.Len() != 120 {
-			panic("Found incorrectly sized subspace!")
+			o.logger.Error("found incorrectly sized subspace", "position", pos, "rank", subspace.Len())
+			return nil, fmt.Errorf("%w: subspace at position %v has rank %v", ErrNotEnoughSubspaces, pos, subspace.Len())
 		}
 
 		subspaces = append(subspaces, subspace)
+		o.metrics.Subspaces(len(subspaces))
 	}
 
 	return
@@ -102,15 +118,18 @@ func nextByToggle(iteration, marker int, x, y [16]byte) (X, Y [16]byte) {
 }
 
 // lowRankDetectionWith is a wrapper around lowRankDetection which injects the right next function
-func lowRankDetectionWith(next nextFunc) func(encoding.Block) []matrix.IncrementalMatrix {
-	return func(cipher encoding.Block) []matrix.IncrementalMatrix {
+func lowRankDetectionWith(next nextFunc) func(encoding.Block) ([]matrix.IncrementalMatrix, error) {
+	return func(cipher encoding.Block) ([]matrix.IncrementalMatrix, error) {
 		return lowRankDetection(cipher, next)
 	}
 }
 
 // lowRankDetection generates subspaces by choosing random pairs of inputs and checking if the linear span of their
 // output is the right size.
-func lowRankDetection(cipher encoding.Block, next nextFunc) (subspaces []matrix.IncrementalMatrix) {
+func lowRankDetection(cipher encoding.Block, next nextFunc) (subspaces []matrix.IncrementalMatrix, err error) {
+	o := optionsOf(cipher)
+	o.ledger.markAdaptive()
+
 	for attempt := 0; attempt < 4000 && len(subspaces) < 16; attempt++ {
 		// Generate a random subspace.
 		x, y := [16]byte{}, [16]byte{}
@@ -126,8 +145,12 @@ func lowRankDetection(cipher encoding.Block, next nextFunc) (subspaces []matrix.
 			subspace.Add(matrix.Row(X[:]).Add(matrix.Row(Y[:])))
 		}
 
+		o.metrics.Batch()
+
 		// Discard it if it's the wrong size.
 		if subspace.Len() != 120 {
+			o.metrics.Retry()
+			o.logger.Debug("discarded subspace", "attempt", attempt, "reason", "wrong size", "rank", subspace.Len())
 			continue
 		}
 
@@ -143,29 +166,29 @@ func lowRankDetection(cipher encoding.Block, next nextFunc) (subspaces []matrix.
 		}
 
 		if dup {
+			o.metrics.Retry()
+			o.logger.Debug("discarded subspace", "attempt", attempt, "reason", "overlaps another")
 			continue
 		}
 
 		// Not discarded, so keep it.
 		subspaces = append(subspaces, subspace)
+		o.metrics.Subspaces(len(subspaces))
+		o.logger.Debug("found subspace", "attempt", attempt, "subspaces", len(subspaces))
 	}
 
 	if len(subspaces) < 16 {
-		panic("Failed to recover enough subspaces.")
+		o.logger.Error("failed to recover enough subspaces", "subspaces", len(subspaces))
+		return nil, fmt.Errorf("%w: found %v of 16", ErrNotEnoughSubspaces, len(subspaces))
 	}
 
 	return
 }
 
-// RecoverAffine finds inputs that cause the internal state of the cipher to collide with something like Low Rank
-// Detection and uses them to remove the trailing affine layer.
-func RecoverAffine(cipher encoding.Block, generator func(encoding.Block) []matrix.IncrementalMatrix) (last encoding.BlockAffine, rest encoding.Block) {
-	subspaces := generator(cipher)
-
-	// Recover span of each column by intersecting 15 others
-	m := matrix.Matrix{}
-
-	for excluded := 0; excluded < 16; excluded++ {
+// columnSpans recovers the span of the columns of the affine layer that each position goes through, by intersecting
+// the subspaces of the 15 other positions.
+func columnSpans(subspaces []matrix.IncrementalMatrix) (spans [16]matrix.IncrementalMatrix) {
+	for excluded := range spans {
 		remaining := []matrix.IncrementalMatrix{}
 
 		for i := 0; i < 16; i++ {
@@ -174,13 +197,38 @@ func RecoverAffine(cipher encoding.Block, generator func(encoding.Block) []matri
 			}
 		}
 
-		intersection := findIntersections(remaining)
+		spans[excluded] = findIntersections(remaining)
+	}
 
+	return
+}
+
+// RecoverAffine finds inputs that cause the internal state of the cipher to collide with something like Low Rank
+// Detection and uses them to remove the trailing affine layer. It returns an error wrapping ErrNotEnoughSubspaces if the
+// generator fails, or ErrSingularLayer if the subspaces it finds don't give an affine layer.
+func RecoverAffine(cipher encoding.Block, generator func(encoding.Block) ([]matrix.IncrementalMatrix, error), opts ...Option) (last encoding.BlockAffine, rest encoding.Block, err error) {
+	o := newOptions(opts)
+	o.ledger.begin("affine layer")
+
+	subspaces, err := generator(newOracle(cipher, o))
+	if err != nil {
+		return last, nil, err
+	}
+
+	m := matrix.Matrix{}
+	for _, span := range columnSpans(subspaces) {
 		for bit := uint(0); bit < 8; bit++ {
-			m = append(m, intersection.Row(1<<bit))
+			m = append(m, span.Row(1<<bit))
 		}
 	}
 
+	if _, ok := m.Transpose().Invert(); !ok {
+		return last, nil, ErrSingularLayer
+	}
+
 	last = encoding.NewBlockAffine(m.Transpose(), [16]byte{})
-	return last, encoding.ComposedBlocks{cipher, encoding.InverseBlock{last}}
+	structure, _ := ClassifyAffine(last)
+	o.logger.Debug("recovered affine layer", "structure", structure)
+
+	return last, encoding.ComposedBlocks{cipher, encoding.InverseBlock{last}}, nil
 }
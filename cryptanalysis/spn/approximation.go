@@ -0,0 +1,181 @@
+package spn
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/matrix"
+)
+
+var (
+	// ErrTooFewSamples is returned when the known plaintexts don't rule out approximations that don't hold, so the
+	// approximations of some S-box can't be told apart from them.
+	ErrTooFewSamples = errors.New("too few known plaintexts to pin down the S-box layer")
+
+	// ErrNoApproximations is returned when the known plaintexts rule out approximations that should hold, so the cipher
+	// doesn't end with an S-box layer after an affine layer.
+	ErrNoApproximations = errors.New("known plaintexts contradict every approximation of the S-box layer")
+)
+
+// LinearApproximation says that the parity of the plaintext bits selected by In equals Out of the byte at Position of
+// the ciphertext, with probability 1/2 + Bias.
+type LinearApproximation struct {
+	Position int
+	In       [16]byte
+	Out      [256]bool
+	Bias     float64
+}
+
+// holds returns true if the approximation holds for the given plaintext and ciphertext.
+func (a LinearApproximation) holds(pt, ct [16]byte) bool {
+	p := byte(0)
+	for i := range pt {
+		p ^= pt[i] & a.In[i]
+	}
+	p ^= p >> 4
+	p ^= p >> 2
+	p ^= p >> 1
+
+	return (p&1 == 1) == a.Out[ct[a.Position]]
+}
+
+// approximationRow is the relation a known plaintext and its ciphertext give the system of one position: the plaintext,
+// followed by an indicator of the ciphertext's byte at that position.
+func approximationRow(pt [16]byte, y byte) matrix.Row {
+	row := matrix.NewRow(128 + 256)
+	copy(row, pt[:])
+
+	return row.SetBit(128+int(y), true)
+}
+
+// RecoverSBoxesFromApproximations removes the trailing S-box layer of a cipher with structure SA from known plaintexts
+// alone. Every bit of the input to an S-box is the parity of some plaintext bits, so it's a linear approximation of the
+// cipher with bias 1/2, whose output side is a bit of the inverse S-box. Each plaintext and ciphertext gives the system
+// of each position one relation between the unknown input mask and output function, and the system's solutions are
+// exactly those approximations once there are enough samples--around 4096, so that every position has taken every
+// value.
+//
+// The last quarter of the samples is held out. The biases of the approximations that are returned are measured on it,
+// and Confidence.Agreement is the fraction of it that satisfies every approximation of a position.
+//
+// Rest is the linear layer the approximations' input masks make up, if every position was recovered and none were left
+// out with WithPositions.
+func RecoverSBoxesFromApproximations(pts, cts [][16]byte, opts ...Option) (*SBoxRecovery, []LinearApproximation, error) {
+	if len(pts) != len(cts) {
+		panic("Number of plaintexts doesn't match the number of ciphertexts!")
+	}
+
+	o := newOptions(opts)
+	ignored := o.ignored()
+	train := len(pts) - len(pts)/4
+
+	res, approxs := &SBoxRecovery{}, []LinearApproximation{}
+	failed := map[error][]int{}
+	linear := matrix.GenerateEmpty(128, 128)
+
+	for pos := range res.Last {
+		res.Last[pos] = encoding.IdentityByte{}
+		if ignored[pos] {
+			continue
+		}
+
+		system, seen := matrix.NewIncrementalMatrix(128+256), [256]bool{}
+		for i := 0; i < train; i++ {
+			system.Add(approximationRow(pts[i], cts[i][pos]))
+			seen[cts[i][pos]] = true
+		}
+
+		values := 0
+		for _, ok := range seen {
+			if ok {
+				values++
+			}
+		}
+
+		basis := system.Matrix().NullSpace()
+		if values < 256 || len(basis) > o.nullSpaceDim {
+			failed[ErrTooFewSamples] = append(failed[ErrTooFewSamples], pos)
+			continue
+		}
+
+		// The system is homogeneous, so it has the trivial solution of a zero input mask and the zero function, which the
+		// basis leaves out. Keep the solutions whose input masks are independent, so that none of them is trivial on the
+		// input side.
+		masks, picked := matrix.NewIncrementalMatrix(128), []LinearApproximation{}
+		for _, v := range basis {
+			a := LinearApproximation{Position: pos}
+			copy(a.In[:], v[:16])
+			if !masks.Add(matrix.Row(a.In[:]).Dup()) {
+				continue
+			}
+
+			for y := range a.Out {
+				a.Out[y] = v.GetBit(128+y) == 1
+			}
+			picked = append(picked, a)
+		}
+
+		// The inverse S-box's value at y is the output sides of the approximations, read as bits.
+		s, ok := encoding.SBox{}, len(picked) == 8
+		for y := 0; y < 256 && ok; y++ {
+			for i, a := range picked {
+				if a.Out[y] {
+					s.DecKey[y] |= 1 << uint(i)
+				}
+			}
+		}
+		for y, x := range s.DecKey {
+			s.EncKey[x] = byte(y)
+		}
+		for y, x := range s.DecKey {
+			ok = ok && s.EncKey[x] == byte(y)
+		}
+		if !ok {
+			failed[ErrNoApproximations] = append(failed[ErrNoApproximations], pos)
+			continue
+		}
+
+		agree, holds := 0, make([]int, len(picked))
+		for i := train; i < len(pts); i++ {
+			all := true
+			for j, a := range picked {
+				if a.holds(pts[i], cts[i]) {
+					holds[j]++
+				} else {
+					all = false
+				}
+			}
+			if all {
+				agree++
+			}
+		}
+
+		for i := range picked {
+			if held := len(pts) - train; held > 0 {
+				picked[i].Bias = float64(holds[i])/float64(held) - 0.5
+				res.Confidence[pos].Agreement = float64(agree) / float64(held)
+			}
+			linear[8*pos+i] = matrix.Row(picked[i].In[:]).Dup()
+		}
+
+		res.Last[pos], res.Recovered[pos] = s, true
+		res.Confidence[pos].NullSpaceDim = len(basis)
+		approxs = append(approxs, picked...)
+	}
+
+	for _, err := range []error{ErrTooFewSamples, ErrNoApproximations} {
+		if positions, ok := failed[err]; ok {
+			return res, approxs, fmt.Errorf("positions %v: %w", positions, err)
+		}
+	}
+
+	if len(o.positions) > 0 {
+		return res, approxs, nil
+	} else if _, ok := linear.Invert(); !ok {
+		return res, approxs, ErrSingularLayer
+	}
+	res.Rest = encoding.NewBlockLinear(linear)
+
+	return res, approxs, nil
+}
@@ -0,0 +1,49 @@
+package spn
+
+import (
+	"github.com/OpenWhiteBox/primitives/gfmatrix"
+)
+
+// arenaChunk is the number of rows an arena allocates at once.
+const arenaChunk = 256
+
+// arena hands out the 256-entry rows that batches of queries are turned into, for the lifetime of one attack. Rows are
+// carved from chunks of arenaChunk rows, and a row that turned out not to raise its position's rank can be handed back
+// and reused by a later batch, since nothing holds on to it. Late in an attack most rows are like that. The arena is
+// dropped wholesale, with every chunk, when the attack returns.
+//
+// It isn't safe for concurrent use. A nil arena allocates every row on its own.
+type arena struct {
+	chunk gfmatrix.Row
+	free  []gfmatrix.Row
+}
+
+// row returns a zero row of 256 entries.
+func (a *arena) row() gfmatrix.Row {
+	if a == nil {
+		return gfmatrix.NewRow(256)
+	} else if n := len(a.free); n > 0 {
+		r := a.free[n-1]
+		a.free = a.free[:n-1]
+
+		for i := range r {
+			r[i] = 0
+		}
+		return r
+	}
+
+	if len(a.chunk) == 0 {
+		a.chunk = make(gfmatrix.Row, 256*arenaChunk)
+	}
+	r := a.chunk[:256:256]
+	a.chunk = a.chunk[256:]
+
+	return r
+}
+
+// recycle hands back a row that nothing refers to anymore.
+func (a *arena) recycle(r gfmatrix.Row) {
+	if a != nil && len(r) == 256 {
+		a.free = append(a.free, r)
+	}
+}
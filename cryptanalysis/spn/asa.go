@@ -0,0 +1,47 @@
+package spn
+
+import (
+	"github.com/OpenWhiteBox/primitives/encoding"
+
+	"github.com/OpenWhiteBox/Generic/constructions/spn"
+)
+
+// ASA is the decomposition of a cipher with structure ASA: it computes Outer(SBoxes(Inner(x))).
+type ASA struct {
+	Inner  encoding.BlockAffine
+	SBoxes encoding.ConcatenatedBlock
+	Outer  encoding.BlockAffine
+}
+
+// Construction returns the decomposition as a constructions/spn.Construction.
+func (d ASA) Construction() spn.Construction {
+	return spn.Construction(encoding.ComposedBlocks{d.Inner, d.SBoxes, d.Outer})
+}
+
+// DecomposeASA recovers the two affine layers of a cipher with structure ASA and the S-box layer between them. It's the
+// base case that the decompositions of SASA and SASAS reduce to, once their trailing S-box layers are removed.
+//
+// The outer affine layer is removed with Low Rank Detection, which leaves an SA cipher. Its S-boxes are recovered with
+// the Cube attack, and the inner affine layer is read off what's left.
+func DecomposeASA(cipher encoding.Block, opts ...Option) (out ASA, err error) {
+	if out.Outer, cipher, err = RecoverAffine(cipher, lowRankDetectionWith(nextByAddition), opts...); err != nil {
+		return out, err
+	}
+
+	res, err := recoverSBoxes(cipher, BalancedPlaintexts(4), newOptions(opts), false)
+	if err != nil {
+		return out, err
+	}
+	out.SBoxes = res.Last
+
+	o := newOptions(opts)
+	o.ledger.begin("inner affine layer")
+
+	inner, ok := encoding.DecomposeBlockAffine(newOracle(res.Rest, o))
+	if !ok {
+		return out, ErrSingularLayer
+	}
+	out.Inner = inner
+
+	return out, nil
+}
@@ -0,0 +1,290 @@
+package spn
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/matrix"
+
+	"github.com/OpenWhiteBox/Generic/constructions/spn"
+)
+
+// Result is what an attack recovers from a cipher: the layers it removed, in the order they're applied in, and what's
+// left of the cipher once they're removed (the identity, if nothing is). Phases breaks down the queries the attack made
+// to the cipher by the step that made them.
+type Result struct {
+	Layers spn.Construction
+	Rest   encoding.Block
+	Phases []Phase
+}
+
+// Attack is implemented by every attack in this package, so that they can be planned and run without knowing which is
+// which.
+type Attack interface {
+	// Name describes the attack.
+	Name() string
+
+	// EstimatedQueries predicts how many queries the attack makes, from Estimate.
+	EstimatedQueries() int
+
+	// EstimatedComplexity predicts the queries, memory, and time the attack takes against a cipher with the given
+	// block and S-box widths (in bits).
+	EstimatedComplexity(blockWidth, sboxWidth int) Complexity
+
+	// Run runs the attack against cipher. It stops with ctx's error if ctx is done before the attack finishes.
+	Run(ctx context.Context, cipher encoding.Block) (Result, error)
+}
+
+// structureNames are the names of the structures in constructions/spn.
+var structureNames = map[spn.Structure]string{
+	spn.AS: "AS", spn.SA: "SA", spn.ASA: "ASA", spn.SAS: "SAS", spn.ASAS: "ASAS", spn.SASA: "SASA", spn.ASASA: "ASASA",
+	spn.SASAS: "SASAS",
+}
+
+// canceled is what a contextBlock panics with when its context is done.
+type canceled struct{ err error }
+
+// contextBlock is a cipher that stops the attack querying it once ctx is done. It sits between the attack and the real
+// cipher, so it's also where queries are recorded in the attack's ledger: attacks on the decryption direction encrypt
+// with an encoding.InverseBlock, which only shows up as a decryption here. Once the attack returns, the block is
+// detached: the Rest of its Result queries the cipher directly, however long it's used for after ctx is done.
+type contextBlock struct {
+	encoding.Block
+	ctx      context.Context
+	ledger   *ledger
+	detached *atomic.Bool
+}
+
+func (cb contextBlock) Encode(in [16]byte) [16]byte {
+	if cb.detached.Load() {
+		return cb.Block.Encode(in)
+	} else if err := cb.ctx.Err(); err != nil {
+		panic(canceled{err})
+	}
+
+	cb.ledger.record(false)
+	return cb.Block.Encode(in)
+}
+
+func (cb contextBlock) Decode(in [16]byte) [16]byte {
+	if cb.detached.Load() {
+		return cb.Block.Decode(in)
+	} else if err := cb.ctx.Err(); err != nil {
+		panic(canceled{err})
+	}
+
+	cb.ledger.record(true)
+	return cb.Block.Decode(in)
+}
+
+// EncodeBatch checks ctx once for the whole batch if the cipher is a BatchEncoder, so that it still gets the batch in one
+// call, and before each query otherwise.
+func (cb contextBlock) EncodeBatch(ins [][16]byte) [][16]byte {
+	if _, ok := cb.Block.(BatchEncoder); !ok {
+		outs := make([][16]byte, len(ins))
+		for i, in := range ins {
+			outs[i] = cb.Encode(in)
+		}
+		return outs
+	} else if cb.detached.Load() {
+		return encodeBatch(cb.Block, ins)
+	} else if err := cb.ctx.Err(); err != nil {
+		panic(canceled{err})
+	}
+
+	for range ins {
+		cb.ledger.record(false)
+	}
+	return encodeBatch(cb.Block, ins)
+}
+
+// runWithContext runs attack on cipher with opts, returning ctx's error instead if ctx is done before attack stops
+// querying it. The queries it makes are recorded in the Result's Phases.
+func runWithContext(ctx context.Context, cipher encoding.Block, opts []Option, attack func(encoding.Block, []Option) (Result, error)) (res Result, err error) {
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+
+	l, detached := &ledger{}, &atomic.Bool{}
+	opts = append(opts[:len(opts):len(opts)], withLedger(l))
+
+	defer func() {
+		detached.Store(true)
+
+		if r := recover(); r != nil {
+			c, ok := r.(canceled)
+			if !ok {
+				panic(r)
+			}
+
+			res, err = Result{}, c.err
+		}
+	}()
+
+	res, err = attack(contextBlock{cipher, ctx, l, detached}, opts)
+	res.Phases = l.Phases()
+
+	return res, err
+}
+
+// DecomposeAttack is DecomposeSPN, as an Attack.
+type DecomposeAttack struct {
+	Structure spn.Structure
+	Options   []Option
+}
+
+func (a DecomposeAttack) Name() string { return structureNames[a.Structure] + " decomposition" }
+
+func (a DecomposeAttack) EstimatedQueries() int { return EstimateSPN(a.Structure).Queries }
+
+func (a DecomposeAttack) EstimatedComplexity(blockWidth, sboxWidth int) Complexity {
+	return EstimateStructure(blockWidth, sboxWidth, a.Structure)
+}
+
+func (a DecomposeAttack) Run(ctx context.Context, cipher encoding.Block) (Result, error) {
+	return runWithContext(ctx, cipher, a.Options, func(cipher encoding.Block, opts []Option) (Result, error) {
+		constr, err := decomposeSPN(cipher, a.Structure, opts)
+		return Result{Layers: constr, Rest: encoding.IdentityBlock{}}, err
+	})
+}
+
+// AffineAttack is RecoverAffine with one of the generators of subspaces in this package, as an Attack.
+type AffineAttack struct {
+	Generator GeneratorType // TrivialSubspaceGenerator, LowRankAdditionGenerator, or LowRankToggleGenerator.
+	Options   []Option
+}
+
+func (a AffineAttack) generator() func(encoding.Block) ([]matrix.IncrementalMatrix, error) {
+	switch a.Generator {
+	case TrivialSubspaceGenerator:
+		return trivialSubspaces
+	case LowRankAdditionGenerator:
+		return lowRankDetectionWith(nextByAddition)
+	case LowRankToggleGenerator:
+		return lowRankDetectionWith(nextByToggle)
+	default:
+		panic("Not a generator of subspaces!")
+	}
+}
+
+func (a AffineAttack) Name() string { return fmt.Sprintf("affine layer recovery (%v)", a.Generator) }
+
+func (a AffineAttack) EstimatedQueries() int { return Estimate(128, 8, a.Generator).Queries }
+
+func (a AffineAttack) EstimatedComplexity(blockWidth, sboxWidth int) Complexity {
+	return Estimate(blockWidth, sboxWidth, a.Generator)
+}
+
+func (a AffineAttack) Run(ctx context.Context, cipher encoding.Block) (Result, error) {
+	generator := a.generator()
+
+	return runWithContext(ctx, cipher, a.Options, func(cipher encoding.Block, opts []Option) (Result, error) {
+		last, rest, err := RecoverAffine(cipher, generator, opts...)
+		return Result{Layers: spn.Construction{last}, Rest: rest}, err
+	})
+}
+
+// SBoxAttack is RecoverSBoxes with one of the generators of plaintexts in this package, as an Attack.
+type SBoxAttack struct {
+	Generator GeneratorType // BalancedGenerator, DualGenerator, or PermutationGenerator.
+	Options   []Option
+}
+
+func (a SBoxAttack) generator() Generator {
+	switch a.Generator {
+	case BalancedGenerator:
+		return BalancedPlaintexts(4)
+	case DualGenerator:
+		return DualPlaintexts(4)
+	case PermutationGenerator:
+		return PermutationPlaintexts(256)
+	default:
+		panic("Not a generator of plaintexts!")
+	}
+}
+
+func (a SBoxAttack) Name() string { return fmt.Sprintf("S-box layer recovery (%v)", a.Generator) }
+
+func (a SBoxAttack) EstimatedQueries() int { return Estimate(128, 8, a.Generator).Queries }
+
+func (a SBoxAttack) EstimatedComplexity(blockWidth, sboxWidth int) Complexity {
+	return Estimate(blockWidth, sboxWidth, a.Generator)
+}
+
+func (a SBoxAttack) Run(ctx context.Context, cipher encoding.Block) (Result, error) {
+	generator := a.generator()
+
+	return runWithContext(ctx, cipher, a.Options, func(cipher encoding.Block, opts []Option) (Result, error) {
+		last, rest, err := recoverSBoxLayer(cipher, generator, opts)
+		return Result{Layers: spn.Construction{last}, Rest: rest}, err
+	})
+}
+
+// LeadingSBoxAttack is RecoverLeadingSBoxes with one of the generators of plaintexts in this package, as an Attack, for
+// targets that only expose decryption. The generator's sets are decrypted, so it removes the leading S-box layer of
+// ciphers whose decryption direction the generator suits--the mirrors of the structures SBoxAttack works against. Its
+// Result is the other way around from the other attacks: the cipher is Rest after Layers, and Rest can only decrypt.
+type LeadingSBoxAttack struct {
+	Generator GeneratorType // BalancedGenerator, DualGenerator, or PermutationGenerator.
+	Options   []Option
+}
+
+// leadingAttack is implemented by attacks whose Result's layers come before its Rest, like LeadingSBoxAttack's.
+type leadingAttack interface {
+	leading()
+}
+
+func (a LeadingSBoxAttack) leading() {}
+
+func (a LeadingSBoxAttack) Name() string {
+	return fmt.Sprintf("leading S-box layer recovery (%v)", a.Generator)
+}
+
+func (a LeadingSBoxAttack) EstimatedQueries() int { return Estimate(128, 8, a.Generator).Queries }
+
+func (a LeadingSBoxAttack) EstimatedComplexity(blockWidth, sboxWidth int) Complexity {
+	return Estimate(blockWidth, sboxWidth, a.Generator)
+}
+
+func (a LeadingSBoxAttack) Run(ctx context.Context, cipher encoding.Block) (Result, error) {
+	generator := SBoxAttack{Generator: a.Generator}.generator()
+
+	return runWithContext(ctx, cipher, a.Options, func(cipher encoding.Block, opts []Option) (Result, error) {
+		last, inv, err := recoverSBoxLayer(encoding.InverseBlock{cipher}, generator, opts)
+		if err != nil {
+			return Result{}, err
+		}
+
+		first := encoding.ConcatenatedBlock{}
+		for pos, s := range last.(encoding.ConcatenatedBlock) {
+			first[pos] = encoding.InverseByte{s}
+		}
+
+		return Result{Layers: spn.Construction{first}, Rest: encoding.InverseBlock{inv}}, nil
+	})
+}
+
+// TrailingLayerAttack is RecoverTrailingLayer, as an Attack. Its estimate is for the most expensive layer it might
+// find, a trailing S-box layer.
+type TrailingLayerAttack struct {
+	Options []Option
+}
+
+func (a TrailingLayerAttack) Name() string { return "trailing layer recovery" }
+
+func (a TrailingLayerAttack) EstimatedQueries() int {
+	return Estimate(128, 8, PermutationGenerator).Queries
+}
+
+func (a TrailingLayerAttack) EstimatedComplexity(blockWidth, sboxWidth int) Complexity {
+	return Estimate(blockWidth, sboxWidth, PermutationGenerator)
+}
+
+func (a TrailingLayerAttack) Run(ctx context.Context, cipher encoding.Block) (Result, error) {
+	return runWithContext(ctx, cipher, a.Options, func(cipher encoding.Block, opts []Option) (Result, error) {
+		_, last, rest, err := RecoverTrailingLayer(cipher, opts...)
+		return Result{Layers: spn.Construction{last}, Rest: rest}, err
+	})
+}
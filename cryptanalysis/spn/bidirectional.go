@@ -0,0 +1,155 @@
+package spn
+
+import (
+	"errors"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+
+	"github.com/OpenWhiteBox/Generic/constructions/spn"
+)
+
+// invertLayer reads the inverse of a layer back into an S-box layer or an affine layer.
+func invertLayer(layer encoding.Block) (encoding.Block, error) {
+	inv, err := invertLayers(spn.Construction{layer})
+	if err != nil {
+		return nil, err
+	}
+
+	return inv[0], nil
+}
+
+// DecomposeSPNFromBothEnds is DecomposeSPN for ciphers that expose both directions. At each step it peels a layer off
+// whichever end of what's left is cheaper to attack, as predicted by EstimateSPN: the trailing layer with the attacks on
+// encryption, or the leading layer with the same attacks on decryption. The layers found from each end meet in the
+// middle. Against SASA, for example, it removes the leading affine layer with Low Rank Detection on the decryption
+// direction instead of needing PermutationPlaintexts(256) to remove the trailing S-box layer.
+//
+// The cipher's Encode and Decode must both work.
+func DecomposeSPNFromBothEnds(cipher encoding.Block, structure spn.Structure, opts ...Option) (spn.Construction, error) {
+	front, back := spn.Construction{}, spn.Construction{}
+
+	for {
+		forwards := EstimateSPN(structure).Queries < EstimateSPN(Mirror(structure)).Queries
+
+		switch structure {
+		case spn.AS, spn.SA, spn.ASA:
+			var middle spn.Construction
+			if forwards {
+				mid, err := decomposeSPN(cipher, structure, opts)
+				if err != nil {
+					return nil, err
+				}
+				middle = mid
+			} else {
+				inv, err := decomposeSPN(encoding.InverseBlock{cipher}, Mirror(structure), opts)
+				if err != nil {
+					return nil, err
+				}
+				if middle, err = invertLayers(inv); err != nil {
+					return nil, err
+				}
+			}
+
+			out := append(front, middle...)
+			for i := len(back) - 1; i >= 0; i-- {
+				out = append(out, back[i])
+			}
+
+			return out, nil
+		}
+
+		if forwards {
+			last, rest, remaining, err := peelLayer(cipher, structure, opts)
+			if err != nil {
+				return nil, err
+			}
+
+			back, cipher, structure = append(back, last), rest, remaining
+			continue
+		}
+
+		last, rest, remaining, err := peelLayer(encoding.InverseBlock{cipher}, Mirror(structure), opts)
+		if err != nil {
+			return nil, err
+		}
+
+		first, err := invertLayer(last)
+		if err != nil {
+			return nil, err
+		}
+
+		newOptions(opts).logger.Debug("peeled leading layer", "structure", structure)
+		front, cipher, structure = append(front, first), encoding.InverseBlock{rest}, Mirror(remaining)
+	}
+}
+
+// ErrInconsistentCore is returned by DecomposeSPNConcurrently when the layers it peeled off each end of the cipher and
+// the decomposition of what's between them aren't equivalent to the cipher.
+var ErrInconsistentCore = errors.New("layers peeled off each end don't reassemble into the cipher")
+
+// cores are the structures left between the first and last layers of the structures DecomposeSPNConcurrently peels
+// from both ends at once. SAS is also peeled from both ends, but leaves a lone affine layer.
+var cores = map[spn.Structure]spn.Structure{spn.ASAS: spn.SA, spn.SASA: spn.AS, spn.SASAS: spn.ASA}
+
+// DecomposeSPNConcurrently is DecomposeSPN for ciphers that expose both directions. Structures of three layers or more
+// have their leading and trailing layers peeled off at the same time, one through encryption and the other through
+// decryption, so the attack takes about as long as the slower of the two instead of their sum. The core left between
+// them is decomposed on its own, through both of the peeled layers. Neither peel sees the other's result, so the full
+// decomposition is checked against the cipher before it's returned. Other structures are decomposed with
+// DecomposeSPNFromBothEnds.
+//
+// The cipher's Encode and Decode must both work, and Metrics given in opts must be safe for concurrent use.
+func DecomposeSPNConcurrently(cipher encoding.Block, structure spn.Structure, opts ...Option) (spn.Construction, error) {
+	core, ok := cores[structure]
+	if !ok && structure != spn.SAS {
+		return DecomposeSPNFromBothEnds(cipher, structure, opts...)
+	}
+
+	var (
+		first, last encoding.Block
+		errs        [2]error
+	)
+
+	newOptions(opts).parallel(2, func(end int) {
+		if end == 0 {
+			last, _, _, errs[0] = peelLayer(cipher, structure, opts)
+			return
+		}
+
+		var inv encoding.Block
+		if inv, _, _, errs[1] = peelLayer(encoding.InverseBlock{cipher}, Mirror(structure), opts); errs[1] == nil {
+			first, errs[1] = invertLayer(inv)
+		}
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	middle := encoding.ComposedBlocks{encoding.InverseBlock{first}, cipher, encoding.InverseBlock{last}}
+
+	var inner spn.Construction
+	if structure == spn.SAS {
+		o := newOptions(opts)
+		o.ledger.begin("inner affine layer")
+
+		aff, ok := encoding.DecomposeBlockAffine(newOracle(middle, o))
+		if !ok {
+			return nil, ErrSingularLayer
+		}
+		inner = spn.Construction{aff}
+	} else {
+		var err error
+		if inner, err = decomposeSPN(middle, core, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	out := append(append(spn.Construction{first}, inner...), last)
+	if !encoding.ProbablyEquivalentBlocks(encoding.ComposedBlocks(out), cipher) {
+		return nil, ErrInconsistentCore
+	}
+
+	return out, nil
+}
@@ -0,0 +1,239 @@
+package spn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+
+	"github.com/OpenWhiteBox/Generic/constructions/spn"
+)
+
+// ErrUnserializableLayer is returned by Run when the recovered layers should be written out, but one of them isn't an
+// S-box layer or an affine layer that constructions/spn can serialize.
+var ErrUnserializableLayer = errors.New("recovered layer can't be serialized")
+
+// Config describes an assessment, so that it can be reproduced from a single file: the oracle to attack, the attacks
+// to run against it one after the other, and where to write what they recover. It's read from JSON by LoadConfig.
+type Config struct {
+	Oracle  OracleConfig   `json:"oracle"`
+	Attacks []AttackConfig `json:"attacks"`
+
+	// Layers is the path the recovered layers are written to, serialized by constructions/spn, if it isn't empty. The
+	// leading layers come first, so the file is the cipher once the attacks have decomposed all of it.
+	Layers string `json:"layers,omitempty"`
+
+	// Report is the path a JSON Assessment is written to, if it isn't empty.
+	Report string `json:"report,omitempty"`
+}
+
+// OracleConfig describes the cipher an assessment attacks. Type is one of:
+//
+//   - "construction": an SPN construction serialized by constructions/spn, read from Path, with the structure named by
+//     Structure (like "SASAS"). It can decrypt, so attacks like LeadingSBoxAttack can run against it.
+//   - "framed": a target speaking the protocol of FramedOracle, reached with net.Dial on Network (like "tcp" or "unix")
+//     and Address.
+type OracleConfig struct {
+	Type      string `json:"type"`
+	Path      string `json:"path,omitempty"`
+	Structure string `json:"structure,omitempty"`
+	Network   string `json:"network,omitempty"`
+	Address   string `json:"address,omitempty"`
+}
+
+// AttackConfig names a registered attack (see Registered) and the parameters to configure it with. Parameters left
+// out keep their defaults.
+type AttackConfig struct {
+	Name string `json:"name"`
+
+	RelationDegree int   `json:"relation_degree,omitempty"` // See WithRelationDegree.
+	MemoryBudget   int   `json:"memory_budget,omitempty"`   // See WithMemoryBudget.
+	Exhaustive     int   `json:"exhaustive,omitempty"`      // See WithExhaustiveSearch.
+	Votes          int   `json:"votes,omitempty"`           // See WithVoting.
+	Positions      []int `json:"positions,omitempty"`       // See WithPositions.
+}
+
+// options returns the options the attack is configured with.
+func (ac AttackConfig) options() (opts []Option) {
+	if ac.RelationDegree > 0 {
+		opts = append(opts, WithRelationDegree(ac.RelationDegree))
+	}
+	if ac.MemoryBudget > 0 {
+		opts = append(opts, WithMemoryBudget(ac.MemoryBudget))
+	}
+	if ac.Exhaustive > 0 {
+		opts = append(opts, WithExhaustiveSearch(ac.Exhaustive))
+	}
+	if ac.Votes > 0 {
+		opts = append(opts, WithVoting(ac.Votes))
+	}
+	if len(ac.Positions) > 0 {
+		opts = append(opts, WithPositions(ac.Positions...))
+	}
+
+	return
+}
+
+// Assessment is what Run recovers: the names of the attacks it ran, the layers they removed from either end of the
+// cipher in the order they're applied in, what's left of the cipher between them, and the queries each phase of each
+// attack made. The cipher is Leading, then Rest, then Layers. Leading holds the layers of attacks like
+// LeadingSBoxAttack, and Layers those of every other attack.
+type Assessment struct {
+	Attacks []string         `json:"attacks"`
+	Leading spn.Construction `json:"-"`
+	Layers  spn.Construction `json:"-"`
+	Rest    encoding.Block   `json:"-"`
+	Phases  []Phase          `json:"phases"`
+}
+
+// parseStructure returns the structure with the given name.
+func parseStructure(name string) (spn.Structure, error) {
+	for structure, n := range structureNames {
+		if n == name {
+			return structure, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown structure %q", name)
+}
+
+// LoadConfig reads an assessment's configuration from the JSON file at path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Open opens the cipher the configuration describes.
+func (oc OracleConfig) Open() (encoding.Block, error) {
+	switch oc.Type {
+	case "construction":
+		structure, err := parseStructure(oc.Structure)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := os.ReadFile(oc.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		return parseConstruction(data, structure)
+	case "framed":
+		conn, err := net.Dial(oc.Network, oc.Address)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewFramedOracle(conn), nil
+	default:
+		return nil, fmt.Errorf("unknown oracle type %q", oc.Type)
+	}
+}
+
+// parseConstruction is spn.Parse, returning an error instead of panicking if data is malformed.
+func parseConstruction(data []byte, structure spn.Structure) (cipher encoding.Block, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			cipher, err = nil, fmt.Errorf("malformed %v construction: %v", structureNames[structure], r)
+		}
+	}()
+
+	return encoding.ComposedBlocks(spn.Parse(data, structure)), nil
+}
+
+// Run runs the assessment configured in the JSON file at configPath. See Config.
+func Run(configPath string) (*Assessment, error) {
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg.Run(context.Background())
+}
+
+// Run runs the configured attacks one after the other, each against what the last one left of the cipher, and writes
+// the outputs the configuration asks for. It stops at the first attack that fails.
+func (c *Config) Run(ctx context.Context) (*Assessment, error) {
+	cipher, err := c.Oracle.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	attacks := map[string]Registration{}
+	for _, r := range Registered() {
+		attacks[r.Name] = r
+	}
+
+	out := &Assessment{Rest: cipher}
+	for _, ac := range c.Attacks {
+		r, ok := attacks[ac.Name]
+		if !ok {
+			return out, fmt.Errorf("unknown attack %q", ac.Name)
+		}
+
+		if err := checkPositions(ac.Positions); err != nil {
+			return out, fmt.Errorf("%v: %w", ac.Name, err)
+		} else if err := checkExhaustive(ac.Exhaustive); err != nil {
+			return out, fmt.Errorf("%v: %w", ac.Name, err)
+		}
+
+		attack := r.New(ac.options()...)
+		res, err := attack.Run(ctx, out.Rest)
+		if err != nil {
+			return out, fmt.Errorf("%v: %w", ac.Name, err)
+		}
+
+		out.Attacks = append(out.Attacks, ac.Name)
+		if _, ok := attack.(leadingAttack); ok {
+			out.Leading = append(out.Leading, res.Layers...)
+		} else {
+			out.Layers = append(append(spn.Construction{}, res.Layers...), out.Layers...)
+		}
+		out.Rest = res.Rest
+		out.Phases = append(out.Phases, res.Phases...)
+	}
+
+	return out, c.write(out)
+}
+
+// write writes the assessment's outputs.
+func (c *Config) write(a *Assessment) error {
+	if c.Layers != "" {
+		layers := append(append(spn.Construction{}, a.Leading...), a.Layers...)
+		for _, layer := range layers {
+			switch layer.(type) {
+			case encoding.ConcatenatedBlock, encoding.BlockAffine:
+			default:
+				return ErrUnserializableLayer
+			}
+		}
+
+		if err := os.WriteFile(c.Layers, layers.Serialize(), 0644); err != nil {
+			return err
+		}
+	}
+
+	if c.Report != "" {
+		report, err := json.MarshalIndent(a, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		return os.WriteFile(c.Report, report, 0644)
+	}
+
+	return nil
+}
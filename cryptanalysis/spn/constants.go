@@ -0,0 +1,303 @@
+package spn
+
+import (
+	"bytes"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+
+	"github.com/OpenWhiteBox/Generic/constructions/spn"
+)
+
+// RoundConstant describes the additive part of one affine layer across several keys of the same cipher.
+type RoundConstant struct {
+	Layer int // The index of the affine layer in the construction.
+
+	// KeyMask has a bit set wherever the constant differs between keys. These bits carry key material. Every other bit
+	// is taken to be a fixed round constant, which is wrong with probability 2^-(n-1) per bit for n random keys.
+	KeyMask [16]byte
+
+	// Fixed is the constant with the bits in KeyMask cleared.
+	Fixed [16]byte
+
+	// Keyed is, for each key, the constant restricted to the bits in KeyMask.
+	Keyed [][16]byte
+}
+
+// sameLinearLayer returns true if the two affine layers have the same linear part.
+func sameLinearLayer(a, b encoding.BlockAffine) bool {
+	if len(a.BlockLinear.Forwards) != len(b.BlockLinear.Forwards) {
+		return false
+	}
+
+	for i, row := range a.BlockLinear.Forwards {
+		if !bytes.Equal(row, b.BlockLinear.Forwards[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sameSBoxLayer returns true if the two S-box layers compute the same function.
+func sameSBoxLayer(a, b encoding.ConcatenatedBlock) bool {
+	for pos := 0; pos < 16; pos++ {
+		for x := 0; x < 256; x++ {
+			if a[pos].Encode(byte(x)) != b[pos].Encode(byte(x)) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// byteRelation maps between the internal states of two decompositions one byte at a time: byte i of one state is sent
+// to byte pos[i] of the other by bytes[i].
+type byteRelation struct {
+	pos   [16]int
+	bytes [16]encoding.Byte
+}
+
+func (r byteRelation) Encode(in [16]byte) (out [16]byte) {
+	for i := 0; i < 16; i++ {
+		out[r.pos[i]] = r.bytes[i].Encode(in[i])
+	}
+
+	return
+}
+
+func (r byteRelation) Decode(in [16]byte) (out [16]byte) {
+	for i := 0; i < 16; i++ {
+		out[i] = r.bytes[i].Decode(in[r.pos[i]])
+	}
+
+	return
+}
+
+// splitBlock writes f as a byteRelation. It returns false if f mixes bytes together.
+func splitBlock(f encoding.Block) (r byteRelation, ok bool) {
+	zero, used := f.Encode([16]byte{}), [16]bool{}
+
+	for i := 0; i < 16; i++ {
+		out := [256][16]byte{}
+		r.pos[i] = -1
+
+		for x := 0; x < 256; x++ {
+			in := [16]byte{}
+			in[i] = byte(x)
+			out[x] = f.Encode(in)
+
+			for j := 0; j < 16; j++ {
+				if out[x][j] == zero[j] || j == r.pos[i] {
+					continue
+				} else if r.pos[i] != -1 {
+					return r, false
+				}
+
+				r.pos[i] = j
+			}
+		}
+
+		if r.pos[i] == -1 || used[r.pos[i]] {
+			return r, false
+		}
+		used[r.pos[i]] = true
+
+		table := make([]byte, 256)
+		for x := 0; x < 256; x++ {
+			table[x] = out[x][r.pos[i]]
+		}
+		r.bytes[i] = encoding.ParseByte(table)
+	}
+
+	return r, true
+}
+
+// alignSBoxLayer lines up the S-box layer other of one decomposition with the S-box layer ref of another, given the
+// relation rel between their inputs. The S-boxes of a decomposition are only determined up to an affine transformation
+// on either side, and any difference in key material added just before the layer is absorbed by the one on their input.
+// alignSBoxLayer finds the translation t of ref's input that undoes this, so that other∘rel∘t∘ref^-1 is affine on every
+// byte, and returns it along with the relation between the layers' outputs.
+func alignSBoxLayer(rel encoding.Block, ref, other encoding.ConcatenatedBlock) (t [16]byte, after byteRelation, ok bool) {
+	before, ok := splitBlock(rel)
+	if !ok {
+		return
+	}
+
+	for i := 0; i < 16; i++ {
+		j, found := before.pos[i], false
+
+		for c := 0; c < 256 && !found; c++ {
+			f := func(x byte) byte { return other[j].Encode(before.bytes[i].Encode(ref[i].Decode(x) ^ byte(c))) }
+
+			if aff, isAffine := asAffine(f); isAffine {
+				t[i], after.pos[i], after.bytes[i], found = byte(c), j, aff, true
+			}
+		}
+
+		if !found {
+			return t, after, false
+		}
+	}
+
+	return t, after, true
+}
+
+// alignTo rewrites constr, a decomposition of the same cipher as ref under a different key, so that it has the same
+// S-box layers as ref and affine layers with the same linear parts.
+func alignTo(ref, constr spn.Construction) (out spn.Construction, ok bool) {
+	if len(constr) != len(ref) {
+		return nil, false
+	}
+	out = make(spn.Construction, len(ref))
+
+	// rel maps the internal state of the aligned decomposition to that of constr.
+	var rel encoding.Block = encoding.IdentityBlock{}
+
+	for i, layer := range ref {
+		switch layer := layer.(type) {
+		case encoding.ConcatenatedBlock:
+			other, ok := constr[i].(encoding.ConcatenatedBlock)
+			if !ok {
+				return nil, false
+			}
+
+			t, after, ok := alignSBoxLayer(rel, layer, other)
+			if !ok {
+				return nil, false
+			}
+
+			// The translation is absorbed by the affine layer above, which doesn't exist for a leading S-box layer.
+			if i == 0 && t != [16]byte{} {
+				return nil, false
+			} else if i > 0 {
+				prev := ref[i-1].(encoding.BlockAffine)
+				for pos := 0; pos < 16; pos++ {
+					t[pos] ^= prev.BlockAdditive[pos]
+				}
+
+				out[i-1] = encoding.BlockAffine{BlockLinear: prev.BlockLinear, BlockAdditive: t}
+			}
+
+			// Likewise, there's no affine layer below a trailing S-box layer to absorb the relation between outputs.
+			if i == len(ref)-1 && !encoding.ProbablyEquivalentBlocks(after, encoding.IdentityBlock{}) {
+				return nil, false
+			}
+
+			out[i], rel = layer, after
+
+		case encoding.BlockAffine:
+			other, ok := constr[i].(encoding.BlockAffine)
+			if !ok {
+				return nil, false
+			}
+
+			if i == len(ref)-1 {
+				if out[i], ok = encoding.DecomposeBlockAffine(encoding.ComposedBlocks{rel, other}); !ok {
+					return nil, false
+				}
+				continue
+			}
+
+			// Move into the coordinates of ref's state after this layer. The aligned layer itself is filled in by the S-box
+			// layer below, once it knows how much of the difference between the constants it has to take back.
+			out[i], rel = layer, encoding.ComposedBlocks{encoding.InverseBlock{Block: layer}, rel, other}
+
+		default:
+			return nil, false
+		}
+	}
+
+	return out, true
+}
+
+// AlignDecompositions brings decompositions of one cipher under several different keys, as returned by DecomposeSPN,
+// into the representation of the first one, so that they can be passed to RecoverRoundConstants. The decompositions
+// differ from each other by an affine transformation on either side of every S-box, which AlignDecompositions moves
+// back into the neighboring affine layers. Key material has to be added in the affine layers; AlignDecompositions
+// panics if the decompositions can't be aligned.
+func AlignDecompositions(constrs []spn.Construction) (out []spn.Construction) {
+	if len(constrs) == 0 {
+		return nil
+	}
+	out = append(out, constrs[0])
+
+	for _, constr := range constrs[1:] {
+		aligned, ok := alignTo(constrs[0], constr)
+		if !ok {
+			panic("Decompositions can't be aligned: they aren't of the same cipher or key material isn't affine!")
+		}
+
+		out = append(out, aligned)
+	}
+
+	return
+}
+
+// RecoverRoundConstants takes the decompositions of one cipher under several different keys and separates the additive
+// constant of each affine layer into key material and fixed round constants, by seeing which bits stay the same across
+// keys.
+//
+// The decompositions must already be in a common representation, so that only their additive constants differ: the
+// S-box layers must be identical and the affine layers must have identical linear parts. Decompositions returned by
+// DecomposeSPN can be brought into one with AlignDecompositions. RecoverRoundConstants panics if they aren't. Note that
+// the constants are then in the basis of the first decomposition's internal state, so only key material added to the
+// last affine layer is found in the same bits as in the cipher itself.
+func RecoverRoundConstants(constrs []spn.Construction) (out []RoundConstant) {
+	if len(constrs) < 2 {
+		panic("At least two keys are needed to separate key material from round constants!")
+	}
+
+	first := constrs[0]
+	for _, constr := range constrs[1:] {
+		if len(constr) != len(first) {
+			panic("Decompositions have different structures!")
+		}
+	}
+
+	for i, layer := range first {
+		switch layer := layer.(type) {
+		case encoding.ConcatenatedBlock:
+			for _, constr := range constrs[1:] {
+				other, ok := constr[i].(encoding.ConcatenatedBlock)
+				if !ok || !sameSBoxLayer(layer, other) {
+					panic("Decompositions aren't in a common representation: S-box layers differ!")
+				}
+			}
+
+		case encoding.BlockAffine:
+			rc := RoundConstant{Layer: i}
+
+			for _, constr := range constrs[1:] {
+				other, ok := constr[i].(encoding.BlockAffine)
+				if !ok || !sameLinearLayer(layer, other) {
+					panic("Decompositions aren't in a common representation: linear layers differ!")
+				}
+
+				for pos := 0; pos < 16; pos++ {
+					rc.KeyMask[pos] |= layer.BlockAdditive[pos] ^ other.BlockAdditive[pos]
+				}
+			}
+
+			for pos := 0; pos < 16; pos++ {
+				rc.Fixed[pos] = layer.BlockAdditive[pos] &^ rc.KeyMask[pos]
+			}
+
+			for _, constr := range constrs {
+				keyed, c := [16]byte{}, constr[i].(encoding.BlockAffine).BlockAdditive
+				for pos := 0; pos < 16; pos++ {
+					keyed[pos] = c[pos] & rc.KeyMask[pos]
+				}
+
+				rc.Keyed = append(rc.Keyed, keyed)
+			}
+
+			out = append(out, rc)
+
+		default:
+			panic("Decomposition contains a layer that is neither an S-box layer nor an affine layer!")
+		}
+	}
+
+	return
+}
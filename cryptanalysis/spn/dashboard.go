@@ -0,0 +1,116 @@
+package spn
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"time"
+)
+
+// Dashboard is an http.Handler that serves a live view of an attack's progress from a RankTracker, for attacks that run
+// for days against slow oracles. The page at its root polls the JSON DashboardState served at "state" next to it, so it
+// works under any prefix with http.StripPrefix:
+//
+//	rt := &spn.RankTracker{}
+//	http.Handle("/attack/", http.StripPrefix("/attack", spn.NewDashboard(rt)))
+//	go http.ListenAndServe("localhost:8080", nil)
+//	spn.RecoverSBoxes(cipher, generator, spn.WithMetrics(rt))
+type Dashboard struct {
+	tracker    *RankTracker
+	sufficient int
+	start      time.Time
+}
+
+// DashboardState is the state of an attack as the dashboard reports it.
+type DashboardState struct {
+	RankState
+
+	Sufficient       int     // Rank each position needs, given the options the dashboard was created with.
+	Elapsed          float64 // Seconds since the dashboard was created.
+	QueriesPerSecond float64
+
+	// ETA is an estimate of the seconds left until every position is sufficiently defined, by extrapolating the rate at
+	// which the slowest position's rank has grown. It's -1 until some rank has grown.
+	ETA float64
+}
+
+// NewDashboard returns a dashboard for the attack rt tracks. The attack should be configured with the same opts, so that
+// the dashboard knows what rank it's waiting for.
+func NewDashboard(rt *RankTracker, opts ...Option) *Dashboard {
+	return &Dashboard{tracker: rt, sufficient: newOptions(opts).sufficientRank(), start: time.Now()}
+}
+
+// State returns the attack's current state.
+func (d *Dashboard) State() DashboardState {
+	s := DashboardState{
+		RankState:  d.tracker.Snapshot(),
+		Sufficient: d.sufficient,
+		Elapsed:    time.Since(d.start).Seconds(),
+		ETA:        -1,
+	}
+
+	if s.Elapsed > 0 {
+		s.QueriesPerSecond = float64(s.Queries) / s.Elapsed
+	}
+
+	min := s.Ranks[0]
+	for _, rank := range s.Ranks {
+		if rank < min {
+			min = rank
+		}
+	}
+
+	if diag := diagnose(min, s.Batches-min, d.sufficient); diag.Remaining == 0 {
+		s.ETA = 0
+	} else if diag.Remaining > 0 && s.Batches > 0 {
+		s.ETA = float64(diag.Remaining) * s.Elapsed / float64(s.Batches)
+	}
+
+	return s
+}
+
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch path.Base(r.URL.Path) {
+	case "state":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d.State())
+	default:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(dashboardPage))
+	}
+}
+
+// dashboardPage is the page served at the dashboard's root.
+const dashboardPage = `<!DOCTYPE html>
+<html>
+<head>
+<title>Attack progress</title>
+<style>
+body { font-family: sans-serif; }
+td { padding: 2px 8px; }
+.bar { background: #ddd; width: 300px; }
+.bar div { background: #48c; height: 12px; }
+</style>
+</head>
+<body>
+<h1>Attack progress</h1>
+<p id="summary">Waiting for the attack&hellip;</p>
+<table id="ranks"></table>
+<script>
+async function refresh() {
+	const s = await (await fetch("state")).json();
+	const eta = s.ETA < 0 ? "unknown" : Math.round(s.ETA) + "s";
+	document.getElementById("summary").textContent =
+		s.Queries + " queries in " + s.Batches + " batches (" + s.QueriesPerSecond.toFixed(1) + "/s), ETA " + eta;
+
+	const rows = s.Ranks.map((rank, pos) =>
+		"<tr><td>" + pos + "</td><td>" + rank + " / " + s.Sufficient + "</td><td class=\"bar\"><div style=\"width: " +
+		Math.min(100, 100 * rank / s.Sufficient) + "%\"></div></td></tr>");
+	document.getElementById("ranks").innerHTML = rows.join("");
+}
+refresh();
+setInterval(refresh, 1000);
+</script>
+</body>
+</html>
+`
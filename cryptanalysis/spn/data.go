@@ -0,0 +1,96 @@
+package spn
+
+import (
+	"sync"
+)
+
+// DataComplexity counts the queries an attack made to the cipher, by kind.
+type DataComplexity struct {
+	Chosen      int // Encryptions of plaintexts chosen without looking at any ciphertext.
+	Adaptive    int // Encryptions of plaintexts chosen from earlier ciphertexts, like Low Rank Detection's.
+	Decryptions int // Decryptions of chosen ciphertexts.
+}
+
+// Total returns the number of queries of every kind.
+func (d DataComplexity) Total() int {
+	return d.Chosen + d.Adaptive + d.Decryptions
+}
+
+// Phase is one step of an attack, like recovering one layer, with the queries it made.
+type Phase struct {
+	Name string
+	Data DataComplexity
+}
+
+// ledger records the queries an attack makes, phase by phase. Queries are attributed to the phase that began most
+// recently, so phases that run at the same time, like DecomposeSPNConcurrently's, share their counts between them. The
+// zero ledger has no phases, and methods on a nil ledger do nothing.
+type ledger struct {
+	mu       sync.Mutex
+	phases   []Phase
+	adaptive bool
+}
+
+// withLedger records every query the attack makes in l.
+func withLedger(l *ledger) Option {
+	return func(o *options) { o.ledger = l }
+}
+
+// begin starts a new phase. Its encryptions are chosen unless it's marked adaptive.
+func (l *ledger) begin(name string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.phases, l.adaptive = append(l.phases, Phase{Name: name}), false
+}
+
+// markAdaptive makes the rest of the current phase's encryptions count as adaptive.
+func (l *ledger) markAdaptive() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.adaptive = true
+}
+
+// record counts one query in the current phase, starting an unnamed one if none has begun.
+func (l *ledger) record(decryption bool) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.phases) == 0 {
+		l.phases = append(l.phases, Phase{})
+	}
+	d := &l.phases[len(l.phases)-1].Data
+
+	if decryption {
+		d.Decryptions++
+	} else if l.adaptive {
+		d.Adaptive++
+	} else {
+		d.Chosen++
+	}
+}
+
+// Phases returns every phase recorded so far.
+func (l *ledger) Phases() []Phase {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]Phase(nil), l.phases...)
+}
@@ -0,0 +1,121 @@
+package spn
+
+import (
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/matrix"
+
+	"github.com/OpenWhiteBox/Generic/constructions/spn"
+)
+
+// Decrypter is an implementation of an SPN block cipher that only exposes decryption.
+type Decrypter interface {
+	Decrypt([]byte, []byte)
+}
+
+// DecryptionEncoding implements encoding.Block over a Decrypter, so that its Encode decrypts and its Decode can not be
+// called. Attacks on it see the decryption direction of the cipher: their trailing layers are the cipher's leading
+// layers, inverted.
+type DecryptionEncoding struct{ Decrypter }
+
+func (d DecryptionEncoding) Encode(in [16]byte) (out [16]byte) {
+	d.Decrypter.Decrypt(out[:], in[:])
+	return
+}
+
+func (d DecryptionEncoding) Decode(in [16]byte) (out [16]byte) {
+	panic("cryptanalysis/spn.DecryptionEncoding.Decode should never be called!")
+}
+
+// Mirror returns the structure of the inverse of a cipher with the given structure.
+func Mirror(structure spn.Structure) spn.Structure {
+	switch structure {
+	case spn.AS:
+		return spn.SA
+	case spn.SA:
+		return spn.AS
+	case spn.ASAS:
+		return spn.SASA
+	case spn.SASA:
+		return spn.ASAS
+	case spn.ASA, spn.SAS, spn.ASASA, spn.SASAS:
+		return structure
+	default:
+		panic("Unknown SPN structure!")
+	}
+}
+
+// invertLayers returns the inverse of a decomposition, which applies the inverse of each of its layers in the opposite
+// order. Each layer is read back into an S-box layer or an affine layer, so that the result is as usable as a
+// decomposition made directly.
+func invertLayers(constr spn.Construction) (out spn.Construction, err error) {
+	for i := len(constr) - 1; i >= 0; i-- {
+		inv := encoding.InverseBlock{constr[i]}
+
+		switch constr[i].(type) {
+		case encoding.ConcatenatedBlock:
+			out = append(out, encoding.DecomposeConcatenatedBlock(inv))
+		default:
+			aff, ok := encoding.DecomposeBlockAffine(inv)
+			if !ok {
+				return nil, ErrSingularLayer
+			}
+			out = append(out, aff)
+		}
+	}
+
+	return out, nil
+}
+
+// Decryptor composes a recovered decomposition into a standalone decryption function: Encode decrypts what the
+// decomposition encrypts, and Decode encrypts. The inverse of each layer is read back into an S-box layer or an affine
+// layer up front, so decrypting costs the same as encrypting and doesn't go through the recovered layers at all.
+func Decryptor(constr spn.Construction) (encoding.Block, error) {
+	inv, err := invertLayers(constr)
+	if err != nil {
+		return nil, err
+	}
+
+	return encoding.ComposedBlocks(inv), nil
+}
+
+// DecomposeSPNByDecryption is DecomposeSPN for ciphers that only expose decryption. It decomposes the decryption
+// direction, whose structure is the mirror of the cipher's, and inverts the result, so the decomposition it returns
+// encrypts like the cipher.
+func DecomposeSPNByDecryption(constr Decrypter, structure spn.Structure, opts ...Option) (spn.Construction, error) {
+	inv, err := decomposeSPN(DecryptionEncoding{constr}, Mirror(structure), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return invertLayers(inv)
+}
+
+// RecoverLeadingSBoxes is RecoverSBoxes for the decryption direction: it removes the leading S-box layer of the given
+// cipher, using only its Decode method, so that the cipher is rest after first. The plaintexts generated by generator
+// are decrypted, so they must suit the layers under the S-box layer in the decryption direction. Rest can only decrypt,
+// like the cipher.
+func RecoverLeadingSBoxes(cipher encoding.Block, generator Generator, opts ...Option) (first encoding.ConcatenatedBlock, rest encoding.Block) {
+	last, inv := RecoverSBoxes(encoding.InverseBlock{cipher}, generator, opts...)
+
+	for pos := range first {
+		first[pos] = encoding.InverseByte{last[pos]}
+	}
+
+	return first, encoding.InverseBlock{inv}
+}
+
+// RecoverLeadingAffine is RecoverAffine for the decryption direction: it removes the leading affine layer of the given
+// cipher, using only its Decode method, so that the cipher is rest after first. Rest can only decrypt, like the cipher.
+func RecoverLeadingAffine(cipher encoding.Block, generator func(encoding.Block) ([]matrix.IncrementalMatrix, error), opts ...Option) (first encoding.BlockAffine, rest encoding.Block, err error) {
+	last, inv, err := RecoverAffine(encoding.InverseBlock{cipher}, generator, opts...)
+	if err != nil {
+		return first, nil, err
+	}
+
+	first, ok := encoding.DecomposeBlockAffine(encoding.InverseBlock{last})
+	if !ok {
+		return first, nil, ErrSingularLayer
+	}
+
+	return first, encoding.InverseBlock{inv}, nil
+}
@@ -0,0 +1,196 @@
+package spn
+
+import (
+	"crypto/rand"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/matrix"
+)
+
+// LayerType is the kind of layer on the outside (the ciphertext side) of a cipher.
+type LayerType int
+
+const (
+	NoLayer     LayerType = iota // The cipher is the identity: there's nothing left to remove.
+	AffineLayer                  // The trailing layer is affine.
+	SBoxLayer                    // The trailing layer is byte-wise and nonlinear.
+)
+
+func (t LayerType) String() string {
+	switch t {
+	case NoLayer:
+		return "none"
+	case AffineLayer:
+		return "affine"
+	case SBoxLayer:
+		return "S-box"
+	default:
+		return "unknown"
+	}
+}
+
+// detectionSamples is the number of random inputs or sets of plaintexts each test in DetectTrailingLayer uses. A wrong
+// answer requires every one of them to fail.
+const detectionSamples = 4
+
+// isIdentityBlock returns true if cipher seems to be the identity.
+func isIdentityBlock(cipher encoding.Block) bool {
+	return encoding.ProbablyEquivalentBlocks(cipher, encoding.IdentityBlock{})
+}
+
+// isByteWise returns true if each byte of cipher's output seems to depend only on the same byte of its input.
+func isByteWise(cipher encoding.Block) bool {
+	for i := 0; i < detectionSamples; i++ {
+		x := [16]byte{}
+		rand.Read(x[:])
+		X := cipher.Encode(x)
+
+		for pos := 0; pos < 16; pos++ {
+			y := x
+			y[pos] ^= 0x01 << uint(i%8)
+			Y := cipher.Encode(y)
+
+			for j := 0; j < 16; j++ {
+				if j != pos && X[j] != Y[j] {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// isAffineBlock returns true if cipher seems to be affine, meaning E(a) + E(b) + E(c) = E(a + b + c).
+func isAffineBlock(cipher encoding.Block) bool {
+	for i := 0; i < detectionSamples; i++ {
+		a, b, c, d := [16]byte{}, [16]byte{}, [16]byte{}, [16]byte{}
+		rand.Read(a[:])
+		rand.Read(b[:])
+		rand.Read(c[:])
+		encoding.XOR(d[:], a[:], b[:])
+		encoding.XOR(d[:], d[:], c[:])
+
+		A, B, C, D := cipher.Encode(a), cipher.Encode(b), cipher.Encode(c), cipher.Encode(d)
+		encoding.XOR(D[:], D[:], A[:])
+		encoding.XOR(D[:], D[:], B[:])
+		encoding.XOR(D[:], D[:], C[:])
+
+		if D != [16]byte{} {
+			return false
+		}
+	}
+
+	return true
+}
+
+// detectionAttempts bounds the number of pairs of plaintexts DetectTrailingLayer tries to find a collision with. About
+// one in seventeen pairs collide when a collision is possible at all, so failing this many times means it isn't.
+const detectionAttempts = 128
+
+// DetectTrailingLayer determines what kind of layer is on the outside of cipher, so that the right attack can be used
+// to remove it. It usually costs a few thousand queries, and about thirty thousand when the trailing layer is an S-box
+// layer with more than two layers under it.
+//
+// Like Low Rank Detection, it looks for a pair of plaintexts that keeps colliding in one byte of the internal state as
+// a byte of both is toggled through different values. The differences of their ciphertexts then span a small subspace.
+// A trailing S-box layer keeps the collision in the same byte of the ciphertexts, while a trailing affine layer mixes
+// it with the other bytes. Pairs can only collide like this when the toggled byte goes through at most two layers
+// before the collision, so if none of them do, the trailing layer is taken to be an S-box layer over a deeper
+// structure, like SASA and SASAS.
+func DetectTrailingLayer(cipher encoding.Block, opts ...Option) LayerType {
+	orc := newOracle(cipher, newOptions(opts))
+
+	if isIdentityBlock(orc) {
+		return NoLayer
+	} else if isByteWise(orc) {
+		return SBoxLayer
+	} else if isAffineBlock(orc) {
+		return AffineLayer
+	}
+
+	for attempt := 0; attempt < detectionAttempts; attempt++ {
+		x, y := [16]byte{}, [16]byte{}
+		rand.Read(x[:])
+		rand.Read(y[:])
+
+		subspace, mask := matrix.NewIncrementalMatrix(128), [16]byte{}
+
+		for i := 0; i < 129 && subspace.Len() <= 120; i++ {
+			x, y = nextByToggle(i, attempt, x, y)
+			X, Y := orc.Encode(x), orc.Encode(y)
+
+			encoding.XOR(X[:], X[:], Y[:])
+			for pos := 0; pos < 16; pos++ {
+				mask[pos] |= X[pos]
+			}
+
+			subspace.Add(matrix.Row(X[:]))
+		}
+
+		if subspace.Len() > 120 {
+			continue
+		} else if subspace.Len() <= 8 {
+			// The differences barely change, so the toggled byte goes through a byte-wise layer and then an affine one.
+			return AffineLayer
+		}
+
+		for pos := 0; pos < 16; pos++ {
+			if mask[pos] == 0 {
+				return SBoxLayer
+			}
+		}
+
+		return AffineLayer
+	}
+
+	return SBoxLayer
+}
+
+// RecoverTrailingLayer detects the kind of layer on the outside of cipher with DetectTrailingLayer and removes it with
+// the attack that suits it. It returns the kind of layer found, the layer itself (the identity, if there wasn't one),
+// and the rest of the cipher.
+//
+// Trailing S-box layers are removed with RecoverSBoxes and PermutationPlaintexts(256), which works against every
+// supported structure, but costs more queries than the generator DecomposeSPN would choose knowing the structure.
+// Trailing affine layers are removed as if the cipher were AS and, if what's left isn't an S-box layer, with Low Rank
+// Detection.
+func RecoverTrailingLayer(cipher encoding.Block, opts ...Option) (kind LayerType, last, rest encoding.Block, err error) {
+	o := newOptions(opts)
+	orc := newOracle(cipher, o)
+
+	o.ledger.begin("trailing layer detection")
+	kind = DetectTrailingLayer(cipher, opts...)
+	o.logger.Debug("detected trailing layer", "type", kind)
+
+	switch kind {
+	case NoLayer:
+		return kind, encoding.IdentityBlock{}, cipher, nil
+
+	case SBoxLayer:
+		if isByteWise(orc) {
+			return kind, encoding.DecomposeConcatenatedBlock(orc), encoding.IdentityBlock{}, nil
+		}
+
+		last, rest, err = recoverSBoxLayer(cipher, PermutationPlaintexts(256), opts)
+		return kind, last, rest, err
+
+	default:
+		if isAffineBlock(orc) {
+			aff, ok := encoding.DecomposeBlockAffine(orc)
+			if !ok {
+				return kind, nil, nil, ErrSingularLayer
+			}
+
+			return kind, aff, encoding.IdentityBlock{}, nil
+		}
+
+		// trivialSubspaces always finds subspaces of the right size, so check what it leaves behind.
+		if aff, rest, err := RecoverAffine(cipher, trivialSubspaces, opts...); err == nil && isByteWise(newOracle(rest, o)) {
+			return kind, aff, rest, nil
+		}
+
+		last, rest, err = RecoverAffine(cipher, lowRankDetectionWith(nextByToggle), opts...)
+		return kind, last, rest, err
+	}
+}
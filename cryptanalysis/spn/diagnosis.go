@@ -0,0 +1,174 @@
+package spn
+
+import (
+	"crypto/rand"
+	"math"
+	"sort"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+)
+
+// diagnosisProbes is the number of random plaintexts a diagnosis queries twice each, to check whether the oracle is
+// consistent.
+const diagnosisProbes = 256
+
+// Cause is a possible explanation for why an attack on an S-box layer failed.
+type Cause int
+
+const (
+	// WrongGeneratorDegree means the generator's sets of plaintexts don't suit the structure: either they don't give new
+	// relations at all, or their cubes are too small for the sums to vanish at the layer being attacked.
+	WrongGeneratorDegree Cause = iota
+
+	// LinearTrailingLayer means the trailing layer is affine rather than an S-box layer, so no byte of the ciphertext is
+	// the output of a single S-box and every position's relations are wrong in the same way.
+	LinearTrailingLayer
+
+	// NoisyOracle means the oracle doesn't always give the same answer to the same query, which puts relations that no
+	// S-box satisfies into the systems. See WithVoting and Consensus.
+	NoisyOracle
+)
+
+func (c Cause) String() string {
+	switch c {
+	case WrongGeneratorDegree:
+		return "wrong generator degree"
+	case LinearTrailingLayer:
+		return "linear trailing layer"
+	case NoisyOracle:
+		return "noisy oracle"
+	default:
+		return "unknown"
+	}
+}
+
+// Hypothesis is a Cause, with how much the evidence supports it, from 0 to 1.
+type Hypothesis struct {
+	Cause    Cause
+	Evidence float64
+}
+
+// Diagnosis is the Cube attack's account of why it failed, in a RecoveryError.
+type Diagnosis struct {
+	// Ranks holds the final rank of every position's system, including the ones that were recovered.
+	Ranks [16]int
+
+	// Correlation holds the correlation between each pair of positions of whether each batch raised their ranks.
+	// Positions whose systems grow in lockstep were held back by the same thing. Two positions whose ranks never changed
+	// after the same batches have correlation 1.
+	Correlation [16][16]float64
+
+	// Inconsistent is how many of Probes plaintexts, each queried twice after the attack, gave two different ciphertexts.
+	Inconsistent, Probes int
+
+	// Hypotheses lists the causes that some evidence supports, most likely first.
+	Hypotheses []Hypothesis
+}
+
+// correlation returns the correlation between two series of indicators, or 1 if either never changes and they're
+// equal.
+func correlation(a, b []bool) float64 {
+	n := float64(len(a))
+	sa, sb, sab := 0.0, 0.0, 0.0
+	for i := range a {
+		if a[i] {
+			sa++
+		}
+		if b[i] {
+			sb++
+		}
+		if a[i] && b[i] {
+			sab++
+		}
+	}
+
+	va, vb := sa*(n-sa), sb*(n-sb)
+	if va == 0 || vb == 0 {
+		if sa == sb && sab == sa {
+			return 1
+		}
+		return 0
+	}
+
+	return (n*sab - sa*sb) / math.Sqrt(va*vb)
+}
+
+// meanCorrelation returns the mean correlation between pairs of the given positions, or 1 if there's at most one.
+func (d *Diagnosis) meanCorrelation(positions []int) float64 {
+	sum, pairs := 0.0, 0
+	for i, p := range positions {
+		for _, q := range positions[i+1:] {
+			sum += d.Correlation[p][q]
+			pairs++
+		}
+	}
+
+	if pairs == 0 {
+		return 1
+	}
+	return sum / float64(pairs)
+}
+
+// diagnoseFailure explains the failure of the Cube attack. growth records, for each batch, which positions it raised the
+// rank of. The oracle is queried with diagnosisProbes pairs of identical plaintexts, since an inconsistent oracle is
+// impossible to tell from a wrong structure by the relations alone.
+func diagnoseFailure(cipher encoding.Block, ims IncrementalMatrices, growth [][16]bool, failed *RecoveryError) *Diagnosis {
+	d := &Diagnosis{Probes: diagnosisProbes}
+	for pos := range d.Ranks {
+		d.Ranks[pos] = ims.Rank(pos)
+	}
+
+	series := [16][]bool{}
+	for pos := range series {
+		for _, batch := range growth {
+			series[pos] = append(series[pos], batch[pos])
+		}
+	}
+	for p := range d.Correlation {
+		for q := range d.Correlation {
+			d.Correlation[p][q] = correlation(series[p], series[q])
+		}
+	}
+
+	for i := 0; i < d.Probes; i++ {
+		pt := [16]byte{}
+		rand.Read(pt[:])
+
+		if cipher.Encode(pt) != cipher.Encode(pt) {
+			d.Inconsistent++
+		}
+	}
+
+	stalled, contradicted := []int{}, []int{}
+	for i, pos := range failed.Positions {
+		switch failed.Reasons[i] {
+		case InsufficientRank:
+			stalled = append(stalled, pos)
+		case NoPermutation:
+			contradicted = append(contradicted, pos)
+		}
+	}
+
+	// Contradictory relations are explained by noise if there's any, and only otherwise by the structure. A linear
+	// trailing layer contradicts every position's relations at once, so the more the contradicted positions grew
+	// together, the likelier it is; cubes that are too small contradict them one at a time.
+	noise := 0.0
+	if d.Inconsistent > 0 {
+		noise = math.Min(1, 0.5+25*float64(d.Inconsistent)/float64(d.Probes))
+	}
+	stalledShare, contradictedShare := float64(len(stalled))/16, float64(len(contradicted))/16
+	together := d.meanCorrelation(contradicted)
+
+	d.Hypotheses = []Hypothesis{
+		{WrongGeneratorDegree, stalledShare*(0.5+0.5*d.meanCorrelation(stalled)) + 0.5*contradictedShare*(1-together)*(1-noise)},
+		{LinearTrailingLayer, contradictedShare * (0.5 + 0.5*together) * (1 - noise)},
+		{NoisyOracle, noise},
+	}
+	sort.SliceStable(d.Hypotheses, func(i, j int) bool { return d.Hypotheses[i].Evidence > d.Hypotheses[j].Evidence })
+
+	for len(d.Hypotheses) > 0 && d.Hypotheses[len(d.Hypotheses)-1].Evidence <= 0 {
+		d.Hypotheses = d.Hypotheses[:len(d.Hypotheses)-1]
+	}
+
+	return d
+}
@@ -0,0 +1,179 @@
+package spn
+
+import (
+	"crypto/rand"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/gfmatrix"
+)
+
+// differenceCount is the number of input differences RecoverSBoxesByDifferences queries pairs with. Each one adds an
+// unknown to every position's system.
+const differenceCount = 16
+
+// RecoverSBoxesByDifferences removes the trailing S-box layer of a cipher with structure SA, like RecoverSBoxes, but
+// from output differences instead of cube sums. It's for targets where the Cube attack's assumption fails--where no
+// generator is known to sum the input of the S-box layer to zero--but an input difference still becomes a fixed
+// difference before the S-box layer, as it does through any affine layer.
+//
+// It queries pairs of random plaintexts x and x^delta for a few fixed deltas. If the pair's ciphertexts have bytes y and
+// y' at some position, then S^-1(y) ^ S^-1(y') is the same for every pair with the same delta. Each delta's difference
+// is an unknown of its own, on top of the 256 entries of S^-1, so a position's system has 256 + 16 columns and the same
+// 9-dimensional nullspace as the Cube attack's.
+func RecoverSBoxesByDifferences(cipher encoding.Block, opts ...Option) (*SBoxRecovery, error) {
+	o := newOptions(opts)
+	o.ledger.begin("S-box layer by differences")
+	orc := newOracle(cipher, o)
+
+	size := 256 + differenceCount
+	sufficient := size - o.nullSpaceDim
+	ims, hist, dependent := NewIncrementalMatrices(16, size), &histogram{}, [16]int{}
+	ignored := o.ignored()
+
+	deltas := make([][16]byte, differenceCount)
+	for i := range deltas {
+		for deltas[i] == [16]byte{} {
+			rand.Read(deltas[i][:])
+		}
+	}
+
+	waiting := func() bool {
+		for _, pos := range ims.Insufficient(sufficient) {
+			if !ignored[pos] {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for attempt := 0; attempt < 2000 && waiting(); attempt++ {
+		for d, delta := range deltas {
+			x, y := [16]byte{}, [16]byte{}
+			rand.Read(x[:])
+			for i := range y {
+				y[i] = x[i] ^ delta[i]
+			}
+
+			X, Y := orc.Encode(x), orc.Encode(y)
+			hist.add(X)
+			hist.add(Y)
+
+			rows := [16]gfmatrix.Row{}
+			for pos := range rows {
+				rows[pos] = gfmatrix.NewRow(size)
+				if !ignored[pos] {
+					rows[pos][X[pos]] = rows[pos][X[pos]].Add(1)
+					rows[pos][Y[pos]] = rows[pos][Y[pos]].Add(1)
+					rows[pos][256+d] = 1
+				}
+			}
+
+			grown := ims.Add(rows[:])
+			for pos := range dependent {
+				dependent[pos]++
+			}
+			for _, pos := range grown {
+				dependent[pos]--
+				o.metrics.Rank(pos, ims.Rank(pos))
+				o.emit(RankIncreased{pos, ims.Rank(pos)})
+			}
+		}
+
+		o.metrics.Batch()
+	}
+
+	return solveRelations(cipher, ims, hist, dependent, sufficient, o)
+}
+
+// solveRelations finds the S-boxes of a trailing layer from each position's system of relations, for attacks that
+// only differ from the Cube attack in how they find relations. Positions left out with WithPositions are skipped.
+func solveRelations(cipher encoding.Block, ims IncrementalMatrices, hist *histogram, dependent [16]int, sufficient int, o *options) (*SBoxRecovery, error) {
+	res, failed, ignored := &SBoxRecovery{}, &RecoveryError{Samples: hist.samples}, o.ignored()
+
+	// Positions are solved in parallel, and their results are collected in order.
+	vs, found := [16]gfmatrix.Row{}, [16]bool{}
+	o.parallel(len(ims), func(pos int) {
+		if ignored[pos] || ims.Rank(pos) < sufficient {
+			return
+		}
+
+		res.NullSpaces[pos] = ims[pos].Matrix().NullSpace()
+		vs[pos], found[pos] = findPermutation(res.NullSpaces[pos], o.search, o.workers)
+	})
+
+	for pos := range ims {
+		res.Last[pos] = encoding.IdentityByte{}
+		res.diagnostics[pos] = diagnose(ims.Rank(pos), dependent[pos], sufficient)
+		if ignored[pos] {
+			continue
+		} else if ims.Rank(pos) < sufficient {
+			failed.add(pos, InsufficientRank, ims[pos], res.diagnostics[pos], hist.distinct[pos])
+			continue
+		}
+
+		basis, v, ok := res.NullSpaces[pos], vs[pos], found[pos]
+		if !ok {
+			failed.add(pos, NoPermutation, ims[pos], res.diagnostics[pos], hist.distinct[pos])
+			continue
+		}
+
+		res.Recovered[pos] = true
+		res.Last[pos] = newSBox(v, true)
+		res.Confidence[pos] = Confidence{
+			NullSpaceDim: len(basis),
+			Candidates:   countPermutations(basis, confidenceSamples),
+			Samples:      confidenceSamples,
+		}
+		o.emit(PositionSolved{pos, res.Last[pos]})
+	}
+
+	res.Rest = encoding.ComposedBlocks{cipher, encoding.InverseBlock{res.Last}}
+
+	var err error
+	if len(failed.Positions) > 0 {
+		o.logger.Error("failed to recover S-boxes", "positions", failed.Positions, "ranks", ims.Ranks())
+		err = failed
+	}
+	o.emit(AttackFinished{res.Recovered, err})
+
+	return res, err
+}
+
+// RecoverSBoxesWithFallback is RecoverSBoxesDetailed, but falls back to RecoverSBoxesByDifferences for the positions
+// the Cube attack couldn't recover. The two attacks' systems are independent for each position, so their S-boxes are
+// combined position by position. The error describes the positions neither attack recovered, with the Cube attack's
+// reasons.
+func RecoverSBoxesWithFallback(cipher encoding.Block, generator func() [][16]byte, opts ...Option) (*SBoxRecovery, error) {
+	res, err := RecoverSBoxesDetailed(cipher, generator, opts...)
+	first, ok := err.(*RecoveryError)
+	if !ok {
+		return res, err
+	}
+
+	newOptions(opts).logger.Info("falling back to differences", "positions", first.Positions)
+	diff, _ := RecoverSBoxesByDifferences(cipher, append(opts[:len(opts):len(opts)], WithPositions(first.Positions...))...)
+
+	failed := &RecoveryError{Samples: first.Samples}
+	for i, pos := range first.Positions {
+		if !diff.Recovered[pos] {
+			failed.Positions = append(failed.Positions, pos)
+			failed.Reasons = append(failed.Reasons, first.Reasons[i])
+			failed.Systems = append(failed.Systems, first.Systems[i])
+			failed.Diagnostics = append(failed.Diagnostics, first.Diagnostics[i])
+			failed.Values = append(failed.Values, first.Values[i])
+			continue
+		}
+
+		res.Last[pos], res.Recovered[pos] = diff.Last[pos], true
+		res.Confidence[pos], res.NullSpaces[pos] = diff.Confidence[pos], diff.NullSpaces[pos]
+		res.diagnostics[pos] = diff.diagnostics[pos]
+	}
+	res.Rest = encoding.ComposedBlocks{cipher, encoding.InverseBlock{res.Last}}
+
+	if len(failed.Positions) > 0 {
+		return res, failed
+	}
+
+	return res, nil
+}
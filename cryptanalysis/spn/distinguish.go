@@ -0,0 +1,165 @@
+package spn
+
+import (
+	"math"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+)
+
+// TestResult is the outcome of a statistical test on a set of ciphertexts. PValue is the probability that ciphertexts
+// of a random permutation would give a statistic at least as extreme, so a small PValue means the test distinguishes
+// the cipher from random.
+type TestResult struct {
+	Statistic float64
+	PValue    float64
+}
+
+// Distinguishes returns true if the result is significant at the given level, like 0.001.
+func (r TestResult) Distinguishes(level float64) bool {
+	return r.PValue < level
+}
+
+// CollectCiphertexts encrypts batches sets of plaintexts from generator, and returns every plaintext along with its
+// ciphertext.
+func CollectCiphertexts(cipher encoding.Block, generator Generator, batches int, opts ...Option) (pts, cts [][16]byte) {
+	o := newOptions(opts)
+	orc := newOracle(cipher, o)
+
+	for i := 0; i < batches; i++ {
+		for _, pt := range generator() {
+			pts, cts = append(pts, pt), append(cts, orc.Encode(pt))
+		}
+
+		o.metrics.Batch()
+	}
+
+	return
+}
+
+// ChiSquareTest tests whether the byte at pos of the ciphertexts is uniformly distributed, with Pearson's chi-square
+// test on 255 degrees of freedom. Sets of plaintexts that make a byte of the ciphertext take every value equally often,
+// like PermutationPlaintexts(256) against SA, give a statistic of 0 and a p-value close to 1. CollisionTest catches
+// those instead.
+func ChiSquareTest(cts [][16]byte, pos int) TestResult {
+	counts := [256]int{}
+	for _, ct := range cts {
+		counts[ct[pos]]++
+	}
+
+	expected := float64(len(cts)) / 256
+	stat := 0.0
+	for _, c := range counts {
+		d := float64(c) - expected
+		stat += d * d / expected
+	}
+
+	return TestResult{Statistic: stat, PValue: upperGamma(255.0/2, stat/2)}
+}
+
+// CollisionTest counts the pairs of ciphertexts that are equal in the byte at pos, and compares that to the number
+// expected of random ciphertexts. The statistic is the number of standard deviations the count is from what's expected,
+// and the test is two-sided: too few collisions, as when the byte is a permutation of the structure's active byte, is
+// as much a distinguisher as too many.
+func CollisionTest(cts [][16]byte, pos int) TestResult {
+	counts := [256]int{}
+	for _, ct := range cts {
+		counts[ct[pos]]++
+	}
+
+	collisions := 0
+	for _, c := range counts {
+		collisions += c * (c - 1) / 2
+	}
+
+	// The number of colliding pairs is approximately Poisson.
+	n := float64(len(cts))
+	expected := n * (n - 1) / 2 / 256
+	z := (float64(collisions) - expected) / math.Sqrt(expected)
+
+	return TestResult{Statistic: z, PValue: math.Erfc(math.Abs(z) / math.Sqrt2)}
+}
+
+// CorrelationTest estimates the correlation between the parity of the plaintext bits selected by in and the parity of
+// the ciphertext bits selected by out, and tests whether it's zero, as it's expected to be for a random permutation. The
+// statistic is the correlation, in [-1, 1].
+func CorrelationTest(pts, cts [][16]byte, in, out [16]byte) TestResult {
+	if len(pts) != len(cts) {
+		panic("Number of plaintexts doesn't match the number of ciphertexts!")
+	}
+
+	parity := func(x, mask [16]byte) (p byte) {
+		for i := range x {
+			p ^= x[i] & mask[i]
+		}
+
+		p ^= p >> 4
+		p ^= p >> 2
+		p ^= p >> 1
+		return p & 1
+	}
+
+	sum := 0
+	for i := range pts {
+		if parity(pts[i], in) == parity(cts[i], out) {
+			sum++
+		} else {
+			sum--
+		}
+	}
+
+	n := float64(len(pts))
+	corr := float64(sum) / n
+
+	return TestResult{Statistic: corr, PValue: math.Erfc(math.Abs(corr) * math.Sqrt(n) / math.Sqrt2)}
+}
+
+// upperGamma returns the regularized upper incomplete gamma function Q(a, x), which is the probability that a
+// chi-square distribution with 2a degrees of freedom exceeds 2x.
+func upperGamma(a, x float64) float64 {
+	if x <= 0 {
+		return 1
+	}
+
+	lg, _ := math.Lgamma(a)
+	scale := math.Exp(-x + a*math.Log(x) - lg)
+
+	if x < a+1 {
+		// Series expansion of the lower incomplete gamma function.
+		sum, term := 1/a, 1/a
+		for n := 1; n < 1000 && term > sum*1e-15; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+		}
+
+		return math.Max(0, 1-sum*scale)
+	}
+
+	// Continued fraction for the upper incomplete gamma function, by Lentz's method.
+	const tiny = 1e-300
+	b := x + 1 - a
+	c, d := 1/tiny, 1/b
+	h := d
+	for n := 1; n < 1000; n++ {
+		an := -float64(n) * (float64(n) - a)
+		b += 2
+
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+
+		d = 1 / d
+		delta := d * c
+		h *= delta
+
+		if math.Abs(delta-1) < 1e-15 {
+			break
+		}
+	}
+
+	return scale * h
+}
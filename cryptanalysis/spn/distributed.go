@@ -0,0 +1,158 @@
+package spn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/gfmatrix"
+	"github.com/OpenWhiteBox/primitives/number"
+)
+
+// Worker runs the batches of an attack on an S-box layer against its own replica of the oracle, and ships the relations
+// they give to a Coordinator. Relations are shipped as frames written to any io.Writer, so that workers on different
+// machines can use whatever transport connects them to the coordinator.
+type Worker struct {
+	cipher    encoding.Block
+	generator Generator
+	o         *options
+
+	ims     IncrementalMatrices
+	pending [16][]gfmatrix.Row
+}
+
+// NewWorker returns a worker that queries cipher with the plaintexts generated by generator.
+func NewWorker(cipher encoding.Block, generator Generator, opts ...Option) *Worker {
+	o := newOptions(opts)
+	return &Worker{cipher: newOracle(cipher, o), generator: generator, o: o, ims: NewIncrementalMatrices(16, 256)}
+}
+
+// Run processes the given number of batches. Relations that raise the rank of the worker's own system are kept until
+// the next call to Ship.
+func (w *Worker) Run(batches int) {
+	for i := 0; i < batches; i++ {
+		rows := batchRows(w.cipher, w.generator, nil)
+
+		for _, pos := range w.ims.Add(rows[:]) {
+			w.pending[pos] = append(w.pending[pos], rows[pos])
+		}
+
+		w.o.metrics.Batch()
+	}
+}
+
+// Ship writes a frame with every relation found since the last call to Ship to dst.
+func (w *Worker) Ship(dst io.Writer) error {
+	for pos := range w.pending {
+		if err := binary.Write(dst, binary.BigEndian, uint16(len(w.pending[pos]))); err != nil {
+			return err
+		}
+
+		for _, row := range w.pending[pos] {
+			buf := make([]byte, 256)
+			for i, x := range row {
+				buf[i] = byte(x)
+			}
+
+			if _, err := dst.Write(buf); err != nil {
+				return err
+			}
+		}
+	}
+
+	w.pending = [16][]gfmatrix.Row{}
+	return nil
+}
+
+// Coordinator merges the relations shipped by Workers into one system per position, and recovers the S-boxes once it's
+// sufficiently defined.
+type Coordinator struct {
+	o   *options
+	ims IncrementalMatrices
+}
+
+// NewCoordinator returns a coordinator with an empty system for each position.
+func NewCoordinator(opts ...Option) *Coordinator {
+	return &Coordinator{o: newOptions(opts), ims: NewIncrementalMatrices(16, 256)}
+}
+
+// Receive reads one frame from src and merges its relations. It returns the positions whose rank increased.
+func (c *Coordinator) Receive(src io.Reader) (grown []int, err error) {
+	frame := NewIncrementalMatrices(16, 256)
+
+	for pos := range frame {
+		var n uint16
+		if err := binary.Read(src, binary.BigEndian, &n); err != nil {
+			return nil, err
+		}
+
+		for i := 0; i < int(n); i++ {
+			buf := make([]byte, 256)
+			if _, err := io.ReadFull(src, buf); err != nil {
+				return nil, err
+			}
+
+			row := gfmatrix.NewRow(256)
+			for j, x := range buf {
+				row[j] = number.ByteFieldElem(x)
+			}
+			frame[pos].Add(row)
+		}
+	}
+
+	grown = c.ims.Merge(frame)
+	for _, pos := range grown {
+		c.o.metrics.Rank(pos, c.ims.Rank(pos))
+		c.o.emit(RankIncreased{pos, c.ims.Rank(pos)})
+	}
+
+	return grown, nil
+}
+
+// Systems returns the merged system of each position.
+func (c *Coordinator) Systems() IncrementalMatrices {
+	return c.ims.Dup()
+}
+
+// SufficientlyDefined returns true once every position's system has enough relations to recover its S-box.
+func (c *Coordinator) SufficientlyDefined() bool {
+	return c.ims.SufficientlyDefined(c.o.sufficientRank())
+}
+
+// Recover recovers the S-box layer from the merged systems. Like RecoverSBoxes, the S-boxes are found for the trailing
+// layer of the cipher the workers attack. It returns a *RecoveryError if some positions can't be recovered, in which
+// case they hold the identity.
+func (c *Coordinator) Recover() (last encoding.ConcatenatedBlock, err error) {
+	failed := &RecoveryError{}
+
+	for pos := range last {
+		last[pos] = encoding.IdentityByte{}
+		diag := diagnose(c.ims.Rank(pos), 0, c.o.sufficientRank())
+
+		if c.ims.Rank(pos) < c.o.sufficientRank() {
+			failed.add(pos, InsufficientRank, c.ims[pos], diag, 0)
+			continue
+		}
+
+		v, ok := findPermutation(c.ims[pos].Matrix().NullSpace(), c.o.search, c.o.workers)
+		if !ok {
+			failed.add(pos, NoPermutation, c.ims[pos], diag, 0)
+			continue
+		}
+
+		last[pos] = newSBox(v, true)
+	}
+
+	if len(failed.Positions) > 0 {
+		return last, failed
+	}
+
+	return last, nil
+}
+
+// String summarizes the coordinator's progress.
+func (c *Coordinator) String() string {
+	min, max := c.ims.rankRange()
+	return fmt.Sprintf("coordinator (rank %v to %v of %v)", min, max, c.o.sufficientRank())
+}
@@ -0,0 +1,317 @@
+package spn
+
+import (
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/matrix"
+
+	"github.com/OpenWhiteBox/Generic/constructions/spn"
+	"github.com/OpenWhiteBox/Generic/internal/aes"
+)
+
+// aesPolynomial is the modulus of AES's representation of GF(2^8), x^8 + x^4 + x^3 + x + 1.
+const aesPolynomial = 0x11b
+
+// KnownSBox is an S-box from a published design, possibly written in a non-standard representation of GF(2^8).
+// Polynomial is the modulus of that representation and Isomorphism is the linear map from the design's own
+// representation into it--the identity, for the design's own representation.
+type KnownSBox struct {
+	Name        string
+	Polynomial  uint16
+	Isomorphism matrix.Matrix
+	SBox        encoding.SBox
+}
+
+// Dual describes how an S-box collapses onto a known S-box: s = Out(Known(In(x))). At most one of In and Out is not
+// the identity, depending on which side of the S-box absorbed the ambiguity of the attack that recovered it.
+type Dual struct {
+	Known   KnownSBox
+	In, Out encoding.ByteAffine
+}
+
+// gfMul multiplies a and b in GF(2)[x]/poly.
+func gfMul(a, b byte, poly uint16) byte {
+	x, out := uint16(a), uint16(0)
+
+	for ; b > 0; b >>= 1 {
+		if b&1 == 1 {
+			out ^= x
+		}
+
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= poly
+		}
+	}
+
+	return byte(out)
+}
+
+// gfInvert inverts a in GF(2)[x]/poly, mapping zero to zero.
+func gfInvert(a byte, poly uint16) byte {
+	for b := 1; b < 256; b++ {
+		if gfMul(a, byte(b), poly) == 1 {
+			return byte(b)
+		}
+	}
+
+	return 0
+}
+
+// polyMod returns a mod b, where both are polynomials over GF(2).
+func polyMod(a, b uint16) uint16 {
+	degree := func(x uint16) (d int) {
+		for d = -1; x > 0; x >>= 1 {
+			d++
+		}
+		return
+	}
+
+	for db := degree(b); degree(a) >= db; {
+		a ^= b << uint(degree(a)-db)
+	}
+
+	return a
+}
+
+// irreduciblePolynomials returns every irreducible polynomial of degree 8 over GF(2).
+func irreduciblePolynomials() (out []uint16) {
+	for p := uint16(0x101); p < 0x200; p += 2 {
+		irreducible := true
+
+		for q := uint16(2); q < 0x20 && irreducible; q++ {
+			irreducible = polyMod(p, q) != 0
+		}
+
+		if irreducible {
+			out = append(out, p)
+		}
+	}
+
+	return
+}
+
+// aesSBox returns the AES S-box.
+func aesSBox() (out encoding.SBox) {
+	for x, y := range aes.SBox() {
+		out.EncKey[x], out.DecKey[y] = y, byte(x)
+	}
+
+	return
+}
+
+// byteMatrix returns the 8-by-8 binary matrix of the linear map f.
+func byteMatrix(f func(byte) byte) matrix.Matrix {
+	m := matrix.Matrix{}
+
+	for i := uint(0); i < 8; i++ {
+		row := byte(0)
+		for j := uint(0); j < 8; j++ {
+			row |= ((f(1<<j) >> i) & 1) << j
+		}
+
+		m = append(m, matrix.Row{row})
+	}
+
+	return m
+}
+
+// identityByte returns the identity as an encoding.ByteAffine.
+func identityByte() encoding.ByteAffine {
+	return encoding.NewByteAffine(matrix.GenerateIdentity(8), 0)
+}
+
+// AESDuals returns the AES S-box and its inverse in each of the 240 representations of GF(2^8): one for each irreducible
+// polynomial and each of the eight roots of AES's polynomial in the field it defines. Barkan and Biham show that
+// rewriting every constant of AES in one of these representations gives a dual cipher that computes a conjugate of AES.
+//
+// "In How Many Ways Can You Write Rijndael?" by Elad Barkan and Eli Biham,
+// https://eprint.iacr.org/2002/157.pdf
+func AESDuals() (out []KnownSBox) {
+	sbox := aesSBox()
+	inverse := encoding.SBox{EncKey: sbox.DecKey, DecKey: sbox.EncKey}
+
+	for _, poly := range irreduciblePolynomials() {
+		for r := 0; r < 256; r++ {
+			root := byte(r)
+
+			// Check that root is a root of x^8 + x^4 + x^3 + x + 1 in GF(2)[x]/poly.
+			powers := [9]byte{1}
+			for i := 1; i < 9; i++ {
+				powers[i] = gfMul(powers[i-1], root, poly)
+			}
+
+			if powers[8]^powers[4]^powers[3]^powers[1]^powers[0] != 0 {
+				continue
+			}
+
+			// The isomorphism sends x to root, so it sends the i^th basis element to root^i.
+			iso := func(x byte) (y byte) {
+				for i := uint(0); i < 8; i++ {
+					if (x>>i)&1 == 1 {
+						y ^= powers[i]
+					}
+				}
+				return
+			}
+
+			for _, known := range []struct {
+				name string
+				sbox encoding.SBox
+			}{{"AES", sbox}, {"AES^-1", inverse}} {
+				dual := encoding.SBox{}
+				for x := 0; x < 256; x++ {
+					X, Y := iso(byte(x)), iso(known.sbox.EncKey[x])
+					dual.EncKey[X], dual.DecKey[Y] = Y, X
+				}
+
+				out = append(out, KnownSBox{
+					Name:        known.name,
+					Polynomial:  poly,
+					Isomorphism: byteMatrix(iso),
+					SBox:        dual,
+				})
+			}
+		}
+	}
+
+	return
+}
+
+// asAffine returns f as an affine transformation, if it is one.
+func asAffine(f func(byte) byte) (encoding.ByteAffine, bool) {
+	c := f(0)
+	linear := func(x byte) byte { return f(x) ^ c }
+
+	for x := 0; x < 256; x++ {
+		y := byte(0)
+		for i := uint(0); i < 8; i++ {
+			if (x>>i)&1 == 1 {
+				y ^= linear(1 << i)
+			}
+		}
+
+		if y != linear(byte(x)) {
+			return encoding.ByteAffine{}, false
+		}
+	}
+
+	m := byteMatrix(linear)
+	if _, ok := m.Invert(); !ok {
+		return encoding.ByteAffine{}, false
+	}
+
+	return encoding.NewByteAffine(m, c), true
+}
+
+// IdentifySBox checks whether s is one of the known S-boxes composed with an affine transformation on either its input
+// or its output. These are exactly the S-boxes the decomposition attacks can recover for a target built from a known
+// S-box: trailing S-boxes are recovered up to an affine transformation on their input, and leading S-boxes up to one on
+// their output.
+func IdentifySBox(s encoding.Byte, known []KnownSBox) (Dual, bool) {
+	for _, k := range known {
+		// s = k ∘ In
+		if in, ok := asAffine(func(x byte) byte { return k.SBox.Decode(s.Encode(x)) }); ok {
+			return Dual{Known: k, In: in, Out: identityByte()}, true
+		}
+
+		// s = Out ∘ k
+		if out, ok := asAffine(func(x byte) byte { return s.Encode(k.SBox.Decode(x)) }); ok {
+			return Dual{Known: k, In: identityByte(), Out: out}, true
+		}
+	}
+
+	return Dual{}, false
+}
+
+// IdentifySBoxLayer runs IdentifySBox on every S-box of an S-box layer. It returns true only if every S-box collapsed.
+func IdentifySBoxLayer(layer encoding.ConcatenatedBlock, known []KnownSBox) (duals [16]Dual, ok bool) {
+	return identifySBoxLayer(layer, func(s encoding.Byte) (Dual, bool) { return IdentifySBox(s, known) })
+}
+
+func identifySBoxLayer(layer encoding.ConcatenatedBlock, identify func(encoding.Byte) (Dual, bool)) (duals [16]Dual, ok bool) {
+	for pos := 0; pos < 16; pos++ {
+		if duals[pos], ok = identify(layer[pos]); !ok {
+			return
+		}
+	}
+
+	return duals, true
+}
+
+// isIdentity returns true if every byte-wise transformation in layer is the identity.
+func isIdentity(layer encoding.ConcatenatedBlock) bool {
+	for _, b := range layer {
+		for x := 0; x < 256; x++ {
+			if b.Encode(byte(x)) != byte(x) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Collapse rewrites a decomposed construction so that every S-box layer which IdentifySBoxLayer can identify uses the
+// known S-boxes directly, moving the affine transformations it finds into the neighboring affine layers (or new ones,
+// where there is no neighboring affine layer). It returns the rewritten construction and, for each S-box layer of the
+// input, the duals that were found or nil if the layer didn't collapse.
+//
+// If an SBoxIndex of the known S-boxes fits in the memory budget (see WithMemoryBudget), S-boxes are looked up in it
+// instead of being compared with every known S-box.
+func Collapse(constr spn.Construction, known []KnownSBox, opts ...Option) (out spn.Construction, duals []*[16]Dual) {
+	layers := []encoding.Block{}
+
+	identify := func(s encoding.Byte) (Dual, bool) { return IdentifySBox(s, known) }
+	if len(known)*indexEntrySize <= newOptions(opts).memoryBudget {
+		identify = NewSBoxIndex(known).Identify
+	}
+
+	for _, layer := range constr {
+		sboxes, ok := layer.(encoding.ConcatenatedBlock)
+		if !ok {
+			layers = append(layers, layer)
+			continue
+		}
+
+		found, ok := identifySBoxLayer(sboxes, identify)
+		if !ok {
+			layers, duals = append(layers, layer), append(duals, nil)
+			continue
+		}
+		duals = append(duals, &found)
+
+		in, middle, after := encoding.ConcatenatedBlock{}, encoding.ConcatenatedBlock{}, encoding.ConcatenatedBlock{}
+		for pos, dual := range found {
+			in[pos], middle[pos], after[pos] = dual.In, dual.Known.SBox, dual.Out
+		}
+
+		if !isIdentity(in) {
+			aff, _ := encoding.DecomposeBlockAffine(in)
+			layers = append(layers, aff)
+		}
+
+		layers = append(layers, middle)
+
+		if !isIdentity(after) {
+			aff, _ := encoding.DecomposeBlockAffine(after)
+			layers = append(layers, aff)
+		}
+	}
+
+	// Merge runs of consecutive affine layers.
+	for _, layer := range layers {
+		if len(out) > 0 {
+			last, ok1 := out[len(out)-1].(encoding.BlockAffine)
+			_, ok2 := layer.(encoding.BlockAffine)
+
+			if ok1 && ok2 {
+				out[len(out)-1], _ = encoding.DecomposeBlockAffine(encoding.ComposedBlocks{last, layer})
+				continue
+			}
+		}
+
+		out = append(out, layer)
+	}
+
+	return out, duals
+}
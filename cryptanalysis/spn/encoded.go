@@ -0,0 +1,69 @@
+package spn
+
+import (
+	"github.com/OpenWhiteBox/primitives/encoding"
+)
+
+// EncodedSBox is a trailing S-box that may have an unknown byte-wise encoding after it, as the outputs of encoded
+// white-boxes do. No query can tell the encoding apart from the S-box, so attacks recover their composition as one
+// S-box, and removing it leaves the rest of the cipher as it would without the encoding. What can be said about the two
+// factors depends on the encoding:
+//
+//   - If the composition is one of the known S-boxes with an affine transformation on its input or its output, it's
+//     factored like IdentifySBox factors it, and Dual.Out is the encoding.
+//   - If not, but it has the same differential uniformity, linearity, and degree as one of the known S-boxes, the
+//     encoding is probably affine, with the attack's affine ambiguity on the other side of the S-box. Factoring it
+//     would need an equivalence with affine transformations on both sides, which isn't searched for.
+//   - Otherwise the encoding is nonlinear, and it can't be separated from the S-box at all.
+type EncodedSBox struct {
+	Composition encoding.Byte
+
+	Factored bool
+	Dual     Dual
+
+	// Resembles holds the known S-boxes with the same affine-invariant properties as the composition.
+	Resembles []KnownSBox
+}
+
+// AffineEncoding returns true if the encoding was factored, or is probably affine.
+func (e EncodedSBox) AffineEncoding() bool {
+	return e.Factored || len(e.Resembles) > 0
+}
+
+// FactorOutputEncodings tries to factor each S-box of a recovered trailing S-box layer into one of the known S-boxes
+// and an encoding after it. See EncodedSBox.
+func FactorOutputEncodings(layer encoding.ConcatenatedBlock, known []KnownSBox) (out [16]EncodedSBox) {
+	features := make([]SBoxFeatures, len(known))
+	for i, k := range known {
+		features[i] = AnalyzeSBox(k.SBox)
+	}
+
+	for pos, s := range layer {
+		out[pos].Composition = s
+		if out[pos].Dual, out[pos].Factored = IdentifySBox(s, known); out[pos].Factored {
+			continue
+		}
+
+		f := AnalyzeSBox(s)
+		for i, k := range known {
+			kf := features[i]
+			if f.DifferentialUniformity == kf.DifferentialUniformity && f.Linearity == kf.Linearity && f.Degree == kf.Degree {
+				out[pos].Resembles = append(out[pos].Resembles, k)
+			}
+		}
+	}
+
+	return
+}
+
+// RecoverEncodedSBoxes is RecoverSBoxesDetailed for a target whose trailing S-boxes each have an unknown byte-wise
+// encoding after them. The compositions are recovered as the trailing S-box layer, and then factored against the known
+// S-boxes with FactorOutputEncodings.
+func RecoverEncodedSBoxes(cipher encoding.Block, generator func() [][16]byte, known []KnownSBox, opts ...Option) (*SBoxRecovery, [16]EncodedSBox, error) {
+	res, err := recoverSBoxes(cipher, generator, newOptions(opts), true)
+	if err != nil {
+		return res, [16]EncodedSBox{}, err
+	}
+
+	return res, FactorOutputEncodings(res.Last, known), nil
+}
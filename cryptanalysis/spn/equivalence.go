@@ -0,0 +1,60 @@
+package spn
+
+import (
+	"crypto/rand"
+	"math"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+)
+
+// equivalenceSamples returns the number of random inputs needed so that two functions that differ on at least the given
+// fraction of inputs agree on every one of them with probability at most 1 - confidence.
+func equivalenceSamples(confidence, fraction float64) int {
+	if confidence <= 0 {
+		return 0
+	} else if confidence >= 1 || fraction <= 0 {
+		panic("Confidence must be less than 1 and the fraction of differing inputs more than 0!")
+	} else if fraction >= 1 {
+		return 1
+	}
+
+	return int(math.Ceil(math.Log(1-confidence) / math.Log(1-fraction)))
+}
+
+// Equivalent decides whether a and b encrypt the same way, for validating a recovered decomposition against the cipher
+// it came from. Only their Encode methods are called.
+//
+// It first compares them on structured probes that catch differences confined to a few inputs of one byte, which random
+// sampling would almost always miss: zero, and every value of each byte with the other bytes zero, which includes every
+// input with a single bit set. Then it compares them on enough random inputs that, if they differ on at least the given fraction of
+// inputs, they're caught with probability at least confidence. It returns false and an input they differ on if it finds
+// one.
+func Equivalent(a, b encoding.Block, confidence, fraction float64) (ok bool, counterexample [16]byte) {
+	differ := func(in [16]byte) bool { return a.Encode(in) != b.Encode(in) }
+
+	if differ([16]byte{}) {
+		return false, [16]byte{}
+	}
+
+	for pos := 0; pos < 16; pos++ {
+		for x := 1; x < 256; x++ {
+			in := [16]byte{}
+			in[pos] = byte(x)
+
+			if differ(in) {
+				return false, in
+			}
+		}
+	}
+
+	for i := 0; i < equivalenceSamples(confidence, fraction); i++ {
+		in := [16]byte{}
+		rand.Read(in[:])
+
+		if differ(in) {
+			return false, in
+		}
+	}
+
+	return true, [16]byte{}
+}
@@ -0,0 +1,163 @@
+package spn
+
+import (
+	"math"
+
+	"github.com/OpenWhiteBox/Generic/constructions/spn"
+)
+
+// GeneratorType identifies a family of chosen-plaintext structures, for planning attacks before making any queries.
+type GeneratorType int
+
+const (
+	BalancedGenerator        GeneratorType = iota // BalancedPlaintexts(4), used against SA.
+	DualGenerator                                 // DualPlaintexts(4), used against SAS.
+	PermutationGenerator                          // PermutationPlaintexts(2^w), used against SASA and SASAS.
+	TrivialSubspaceGenerator                      // Fixing one input, used against AS.
+	LowRankAdditionGenerator                      // Low Rank Detection by addition, used against ASA.
+	LowRankToggleGenerator                        // Low Rank Detection by toggling a position, used against ASAS.
+)
+
+func (g GeneratorType) String() string {
+	switch g {
+	case BalancedGenerator:
+		return "balanced"
+	case DualGenerator:
+		return "dual"
+	case PermutationGenerator:
+		return "permutation"
+	case TrivialSubspaceGenerator:
+		return "trivial subspaces"
+	case LowRankAdditionGenerator:
+		return "low rank by addition"
+	case LowRankToggleGenerator:
+		return "low rank by toggling"
+	default:
+		return "unknown"
+	}
+}
+
+// Complexity is the predicted cost of an attack.
+type Complexity struct {
+	Batches int // Number of chosen-plaintext structures processed.
+	Queries int // Number of oracle queries.
+	Memory  int // Peak size of the linear systems kept by the attack, in bytes.
+	Time    int // Number of 64-bit word operations spent reducing the linear systems, not counting the oracle's time.
+}
+
+// Add returns the cost of running two attacks one after the other.
+func (c Complexity) Add(d Complexity) Complexity {
+	out := Complexity{Batches: c.Batches + d.Batches, Queries: c.Queries + d.Queries, Memory: c.Memory, Time: c.Time + d.Time}
+	if d.Memory > out.Memory {
+		out.Memory = d.Memory
+	}
+
+	return out
+}
+
+// Estimate predicts the cost of removing one layer of a cipher with the given block and S-box widths (in bits) with a
+// given type of generator. The predictions are expected values from a heuristic model of each attack, calibrated
+// against the 128-bit, 8-bit S-box case; individual runs routinely vary by a third in either direction. Time assumes
+// every new row is reduced against a full system, so it's an upper bound.
+func Estimate(blockWidth, sboxWidth int, generator GeneratorType) Complexity {
+	positions, values := blockWidth/sboxWidth, 1<<uint(sboxWidth)
+
+	switch generator {
+	case BalancedGenerator, DualGenerator, PermutationGenerator:
+		// Each batch contributes one row to the system of every position, and each system needs all but w+1 dimensions
+		// filled in. Small structures only touch a few entries of each row, so they take as long as it takes to see every
+		// value of the S-box (a coupon collector's problem); large ones are limited by the rank itself.
+		size := 4
+		if generator == PermutationGenerator {
+			size = values
+		}
+
+		rank := values - (sboxWidth + 1)
+		covering := 1.4 * float64(values) / float64(size) * (float64(sboxWidth)*math.Ln2 + 0.5772)
+		batches := int(math.Max(float64(rank+2), math.Ceil(covering)))
+
+		return Complexity{
+			Batches: batches,
+			Queries: batches * size,
+			Memory:  positions * values * values,
+			Time:    batches * positions * rank * words(values),
+		}
+
+	case TrivialSubspaceGenerator:
+		// One subspace per position, each spanned by differences of pairs of ciphertexts.
+		dim := blockWidth - sboxWidth
+
+		return Complexity{
+			Batches: positions,
+			Queries: positions * 2 * (dim + 2),
+			Memory:  positions * dim * blockWidth / 8,
+			Time:    positions * (dim + 2) * dim * words(blockWidth),
+		}
+
+	case LowRankAdditionGenerator, LowRankToggleGenerator:
+		// An attempt finds a collision when the internal states of the pair collide in exactly one S-box, and the attack
+		// needs to see a collision in every one of them. Attempts with a collision never exceed the expected rank, so they
+		// make every one of their blockWidth+1 pairs of queries (even if the collision turns out to be a duplicate).
+		// Attempts without one stop as soon as they exceed it.
+		dim := blockWidth - sboxWidth
+		p := float64(positions) / float64(values) * math.Pow(1-1/float64(values), float64(positions-1))
+
+		harmonic := 0.0
+		for i := 1; i <= positions; i++ {
+			harmonic += 1 / float64(i)
+		}
+
+		collisions := int(math.Ceil(float64(positions) * harmonic))
+		attempts := int(math.Ceil(float64(collisions) / p))
+
+		queries := collisions*2*(blockWidth+1) + (attempts-collisions)*2*(dim+1)
+
+		return Complexity{
+			Batches: attempts,
+			Queries: queries,
+			Memory:  2 * positions * dim * blockWidth / 8,
+			Time:    queries / 2 * (dim + 1) * words(blockWidth),
+		}
+
+	default:
+		panic("Unknown generator type!")
+	}
+}
+
+// words returns the number of 64-bit words in a row of n bits.
+func words(n int) int { return (n + 63) / 64 }
+
+// EstimateSPN predicts the cost of DecomposeSPN against a construction with the given structure, by adding up the cost
+// of removing each layer.
+func EstimateSPN(structure spn.Structure) Complexity {
+	return EstimateStructure(128, 8, structure)
+}
+
+// EstimateStructure is EstimateSPN for a cipher with the given block and S-box widths (in bits), so that attacks on
+// ciphers of other sizes can be compared before they're run.
+func EstimateStructure(blockWidth, sboxWidth int, structure spn.Structure) Complexity {
+	positions, values := blockWidth/sboxWidth, 1<<uint(sboxWidth)
+	est := func(generator GeneratorType) Complexity { return Estimate(blockWidth, sboxWidth, generator) }
+	rest := func(structure spn.Structure) Complexity { return EstimateStructure(blockWidth, sboxWidth, structure) }
+
+	// The last layer left over is read off directly: an S-box layer a byte at a time, and an affine layer from the image
+	// of each basis vector.
+	switch structure {
+	case spn.AS:
+		return est(TrivialSubspaceGenerator).Add(Complexity{Queries: positions * values})
+	case spn.SA:
+		return est(BalancedGenerator).Add(Complexity{Queries: blockWidth + 1})
+	case spn.ASA:
+		return est(LowRankAdditionGenerator).Add(rest(spn.SA))
+	case spn.SAS:
+		return est(DualGenerator).Add(rest(spn.AS))
+	case spn.ASAS:
+		return est(LowRankToggleGenerator).Add(rest(spn.SAS))
+	case spn.SASA:
+		return est(PermutationGenerator).Add(rest(spn.ASA))
+	case spn.SASAS:
+		return est(PermutationGenerator).Add(rest(spn.ASAS))
+	default:
+		panic("Unknown SPN structure!")
+	}
+}
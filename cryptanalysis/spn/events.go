@@ -0,0 +1,82 @@
+package spn
+
+import (
+	"github.com/OpenWhiteBox/primitives/encoding"
+)
+
+// Event is something that happened during an attack on a trailing S-box layer. It's one of BatchCollected,
+// RankIncreased, PositionSolved, or AttackFinished.
+type Event interface {
+	event()
+}
+
+// BatchCollected is emitted after each set of plaintexts has been queried and its relations added to the systems.
+type BatchCollected struct {
+	Batch int   // Batches collected so far, including this one.
+	Stage int   // Index of the generator in use: 0 for the one the attack was given, then each of WithEscalation's.
+	Grown []int // Positions whose rank this batch increased.
+}
+
+// RankIncreased is emitted whenever the system of relations for a position reaches a new rank.
+type RankIncreased struct {
+	Position, Rank int
+}
+
+// PositionSolved is emitted when the S-box at a position has been recovered.
+type PositionSolved struct {
+	Position int
+	SBox     encoding.Byte
+}
+
+// AttackFinished is emitted once, when the attack returns. Err is the error it returns, if any.
+type AttackFinished struct {
+	Recovered [16]bool
+	Err       error
+}
+
+func (BatchCollected) event() {}
+func (RankIncreased) event()  {}
+func (PositionSolved) event() {}
+func (AttackFinished) event() {}
+
+// WithEvents calls handler with every Event of attacks on trailing S-box layers, so that a user interface or notebook can
+// follow the attack as it runs. The handler is called synchronously from the attack's goroutine, so it should hand
+// events off quickly--to a buffered channel, for example--instead of doing slow work itself.
+func WithEvents(handler func(Event)) Option {
+	return func(o *options) { o.events = handler }
+}
+
+// emit passes e to the attack's event handler and hooks, if it has them.
+func (o *options) emit(e Event) {
+	if o.events != nil {
+		o.events(e)
+	}
+
+	switch e := e.(type) {
+	case RankIncreased:
+		if o.hooks.OnRank != nil {
+			o.hooks.OnRank(e.Position, e.Rank)
+		}
+	case PositionSolved:
+		if o.hooks.OnSolve != nil {
+			o.hooks.OnSolve(e.Position, e.SBox)
+		}
+	}
+}
+
+// Hooks are called at the key points of an attack, so that callers can build metrics, dashboards, or strategies that
+// adapt to how an attack is going, without changing the attack. Any of them can be nil.
+//
+// OnQuery is called with every query an attack makes to its oracle, of any attack. OnRank and OnSolve are called with
+// the RankIncreased and PositionSolved events of attacks on trailing S-box layers. Hooks are called synchronously, like
+// WithEvents's handler, and OnQuery is called concurrently if the attack queries concurrently.
+type Hooks struct {
+	OnQuery func(q Query)
+	OnRank  func(pos, rank int)
+	OnSolve func(pos int, sbox encoding.Byte)
+}
+
+// WithHooks attaches hooks to the attack. It replaces any hooks attached before.
+func WithHooks(h Hooks) Option {
+	return func(o *options) { o.hooks = h }
+}
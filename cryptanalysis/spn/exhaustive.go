@@ -0,0 +1,96 @@
+package spn
+
+import (
+	"github.com/OpenWhiteBox/primitives/gfmatrix"
+	"github.com/OpenWhiteBox/primitives/number"
+)
+
+// echelon returns a basis for the span of basis in reduced row echelon form, along with the pivot column of each row.
+func echelon(basis []gfmatrix.Row) (rows []gfmatrix.Row, pivots []int) {
+	for _, row := range basis {
+		rows = append(rows, row.Dup())
+	}
+
+	r := 0
+	for col := 0; col < rows[0].Size() && r < len(rows); col++ {
+		p := -1
+		for i := r; i < len(rows); i++ {
+			if !rows[i][col].IsZero() {
+				p = i
+				break
+			}
+		}
+
+		if p == -1 {
+			continue
+		}
+
+		rows[r], rows[p] = rows[p], rows[r]
+		rows[r] = rows[r].ScalarMul(rows[r][col].Invert())
+
+		for i := range rows {
+			if i != r && !rows[i][col].IsZero() {
+				rows[i] = rows[i].Add(rows[r].ScalarMul(rows[i][col]))
+			}
+		}
+
+		pivots = append(pivots, col)
+		r++
+	}
+
+	return rows[:r], pivots
+}
+
+// enumeratePermutations calls visit on every linear combination of basis that gives a permutation vector, until visit
+// returns false.
+//
+// The basis is put in reduced row echelon form first. Then a combination's entries before the pivot of its k^th row
+// only depend on its first k coefficients, so any choice of them that already repeats a value in those entries can be
+// pruned along with every combination that extends it.
+func enumeratePermutations(basis []gfmatrix.Row, visit func(gfmatrix.Row) bool) {
+	if len(basis) == 0 {
+		return
+	}
+
+	rows, pivots := echelon(basis)
+	size := rows[0].Size()
+	if size > 256 {
+		size = 256
+	}
+
+	// end returns the first entry that isn't determined once the first k coefficients are chosen.
+	end := func(k int) int {
+		if k < 0 {
+			return 0
+		} else if k < len(rows) && pivots[k] < size {
+			return pivots[k]
+		}
+		return size
+	}
+
+	// search checks the entries that the first k coefficients, combined into v, have just fixed, and then tries every
+	// value of the next one.
+	var search func(k int, v gfmatrix.Row, used [256]bool) bool
+	search = func(k int, v gfmatrix.Row, used [256]bool) bool {
+		for i := end(k - 1); i < end(k); i++ {
+			if used[v[i]] {
+				return true
+			}
+			used[v[i]] = true
+		}
+
+		if k == len(rows) {
+			return visit(v)
+		}
+
+		for c := 0; c < 256; c++ {
+			if !search(k+1, v.Add(rows[k].ScalarMul(number.ByteFieldElem(c))), used) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	search(0, gfmatrix.NewRow(rows[0].Size()), [256]bool{})
+}
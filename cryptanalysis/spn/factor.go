@@ -0,0 +1,96 @@
+package spn
+
+import (
+	"github.com/OpenWhiteBox/primitives/matrix"
+)
+
+// Factorization is a binary matrix put in reduced row echelon form once, so that systems sharing it as their
+// coefficient matrix can be solved for many right-hand sides without redoing elimination. It remembers the row
+// operations of the elimination as a matrix T, with T·m equal to the echelon form, so a solve only applies T to the
+// right-hand side and reads the solution off at the pivots.
+type Factorization struct {
+	echelon   matrix.Matrix // The reduced row echelon form of m; rows after the rank are zero.
+	transform matrix.Matrix // T, taking m to echelon.
+	pivots    []int         // The pivot column of each nonzero row of echelon.
+	cols      int
+}
+
+// Factor eliminates m. m isn't modified.
+func Factor(m matrix.Matrix) *Factorization {
+	rows, cols := m.Size()
+	f := &Factorization{echelon: m.Dup(), transform: matrix.GenerateIdentity(rows), cols: cols}
+
+	for col := 0; col < cols && len(f.pivots) < rows; col++ {
+		r := len(f.pivots)
+
+		pivot := -1
+		for i := r; i < rows; i++ {
+			if f.echelon[i].GetBit(col) == 1 {
+				pivot = i
+				break
+			}
+		}
+		if pivot == -1 {
+			continue
+		}
+
+		f.echelon[r], f.echelon[pivot] = f.echelon[pivot], f.echelon[r]
+		f.transform[r], f.transform[pivot] = f.transform[pivot], f.transform[r]
+
+		for i := 0; i < rows; i++ {
+			if i != r && f.echelon[i].GetBit(col) == 1 {
+				f.echelon[i] = f.echelon[i].Add(f.echelon[r])
+				f.transform[i] = f.transform[i].Add(f.transform[r])
+			}
+		}
+
+		f.pivots = append(f.pivots, col)
+	}
+
+	return f
+}
+
+// Rank returns the rank of the factored matrix.
+func (f *Factorization) Rank() int {
+	return len(f.pivots)
+}
+
+// Solve returns an x with m·x = b, with every free variable set to zero. It returns false if there's no such x.
+func (f *Factorization) Solve(b matrix.Row) (x matrix.Row, ok bool) {
+	c := f.transform.Mul(b)
+	for i := len(f.pivots); i < len(f.transform); i++ {
+		if c.GetBit(i) == 1 {
+			return nil, false
+		}
+	}
+
+	x = matrix.NewRow(f.cols)
+	for i, p := range f.pivots {
+		x = x.SetBit(p, c.GetBit(i) == 1)
+	}
+
+	return x, true
+}
+
+// NullSpace returns a basis for the solutions of m·x = 0: one for each free variable, set to one with every other free
+// variable set to zero.
+func (f *Factorization) NullSpace() (basis []matrix.Row) {
+	isPivot := make([]bool, f.cols)
+	for _, p := range f.pivots {
+		isPivot[p] = true
+	}
+
+	for free := 0; free < f.cols; free++ {
+		if isPivot[free] {
+			continue
+		}
+
+		v := matrix.NewRow(f.cols).SetBit(free, true)
+		for i, p := range f.pivots {
+			v = v.SetBit(p, f.echelon[i].GetBit(free) == 1)
+		}
+		basis = append(basis, v)
+	}
+
+	return
+}
@@ -0,0 +1,155 @@
+package spn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+)
+
+// Operations of a framed request.
+const (
+	framedEncrypt byte = 'E'
+	framedDecrypt byte = 'D'
+)
+
+// maxFrame bounds the length of a frame, so that a corrupted length doesn't make the reader allocate gigabytes.
+const maxFrame = 1 << 16
+
+// ErrFrameTooLong is returned when a frame claims to be longer than any frame of the protocol can be.
+var ErrFrameTooLong = errors.New("frame is too long")
+
+// writeFrame writes payload with its length in front, as a big-endian uint32.
+func writeFrame(w io.Writer, payload []byte) error {
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame reads a frame written by writeFrame and returns its payload.
+func readFrame(r io.Reader) ([]byte, error) {
+	length := [4]byte{}
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(length[:])
+	if n > maxFrame {
+		return nil, ErrFrameTooLong
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return payload, nil
+}
+
+// FramedOracle queries a cipher on the other end of a byte stream--a serial port, a unix socket, or an SSH channel--so
+// that a target can be an oracle without glue code of its own. Every message is a frame: a big-endian uint32 length,
+// followed by that many bytes. A request is the operation, 'E' to encrypt or 'D' to decrypt, followed by the 16-byte
+// block. The response is a zero byte followed by the 16-byte result, or a nonzero byte followed by a description of why
+// the target failed. ServeFramed is the other end.
+//
+// Queries are serialized, so it's safe for concurrent use.
+type FramedOracle struct {
+	mu sync.Mutex
+	rw io.ReadWriter
+}
+
+// NewFramedOracle returns an oracle that makes its queries over rw.
+func NewFramedOracle(rw io.ReadWriter) *FramedOracle {
+	return &FramedOracle{rw: rw}
+}
+
+// query sends one request and waits for its response.
+func (f *FramedOracle) query(op byte, in [16]byte) (out [16]byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := writeFrame(f.rw, append([]byte{op}, in[:]...)); err != nil {
+		return out, err
+	}
+
+	payload, err := readFrame(f.rw)
+	if err != nil {
+		return out, err
+	} else if len(payload) > 0 && payload[0] != 0 {
+		return out, fmt.Errorf("target failed: %s", payload[1:])
+	} else if len(payload) != 17 {
+		return out, fmt.Errorf("response has %v bytes instead of 17", len(payload))
+	}
+	copy(out[:], payload[1:])
+
+	return out, nil
+}
+
+func (f *FramedOracle) Encode(in [16]byte) [16]byte {
+	out, err := f.query(framedEncrypt, in)
+	if err != nil {
+		panic(fmt.Sprintf("cryptanalysis/spn.FramedOracle: encryption failed: %v", err))
+	}
+
+	return out
+}
+
+func (f *FramedOracle) Decode(in [16]byte) [16]byte {
+	out, err := f.query(framedDecrypt, in)
+	if err != nil {
+		panic(fmt.Sprintf("cryptanalysis/spn.FramedOracle: decryption failed: %v", err))
+	}
+
+	return out
+}
+
+// ServeFramed answers the requests of a FramedOracle on rw with cipher, until rw is closed between requests. A request
+// that cipher panics on is answered with the panic, so a cipher that only encrypts can be served too.
+func ServeFramed(rw io.ReadWriter, cipher encoding.Block) error {
+	for {
+		req, err := readFrame(rw)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := writeFrame(rw, serveRequest(cipher, req)); err != nil {
+			return err
+		}
+	}
+}
+
+// serveRequest returns the response to one request.
+func serveRequest(cipher encoding.Block, req []byte) (resp []byte) {
+	failure := func(reason string) []byte { return append([]byte{1}, reason...) }
+	if len(req) != 17 {
+		return failure("malformed request")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			resp = failure(fmt.Sprint(r))
+		}
+	}()
+
+	in, out := [16]byte{}, [16]byte{}
+	copy(in[:], req[1:])
+
+	switch req[0] {
+	case framedEncrypt:
+		out = cipher.Encode(in)
+	case framedDecrypt:
+		out = cipher.Decode(in)
+	default:
+		return failure("unknown operation")
+	}
+
+	return append([]byte{0}, out[:]...)
+}
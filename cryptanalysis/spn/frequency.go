@@ -0,0 +1,99 @@
+package spn
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+)
+
+// FrequencyReport holds per-position statistics of the bytes of ciphertexts across a number of chosen-plaintext
+// structures. They're the raw signal RecoverSBoxes works from: each structure gives a position one relation, namely
+// which of its values appeared an odd number of times.
+type FrequencyReport struct {
+	Batches, Samples int
+
+	// Counts is how many times each value appeared at each position, over every structure.
+	Counts [16][256]int
+
+	// Distinct is how many different values each position took. Positions that are outputs of S-boxes take nearly all
+	// 256 of them after a few hundred structures; far fewer means the position is degenerate, like a truncated one.
+	Distinct [16]int
+
+	// Odd is the average number of values that appeared an odd number of times in one structure, for each position.
+	// Structures that give no relation to a position leave it at 0, since every value appears an even number of times.
+	Odd [16]float64
+
+	// Empty is the number of structures that left each position without a relation.
+	Empty [16]int
+}
+
+// AnalyzeFrequencies queries the cipher on batches structures from generator and reports the statistics of each
+// position of their ciphertexts.
+func AnalyzeFrequencies(cipher encoding.Block, generator Generator, batches int, opts ...Option) *FrequencyReport {
+	o := newOptions(opts)
+	orc := newOracle(cipher, o)
+	r := &FrequencyReport{Batches: batches}
+
+	for i := 0; i < batches; i++ {
+		batch := [16][256]int{}
+
+		for _, pt := range generator() {
+			ct := orc.Encode(pt)
+			for pos, v := range ct {
+				batch[pos][v]++
+			}
+
+			r.Samples++
+		}
+
+		for pos := range batch {
+			odd := 0
+			for v, c := range batch[pos] {
+				if r.Counts[pos][v] == 0 && c > 0 {
+					r.Distinct[pos]++
+				}
+				r.Counts[pos][v] += c
+				odd += c % 2
+			}
+
+			r.Odd[pos] += float64(odd) / float64(batches)
+			if odd == 0 {
+				r.Empty[pos]++
+			}
+		}
+
+		o.metrics.Batch()
+	}
+
+	return r
+}
+
+// MinMax returns the number of times the rarest and the most common value appeared at pos.
+func (r *FrequencyReport) MinMax(pos int) (min, max int) {
+	min = r.Counts[pos][0]
+	for _, c := range r.Counts[pos] {
+		if c < min {
+			min = c
+		}
+		if c > max {
+			max = c
+		}
+	}
+
+	return
+}
+
+// String formats the report as a table with one row for each position.
+func (r *FrequencyReport) String() string {
+	out := &strings.Builder{}
+	fmt.Fprintf(out, "%v structures, %v ciphertexts\n", r.Batches, r.Samples)
+	fmt.Fprintf(out, "%-8v %8v %6v %6v %8v %6v\n", "position", "distinct", "min", "max", "odd", "empty")
+
+	for pos := range r.Counts {
+		min, max := r.MinMax(pos)
+		fmt.Fprintf(out, "%-8v %8v %6v %6v %8.2f %6v\n", pos, r.Distinct[pos], min, max, r.Odd[pos], r.Empty[pos])
+	}
+
+	return out.String()
+}
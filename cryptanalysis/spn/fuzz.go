@@ -0,0 +1,44 @@
+package spn
+
+import (
+	"errors"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+)
+
+// ErrMalformedTranscript is returned by RecoverSBoxesFromTranscript when its input ends in the middle of a batch.
+var ErrMalformedTranscript = errors.New("transcript ends in the middle of a batch")
+
+// RecoverSBoxesFromTranscript recovers a trailing S-box layer from the ciphertexts of an attack that's already been
+// run, without querying anything. The data is a sequence of batches, each a byte n followed by the n 16-byte ciphertexts
+// of one set of plaintexts from a generator. Each batch gives one relation per position, as in RecoverSBoxes, and the
+// S-boxes are then found like Coordinator.Recover finds them, returning a *RecoveryError for the positions whose systems
+// aren't sufficiently defined or have no permutation vector.
+//
+// It's meant as an entry point for fuzzing the algebra behind the attacks: only the search of a sufficiently defined
+// nullspace is randomized, and no input makes it panic.
+func RecoverSBoxesFromTranscript(data []byte, opts ...Option) (encoding.ConcatenatedBlock, error) {
+	c := NewCoordinator(opts...)
+
+	for len(data) > 0 {
+		n := int(data[0])
+		if len(data) < 1+16*n {
+			return encoding.ConcatenatedBlock{}, ErrMalformedTranscript
+		}
+
+		cts := make([][16]byte, n)
+		for i := range cts {
+			copy(cts[i][:], data[1+16*i:])
+		}
+		data = data[1+16*n:]
+
+		rows := ciphertextRows(cts)
+		grown := c.ims.Add(rows[:])
+		for _, pos := range grown {
+			c.o.metrics.Rank(pos, c.ims.Rank(pos))
+			c.o.emit(RankIncreased{pos, c.ims.Rank(pos)})
+		}
+	}
+
+	return c.Recover()
+}
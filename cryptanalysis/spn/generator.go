@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 
 	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/matrix"
 )
 
 type Generator func() [][16]byte
@@ -74,3 +75,49 @@ func PermutationPlaintexts(n int) Generator {
 		return out
 	}
 }
+
+// influence returns the input positions that a linear layer diffuses into any of the target output positions. The layer
+// is given as a 128-by-128 matrix over GF(2).
+func influence(diffusion matrix.Matrix, targets []int) (positions []int) {
+	for in := 0; in < 16; in++ {
+	found:
+		for _, out := range targets {
+			for bit := 0; bit < 8; bit++ {
+				if diffusion[8*out+bit][in] != 0 {
+					positions = append(positions, in)
+					break found
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// TargetedPermutationPlaintexts is PermutationPlaintexts, except that it only ever saturates positions that diffusion,
+// the known linear layer between the plaintext and the S-boxes being attacked, diffuses into the target positions. A
+// set that saturates any other position leaves the inputs of the target S-boxes constant and gives them no relations,
+// so when only some S-boxes need to be recovered and the layer is sparse, like one round of AES's, this saves the
+// batches PermutationPlaintexts would waste on them.
+func TargetedPermutationPlaintexts(n int, diffusion matrix.Matrix, targets ...int) Generator {
+	active := influence(diffusion, targets)
+	if len(active) == 0 {
+		panic("No position of the plaintext reaches the targets!")
+	}
+
+	return func() (out [][16]byte) {
+		master := [17]byte{}
+		rand.Read(master[:])
+		pos := active[int(master[16])%len(active)]
+
+		for i := 0; i < n; i++ {
+			pt := [16]byte{}
+			copy(pt[:], master[:16])
+			pt[pos] ^= byte(i)
+
+			out = append(out, pt)
+		}
+
+		return out
+	}
+}
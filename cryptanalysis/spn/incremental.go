@@ -0,0 +1,147 @@
+package spn
+
+import (
+	"github.com/OpenWhiteBox/primitives/gfmatrix"
+)
+
+// IncrementalMatrices is a system of linear relations for each of several positions, built up a row at a time. Attacks
+// that learn relations about every S-box of a layer at once, like RecoverSBoxes, keep one for the whole layer.
+type IncrementalMatrices []gfmatrix.IncrementalMatrix
+
+// NewIncrementalMatrices returns a new slice of x n-by-n incremental matrices.
+func NewIncrementalMatrices(x, n int) (ims IncrementalMatrices) {
+	ims = make([]gfmatrix.IncrementalMatrix, x)
+	for i := range ims {
+		ims[i] = gfmatrix.NewIncrementalMatrix(n)
+	}
+
+	return
+}
+
+// Add adds the i^th row to the i^th incremental matrix and returns the positions whose rank increased.
+func (ims IncrementalMatrices) Add(rows []gfmatrix.Row) (grown []int) {
+	if len(rows) != len(ims) {
+		panic("Number of rows doesn't match the number of positions!")
+	}
+
+	for pos, row := range rows {
+		if ims[pos].Add(row) {
+			grown = append(grown, pos)
+		}
+	}
+
+	return
+}
+
+// Merge adds every relation in other to the incremental matrix at the same position, as if the rows that built other
+// had been added to ims as well. It returns the positions whose rank increased.
+func (ims IncrementalMatrices) Merge(other IncrementalMatrices) (grown []int) {
+	if len(other) != len(ims) {
+		panic("Can't merge systems with different numbers of positions!")
+	}
+
+	for pos := range other {
+		novel := false
+		for _, row := range other[pos].Matrix() {
+			novel = ims[pos].Add(row) || novel
+		}
+
+		if novel {
+			grown = append(grown, pos)
+		}
+	}
+
+	return
+}
+
+// Union returns a new system whose row space at each position is the union of the row spaces of every system at that
+// position. None of the systems are modified, so it can combine systems that are still being accumulated elsewhere.
+func Union(systems ...IncrementalMatrices) IncrementalMatrices {
+	if len(systems) == 0 {
+		return IncrementalMatrices{}
+	}
+
+	out := systems[0].Dup()
+	for _, other := range systems[1:] {
+		out.Merge(other)
+	}
+
+	return out
+}
+
+// Dup returns a copy of every incremental matrix.
+func (ims IncrementalMatrices) Dup() IncrementalMatrices {
+	out := make(IncrementalMatrices, len(ims))
+	for i := range ims {
+		out[i] = ims[i].Dup()
+	}
+
+	return out
+}
+
+// Rank returns the rank of the incremental matrix at pos.
+func (ims IncrementalMatrices) Rank(pos int) int {
+	return ims[pos].Len()
+}
+
+// Ranks returns the rank of each incremental matrix.
+func (ims IncrementalMatrices) Ranks() (out []int) {
+	for _, im := range ims {
+		out = append(out, im.Len())
+	}
+
+	return
+}
+
+// rankRange returns the smallest and largest rank of any incremental matrix.
+func (ims IncrementalMatrices) rankRange() (min, max int) {
+	min = -1
+
+	for _, rank := range ims.Ranks() {
+		if min == -1 || rank < min {
+			min = rank
+		}
+		if rank > max {
+			max = rank
+		}
+	}
+
+	return
+}
+
+// SufficientlyDefined returns true if every incremental matrix has at least the given rank.
+func (ims IncrementalMatrices) SufficientlyDefined(rank int) bool {
+	return len(ims.Insufficient(rank)) == 0
+}
+
+// Insufficient returns the positions whose incremental matrices have less than the given rank.
+func (ims IncrementalMatrices) Insufficient(rank int) (positions []int) {
+	for pos, im := range ims {
+		if im.Len() < rank {
+			positions = append(positions, pos)
+		}
+	}
+
+	return
+}
+
+// Matrices returns a slice of matrices, one for each incremental matrix.
+func (ims IncrementalMatrices) Matrices() (out []gfmatrix.Matrix) {
+	out = make([]gfmatrix.Matrix, len(ims))
+	for i, im := range ims {
+		out[i] = im.Matrix()
+	}
+
+	return out
+}
+
+// NullSpaces returns a basis for the nullspace of each incremental matrix: the vectors that satisfy every relation
+// found for that position.
+func (ims IncrementalMatrices) NullSpaces() (out [][]gfmatrix.Row) {
+	out = make([][]gfmatrix.Row, len(ims))
+	for i, im := range ims {
+		out[i] = im.Matrix().NullSpace()
+	}
+
+	return out
+}
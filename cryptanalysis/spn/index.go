@@ -0,0 +1,64 @@
+package spn
+
+import (
+	"github.com/OpenWhiteBox/primitives/encoding"
+)
+
+// indexEntry is a known S-box filed under one of its canonical representatives, along with the affine transformation
+// that normalizes it.
+type indexEntry struct {
+	known int
+	aff   encoding.ByteAffine
+}
+
+// SBoxIndex identifies S-boxes like IdentifySBox, but by looking up their canonical representatives (see
+// NormalizeInput and NormalizeOutput) in precomputed tables instead of trying every known S-box in turn.
+type SBoxIndex struct {
+	known           []KnownSBox
+	inputs, outputs map[[256]byte][]indexEntry
+}
+
+// indexEntrySize is roughly how much memory an SBoxIndex takes for each known S-box: two representatives with their
+// affine transformations and map overhead.
+const indexEntrySize = 2 * (256 + 256 + 64)
+
+// NewSBoxIndex builds an index of the known S-boxes.
+func NewSBoxIndex(known []KnownSBox) *SBoxIndex {
+	idx := &SBoxIndex{
+		known:   known,
+		inputs:  make(map[[256]byte][]indexEntry),
+		outputs: make(map[[256]byte][]indexEntry),
+	}
+
+	for i, k := range known {
+		norm, in := NormalizeInput(k.SBox)
+		idx.inputs[norm.EncKey] = append(idx.inputs[norm.EncKey], indexEntry{i, in})
+
+		norm, out := NormalizeOutput(k.SBox)
+		idx.outputs[norm.EncKey] = append(idx.outputs[norm.EncKey], indexEntry{i, out})
+	}
+
+	return idx
+}
+
+// Identify is IdentifySBox, against the S-boxes in the index. It finds the same dual IdentifySBox would.
+func (idx *SBoxIndex) Identify(s encoding.Byte) (Dual, bool) {
+	normIn, inS := NormalizeInput(s)
+	normOut, outS := NormalizeOutput(s)
+	ins, outs := idx.inputs[normIn.EncKey], idx.outputs[normOut.EncKey]
+
+	// IdentifySBox prefers the first known S-box that matches, and an input transformation over an output one.
+	if len(ins) > 0 && (len(outs) == 0 || ins[0].known <= outs[0].known) {
+		// s = n(inS(x)) and k = n(inK(x)), so s = k(inK^-1(inS(x))).
+		inK := ins[0].aff
+		in, _ := asAffine(func(x byte) byte { return inK.Decode(inS.Encode(x)) })
+		return Dual{Known: idx.known[ins[0].known], In: in, Out: identityByte()}, true
+	} else if len(outs) > 0 {
+		// s = outS(n(x)) and k = outK(n(x)), so s = outS(outK^-1(k(x))).
+		outK := outs[0].aff
+		out, _ := asAffine(func(x byte) byte { return outS.Encode(outK.Decode(x)) })
+		return Dual{Known: idx.known[outs[0].known], In: identityByte(), Out: out}, true
+	}
+
+	return Dual{}, false
+}
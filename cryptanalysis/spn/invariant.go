@@ -0,0 +1,178 @@
+package spn
+
+import (
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/matrix"
+)
+
+// byteSpan is a subspace of GF(2)^8, kept as a basis reduced by leading bit.
+type byteSpan [8]byte
+
+// reduce returns x with every basis vector that shares its leading bit added to it.
+func (s *byteSpan) reduce(x byte) byte {
+	for bit := 7; bit >= 0; bit-- {
+		if (x>>uint(bit))&1 == 1 && s[bit] != 0 {
+			x ^= s[bit]
+		}
+	}
+
+	return x
+}
+
+// add adds x to the subspace and returns true if it wasn't already in it.
+func (s *byteSpan) add(x byte) bool {
+	x = s.reduce(x)
+	for bit := 7; bit >= 0; bit-- {
+		if (x>>uint(bit))&1 == 1 {
+			s[bit] = x
+			return true
+		}
+	}
+
+	return false
+}
+
+// canonical returns the subspace with its basis fully reduced, so that equal subspaces have equal bases.
+func (s byteSpan) canonical() byteSpan {
+	for bit := 7; bit >= 0; bit-- {
+		if s[bit] == 0 {
+			continue
+		}
+
+		for other := bit + 1; other < 8; other++ {
+			if (s[other]>>uint(bit))&1 == 1 {
+				s[other] ^= s[bit]
+			}
+		}
+	}
+
+	return s
+}
+
+// basis returns the basis vectors of the subspace.
+func (s *byteSpan) basis() (out []byte) {
+	for _, v := range s {
+		if v != 0 {
+			out = append(out, v)
+		}
+	}
+
+	return
+}
+
+// SBoxInvariantSubspaces returns the proper, nonzero subspaces V of GF(2)^8 that s maps to themselves up to a constant:
+// s(a + V) = s(a) + V for every a. Each is the smallest such subspace containing one nonzero byte, found by closing the
+// span of that byte under the differences s(a + v) + s(a) for v in it, and is returned as a basis. Random S-boxes have
+// none, while an S-box built from two 4-bit S-boxes has the subspaces of each nibble.
+func SBoxInvariantSubspaces(s encoding.Byte) (out [][]byte) {
+	seen := map[[8]byte]bool{}
+
+	for v := 1; v < 256; v++ {
+		span := byteSpan{}
+		span.add(byte(v))
+
+		for grown := true; grown; {
+			grown = false
+			for _, g := range span.basis() {
+				for a := 0; a < 256; a++ {
+					if span.add(s.Encode(byte(a)^g) ^ s.Encode(byte(a))) {
+						grown = true
+					}
+				}
+			}
+		}
+
+		if span = span.canonical(); len(span.basis()) < 8 && !seen[span] {
+			seen[span] = true
+			out = append(out, span.basis())
+		}
+	}
+
+	return
+}
+
+// InvariantSubspace is a subspace of the state that a round of an SPN maps to a coset of itself. At each position set in
+// Positions, it contains the subspace of the byte spanned by Basis, and it's zero at the other positions.
+type InvariantSubspace struct {
+	Positions uint16
+	Basis     []byte
+
+	// Dim is the dimension of the subspace. A key in a specific coset of it keeps a specific coset invariant, so the
+	// fraction of round keys that are weak for the invariant is 2^(Dim-128).
+	Dim int
+}
+
+// contains returns true if the state x is in the subspace.
+func (u InvariantSubspace) contains(x []byte) bool {
+	span := byteSpan{}
+	for _, v := range u.Basis {
+		span.add(v)
+	}
+
+	for pos, b := range x {
+		if (u.Positions>>uint(pos))&1 == 0 {
+			if b != 0 {
+				return false
+			}
+		} else if span.reduce(b) != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FindInvariantSubspaces searches for invariant subspaces of a round of an SPN with the given S-box layer and linear
+// layer, as recovered by a decomposition, for the invariant subspace attack. Each subspace it returns is built from an
+// invariant subspace of the S-boxes (see SBoxInvariantSubspaces) at some set of positions, which it shrinks until the
+// linear layer maps it into itself. A round with a key in the right coset then keeps a coset of it invariant.
+//
+// "A Cryptanalysis of PRINTcipher: The Invariant Subspace Attack" by Gregor Leander, Mohamed Ahmed Abdelraheem, Hoda
+// AlKhzaimi, and Erik Zenner, https://link.springer.com/chapter/10.1007/978-3-642-22792-9_12
+func FindInvariantSubspaces(sboxes encoding.ConcatenatedBlock, linear matrix.Matrix) (out []InvariantSubspace) {
+	candidates, compatible := [][]byte{}, map[string]uint16{}
+	for pos := 0; pos < 16; pos++ {
+		for _, basis := range SBoxInvariantSubspaces(sboxes[pos]) {
+			if _, ok := compatible[string(basis)]; !ok {
+				candidates = append(candidates, basis)
+			}
+			compatible[string(basis)] |= 1 << uint(pos)
+		}
+	}
+
+	for _, basis := range candidates {
+		u := InvariantSubspace{Positions: compatible[string(basis)], Basis: basis}
+
+		// Drop every position whose part of the subspace the linear layer maps outside of it.
+		for shrunk := true; shrunk && u.Positions != 0; {
+			shrunk = false
+
+			for pos := 0; pos < 16; pos++ {
+				if (u.Positions>>uint(pos))&1 == 0 {
+					continue
+				}
+
+				for _, v := range basis {
+					x := make(matrix.Row, 16)
+					x[pos] = v
+
+					if !u.contains(linear.Mul(x)) {
+						u.Positions &^= 1 << uint(pos)
+						shrunk = true
+						break
+					}
+				}
+			}
+		}
+
+		if u.Positions != 0 {
+			for pos := 0; pos < 16; pos++ {
+				u.Dim += len(basis) * int((u.Positions>>uint(pos))&1)
+			}
+
+			out = append(out, u)
+		}
+	}
+
+	return
+}
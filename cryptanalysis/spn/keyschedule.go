@@ -0,0 +1,176 @@
+package spn
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+)
+
+// aesWord is a column of the AES key schedule.
+type aesWord [4]byte
+
+func (w aesWord) xor(v aesWord) (out aesWord) {
+	for i := range out {
+		out[i] = w[i] ^ v[i]
+	}
+	return
+}
+
+// aesScheduleCore returns the word that's added to w[i-nk] to get w[i], given w[i-1] and the AES S-box.
+func aesScheduleCore(i, nk int, prev aesWord, sbox encoding.SBox) aesWord {
+	sub := func(w aesWord) (out aesWord) {
+		for j := range w {
+			out[j] = sbox.Encode(w[j])
+		}
+		return
+	}
+
+	if i%nk == 0 {
+		rcon := byte(1)
+		for j := 1; j < i/nk; j++ {
+			rcon = gfMul(rcon, 2, aesPolynomial)
+		}
+
+		out := sub(aesWord{prev[1], prev[2], prev[3], prev[0]})
+		out[0] ^= rcon
+		return out
+	} else if nk > 6 && i%nk == 4 {
+		return sub(prev)
+	}
+
+	return prev
+}
+
+// aesKeyWords returns the number of words in an AES key of the given size in bytes, and the number of rounds it's used
+// for.
+func aesKeyWords(keySize int) (nk, rounds int) {
+	switch keySize {
+	case 16, 24, 32:
+		return keySize / 4, keySize/4 + 6
+	default:
+		panic("AES keys are 16, 24, or 32 bytes long!")
+	}
+}
+
+// expandAESKey returns every round key of AES under key, from the first to the last.
+func expandAESKey(key []byte) (roundKeys [][16]byte) {
+	nk, rounds := aesKeyWords(len(key))
+	sbox := aesSBox()
+
+	w := make([]aesWord, 4*(rounds+1))
+	for i := 0; i < nk; i++ {
+		copy(w[i][:], key[4*i:])
+	}
+	for i := nk; i < len(w); i++ {
+		w[i] = w[i-nk].xor(aesScheduleCore(i, nk, w[i-1], sbox))
+	}
+
+	roundKeys = make([][16]byte, rounds+1)
+	for i := range w {
+		copy(roundKeys[i/4][4*(i%4):], w[i][:])
+	}
+
+	return
+}
+
+// InvertAESKeySchedule recovers an AES key of the given size in bytes from the last round keys of its key schedule,
+// given in order with the final round key last. Every word of the schedule is determined by the key-size's worth of
+// words before it, and vice versa, so it takes one round key for AES-128 and two for AES-192 and AES-256. It returns an
+// error if it's given too few.
+func InvertAESKeySchedule(keySize int, roundKeys [][16]byte) ([]byte, error) {
+	nk, rounds := aesKeyWords(keySize)
+	sbox := aesSBox()
+
+	if 4*len(roundKeys) < nk {
+		return nil, fmt.Errorf("recovering a %v-byte key takes %v round keys, not %v", keySize, (nk+3)/4, len(roundKeys))
+	} else if len(roundKeys) > rounds+1 {
+		return nil, fmt.Errorf("AES with a %v-byte key only has %v round keys, not %v", keySize, rounds+1, len(roundKeys))
+	}
+
+	// Fill in the end of the schedule with the words we're given, and run it backwards from there.
+	w := make([]aesWord, 4*(rounds+1))
+	start := len(w) - 4*len(roundKeys)
+	for i, rk := range roundKeys {
+		for j := 0; j < 4; j++ {
+			copy(w[start+4*i+j][:], rk[4*j:])
+		}
+	}
+
+	for i := start + nk - 1; i >= nk; i-- {
+		w[i-nk] = w[i].xor(aesScheduleCore(i, nk, w[i-1], sbox))
+	}
+
+	key := make([]byte, 0, keySize)
+	for i := 0; i < nk; i++ {
+		key = append(key, w[i][:]...)
+	}
+
+	return key, nil
+}
+
+var (
+	// ErrNoLastRoundKey is returned by RecoverAESKey when some recovered S-box isn't the AES S-box after an affine
+	// transformation, with a byte of key added to its output.
+	ErrNoLastRoundKey = errors.New("recovered S-boxes aren't AES's with a last round key")
+
+	// ErrWrongKey is returned by RecoverAESKey when the key it derives doesn't encrypt like the cipher.
+	ErrWrongKey = errors.New("derived key doesn't encrypt like the cipher")
+)
+
+// aesKeyChecks is the number of random plaintexts a derived AES key is checked against the cipher on.
+const aesKeyChecks = 4
+
+// RecoverLastRoundKey reads the last round key of AES out of a trailing S-box layer recovered from it. The last round
+// of AES is SubBytes, ShiftRows, and the last round key, so each recovered S-box is S(A(x)) ^ k for the AES S-box S, the
+// affine transformation A it's recovered up to, and byte k of the key at its position of the ciphertext. k is the only
+// byte for which S^-1(s(x) ^ k) is affine. It returns false if some S-box has no such byte.
+func RecoverLastRoundKey(last encoding.ConcatenatedBlock) (key [16]byte, ok bool) {
+	sbox := aesSBox()
+
+	for pos, s := range last {
+		found := false
+		for k := 0; k < 256 && !found; k++ {
+			if _, found = asAffine(func(x byte) byte { return sbox.Decode(s.Encode(x) ^ byte(k)) }); found {
+				key[pos] = byte(k)
+			}
+		}
+
+		if !found {
+			return key, false
+		}
+	}
+
+	return key, true
+}
+
+// RecoverAESKey derives an AES-128 key from the trailing S-box layer recovered from cipher, with RecoverLastRoundKey and
+// InvertAESKeySchedule, and checks that it encrypts like the cipher.
+func RecoverAESKey(cipher encoding.Block, last encoding.ConcatenatedBlock) ([]byte, error) {
+	roundKey, ok := RecoverLastRoundKey(last)
+	if !ok {
+		return nil, ErrNoLastRoundKey
+	}
+
+	key, err := InvertAESKeySchedule(16, [][16]byte{roundKey})
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := NewAES(key)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < aesKeyChecks; i++ {
+		pt := [16]byte{}
+		rand.Read(pt[:])
+
+		if cipher.Encode(pt) != target.Encode(pt) {
+			return nil, ErrWrongKey
+		}
+	}
+
+	return key, nil
+}
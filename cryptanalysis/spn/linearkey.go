@@ -0,0 +1,112 @@
+package spn
+
+import (
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/matrix"
+)
+
+// LinearKeySchedule is a key schedule in which every round key is an affine function of the master key: Rounds[i] is the
+// 128-by-n binary matrix taking an n-bit master key to round key i, and Constants[i] is added to the result.
+type LinearKeySchedule struct {
+	Rounds    []matrix.Matrix
+	Constants [][16]byte
+}
+
+// Expand returns every round key under the given master key.
+func (ks LinearKeySchedule) Expand(key matrix.Row) (out [][16]byte) {
+	out = make([][16]byte, len(ks.Rounds))
+	for i, m := range ks.Rounds {
+		rk := m.Mul(key)
+		encoding.XOR(out[i][:], rk, ks.Constants[i][:])
+	}
+
+	return
+}
+
+// RoundKey is a round key recovered by an attack. Attacks often only learn a round key up to some ambiguity--for
+// example, the part of it that's absorbed into an affine layer--so only the bits set in Mask are taken as known.
+type RoundKey struct {
+	Round     int
+	Key, Mask [16]byte
+}
+
+// keyEquation is one linear equation on the bits of a master key: a · key = b.
+type keyEquation struct {
+	a matrix.Row
+	b byte
+}
+
+// keySystem is a system of keyEquations in echelon form. Each equation is reduced by every one before it, so pivots[i]
+// is zero in every equation after i.
+type keySystem struct {
+	eqs    []keyEquation
+	pivots []int
+}
+
+// add reduces e by the system and adds it if it's novel. It returns false if e contradicts the system.
+func (ks *keySystem) add(e keyEquation) bool {
+	e.a = e.a.Dup()
+	for i, p := range ks.pivots {
+		if e.a.GetBit(p) == 1 {
+			e.a, e.b = e.a.Add(ks.eqs[i].a), e.b^ks.eqs[i].b
+		}
+	}
+
+	for p := 0; p < e.a.Size(); p++ {
+		if e.a.GetBit(p) == 1 {
+			ks.eqs, ks.pivots = append(ks.eqs, e), append(ks.pivots, p)
+			return true
+		}
+	}
+
+	return e.b == 0
+}
+
+// solve returns a solution of the system, with every free bit set to zero.
+func (ks *keySystem) solve(n int) matrix.Row {
+	key := matrix.NewRow(n)
+
+	for i := len(ks.eqs) - 1; i >= 0; i-- {
+		e, p := ks.eqs[i], ks.pivots[i]
+
+		x := e.b
+		for j := 0; j < n; j++ {
+			if j != p && e.a.GetBit(j) == 1 {
+				x ^= key.GetBit(j)
+			}
+		}
+		key = key.SetBit(p, x == 1)
+	}
+
+	return key
+}
+
+// Reconstruct finds a master key whose schedule agrees with every known bit of the given round keys. When the round keys
+// don't determine the master key, it's one of many equivalent keys--each gives the same known bits, and unknown bits are
+// set to zero wherever they're free. Round keys are taken in order, and the indices of those that contradict the ones
+// before them are returned in inconsistent; they're left out of the reconstruction.
+func (ks LinearKeySchedule) Reconstruct(known []RoundKey) (key matrix.Row, inconsistent []int) {
+	_, n := ks.Rounds[0].Size()
+	system := &keySystem{}
+
+	for i, rk := range known {
+		eqs, pivots := len(system.eqs), len(system.pivots)
+		consistent := true
+
+		for bit := 0; bit < 128 && consistent; bit++ {
+			if (rk.Mask[bit/8]>>uint(bit%8))&1 == 0 {
+				continue
+			}
+
+			b := (rk.Key[bit/8] ^ ks.Constants[rk.Round][bit/8]) >> uint(bit%8) & 1
+			consistent = system.add(keyEquation{ks.Rounds[rk.Round][bit], b})
+		}
+
+		if !consistent {
+			system.eqs, system.pivots = system.eqs[:eqs], system.pivots[:pivots]
+			inconsistent = append(inconsistent, i)
+		}
+	}
+
+	return system.solve(n), inconsistent
+}
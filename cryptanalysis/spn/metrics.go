@@ -0,0 +1,107 @@
+package spn
+
+import (
+	"expvar"
+	"strconv"
+	"sync"
+)
+
+// Metrics receives counters and gauges from a running attack, so that attacks embedded in long-running services can be
+// monitored. Implementations must be safe for concurrent use.
+type Metrics interface {
+	// Queries is called when n more queries have been made to the oracle.
+	Queries(n int)
+
+	// Batch is called when a set of chosen plaintexts has been processed.
+	Batch()
+
+	// Rank is called when the linear system kept for output position pos reaches a new rank.
+	Rank(pos, rank int)
+
+	// Subspaces is called when an attack on an affine layer has found n of the subspaces it needs, one for each position.
+	Subspaces(n int)
+
+	// Retry is called when a set of chosen plaintexts didn't contribute to the attack and another one will be tried.
+	Retry()
+}
+
+type noMetrics struct{}
+
+func (noMetrics) Queries(int)   {}
+func (noMetrics) Batch()        {}
+func (noMetrics) Rank(int, int) {}
+func (noMetrics) Retry()        {}
+func (noMetrics) Subspaces(int) {}
+
+// ExpvarMetrics implements Metrics by publishing a map of the form
+//
+//	{"queries": 123456, "batches": 1234, "retries": 12, "subspaces": 16, "rank": {"0": 247, "1": 245, ...}}
+//
+// with the expvar package, so that it's served at /debug/vars along with any other exported variables.
+type ExpvarMetrics struct {
+	queries, batches, retries, subspaces expvar.Int
+	rank                                 expvar.Map
+}
+
+// NewExpvarMetrics publishes a new set of metrics under the given name. Like expvar.Publish, it panics if the name is
+// already in use.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	m := &ExpvarMetrics{}
+	m.rank.Init()
+
+	root := expvar.NewMap(name)
+	root.Set("queries", &m.queries)
+	root.Set("batches", &m.batches)
+	root.Set("retries", &m.retries)
+	root.Set("subspaces", &m.subspaces)
+	root.Set("rank", &m.rank)
+
+	return m
+}
+
+func (m *ExpvarMetrics) Queries(n int)   { m.queries.Add(int64(n)) }
+func (m *ExpvarMetrics) Batch()          { m.batches.Add(1) }
+func (m *ExpvarMetrics) Retry()          { m.retries.Add(1) }
+func (m *ExpvarMetrics) Subspaces(n int) { m.subspaces.Set(int64(n)) }
+
+func (m *ExpvarMetrics) Rank(pos, rank int) {
+	v := new(expvar.Int)
+	v.Set(int64(rank))
+
+	m.rank.Set(strconv.Itoa(pos), v)
+}
+
+// RankState is the state of an attack at some point while it's running, as recorded by a RankTracker.
+type RankState struct {
+	Queries, Batches, Retries, Subspaces int
+
+	// Ranks holds the last rank reported for each position, or 0 for positions that haven't reported one.
+	Ranks [16]int
+}
+
+// RankTracker implements Metrics by keeping the latest state of the attack in memory, so that a user interface can poll
+// it with Snapshot from another goroutine while the attack runs.
+type RankTracker struct {
+	mu    sync.Mutex
+	state RankState
+}
+
+// Snapshot returns the state of the attack so far. It's safe to call while the attack is running.
+func (rt *RankTracker) Snapshot() RankState {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	return rt.state
+}
+
+func (rt *RankTracker) update(f func(*RankState)) {
+	rt.mu.Lock()
+	f(&rt.state)
+	rt.mu.Unlock()
+}
+
+func (rt *RankTracker) Queries(n int)      { rt.update(func(s *RankState) { s.Queries += n }) }
+func (rt *RankTracker) Batch()             { rt.update(func(s *RankState) { s.Batches++ }) }
+func (rt *RankTracker) Retry()             { rt.update(func(s *RankState) { s.Retries++ }) }
+func (rt *RankTracker) Subspaces(n int)    { rt.update(func(s *RankState) { s.Subspaces = n }) }
+func (rt *RankTracker) Rank(pos, rank int) { rt.update(func(s *RankState) { s.Ranks[pos] = rank }) }
@@ -0,0 +1,143 @@
+package spn
+
+import (
+	"crypto/rand"
+	"errors"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+)
+
+// mixPairs is the number of pairs of plaintexts RecoverMixColumns uses for each byte of the first round key. A wrong
+// guess of the byte survives each pair with probability about 2^-8.
+const mixPairs = 4
+
+// ErrNotAESShaped is returned by RecoverMixColumns when no MixColumns matrix and round keys explain the cipher's outputs.
+var ErrNotAESShaped = errors.New("no MixColumns matrix and round keys explain the cipher")
+
+// MixColumnsRound is one round of an AES-shaped cipher whose MixColumns matrix is secret: a key added to the plaintext,
+// the known S-box, ShiftRows, the matrix applied to each column over AES's representation of GF(2^8), and another key.
+type MixColumnsRound struct {
+	Mix               [4][4]byte
+	FirstKey, LastKey [16]byte
+	SBox              encoding.Byte
+}
+
+// mixColumn multiplies a column by the matrix.
+func (r MixColumnsRound) mixColumn(in [4]byte) (out [4]byte) {
+	for i := range out {
+		for j := range in {
+			out[i] ^= gfMul(r.Mix[i][j], in[j], aesPolynomial)
+		}
+	}
+
+	return
+}
+
+// Encode implements encoding.Block.
+func (r MixColumnsRound) Encode(in [16]byte) (out [16]byte) {
+	for i := range in {
+		in[i] = r.SBox.Encode(in[i] ^ r.FirstKey[i])
+	}
+
+	for col := 0; col < 4; col++ {
+		column := [4]byte{}
+		for row := range column {
+			column[row] = in[aesShiftRows(4*col+row)]
+		}
+
+		column = r.mixColumn(column)
+		for row := range column {
+			out[4*col+row] = column[row] ^ r.LastKey[4*col+row]
+		}
+	}
+
+	return
+}
+
+// Decode panics, because the matrix might not be invertible.
+func (r MixColumnsRound) Decode(in [16]byte) [16]byte {
+	panic("cryptanalysis/spn.MixColumnsRound.Decode isn't implemented!")
+}
+
+// RecoverMixColumns recovers the secret MixColumns matrix and the round keys of one round of an AES-shaped cipher with a
+// known S-box. Changing byte p of the plaintext only changes the column ShiftRows moves it into, by the column of the
+// matrix for p's row times the difference of the S-box's outputs. Guessing the byte of the first round key gives that
+// difference, and the right guess is the one for which every pair of plaintexts gives the same column. The last round
+// key then follows from any plaintext. It takes 81 queries.
+//
+// Rounds beyond the first have to be peeled off the cipher before it's attacked, for example with DecomposeSPN.
+func RecoverMixColumns(cipher encoding.Block, sbox encoding.Byte, opts ...Option) (MixColumnsRound, error) {
+	orc := newOracle(cipher, newOptions(opts))
+	out := MixColumnsRound{SBox: sbox}
+	found := [4]bool{}
+
+	for p := 0; p < 16; p++ {
+		// Position p of the plaintext is moved into row p%4 of column col by ShiftRows.
+		row, col := p%4, 0
+		for c := 0; c < 4; c++ {
+			if aesShiftRows(4*c+row) == p {
+				col = c
+			}
+		}
+
+		base := [16]byte{}
+		rand.Read(base[:])
+		baseCt := orc.Encode(base)
+
+		pts, diffs := [mixPairs]byte{}, [mixPairs][4]byte{}
+		for i := range pts {
+			for pts[i] = base[p]; pts[i] == base[p]; {
+				rand.Read(pts[i : i+1])
+			}
+
+			pt := base
+			pt[p] = pts[i]
+			ct := orc.Encode(pt)
+
+			for j := range diffs[i] {
+				diffs[i][j] = ct[4*col+j] ^ baseCt[4*col+j]
+			}
+		}
+
+		guesses := 0
+		for k := 0; k < 256; k++ {
+			column, ok := [4]byte{}, true
+
+			for i := 0; i < mixPairs && ok; i++ {
+				delta := sbox.Encode(base[p]^byte(k)) ^ sbox.Encode(pts[i]^byte(k))
+				inv := gfInvert(delta, aesPolynomial)
+
+				for j := range column {
+					c := gfMul(diffs[i][j], inv, aesPolynomial)
+					if i == 0 {
+						column[j] = c
+					} else if column[j] != c {
+						ok = false
+					}
+				}
+			}
+
+			if !ok || (found[row] && column != [4]byte{out.Mix[0][row], out.Mix[1][row], out.Mix[2][row], out.Mix[3][row]}) {
+				continue
+			}
+
+			guesses++
+			out.FirstKey[p] = byte(k)
+			for j := range column {
+				out.Mix[j][row] = column[j]
+			}
+		}
+
+		if guesses != 1 {
+			return out, ErrNotAESShaped
+		}
+		found[row] = true
+	}
+
+	pt := [16]byte{}
+	rand.Read(pt[:])
+	ct, guess := orc.Encode(pt), out.Encode(pt)
+	encoding.XOR(out.LastKey[:], ct[:], guess[:])
+
+	return out, nil
+}
@@ -0,0 +1,116 @@
+package spn
+
+import (
+	"crypto/rand"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/matrix"
+)
+
+// aesDiagonal returns a mask with the bytes of the i^th diagonal of the state set: the bytes ShiftRows moves into the
+// i^th column.
+func aesDiagonal(i int) (mask uint16) {
+	for row := 0; row < 4; row++ {
+		mask |= 1 << uint(aesShiftRows(4*i+row))
+	}
+
+	return
+}
+
+// Mixture returns the mixture of p1 and p2 that takes the bytes set in mask from p2 and the rest from p1, along with
+// its complement. Every byte of the two mixtures takes the same pair of values as the same byte of p1 and p2, so an
+// S-box layer keeps the four of them a mixture quadruple, and any affine layer after it makes their sum zero.
+//
+// "Mixture Differential Cryptanalysis: a New Approach to Distinguishers and Attacks on round-reduced AES" by Lorenzo
+// Grassi, https://eprint.iacr.org/2017/832.pdf
+func Mixture(p1, p2 [16]byte, mask uint16) (p3, p4 [16]byte) {
+	p3, p4 = swapBytes(p1, p2, mask)
+	return
+}
+
+// mixtureQuadruple returns p1 and p2 that differ in every byte of the given diagonal, and a random mixture of them that
+// mixes some but not all of those bytes.
+func mixtureQuadruple(diagonal int) (p1, p2, p3, p4 [16]byte) {
+	rand.Read(p1[:])
+	p2 = p1
+
+	mask, chosen := aesDiagonal(diagonal), uint16(0)
+	for chosen == 0 || chosen == mask {
+		buf := [2]byte{}
+		rand.Read(buf[:])
+		chosen = (uint16(buf[0]) | uint16(buf[1])<<8) & mask
+	}
+
+	for pos := 0; pos < 16; pos++ {
+		if (mask>>uint(pos))&1 == 1 {
+			delta := [1]byte{}
+			for delta[0] == 0 {
+				rand.Read(delta[:])
+			}
+			p2[pos] ^= delta[0]
+		}
+	}
+
+	p3, p4 = Mixture(p1, p2, chosen)
+	return
+}
+
+// mixtureTrials is the number of mixture quadruples IsAESLike encrypts. A cipher that isn't AES-like passes each check
+// with negligible probability, so one failure is enough to reject it.
+const mixtureTrials = 16
+
+// IsAESLike tests whether the cipher is rounds rounds of an AES-like cipher, where each round applies an S-box layer,
+// the given linear layer (AESLinearLayer, for AES itself) and a key, using mixture quadruples active in one diagonal.
+// It's meant for the residual cipher left after peeling layers off a larger one, and only supports one or two rounds,
+// where the properties it checks hold with probability one:
+//
+//   - After one round, the ciphertexts of a mixture quadruple sum to zero.
+//   - After two rounds, undoing the last linear layer leaves the ciphertexts of a quadruple equal outside the column the
+//     active diagonal moves into, because the first round confines their differences to it.
+//
+// Grassi's distinguishers on four to six rounds count events that happen for one pair in 2^32 or fewer, and need far
+// more queries than a test like this should make.
+func IsAESLike(cipher encoding.Block, rounds int, linear matrix.Matrix, opts ...Option) bool {
+	orc := newOracle(cipher, newOptions(opts))
+
+	inverse, ok := linear.Invert()
+	if !ok {
+		panic("Linear layer is singular!")
+	}
+
+	for i := 0; i < mixtureTrials; i++ {
+		diagonal := i % 4
+		p1, p2, p3, p4 := mixtureQuadruple(diagonal)
+		cts := [4][16]byte{orc.Encode(p1), orc.Encode(p2), orc.Encode(p3), orc.Encode(p4)}
+
+		switch rounds {
+		case 1:
+			sum := [16]byte{}
+			for _, ct := range cts {
+				encoding.XOR(sum[:], sum[:], ct[:])
+			}
+
+			if sum != [16]byte{} {
+				return false
+			}
+
+		case 2:
+			for _, ct := range cts[1:] {
+				diff := [16]byte{}
+				encoding.XOR(diff[:], ct[:], cts[0][:])
+				copy(diff[:], inverse.Mul(matrix.Row(diff[:])))
+
+				for pos := 0; pos < 16; pos++ {
+					if pos/4 != diagonal && diff[pos] != 0 {
+						return false
+					}
+				}
+			}
+
+		default:
+			panic("IsAESLike only supports one or two rounds!")
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,207 @@
+package spn
+
+import (
+	"crypto/rand"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/matrix"
+)
+
+// quadraticTerms is the number of monomials of degree one or two in the eight bits of a byte.
+const quadraticTerms = 8 + 28
+
+// monomials returns the value of every monomial of degree one or two at x, in a fixed order.
+func monomials(x byte) (out [quadraticTerms]byte) {
+	n := 0
+	for i := uint(0); i < 8; i++ {
+		out[n] = (x >> i) & 1
+		n++
+	}
+	for i := uint(0); i < 8; i++ {
+		for j := i + 1; j < 8; j++ {
+			out[n] = (x >> i) & (x >> j) & 1
+			n++
+		}
+	}
+
+	return
+}
+
+// solveGF2 returns a basis for the solutions of the homogeneous system whose equations are given as rows of coefficients
+// in {0, 1}, with n unknowns.
+func solveGF2(equations [][]byte, n int) (out [][]byte) {
+	m := matrix.Matrix{}
+	for _, eq := range equations {
+		row := matrix.NewRow(n)
+		for j, c := range eq {
+			if c == 1 {
+				row[j/8] |= 1 << uint(j%8)
+			}
+		}
+		m = append(m, row)
+	}
+
+	// Unknowns that only pad the rows out to whole bytes are forced to zero.
+	for j := n; j < 8*len(matrix.NewRow(n)); j++ {
+		row := matrix.NewRow(n)
+		row[j/8] |= 1 << uint(j%8)
+		m = append(m, row)
+	}
+
+	for _, v := range m.NullSpace() {
+		sol := make([]byte, n)
+		for j := range sol {
+			sol[j] = v.GetBit(j)
+		}
+		out = append(out, sol)
+	}
+
+	return
+}
+
+// quadraticTable returns the truth table of the quadratic function of a byte with the given coefficients.
+func quadraticTable(coeffs []byte) (out [256]byte) {
+	for x := 0; x < 256; x++ {
+		for i, m := range monomials(byte(x)) {
+			out[x] ^= m & coeffs[i]
+		}
+	}
+
+	return
+}
+
+// SBoxQuadraticInvariants returns a basis for the functions g of degree one or two, with no constant term, such that
+// g(s(x)) = g(x) + c for some constant c and every x. Each is returned as a truth table. A random S-box has none.
+func SBoxQuadraticInvariants(s encoding.Byte) (out [][256]byte) {
+	equations := [][]byte{}
+	for x := 0; x < 256; x++ {
+		a, b := monomials(byte(x)), monomials(s.Encode(byte(x)))
+
+		eq := make([]byte, quadraticTerms+1)
+		for i := range a {
+			eq[i] = a[i] ^ b[i]
+		}
+		eq[quadraticTerms] = 1 // The constant c.
+
+		equations = append(equations, eq)
+	}
+
+	for _, sol := range solveGF2(equations, quadraticTerms+1) {
+		if table := quadraticTable(sol[:quadraticTerms]); table != ([256]byte{}) {
+			out = append(out, table)
+		}
+	}
+
+	return
+}
+
+// NonlinearInvariant is a function of degree at most two on the state that's the sum of a function of each byte, and
+// that a round of an SPN maps to itself up to a constant, for weak keys.
+type NonlinearInvariant struct {
+	Tables [16][256]byte
+
+	// WeakKeyDim is the dimension of the subspace of round keys that keep it invariant: those that add a constant to it
+	// wherever they're added. The fraction of round keys that are weak is 2^(WeakKeyDim-128).
+	WeakKeyDim int
+}
+
+// Eval evaluates the invariant on a state.
+func (g NonlinearInvariant) Eval(x [16]byte) (out byte) {
+	for pos, b := range x {
+		out ^= g.Tables[pos][b]
+	}
+
+	return
+}
+
+// nonlinearSamples is the number of random states, beyond the number of unknowns, at which FindNonlinearInvariants
+// requires an invariant of the S-box layer to be invariant under the linear layer too.
+const nonlinearSamples = 64
+
+// FindNonlinearInvariants searches for nonlinear invariants of degree two of a round of an SPN with the given S-box
+// layer and linear layer, as recovered by a decomposition, for the nonlinear invariant attack. Every sum of invariants
+// of the S-boxes (see SBoxQuadraticInvariants) is an invariant of the S-box layer; it returns a basis for the ones that
+// the linear layer also maps to themselves up to a constant, along with the dimension of the subspace of weak keys for
+// each.
+//
+// "Nonlinear Invariant Attack: Practical Attack on Full SCREAM, iSCREAM, and Midori64" by Yosuke Todo, Gregor Leander,
+// and Yu Sasaki, https://eprint.iacr.org/2016/732.pdf
+func FindNonlinearInvariants(sboxes encoding.ConcatenatedBlock, linear matrix.Matrix) (out []NonlinearInvariant) {
+	type term struct {
+		pos   int
+		table [256]byte
+	}
+
+	terms := []term{}
+	for pos := 0; pos < 16; pos++ {
+		for _, table := range SBoxQuadraticInvariants(sboxes[pos]) {
+			terms = append(terms, term{pos, table})
+		}
+	}
+
+	if len(terms) == 0 {
+		return nil
+	}
+
+	// Each random state gives a linear equation in which terms make up the invariant, plus a constant.
+	equations := [][]byte{}
+	for i := 0; i < len(terms)+1+nonlinearSamples; i++ {
+		x := [16]byte{}
+		rand.Read(x[:])
+
+		y := [16]byte{}
+		copy(y[:], linear.Mul(matrix.Row(x[:])))
+
+		eq := make([]byte, len(terms)+1)
+		for j, t := range terms {
+			eq[j] = t.table[x[t.pos]] ^ t.table[y[t.pos]]
+		}
+		eq[len(terms)] = 1
+
+		equations = append(equations, eq)
+	}
+
+	for _, sol := range solveGF2(equations, len(terms)+1) {
+		g := NonlinearInvariant{}
+		for j, t := range terms {
+			if sol[j] == 1 {
+				for x := range g.Tables[t.pos] {
+					g.Tables[t.pos][x] ^= t.table[x]
+				}
+			}
+		}
+
+		if g.Tables == ([16][256]byte{}) {
+			continue
+		}
+
+		g.WeakKeyDim = weakKeyDim(g)
+		out = append(out, g)
+	}
+
+	return
+}
+
+// weakKeyDim returns the dimension of the keys k such that g(x + k) + g(x) doesn't depend on x: the radical of the
+// bilinear form g(x + k) + g(x) + g(k) + g(0).
+func weakKeyDim(g NonlinearInvariant) int {
+	unit := func(i int) (x [16]byte) {
+		x[i/8] = 1 << uint(i%8)
+		return
+	}
+
+	form := matrix.GenerateEmpty(128, 128)
+	for i := 0; i < 128; i++ {
+		for j := 0; j < 128; j++ {
+			a, b := unit(i), unit(j)
+			sum := a
+			encoding.XOR(sum[:], sum[:], b[:])
+
+			if g.Eval(sum)^g.Eval(a)^g.Eval(b)^g.Eval([16]byte{}) == 1 {
+				form[i][j/8] |= 1 << uint(j%8)
+			}
+		}
+	}
+
+	return len(form.NullSpace())
+}
@@ -0,0 +1,121 @@
+package spn
+
+import (
+	"github.com/OpenWhiteBox/primitives/encoding"
+
+	"github.com/OpenWhiteBox/Generic/constructions/spn"
+)
+
+// NormalizeOutput returns the canonical representative of the S-boxes that differ from s by an affine transformation on
+// their output, and the transformation out such that s = out(norm(x)). The canonical representative maps 0 to 0 and,
+// taking inputs in increasing order, the first eight whose images are linearly independent to 0x01, 0x02, ..., 0x80.
+// Any S-box of the form A(s(x)) with A affine has the same representative as s.
+func NormalizeOutput(s encoding.Byte) (norm encoding.SBox, out encoding.ByteAffine) {
+	c := s.Encode(0)
+
+	// Pick the first inputs whose images span the output space, keeping a basis of them reduced by leading bit.
+	inputs, images, reduced := []byte{}, []byte{}, [8]byte{}
+	for x := 1; x < 256 && len(inputs) < 8; x++ {
+		y := s.Encode(byte(x)) ^ c
+
+		r := y
+		for bit := 7; bit >= 0; bit-- {
+			if (r>>uint(bit))&1 == 1 && reduced[bit] != 0 {
+				r ^= reduced[bit]
+			}
+		}
+
+		if r == 0 {
+			continue
+		}
+
+		for bit := 7; bit >= 0; bit-- {
+			if (r>>uint(bit))&1 == 1 {
+				reduced[bit] = r
+				break
+			}
+		}
+
+		inputs, images = append(inputs, byte(x)), append(images, y)
+	}
+
+	// out's linear part sends the i^th basis vector to the image of the i^th input.
+	linear := func(x byte) (y byte) {
+		for i := uint(0); i < 8; i++ {
+			if (x>>i)&1 == 1 {
+				y ^= images[i]
+			}
+		}
+		return
+	}
+	out = encoding.NewByteAffine(byteMatrix(linear), c)
+
+	for x := 0; x < 256; x++ {
+		y := out.Decode(s.Encode(byte(x)))
+		norm.EncKey[x], norm.DecKey[y] = y, byte(x)
+	}
+
+	return
+}
+
+// NormalizeInput returns the canonical representative of the S-boxes that differ from s by an affine transformation on
+// their input, and the transformation in such that s = norm(in(x)). It's the inverse of the representative
+// NormalizeOutput gives for the inverse of s.
+func NormalizeInput(s encoding.Byte) (norm encoding.SBox, in encoding.ByteAffine) {
+	inv, out := NormalizeOutput(encoding.InverseByte{s})
+
+	// s^-1 = out(inv(x)), so s = inv^-1(out^-1(x)).
+	linear := func(x byte) byte { return out.Decode(x) ^ out.Decode(0) }
+	in = encoding.NewByteAffine(byteMatrix(linear), out.Decode(0))
+
+	norm = encoding.SBox{EncKey: inv.DecKey, DecKey: inv.EncKey}
+	return
+}
+
+// NormalizeSPN replaces each S-box of a decomposition with its canonical representative, and absorbs the affine
+// transformations that removes into a neighboring affine layer. The functionality of the construction doesn't change.
+//
+// Each S-box is normalized on the side where the attacks leave it ambiguous: its input if an affine layer comes before
+// it, and its output otherwise. The S-boxes of a trailing S-box layer are then the same no matter which decomposition of
+// the cipher they came from. S-boxes with affine layers on both sides are ambiguous on both, and normalizing one side
+// doesn't make them canonical.
+func NormalizeSPN(constr spn.Construction) spn.Construction {
+	out := append(spn.Construction{}, constr...)
+
+	isAffine := func(i int) bool {
+		if i < 0 || i >= len(out) {
+			return false
+		}
+		_, ok := out[i].(encoding.BlockAffine)
+		return ok
+	}
+
+	for i, layer := range out {
+		sboxes, ok := layer.(encoding.ConcatenatedBlock)
+		if !ok {
+			continue
+		}
+
+		norms, absorbed := encoding.ConcatenatedBlock{}, encoding.ConcatenatedBlock{}
+
+		if isAffine(i - 1) {
+			for pos := 0; pos < 16; pos++ {
+				norm, in := NormalizeInput(sboxes[pos])
+				norms[pos], absorbed[pos] = norm, in
+			}
+
+			aff, _ := encoding.DecomposeBlockAffine(encoding.ComposedBlocks{out[i-1], absorbed})
+			out[i-1], out[i] = aff, norms
+		} else if isAffine(i + 1) {
+			for pos := 0; pos < 16; pos++ {
+				norm, o := NormalizeOutput(sboxes[pos])
+				norms[pos], absorbed[pos] = norm, o
+			}
+
+			aff, _ := encoding.DecomposeBlockAffine(encoding.ComposedBlocks{absorbed, out[i+1]})
+			out[i], out[i+1] = norms, aff
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,317 @@
+package spn
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+)
+
+// Option configures an attack.
+type Option func(*options)
+
+type options struct {
+	metrics      Metrics
+	logger       *slog.Logger
+	escalation   escalation
+	exhaustive   int
+	memoryBudget int
+	transcript   *Transcript
+	systems      IncrementalMatrices
+	reference    encoding.Byte
+	positions    []int
+	votes        int
+	ledger       *ledger
+	events       func(Event)
+	hooks        Hooks
+	workers      int
+	search       PermutationSearch
+
+	// nullSpaceDim is the dimension of the nullspace each position's system is expected to end up with.
+	nullSpaceDim int
+}
+
+// escalation is the list of generators an attack on an S-box layer falls back on when it stalls.
+type escalation struct {
+	patience   int
+	generators []Generator
+}
+
+// newOptions returns the configuration given by a list of options, on top of the defaults.
+func newOptions(opts []Option) *options {
+	o := &options{
+		metrics:      noMetrics{},
+		logger:       slog.New(discardHandler{}),
+		nullSpaceDim: NullSpaceDim(1),
+		memoryBudget: defaultMemoryBudget,
+		workers:      runtime.GOMAXPROCS(0),
+		search:       RandomSampling{},
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// WithMetrics reports the progress of the attack to m.
+func WithMetrics(m Metrics) Option {
+	return func(o *options) { o.metrics = m }
+}
+
+// WithLogger logs the progress of the attack to l. Batch statistics and changes in rank are logged at debug level,
+// escalations at info level, and the state of the attack is logged at error level before it gives up.
+func WithLogger(l *slog.Logger) Option {
+	return func(o *options) { o.logger = l }
+}
+
+// WithEscalation makes attacks on S-box layers switch to the next of generators, in order, whenever patience batches in a
+// row fail to raise the rank of any position, instead of carrying on with the same generator until they give up. Each
+// generator is used for at most as many batches as the attack would otherwise make in total, which is also when it moves
+// on if patience isn't positive.
+//
+// Every batch from every generator contributes relations to the same systems, so the generators should all make cube
+// sums vanish at the layer being attacked. Larger cubes of the same kind, like BalancedPlaintexts(8) after
+// BalancedPlaintexts(4), are the usual choice.
+func WithEscalation(patience int, generators ...Generator) Option {
+	return func(o *options) { o.escalation = escalation{patience, generators} }
+}
+
+// WithExhaustiveSearch makes attacks on S-box layers enumerate every linear combination of nullspaces of dimension at
+// most d, instead of sampling them until one is a permutation vector, so that every S-box consistent with the relations
+// is found. Combinations are pruned as soon as the entries they've already fixed repeat a value, but the number of
+// permutation vectors left is still about 2^(8d-2): the nullspace the attack usually ends up with has dimension 9, and
+// only positions constrained beyond that, with d of 2 or 3, are practical to search. It panics if d is more than 7, or 3
+// where ints are 32 bits, since a larger nullspace has more linear combinations than an int can count.
+func WithExhaustiveSearch(d int) Option {
+	if checkExhaustive(d) != nil {
+		panic("Exhaustive searches must have fewer linear combinations than an int can count!")
+	}
+
+	return func(o *options) { o.exhaustive = d }
+}
+
+// maxExhaustiveDim is the largest dimension of nullspace that can be searched exhaustively: the 2^(8d) linear
+// combinations of one of dimension d must fit in an int.
+const maxExhaustiveDim = (strconv.IntSize - 8) / 8
+
+// checkExhaustive returns an error if d is too large a dimension to search exhaustively.
+func checkExhaustive(d int) error {
+	if d > maxExhaustiveDim {
+		return fmt.Errorf("exhaustive search of dimension %v is more than %v", d, maxExhaustiveDim)
+	}
+
+	return nil
+}
+
+// WithRelationDegree tells attacks on S-box layers that the generators they're given make sums of every function of
+// degree at most degree in the output bits of the S-boxes vanish, not just the linear ones. Each position's system then
+// stops growing at rank 256 - NullSpaceDim(degree), so the attack waits for that rank instead of 247. A generator that
+// gives fewer relations than promised makes the attack run out of batches, and one that gives more makes it find no
+// permutation vector. Larger nullspaces hold relatively fewer permutation vectors, so sampling them for one is less
+// likely to succeed.
+func WithRelationDegree(degree int) Option {
+	dim := NullSpaceDim(degree)
+	return func(o *options) { o.nullSpaceDim = dim }
+}
+
+// sufficientRank is the rank at which a position's system is sufficiently defined: its nullspace is small enough to
+// search, but not so small that there's nowhere left to look for the S-box.
+func (o *options) sufficientRank() int {
+	return 256 - o.nullSpaceDim
+}
+
+// defaultMemoryBudget is the memory budget of attacks that aren't given one, in bytes.
+const defaultMemoryBudget = 64 << 20
+
+// WithMemoryBudget bounds the memory, in bytes, that searches may spend on tables to save time: Collapse indexes the
+// known S-boxes (see SBoxIndex) if the index fits, instead of trying each in turn, and exhaustive searches of nullspaces
+// keep every candidate S-box only while they fit. The default is 64 MiB.
+func WithMemoryBudget(bytes int) Option {
+	return func(o *options) { o.memoryBudget = bytes }
+}
+
+// WithTrailingConstants makes attacks on trailing S-box layers write each S-box they recover as s(x ^ c), where s maps 0
+// to the same value as reference, and report c separately (see SBoxRecovery.Constants). The constant is moved into what's
+// left of the cipher. Recovered S-boxes are only determined up to an affine transformation on their input, so without
+// this, key material added before them is absorbed into that transformation; with it, an S-box that's reference with
+// key material added before it comes out as reference itself, and the key byte as its constant.
+func WithTrailingConstants(reference encoding.Byte) Option {
+	return func(o *options) { o.reference = reference }
+}
+
+// WithPositions restricts attacks on trailing S-box layers to the S-boxes at the given positions of the output. Relations
+// are only kept for those positions and the attack stops as soon as they have enough, so targeting a few S-boxes--for
+// example, the ones a partial key recovery needs--saves both queries and time. The other positions hold the identity in
+// what's recovered and aren't reported as failures. It panics if a position is outside [0, 16) or given twice.
+func WithPositions(positions ...int) Option {
+	if checkPositions(positions) != nil {
+		panic("Positions must be distinct and in [0, 16)!")
+	}
+
+	return func(o *options) { o.positions = positions }
+}
+
+// checkPositions returns an error if a position is outside [0, 16) or given twice.
+func checkPositions(positions []int) error {
+	seen := [16]bool{}
+	for _, pos := range positions {
+		if pos < 0 || pos >= 16 {
+			return fmt.Errorf("position %v is out of range [0, 16)", pos)
+		} else if seen[pos] {
+			return fmt.Errorf("position %v is given twice", pos)
+		}
+		seen[pos] = true
+	}
+
+	return nil
+}
+
+// WithVoting makes attacks on trailing S-box layers tolerate an oracle that occasionally returns corrupted ciphertexts,
+// for example because of flaky instrumentation or network errors. A single corrupted ciphertext gives a relation the
+// S-box doesn't satisfy, which poisons its position's system for good. With voting, every relation that would raise the
+// rank of a system is checked by querying the same plaintexts votes-1 more times, and only kept if more than half of the
+// votes agree on it. Relations the system already implies are harmless and aren't checked. Since nearly every batch
+// raises some position's rank until the systems are sufficiently defined, this multiplies the cost of the attack by
+// about votes. Verification batches aren't voted on, so Confidence.Agreement still shows corruption.
+func WithVoting(votes int) Option {
+	return func(o *options) { o.votes = votes }
+}
+
+// WithWorkers sets how many goroutines attacks spread their work between: the searches for each position's S-box, and
+// the two ends DecomposeSPNConcurrently peels. It defaults to GOMAXPROCS, which is the number of CPUs unless it's been
+// changed. With 1 or fewer, everything runs on the calling goroutine in a fixed order, which keeps logs and events in
+// the same order from one run to the next when debugging.
+func WithWorkers(n int) Option {
+	return func(o *options) { o.workers = n }
+}
+
+// parallel calls f with every integer in [0, n), spread between the configured number of goroutines, and returns once
+// every call has. Without more than one worker, the calls are made in order on the calling goroutine. If a call panics,
+// as queries do once an Attack's context is done, the other workers stop taking calls and the first panic is passed on
+// to the calling goroutine, where runWithContext can recover it.
+func (o *options) parallel(n int, f func(i int)) {
+	if o.workers <= 1 {
+		for i := 0; i < n; i++ {
+			f(i)
+		}
+		return
+	}
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		panicked interface{}
+	)
+	next := int64(-1)
+	for w := 0; w < o.workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					once.Do(func() { panicked = r })
+					atomic.StoreInt64(&next, int64(n))
+				}
+			}()
+
+			for i := int(atomic.AddInt64(&next, 1)); i < n; i = int(atomic.AddInt64(&next, 1)) {
+				f(i)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if panicked != nil {
+		panic(panicked)
+	}
+}
+
+// ignored returns the positions an attack on a trailing S-box layer should skip.
+func (o *options) ignored() (out [16]bool) {
+	if o.positions == nil {
+		return
+	}
+
+	for pos := range out {
+		out[pos] = true
+	}
+	for _, pos := range o.positions {
+		out[pos] = false
+	}
+
+	return
+}
+
+// discardHandler is a slog.Handler that drops everything.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
+
+// oracle wraps the cipher under attack, accounting for every query made to it. It carries the attack's configuration
+// so that generators, which only see the cipher, can report to it as well.
+type oracle struct {
+	encoding.Block
+	opts *options
+}
+
+func newOracle(cipher encoding.Block, opts *options) oracle {
+	return oracle{cipher, opts}
+}
+
+func (o oracle) Encode(in [16]byte) [16]byte {
+	o.opts.metrics.Queries(1)
+	out := o.Block.Encode(in)
+	o.record(Query{Input: in, Output: out})
+
+	return out
+}
+
+func (o oracle) Decode(in [16]byte) [16]byte {
+	o.opts.metrics.Queries(1)
+	out := o.Block.Decode(in)
+	o.record(Query{Input: out, Output: in, Decrypted: true})
+
+	return out
+}
+
+// EncodeBatch passes a batch of queries on to the cipher in one call if it's a BatchEncoder, accounting for each of
+// them.
+func (o oracle) EncodeBatch(ins [][16]byte) [][16]byte {
+	outs := encodeBatch(o.Block, ins)
+	o.opts.metrics.Queries(len(outs))
+	for i, out := range outs {
+		o.record(Query{Input: ins[i], Output: out})
+	}
+
+	return outs
+}
+
+// record passes a query that's been made to the transcript and the OnQuery hook, if the attack has them.
+func (o oracle) record(q Query) {
+	if o.opts.transcript != nil {
+		o.opts.transcript.record(q)
+	}
+	if o.opts.hooks.OnQuery != nil {
+		o.opts.hooks.OnQuery(q)
+	}
+}
+
+// optionsOf returns the configuration of the attack the cipher is being queried by, or the defaults if it isn't being
+// queried through an oracle.
+func optionsOf(cipher encoding.Block) *options {
+	if o, ok := cipher.(oracle); ok {
+		return o.opts
+	}
+
+	return newOptions(nil)
+}
@@ -0,0 +1,264 @@
+package spn
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+)
+
+// BatchEncoder is implemented by oracles that encrypt many blocks faster together than one at a time, like TokenOracle.
+// Attacks on S-box layers send each set of chosen plaintexts to one in a single call.
+type BatchEncoder interface {
+	EncodeBatch(ins [][16]byte) (outs [][16]byte)
+}
+
+// encodeBatch encrypts every block of ins with cipher, in one call if it's a BatchEncoder.
+func encodeBatch(cipher encoding.Block, ins [][16]byte) [][16]byte {
+	if be, ok := cipher.(BatchEncoder); ok {
+		return be.EncodeBatch(ins)
+	}
+
+	outs := make([][16]byte, len(ins))
+	for i, in := range ins {
+		outs[i] = cipher.Encode(in)
+	}
+
+	return outs
+}
+
+// Consensus wraps an oracle whose outputs are only right on average, like an implementation protected with random
+// masking that doesn't always cancel. Each query is repeated Repeats times and each byte of the output is the value that
+// byte took most often, so attacks on it see a deterministic cipher as long as the right value is the most common one.
+// It multiplies the number of queries by Repeats.
+type Consensus struct {
+	encoding.Block
+	Repeats int
+}
+
+func (c Consensus) Encode(in [16]byte) [16]byte {
+	return c.vote(func() [16]byte { return c.Block.Encode(in) })
+}
+
+func (c Consensus) Decode(in [16]byte) [16]byte {
+	return c.vote(func() [16]byte { return c.Block.Decode(in) })
+}
+
+// vote queries the oracle Repeats times and returns the most common value of each byte of its output.
+func (c Consensus) vote(query func() [16]byte) (out [16]byte) {
+	counts := [16][256]int{}
+	for i := 0; i < c.Repeats; i++ {
+		for pos, b := range query() {
+			counts[pos][b]++
+		}
+	}
+
+	for pos := range counts {
+		for b, n := range counts[pos] {
+			if n > counts[pos][out[pos]] {
+				out[pos] = byte(b)
+			}
+		}
+	}
+
+	return
+}
+
+// StatefulOracle adapts an oracle that needs state carried from one query to the next, like a session that has to be
+// opened with a handshake or a counter that has to increase with every query, into a deterministic encoding.Block.
+// Queries are serialized, so it's safe for concurrent use.
+type StatefulOracle struct {
+	// Start opens a new session and returns the state its first query is made with. It's called before the first query,
+	// and again whenever a query fails.
+	Start func() (state []byte, err error)
+
+	// Query encrypts in with the given state, and returns the state of the next query.
+	Query func(state []byte, in [16]byte) (out [16]byte, next []byte, err error)
+
+	// Retries is how many times a failed query is retried in a new session before Encode panics.
+	Retries int
+
+	mu      sync.Mutex
+	state   []byte
+	started bool
+}
+
+// NewCounterOracle returns an oracle for ciphers that take a counter with each query and reject any counter that's
+// smaller than one they've seen. The counter starts at zero and is incremented after every query, including failed
+// ones.
+func NewCounterOracle(query func(counter uint64, in [16]byte) ([16]byte, error), retries int) *StatefulOracle {
+	var counter uint64
+
+	return &StatefulOracle{
+		Start: func() ([]byte, error) { return nil, nil },
+		Query: func(_ []byte, in [16]byte) ([16]byte, []byte, error) {
+			out, err := query(counter, in)
+			counter++
+			return out, nil, err
+		},
+		Retries: retries,
+	}
+}
+
+// NewNonceOracle returns an oracle for ciphers that take a fresh nonce of the given size with each query. Nonces are
+// read from rand.
+func NewNonceOracle(rand io.Reader, size int, query func(nonce []byte, in [16]byte) ([16]byte, error), retries int) *StatefulOracle {
+	return &StatefulOracle{
+		Start: func() ([]byte, error) { return nil, nil },
+		Query: func(_ []byte, in [16]byte) ([16]byte, []byte, error) {
+			nonce := make([]byte, size)
+			if _, err := io.ReadFull(rand, nonce); err != nil {
+				return [16]byte{}, nil, err
+			}
+
+			out, err := query(nonce, in)
+			return out, nil, err
+		},
+		Retries: retries,
+	}
+}
+
+func (s *StatefulOracle) Encode(in [16]byte) [16]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt <= s.Retries; attempt++ {
+		if !s.started {
+			if s.state, err = s.Start(); err != nil {
+				continue
+			}
+			s.started = true
+		}
+
+		var out [16]byte
+		if out, s.state, err = s.Query(s.state, in); err == nil {
+			return out
+		}
+		s.started = false
+	}
+
+	panic(fmt.Sprintf("cryptanalysis/spn.StatefulOracle: query failed after %v retries: %v", s.Retries, err))
+}
+
+// Decode panics, because stateful oracles only expose encryption.
+func (s *StatefulOracle) Decode(in [16]byte) [16]byte {
+	panic("cryptanalysis/spn.StatefulOracle.Decode isn't implemented!")
+}
+
+// Token is the part of a PKCS#11 module that TokenOracle uses, with a key already on the token. Its methods map onto
+// C_OpenSession, C_CloseSession, C_EncryptInit with CKM_AES_ECB and the key, and single-part C_Encrypt, so a wrapper
+// around any PKCS#11 binding implements it in a few lines.
+type Token interface {
+	OpenSession() (session uint, err error)
+	CloseSession(session uint) error
+	EncryptInit(session uint) error
+	Encrypt(session uint, data []byte) ([]byte, error)
+}
+
+// TokenOracle queries a cipher on a hardware token, like an HSM or a smartcard, through its ECB encryption. Queries are
+// sent Batch blocks at a time by EncodeBatch--ECB encrypts each block on its own--which saves a round trip to the token
+// for every block but the first. It's a BatchEncoder, so attacks on S-box layers batch their queries to it. The session is opened on the first query and reopened whenever an operation fails, up
+// to Retries times before the query panics. It's safe for concurrent use.
+type TokenOracle struct {
+	Token   Token
+	Batch   int
+	Retries int
+
+	mu      sync.Mutex
+	session uint
+	open    bool
+}
+
+// Close closes the oracle's session, if it has one open.
+func (t *TokenOracle) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.open {
+		return nil
+	}
+	t.open = false
+
+	return t.Token.CloseSession(t.session)
+}
+
+// encrypt encrypts one batch of blocks with the current session, opening one if there isn't.
+func (t *TokenOracle) encrypt(data []byte) ([]byte, error) {
+	if !t.open {
+		session, err := t.Token.OpenSession()
+		if err != nil {
+			return nil, err
+		}
+		t.session, t.open = session, true
+	}
+
+	if err := t.Token.EncryptInit(t.session); err != nil {
+		return nil, err
+	}
+
+	out, err := t.Token.Encrypt(t.session, data)
+	if err == nil && len(out) != len(data) {
+		err = fmt.Errorf("token returned %v bytes for %v", len(out), len(data))
+	}
+
+	return out, err
+}
+
+// EncodeBatch encrypts every block of ins on the token.
+func (t *TokenOracle) EncodeBatch(ins [][16]byte) (outs [][16]byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch := t.Batch
+	if batch < 1 {
+		batch = 1
+	}
+
+	for len(ins) > 0 {
+		n := batch
+		if n > len(ins) {
+			n = len(ins)
+		}
+
+		data := make([]byte, 0, 16*n)
+		for _, in := range ins[:n] {
+			data = append(data, in[:]...)
+		}
+
+		var (
+			out []byte
+			err error
+		)
+		for attempt := 0; attempt <= t.Retries; attempt++ {
+			if out, err = t.encrypt(data); err == nil {
+				break
+			} else if t.open {
+				t.Token.CloseSession(t.session)
+				t.open = false
+			}
+		}
+		if err != nil {
+			panic(fmt.Sprintf("cryptanalysis/spn.TokenOracle: encryption failed after %v retries: %v", t.Retries, err))
+		}
+
+		for i := 0; i < n; i++ {
+			block := [16]byte{}
+			copy(block[:], out[16*i:])
+			outs = append(outs, block)
+		}
+		ins = ins[n:]
+	}
+
+	return outs
+}
+
+func (t *TokenOracle) Encode(in [16]byte) [16]byte {
+	return t.EncodeBatch([][16]byte{in})[0]
+}
+
+// Decode panics, because tokens are only queried for encryption.
+func (t *TokenOracle) Decode(in [16]byte) [16]byte {
+	panic("cryptanalysis/spn.TokenOracle.Decode isn't implemented!")
+}
@@ -0,0 +1,204 @@
+package spn
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/matrix"
+
+	"github.com/OpenWhiteBox/Generic/constructions/spn"
+)
+
+var (
+	// ErrPipelineCycle is returned when the stages of a pipeline need each other's artifacts in a cycle.
+	ErrPipelineCycle = errors.New("pipeline stages depend on each other in a cycle")
+
+	// ErrMalformedLayer is returned by UnmarshalLayer when its input isn't a serialized layer.
+	ErrMalformedLayer = errors.New("artifact isn't a serialized S-box or affine layer")
+)
+
+// Artifacts holds the output of each stage of a pipeline, by the stage's name.
+type Artifacts map[string][]byte
+
+// Stage is one step of a Pipeline, like recovering a layer or identifying an S-box. Run is given the artifacts of the
+// stages named in Needs, and returns its own.
+type Stage struct {
+	Name  string
+	Needs []string
+	Run   func(ctx context.Context, in Artifacts) ([]byte, error)
+}
+
+// Pipeline runs stages in the order their needs call for. If Dir isn't empty, every artifact is cached in a file in it,
+// named after its stage and a hash of what the stage was given, and a stage whose file already exists isn't run again.
+// A stage whose needs change, because an earlier stage was rerun differently, gets a new file.
+type Pipeline struct {
+	Stages []Stage
+	Dir    string
+}
+
+// order returns the stages so that each comes after the stages it needs.
+func (p Pipeline) order() ([]Stage, error) {
+	byName := map[string]Stage{}
+	for _, s := range p.Stages {
+		if _, ok := byName[s.Name]; ok {
+			return nil, fmt.Errorf("pipeline has two stages named %q", s.Name)
+		}
+		byName[s.Name] = s
+	}
+
+	out, state := []Stage{}, map[string]int{} // 1 while a stage's needs are being visited, then 2.
+	var visit func(s Stage) error
+	visit = func(s Stage) error {
+		switch state[s.Name] {
+		case 1:
+			return ErrPipelineCycle
+		case 2:
+			return nil
+		}
+
+		state[s.Name] = 1
+		for _, name := range s.Needs {
+			need, ok := byName[name]
+			if !ok {
+				return fmt.Errorf("stage %q needs unknown stage %q", s.Name, name)
+			} else if err := visit(need); err != nil {
+				return err
+			}
+		}
+		state[s.Name] = 2
+
+		out = append(out, s)
+		return nil
+	}
+
+	for _, s := range p.Stages {
+		if err := visit(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+// cachePath returns the file the artifact of s, given in, is cached in.
+func (p Pipeline) cachePath(s Stage, in Artifacts) string {
+	h := sha256.New()
+	h.Write([]byte(s.Name))
+	for _, name := range s.Needs {
+		sum := sha256.Sum256(in[name])
+		h.Write([]byte(name))
+		h.Write(sum[:])
+	}
+
+	return filepath.Join(p.Dir, fmt.Sprintf("%v-%v", s.Name, hex.EncodeToString(h.Sum(nil)[:8])))
+}
+
+// Run runs the pipeline, and returns every stage's artifact. It stops at the first stage that fails.
+func (p Pipeline) Run(ctx context.Context) (Artifacts, error) {
+	stages, err := p.order()
+	if err != nil {
+		return nil, err
+	}
+
+	out := Artifacts{}
+	for _, s := range stages {
+		in := Artifacts{}
+		for _, name := range s.Needs {
+			in[name] = out[name]
+		}
+
+		path := ""
+		if p.Dir != "" {
+			path = p.cachePath(s, in)
+			if data, err := os.ReadFile(path); err == nil {
+				out[s.Name] = data
+				continue
+			}
+		}
+
+		data, err := s.Run(ctx, in)
+		if err != nil {
+			return out, fmt.Errorf("%v: %w", s.Name, err)
+		}
+		out[s.Name] = data
+
+		if path != "" {
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				return out, err
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// MarshalLayer serializes an S-box layer or an affine layer the way constructions/spn serializes constructions, for
+// artifacts.
+func MarshalLayer(layer encoding.Block) ([]byte, error) {
+	switch layer.(type) {
+	case encoding.ConcatenatedBlock, encoding.BlockAffine:
+		constr := spn.Construction{layer}
+		return constr.Serialize(), nil
+	default:
+		return nil, ErrUnserializableLayer
+	}
+}
+
+// UnmarshalLayer parses a layer serialized by MarshalLayer. The two kinds of layer serialize to different lengths.
+func UnmarshalLayer(data []byte) (encoding.Block, error) {
+	switch len(data) {
+	case 16 * 256:
+		layer := encoding.ConcatenatedBlock{}
+		for pos := range layer {
+			layer[pos] = encoding.ParseByte(data[256*pos : 256*(pos+1)])
+		}
+
+		return layer, nil
+	case 128*16 + 16:
+		linear, constant := matrix.Matrix{}, [16]byte{}
+		for row := 0; row < 128; row++ {
+			linear = append(linear, matrix.Row(data[16*row:16*(row+1)]))
+		}
+		copy(constant[:], data[128*16:])
+
+		if _, ok := linear.Invert(); !ok {
+			return nil, ErrSingularLayer
+		}
+		return encoding.NewBlockAffine(linear, constant), nil
+	default:
+		return nil, ErrMalformedLayer
+	}
+}
+
+// LayerStage is a stage that removes one more layer from the end of cipher. The stages it needs are earlier layer
+// stages, named in the order their layers were removed in; recover is given what's left of cipher once they're removed,
+// and returns the trailing layer of that, which is the stage's artifact.
+func LayerStage(name string, cipher encoding.Block, needs []string, recover func(ctx context.Context, rest encoding.Block) (encoding.Block, error)) Stage {
+	return Stage{
+		Name:  name,
+		Needs: needs,
+		Run: func(ctx context.Context, in Artifacts) ([]byte, error) {
+			rest := cipher
+			for _, need := range needs {
+				layer, err := UnmarshalLayer(in[need])
+				if err != nil {
+					return nil, fmt.Errorf("%v: %w", need, err)
+				}
+				rest = encoding.ComposedBlocks{rest, encoding.InverseBlock{layer}}
+			}
+
+			layer, err := recover(ctx, rest)
+			if err != nil {
+				return nil, err
+			}
+
+			return MarshalLayer(layer)
+		},
+	}
+}
@@ -0,0 +1,78 @@
+package spn
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+)
+
+// preflightDegree is the highest degree Preflight tests the cipher for. It costs about 2^(preflightDegree+2) queries,
+// and is enough to tell one round from more.
+const preflightDegree = 8
+
+// PreflightReport summarizes what cheap probes reveal about a cipher before running an expensive attack on it.
+type PreflightReport struct {
+	Widths Widths
+	Rounds RoundEstimate
+
+	// DifferenceRanks is, for each byte of the input, the dimension of the space spanned by the differences of the
+	// ciphertexts as the byte takes every value with the others fixed.
+	DifferenceRanks [16]int
+
+	// LinearTrailing is true if every rank in DifferenceRanks is at most 8, meaning each byte of the input only reaches
+	// an affine subspace of its own dimension. The cipher is then an affine layer over a byte-wise one, and the Cube
+	// attack on a trailing S-box layer can't succeed: remove the affine layer with RecoverAffine first.
+	LinearTrailing bool
+}
+
+// DifferenceRank returns the dimension of the space spanned by the differences of the ciphertexts as the byte at pos of
+// the input takes every value, with the rest fixed at random. It's at most 8 if the cipher is an affine layer over a
+// byte-wise (or affine) one, and usually larger if any nonlinear layer comes after the first layer that mixes bytes.
+// It takes 256 queries.
+func DifferenceRank(cipher encoding.Block, pos int, opts ...Option) int {
+	return saturationSpan(newOracle(cipher, newOptions(opts)), 8*pos, 8, 128)
+}
+
+// Preflight runs every cheap probe on the cipher: ProbeWidths, EstimateRounds up to degree 8, and DifferenceRank at
+// each position. It takes a few thousand queries.
+func Preflight(cipher encoding.Block, opts ...Option) *PreflightReport {
+	r := &PreflightReport{
+		Widths:         ProbeWidths(cipher, opts...),
+		Rounds:         EstimateRounds(cipher, 7, preflightDegree, opts...),
+		LinearTrailing: true,
+	}
+
+	for pos := 0; pos < r.Widths.Block/8; pos++ {
+		r.DifferenceRanks[pos] = DifferenceRank(cipher, pos, opts...)
+		if r.DifferenceRanks[pos] > 8 {
+			r.LinearTrailing = false
+		}
+	}
+
+	newOptions(opts).logger.Debug("preflight", "widths", r.Widths, "rounds", r.Rounds, "linear_trailing", r.LinearTrailing)
+
+	return r
+}
+
+func (r *PreflightReport) String() string {
+	out := &strings.Builder{}
+
+	fmt.Fprintf(out, "block width: %v bits\n", r.Widths.Block)
+	if r.Widths.SBox > 0 {
+		fmt.Fprintf(out, "leading S-box width: %v bits\n", r.Widths.SBox)
+	} else {
+		fmt.Fprintf(out, "leading S-box width: unknown\n")
+	}
+
+	if r.Rounds.Exact {
+		fmt.Fprintf(out, "degree: %v (%v rounds)\n", r.Rounds.Degree, r.Rounds.Rounds)
+	} else {
+		fmt.Fprintf(out, "degree: at least %v (at least %v rounds)\n", r.Rounds.Degree, r.Rounds.Rounds)
+	}
+
+	fmt.Fprintf(out, "difference ranks: %v\n", r.DifferenceRanks)
+	fmt.Fprintf(out, "linear trailing layer: %v\n", r.LinearTrailing)
+
+	return out.String()
+}
@@ -0,0 +1,179 @@
+package spn
+
+import (
+	"fmt"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+)
+
+// Provenance is a guess at how an S-box was built.
+type Provenance int
+
+const (
+	Random       Provenance = iota // None of the others.
+	Inversion                      // Inversion in a finite field, up to affine equivalence.
+	PowerMap                       // Some other power map x^d in a finite field, up to affine equivalence.
+	SmallNetwork                   // A few rounds of a Feistel or Lai-Massey network of 4-bit functions.
+)
+
+func (p Provenance) String() string {
+	switch p {
+	case Inversion:
+		return "inversion"
+	case PowerMap:
+		return "power map"
+	case SmallNetwork:
+		return "small network"
+	default:
+		return "random"
+	}
+}
+
+// SBoxFeatures are the properties of an S-box that ClassifySBox looks at. All of them are the same for affine-equivalent
+// S-boxes, so it doesn't matter which affine transformations an attack recovered an S-box up to.
+type SBoxFeatures struct {
+	DifferentialUniformity int // The largest entry of the difference distribution table, outside of its first row.
+	Linearity              int // The largest absolute Walsh coefficient, outside of the zero output mask.
+	Degree                 int // The largest algebraic degree of a coordinate.
+
+	// UniformRows is true if every row of the difference distribution table has the same entries, in some order, as
+	// power maps do. InversionRows is true if they're those of inversion: one 4, 126 2s, and 129 0s.
+	UniformRows, InversionRows bool
+}
+
+// String implements fmt.Stringer.
+func (f SBoxFeatures) String() string {
+	return fmt.Sprintf("differential uniformity %v, linearity %v, degree %v, uniform rows %v",
+		f.DifferentialUniformity, f.Linearity, f.Degree, f.UniformRows)
+}
+
+// rowSpectrum returns how many times each count appears in the row of s's difference distribution table for input
+// difference a.
+func rowSpectrum(s encoding.Byte, a byte) (spectrum [257]int) {
+	row := [256]int{}
+	for x := 0; x < 256; x++ {
+		row[s.Encode(byte(x))^s.Encode(byte(x)^a)]++
+	}
+
+	for _, c := range row {
+		spectrum[c]++
+	}
+
+	return
+}
+
+// walsh returns the Walsh transform of the Boolean function x -> b.x ^ s(x).
+func walsh(s encoding.Byte, b byte) (w [256]int) {
+	for x := 0; x < 256; x++ {
+		w[x] = 1 - 2*parity(b&s.Encode(byte(x)))
+	}
+
+	for h := 1; h < 256; h <<= 1 {
+		for i := 0; i < 256; i += 2 * h {
+			for j := i; j < i+h; j++ {
+				w[j], w[j+h] = w[j]+w[j+h], w[j]-w[j+h]
+			}
+		}
+	}
+
+	return
+}
+
+// parity returns the number of set bits of x, mod 2.
+func parity(x byte) int {
+	x ^= x >> 4
+	x ^= x >> 2
+	x ^= x >> 1
+	return int(x & 1)
+}
+
+// coordinateDegree returns the algebraic degree of bit i of s, from its algebraic normal form.
+func coordinateDegree(s encoding.Byte, i uint) (degree int) {
+	anf := [256]byte{}
+	for x := range anf {
+		anf[x] = (s.Encode(byte(x)) >> i) & 1
+	}
+
+	for h := 1; h < 256; h <<= 1 {
+		for x := range anf {
+			if x&h != 0 {
+				anf[x] ^= anf[x^h]
+			}
+		}
+	}
+
+	for x, c := range anf {
+		if c == 1 {
+			if d := weight(byte(x)); d > degree {
+				degree = d
+			}
+		}
+	}
+
+	return
+}
+
+// weight returns the number of set bits of x.
+func weight(x byte) (w int) {
+	for ; x > 0; x &= x - 1 {
+		w++
+	}
+	return
+}
+
+// AnalyzeSBox computes the features of an S-box.
+func AnalyzeSBox(s encoding.Byte) (f SBoxFeatures) {
+	first := rowSpectrum(s, 1)
+	f.UniformRows = true
+
+	for a := 1; a < 256; a++ {
+		spectrum := rowSpectrum(s, byte(a))
+		f.UniformRows = f.UniformRows && spectrum == first
+
+		for c := len(spectrum) - 1; c > f.DifferentialUniformity; c-- {
+			if spectrum[c] > 0 {
+				f.DifferentialUniformity = c
+			}
+		}
+	}
+	f.InversionRows = f.UniformRows && first[4] == 1 && first[2] == 126 && first[0] == 129
+
+	for b := 1; b < 256; b++ {
+		for _, c := range walsh(s, byte(b)) {
+			if c < 0 {
+				c = -c
+			}
+			if c > f.Linearity {
+				f.Linearity = c
+			}
+		}
+	}
+
+	for i := uint(0); i < 8; i++ {
+		if d := coordinateDegree(s, i); d > f.Degree {
+			f.Degree = d
+		}
+	}
+
+	return
+}
+
+// ClassifySBox guesses how an S-box was built from its features. Power maps, inversion included, are the only S-boxes
+// whose difference distribution tables have uniform rows. A Feistel or Lai-Massey network on 4-bit halves leaks the
+// structure of its halves as differentials and linear approximations far stronger than a random S-box's, which almost
+// never has an entry of 16 in its table or a Walsh coefficient of 96. The guess is a heuristic: any S-box that isn't a
+// power map and isn't badly weak is called random, whether or not it is.
+func ClassifySBox(s encoding.Byte) (Provenance, SBoxFeatures) {
+	f := AnalyzeSBox(s)
+
+	switch {
+	case f.InversionRows:
+		return Inversion, f
+	case f.UniformRows:
+		return PowerMap, f
+	case f.DifferentialUniformity >= 16 || f.Linearity >= 96:
+		return SmallNetwork, f
+	default:
+		return Random, f
+	}
+}
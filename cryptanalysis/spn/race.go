@@ -0,0 +1,149 @@
+package spn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+
+	"github.com/OpenWhiteBox/Generic/constructions/spn"
+)
+
+var (
+	// ErrNoAttacks is returned by Race when it's given no attacks to run.
+	ErrNoAttacks = errors.New("no attacks to race")
+
+	// ErrUnverified is the error of an attack in a Race that finished, but whose result wasn't accepted.
+	ErrUnverified = errors.New("result doesn't verify")
+)
+
+// CachedOracle remembers every query made to a cipher, so that attacks sharing it don't pay twice for the same
+// plaintext or ciphertext. An encryption also answers the decryption of its ciphertext, and the other way around. It's
+// safe for concurrent use.
+type CachedOracle struct {
+	cipher encoding.Block
+
+	mu       sync.Mutex
+	enc, dec map[[16]byte][16]byte
+	queries  int
+}
+
+// NewCachedOracle returns an empty cache in front of cipher.
+func NewCachedOracle(cipher encoding.Block) *CachedOracle {
+	return &CachedOracle{cipher: cipher, enc: make(map[[16]byte][16]byte), dec: make(map[[16]byte][16]byte)}
+}
+
+func (c *CachedOracle) Encode(in [16]byte) [16]byte {
+	return c.lookup(in, c.enc, c.dec, c.cipher.Encode)
+}
+
+func (c *CachedOracle) Decode(in [16]byte) [16]byte {
+	return c.lookup(in, c.dec, c.enc, c.cipher.Decode)
+}
+
+// lookup answers in from forwards if it's there, and otherwise queries the cipher and remembers the answer both ways.
+// The cipher isn't queried with the lock held, so slow queries from different attacks overlap.
+func (c *CachedOracle) lookup(in [16]byte, forwards, backwards map[[16]byte][16]byte, query func([16]byte) [16]byte) [16]byte {
+	c.mu.Lock()
+	out, ok := forwards[in]
+	c.mu.Unlock()
+	if ok {
+		return out
+	}
+
+	out = query(in)
+
+	c.mu.Lock()
+	forwards[in], backwards[out] = out, in
+	c.queries++
+	c.mu.Unlock()
+
+	return out
+}
+
+// Queries returns the number of queries that made it through the cache to the cipher.
+func (c *CachedOracle) Queries() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.queries
+}
+
+// VerifyResult checks that an attack's result reassembles into the cipher, with its layers either after what's left,
+// or before it as with LeadingSBoxAttack. It's Race's default verifier.
+func VerifyResult(cipher encoding.Block, res Result) bool {
+	if res.Rest == nil {
+		return false
+	}
+	layers := encoding.ComposedBlocks(res.Layers)
+
+	return encoding.ProbablyEquivalentBlocks(encoding.ComposedBlocks{res.Rest, layers}, cipher) ||
+		encoding.ProbablyEquivalentBlocks(encoding.ComposedBlocks{layers, res.Rest}, cipher)
+}
+
+// Race runs attacks concurrently against one CachedOracle in front of cipher, and returns the first of them to finish
+// with a result that verify accepts, along with that result. The other attacks are canceled and stop at their next
+// query, but the winner's Rest keeps working, even after ctx is done. A nil verify is VerifyResult. If no attack succeeds,
+// the error joins every attack's error.
+//
+// Racing trades CPU for wall-clock time, when it isn't clear which attack will be fastest against a target.
+func Race(ctx context.Context, cipher encoding.Block, attacks []Attack, verify func(encoding.Block, Result) bool) (Attack, Result, error) {
+	if len(attacks) == 0 {
+		return nil, Result{}, ErrNoAttacks
+	} else if verify == nil {
+		verify = VerifyResult
+	}
+
+	type outcome struct {
+		i   int
+		res Result
+		err error
+	}
+	oracle, outcomes := NewCachedOracle(cipher), make(chan outcome, len(attacks))
+
+	// Each attack gets a context of its own, so that the others can be canceled without canceling the winner.
+	cancels := make([]context.CancelFunc, len(attacks))
+	for i, attack := range attacks {
+		var actx context.Context
+		actx, cancels[i] = context.WithCancel(ctx)
+
+		go func(i int, attack Attack) {
+			res, err := attack.Run(actx, oracle)
+			outcomes <- outcome{i, res, err}
+		}(i, attack)
+	}
+
+	errs := []error{}
+	for range attacks {
+		out := <-outcomes
+		if out.err == nil && verify(oracle, out.res) {
+			for i, cancel := range cancels {
+				if i != out.i {
+					cancel()
+				}
+			}
+
+			return attacks[out.i], out.res, nil
+		} else if out.err == nil {
+			out.err = ErrUnverified
+		}
+
+		cancels[out.i]()
+		errs = append(errs, fmt.Errorf("%v: %w", attacks[out.i].Name(), out.err))
+	}
+
+	return nil, Result{}, errors.Join(errs...)
+}
+
+// RaceApplicable races every registered attack that's applicable to a cipher with the given structure, through an
+// oracle with the given capabilities (see Applicable). Each attack is configured with opts.
+func RaceApplicable(ctx context.Context, cipher encoding.Block, structure spn.Structure, caps Capability, opts ...Option) (Attack, Result, error) {
+	attacks := []Attack{}
+	for _, r := range Applicable(structure, caps) {
+		attacks = append(attacks, r.New(opts...))
+	}
+
+	return Race(ctx, cipher, attacks, nil)
+}
@@ -0,0 +1,151 @@
+package spn
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/OpenWhiteBox/Generic/constructions/spn"
+)
+
+// Capability is a kind of query an oracle answers.
+type Capability int
+
+const (
+	ChosenPlaintext  Capability = 1 << iota // The oracle encrypts plaintexts of the attacker's choosing.
+	ChosenCiphertext                        // The oracle decrypts ciphertexts of the attacker's choosing.
+)
+
+// UnknownStructure stands for the structure of a cipher that hasn't been identified, in Applicable.
+const UnknownStructure spn.Structure = -1
+
+// Registration describes an attack that's available to tools built on this package.
+type Registration struct {
+	Name string
+
+	// Structures are the structures of the ciphers the attack can be run against. An empty list means it works against
+	// any of them.
+	Structures []spn.Structure
+
+	// Requires is every capability the attack needs the oracle to have.
+	Requires Capability
+
+	// New returns the attack, configured with opts.
+	New func(opts ...Option) Attack
+}
+
+var registry struct {
+	sync.Mutex
+	attacks map[string]Registration
+}
+
+// Register makes an attack available through Registered and Applicable. It panics if an attack with the same name is
+// already registered.
+func Register(r Registration) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if registry.attacks == nil {
+		registry.attacks = make(map[string]Registration)
+	} else if _, ok := registry.attacks[r.Name]; ok {
+		panic("Attack " + r.Name + " is already registered!")
+	}
+
+	registry.attacks[r.Name] = r
+}
+
+// Registered returns every registered attack, sorted by name.
+func Registered() (out []Registration) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	for _, r := range registry.attacks {
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+
+	return
+}
+
+// Applicable returns the registered attacks that can be run against a cipher with the given structure, through an
+// oracle with the given capabilities. If the structure is UnknownStructure, only attacks that work against any
+// structure are returned.
+func Applicable(structure spn.Structure, caps Capability) (out []Registration) {
+	for _, r := range Registered() {
+		if r.Requires&caps != r.Requires {
+			continue
+		}
+
+		ok := len(r.Structures) == 0
+		for _, s := range r.Structures {
+			ok = ok || s == structure
+		}
+
+		if ok {
+			out = append(out, r)
+		}
+	}
+
+	return
+}
+
+func init() {
+	for _, structure := range []spn.Structure{spn.AS, spn.SA, spn.ASA, spn.SAS, spn.ASAS, spn.SASA, spn.SASAS} {
+		structure := structure
+		Register(Registration{
+			Name:       DecomposeAttack{Structure: structure}.Name(),
+			Structures: []spn.Structure{structure},
+			Requires:   ChosenPlaintext,
+			New:        func(opts ...Option) Attack { return DecomposeAttack{structure, opts} },
+		})
+	}
+
+	affine := map[GeneratorType][]spn.Structure{
+		TrivialSubspaceGenerator: {spn.AS},
+		LowRankAdditionGenerator: {spn.ASA},
+		LowRankToggleGenerator:   {spn.ASAS},
+	}
+	for generator, structures := range affine {
+		generator := generator
+		Register(Registration{
+			Name:       AffineAttack{Generator: generator}.Name(),
+			Structures: structures,
+			Requires:   ChosenPlaintext,
+			New:        func(opts ...Option) Attack { return AffineAttack{generator, opts} },
+		})
+	}
+
+	sboxes := map[GeneratorType][]spn.Structure{
+		BalancedGenerator:    {spn.SA},
+		DualGenerator:        {spn.SAS},
+		PermutationGenerator: {spn.SA, spn.SAS, spn.SASA, spn.SASAS},
+	}
+	for generator, structures := range sboxes {
+		generator := generator
+		Register(Registration{
+			Name:       SBoxAttack{Generator: generator}.Name(),
+			Structures: structures,
+			Requires:   ChosenPlaintext,
+			New:        func(opts ...Option) Attack { return SBoxAttack{generator, opts} },
+		})
+	}
+
+	for generator, structures := range sboxes {
+		generator, mirrors := generator, []spn.Structure{}
+		for _, structure := range structures {
+			mirrors = append(mirrors, Mirror(structure))
+		}
+
+		Register(Registration{
+			Name:       LeadingSBoxAttack{Generator: generator}.Name(),
+			Structures: mirrors,
+			Requires:   ChosenCiphertext,
+			New:        func(opts ...Option) Attack { return LeadingSBoxAttack{generator, opts} },
+		})
+	}
+
+	Register(Registration{
+		Name:     TrailingLayerAttack{}.Name(),
+		Requires: ChosenPlaintext,
+		New:      func(opts ...Option) Attack { return TrailingLayerAttack{opts} },
+	})
+}
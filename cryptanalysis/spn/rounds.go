@@ -0,0 +1,86 @@
+package spn
+
+import (
+	"crypto/rand"
+	"math"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+)
+
+// degreeTrials is the number of random subspaces of each dimension EstimateRounds sums the cipher over. A cipher of too
+// high a degree only passes for a lower one if every sum vanishes by chance.
+const degreeTrials = 2
+
+// RoundEstimate is the result of EstimateRounds.
+type RoundEstimate struct {
+	// Degree is the algebraic degree of the cipher: the highest degree of any output bit as a polynomial in the input
+	// bits. If Exact is false, the cipher's degree is at least Degree, which is as high as the test went.
+	Degree int
+	Exact  bool
+
+	// Rounds is the fewest S-box layers that reach the degree, for S-boxes of the given degree. If Exact is false, it's a
+	// lower bound as well.
+	Rounds int
+}
+
+// derivativeVanishes returns true if the sum of the cipher's outputs over a random affine subspace of dimension dim is
+// zero, which it always is if dim is more than the cipher's degree.
+func derivativeVanishes(cipher encoding.Block, dim int) bool {
+	basis, offset := make([][16]byte, dim), [16]byte{}
+	rand.Read(offset[:])
+	for i := range basis {
+		rand.Read(basis[i][:])
+	}
+
+	sum := [16]byte{}
+	for x := 0; x < 1<<uint(dim); x++ {
+		pt := offset
+		for i, v := range basis {
+			if (x>>uint(i))&1 == 1 {
+				encoding.XOR(pt[:], pt[:], v[:])
+			}
+		}
+
+		ct := cipher.Encode(pt)
+		encoding.XOR(sum[:], sum[:], ct[:])
+	}
+
+	return sum == [16]byte{}
+}
+
+// EstimateRounds estimates the number of rounds of a black-box cipher whose S-boxes have degree sboxDegree (7, for
+// bijective 8-bit S-boxes), from the growth of its algebraic degree. A cipher of degree d has every higher-order
+// derivative of order more than d equal to zero, so it sums its outputs over random subspaces of increasing dimension
+// until they vanish, up to maxDim, which takes about 2^(maxDim+2) queries in total. Each S-box layer multiplies the
+// degree by at most sboxDegree, so r rounds reach a degree of at most sboxDegree^r.
+//
+// The degree bounds which attacks are feasible: the Cube attacks in this package need the degree of the cipher up to
+// the layer being attacked to be small. Ciphers of more than two rounds are usually beyond any feasible maxDim, and only
+// get a lower bound.
+func EstimateRounds(cipher encoding.Block, sboxDegree, maxDim int, opts ...Option) RoundEstimate {
+	o := newOptions(opts)
+	orc := newOracle(cipher, o)
+
+	rounds := func(degree int) int {
+		if degree <= 1 {
+			return 0
+		}
+
+		return int(math.Ceil(math.Log(float64(degree))/math.Log(float64(sboxDegree)) - 1e-9))
+	}
+
+	for dim := 1; dim <= maxDim; dim++ {
+		vanishes := true
+		for i := 0; i < degreeTrials && vanishes; i++ {
+			vanishes = derivativeVanishes(orc, dim)
+			o.metrics.Batch()
+		}
+
+		if vanishes {
+			o.logger.Debug("derivative vanishes", "dimension", dim)
+			return RoundEstimate{Degree: dim - 1, Exact: true, Rounds: rounds(dim - 1)}
+		}
+	}
+
+	return RoundEstimate{Degree: maxDim, Rounds: rounds(maxDim)}
+}
@@ -2,73 +2,115 @@ package spn
 
 import (
 	"crypto/rand"
+	"fmt"
+	"math/bits"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/OpenWhiteBox/primitives/encoding"
 	"github.com/OpenWhiteBox/primitives/gfmatrix"
 	"github.com/OpenWhiteBox/primitives/number"
 )
 
-// incrementalMatrices implements succint operations over a slice of incremental matrices.
-type incrementalMatrices []gfmatrix.IncrementalMatrix
+// NullSpaceDim returns the dimension of the nullspace that a position's system of relations is expected to end up with,
+// when every set of plaintexts from the generator sums each function of degree at most degree in the S-box's output
+// bits to zero: one dimension for each monomial of that degree or less. Every generator in this package has degree 1
+// against the layer it's meant for, which gives a 9-dimensional nullspace--one dimension for each output bit, and one
+// for constants.
+func NullSpaceDim(degree int) (dim int) {
+	if degree < 0 || degree > 7 {
+		panic("Degree of relations must be between 0 and 7!")
+	}
 
-// NewIncrementalMatrices returns a new slice of x n-by-n incremental matrices.
-func newIncrementalMatrices(x, n int) (ims incrementalMatrices) {
-	ims = make([]gfmatrix.IncrementalMatrix, x)
-	for i, _ := range ims {
-		ims[i] = gfmatrix.NewIncrementalMatrix(n)
+	binomial := 1
+	for i := 0; i <= degree; i++ {
+		dim += binomial
+		binomial = binomial * (8 - i) / (i + 1)
 	}
 
 	return
 }
 
-// SufficientlyDefined returns true if every incremental matrix is sufficiently defined. The must all have a
-// 9-dimensional nullspace or smallter. This way, it is small enough to search, but not so small that we have nowhere to
-// look for solutions.
-func (ims incrementalMatrices) SufficientlyDefined() bool {
-	for _, im := range ims {
-		if im.Len() < 247 {
-			return false
+// randomPermutationVector returns a random linear combination of a set of basis vectors, if its first 256 entries are a
+// permutation vector. It computes those entries one at a time and gives up at the first value that repeats, which for
+// most combinations that aren't permutation vectors is within the first few dozen.
+func randomPermutationVector(basis []gfmatrix.Row) (gfmatrix.Row, bool) {
+	coeffs := make([]byte, len(basis))
+	rand.Read(coeffs)
+
+	v, seen := gfmatrix.NewRow(basis[0].Size()), [256]bool{}
+	entry := func(i int) {
+		for j, c_j := range coeffs {
+			v[i] = v[i].Add(basis[j][i].Mul(number.ByteFieldElem(c_j)))
 		}
 	}
 
-	return true
-}
+	for i := 0; i < 256; i++ {
+		if entry(i); seen[v[i]] {
+			return nil, false
+		}
+		seen[v[i]] = true
+	}
 
-// Matrices returns a slice of matrices, one for each incremental matrix.
-func (ims incrementalMatrices) Matrices() (out []gfmatrix.Matrix) {
-	out = make([]gfmatrix.Matrix, len(ims))
-	for i, im := range ims {
-		out[i] = im.Matrix()
+	for i := 256; i < len(v); i++ {
+		entry(i)
 	}
 
-	return out
+	return v, true
 }
 
-// randomLinearCombination returns a random linear combination of a set of basis vectors.
-func randomLinearCombination(basis []gfmatrix.Row) gfmatrix.Row {
-	coeffs := make([]byte, len(basis))
-	rand.Read(coeffs)
-
-	v := gfmatrix.NewRow(basis[0].Size())
+// permutationSamples bounds the number of linear combinations findPermutation tries. About 29% of them are permutation
+// vectors when the nullspace is what the attack expects, so failing this many times means there isn't one to find.
+const permutationSamples = 4096
 
-	for i, c_i := range coeffs {
-		v = v.Add(basis[i].ScalarMul(number.ByteFieldElem(c_i)))
+// findPermutation takes a set of vectors and finds a linear combination of them that gives a permutation vector. It
+// returns false if there doesn't seem to be one, for example because the system had more relations than the S-box
+// should satisfy.
+//
+// The linear combinations are chosen by search (see WithSearch), and its attempts are split between the given number of
+// goroutines, which stop as soon as any of them finds a permutation vector (see WithWorkers).
+func findPermutation(basis []gfmatrix.Row, search PermutationSearch, workers int) (gfmatrix.Row, bool) {
+	if len(basis) == 0 {
+		return nil, false
 	}
 
-	return v
-}
+	var (
+		wg      sync.WaitGroup
+		once    sync.Once
+		sampled int64
+		found   gfmatrix.Row
+	)
+	done := make(chan struct{})
 
-// findPermutation takes a set of vectors and finds a linear combination of them that gives a permutation vector.
-func findPermutation(basis []gfmatrix.Row) gfmatrix.Row {
-	for true {
-		v := randomLinearCombination(basis)
+	for w := 0; w < max(workers, 1); w++ {
+		wg.Add(1)
 
-		if v[:256].IsPermutation() {
-			return v
-		}
+		go func() {
+			defer wg.Done()
+
+			for atomic.LoadInt64(&sampled) < permutationSamples {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				v, ok, evaluated := search.Attempt(basis)
+				atomic.AddInt64(&sampled, int64(evaluated))
+				if ok {
+					once.Do(func() {
+						found = v
+						close(done)
+					})
+					return
+				}
+			}
+		}()
 	}
 
-	return nil
+	wg.Wait()
+	return found, found != nil
 }
 
 // newSBox takes a permutation vector as input and returns its corresponding S-Box. It inverts the S-Box if backwards is
@@ -89,37 +131,563 @@ func newSBox(v gfmatrix.Row, backwards bool) (out encoding.SBox) {
 	return
 }
 
+// splitConstant writes s as norm(x ^ c), where norm maps 0 to zero.
+func splitConstant(s encoding.Byte, zero byte) (norm encoding.SBox, c byte) {
+	c = s.Decode(zero)
+
+	for x := 0; x < 256; x++ {
+		y := s.Encode(byte(x) ^ c)
+		norm.EncKey[x], norm.DecKey[y] = y, byte(x)
+	}
+
+	return
+}
+
+// countPermutations samples linear combinations of a set of basis vectors and returns how many give a permutation vector.
+func countPermutations(basis []gfmatrix.Row, samples int) (count int) {
+	for i := 0; i < samples; i++ {
+		if _, ok := randomPermutationVector(basis); ok {
+			count++
+		}
+	}
+
+	return
+}
+
+const (
+	histogramSamples = 1024 // Ciphertexts to see before judging whether a position is bijective.
+	histogramValues  = 192  // Distinct values a position must take by then. Outputs of an S-box take about 251.
+)
+
+// histogram records which values have been seen in each position of the ciphertexts.
+type histogram struct {
+	seen     [16][256]bool
+	distinct [16]int
+	samples  int
+}
+
+func (h *histogram) add(ct [16]byte) {
+	for pos, v := range ct {
+		if !h.seen[pos][v] {
+			h.seen[pos][v] = true
+			h.distinct[pos]++
+		}
+	}
+
+	h.samples++
+}
+
+// degenerate returns the positions that have taken too few distinct values to be the outputs of S-boxes, for example
+// because the cipher's output is truncated. It returns nothing until enough ciphertexts have been seen to tell.
+func (h *histogram) degenerate() (positions []int) {
+	if h.samples < histogramSamples {
+		return nil
+	}
+
+	for pos, n := range h.distinct {
+		if n < histogramValues {
+			positions = append(positions, pos)
+		}
+	}
+
+	return
+}
+
+// batchRows queries the cipher on one set of plaintexts from generator and returns, for each position, the row counting
+// how many times each value appeared in that position of the ciphertexts (mod 2). The ciphertexts are also added to h,
+// unless it's nil.
+func batchRows(cipher encoding.Block, generator func() [][16]byte, h *histogram) (rows [16]gfmatrix.Row) {
+	return plaintextRows(cipher, generator(), h, nil)
+}
+
+// plaintextRows is batchRows for a set of plaintexts that's already been generated, with rows taken from a.
+func plaintextRows(cipher encoding.Block, pts [][16]byte, h *histogram, a *arena) (rows [16]gfmatrix.Row) {
+	p := &parities{}
+
+	for _, ct := range encodeBatch(cipher, pts) {
+		p.add(ct)
+		if h != nil {
+			h.add(ct)
+		}
+	}
+
+	return p.rows(a)
+}
+
+// ciphertextRows returns, for each position, the row counting how many times each value appeared in that position of
+// the ciphertexts (mod 2).
+func ciphertextRows(cts [][16]byte) (rows [16]gfmatrix.Row) {
+	p := &parities{}
+	for _, ct := range cts {
+		p.add(ct)
+	}
+
+	return p.rows(nil)
+}
+
+// parities accumulates, for each position, the parity of the number of times each value appeared in that position of a
+// set of ciphertexts, as a 256-bit mask. Each ciphertext is read once and flips one bit in each position's 32 bytes,
+// which all fit in a few cache lines, so it's much cheaper than filling rows of field elements one ciphertext at a time.
+// The masks are only turned into rows once the set is done.
+type parities [16][4]uint64
+
+func (p *parities) add(ct [16]byte) {
+	for pos, v := range ct {
+		p[pos][v>>6] ^= 1 << (v & 63)
+	}
+}
+
+// rows converts the masks into rows of the positions' systems. The rows are taken from a, or share one allocation if a
+// is nil.
+func (p *parities) rows(a *arena) (rows [16]gfmatrix.Row) {
+	var slab gfmatrix.Row
+	if a == nil {
+		slab = make(gfmatrix.Row, 16*256)
+	}
+
+	for pos := range rows {
+		if a == nil {
+			rows[pos] = slab[256*pos : 256*(pos+1) : 256*(pos+1)]
+		} else {
+			rows[pos] = a.row()
+		}
+
+		for w, mask := range p[pos] {
+			for ; mask != 0; mask &= mask - 1 {
+				rows[pos][64*w+bits.TrailingZeros64(mask)] = 0x01
+			}
+		}
+	}
+
+	return
+}
+
+// votedRows queries the cipher on one set of plaintexts from generator like batchRows and, if votes is more than 1,
+// re-queries it on the same plaintexts to vote on each row that would raise the rank of its position's system. A row is
+// replaced by the one that more than half of the votes agree on, or by a zero row if none does. Rows that don't raise the
+// rank can't poison the system, so they're never re-queried. The first set of rows is taken from a.
+func votedRows(cipher encoding.Block, generator func() [][16]byte, h *histogram, ims IncrementalMatrices, votes int, a *arena) (rows [16]gfmatrix.Row) {
+	pts := generator()
+	rows = plaintextRows(cipher, pts, h, a)
+
+	suspicious := []int{}
+	for pos := range rows {
+		if votes > 1 && ims[pos].Novel(rows[pos]) {
+			suspicious = append(suspicious, pos)
+		}
+	}
+	if len(suspicious) == 0 {
+		return
+	}
+
+	ballots := [16][]gfmatrix.Row{}
+	for _, pos := range suspicious {
+		ballots[pos] = append(ballots[pos], rows[pos])
+	}
+	for i := 1; i < votes; i++ {
+		recast := plaintextRows(cipher, pts, nil, nil)
+		for _, pos := range suspicious {
+			ballots[pos] = append(ballots[pos], recast[pos])
+		}
+	}
+
+	for _, pos := range suspicious {
+		rows[pos] = gfmatrix.NewRow(256)
+
+		for _, candidate := range ballots[pos] {
+			agree := 0
+			for _, other := range ballots[pos] {
+				if candidate.Add(other).IsZero() {
+					agree++
+				}
+			}
+
+			if 2*agree > votes {
+				rows[pos] = candidate
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// Confidence describes how much trust to place in a recovered S-box.
+type Confidence struct {
+	// NullSpaceDim is the dimension of the nullspace the S-box was found in. The attack expects it to be 9 (see
+	// NullSpaceDim and WithRelationDegree). Larger nullspaces mean the S-box is less constrained.
+	NullSpaceDim int
+
+	// Candidates is how many of Samples random linear combinations of the nullspace were permutation vectors. About 29%
+	// are when the nullspace has the expected dimension. If the nullspace was searched exhaustively, Samples is the
+	// number of linear combinations it has and Candidates is exact.
+	Candidates, Samples int
+
+	// Agreement is the fraction of fresh batches of plaintexts whose relations the S-box satisfies. Anything less than 1
+	// means the recovered S-box is wrong.
+	Agreement float64
+}
+
+// SBoxRecovery is the result of RecoverSBoxesDetailed. If the attack only partially succeeded, the positions that
+// weren't recovered hold the identity in Last, so that Rest only has the recovered S-boxes removed.
+type SBoxRecovery struct {
+	Last       encoding.ConcatenatedBlock
+	Rest       encoding.Block
+	Recovered  [16]bool
+	Confidence [16]Confidence
+
+	// NullSpaces holds, for each position whose system was sufficiently defined, a basis of its nullspace. The S-box in
+	// Last was found as a linear combination of it, but callers with constraints of their own, like ones from another
+	// attack, can intersect or search it themselves.
+	NullSpaces [16][]gfmatrix.Row
+
+	// Constants holds, with WithTrailingConstants, the byte added before each S-box of Last. Rest ends by adding them.
+	Constants [16]byte
+
+	diagnostics [16]PositionDiagnostics
+
+	// Alternatives holds, for each position whose nullspace was searched exhaustively (see WithExhaustiveSearch), every
+	// S-box consistent with its relations. The one in Last is the first of them. Positions with more candidates than fit
+	// in the memory budget (see WithMemoryBudget) have none.
+	Alternatives [16][]encoding.SBox
+}
+
+// Diagnostics returns the state of each position's system of relations at the end of the attack.
+func (r *SBoxRecovery) Diagnostics() [16]PositionDiagnostics {
+	return r.diagnostics
+}
+
+// PositionDiagnostics describes how far the attack got with the system of relations for one position.
+type PositionDiagnostics struct {
+	Rank      int // Rank of the system.
+	Dependent int // Rows that didn't raise the rank of the system, because it already implied them.
+
+	// Remaining estimates how many more batches the system needs to be sufficiently defined, by extrapolating the rate
+	// at which batches have raised its rank so far. It's 0 if the system is already sufficiently defined, and -1 if
+	// nothing has raised its rank yet.
+	Remaining int
+}
+
+// diagnose returns the diagnostics of a system of the given rank that has had dependent rows rejected, and needs the
+// given rank to be sufficiently defined.
+func diagnose(rank, dependent, sufficient int) PositionDiagnostics {
+	d := PositionDiagnostics{Rank: rank, Dependent: dependent}
+
+	if rank >= sufficient {
+		return d
+	} else if rank == 0 {
+		d.Remaining = -1
+		return d
+	}
+
+	d.Remaining = ((sufficient-rank)*(rank+dependent) + rank - 1) / rank
+	return d
+}
+
+// FailureReason explains why the S-box at some position couldn't be recovered.
+type FailureReason int
+
+const (
+	// InsufficientRank means the Cube attack didn't find enough linear relations for the S-box.
+	InsufficientRank FailureReason = iota
+
+	// NoPermutation means the relations that were found rule out every S-box, usually because the system has more of
+	// them than a real S-box satisfies.
+	NoPermutation
+
+	// NotBijective means the position of the ciphertext took too few distinct values to be the output of an S-box, for
+	// example because it's truncated. The attack stops waiting for its rank to grow as soon as this is noticed.
+	NotBijective
+)
+
+func (r FailureReason) String() string {
+	switch r {
+	case InsufficientRank:
+		return "insufficient rank"
+	case NoPermutation:
+		return "no permutation"
+	case NotBijective:
+		return "not bijective"
+	default:
+		return "unknown"
+	}
+}
+
+// RecoveryError is returned when the S-boxes at some positions couldn't be recovered. It holds, for each position that
+// failed, why it failed, the system of relations found for it, its diagnostics, and how many distinct values it took out
+// of Samples ciphertexts, so that the attack can be resumed or diagnosed. Diagnosis explains the failure as a whole when
+// it comes from a Cube attack that collected its own relations, and is nil otherwise.
+type RecoveryError struct {
+	Positions   []int
+	Reasons     []FailureReason
+	Systems     []gfmatrix.IncrementalMatrix
+	Diagnostics []PositionDiagnostics
+	Values      []int
+	Samples     int
+
+	Diagnosis *Diagnosis
+}
+
+func (e *RecoveryError) add(pos int, reason FailureReason, system gfmatrix.IncrementalMatrix, diag PositionDiagnostics, values int) {
+	e.Positions = append(e.Positions, pos)
+	e.Reasons = append(e.Reasons, reason)
+	e.Systems = append(e.Systems, system.Dup())
+	e.Diagnostics = append(e.Diagnostics, diag)
+	e.Values = append(e.Values, values)
+}
+
+func (e *RecoveryError) Error() string {
+	failures := make([]string, len(e.Positions))
+	for i, pos := range e.Positions {
+		if e.Reasons[i] == NotBijective {
+			failures[i] = fmt.Sprintf("%v (%v, %v of 256 values in %v ciphertexts)", pos, e.Reasons[i], e.Values[i], e.Samples)
+		} else if e.Reasons[i] == InsufficientRank && e.Diagnostics[i].Remaining > 0 {
+			failures[i] = fmt.Sprintf("%v (%v, rank %v, about %v more batches)", pos, e.Reasons[i], e.Systems[i].Len(), e.Diagnostics[i].Remaining)
+		} else {
+			failures[i] = fmt.Sprintf("%v (%v, rank %v)", pos, e.Reasons[i], e.Systems[i].Len())
+		}
+	}
+
+	return fmt.Sprintf("failed to recover the S-boxes at positions %v", strings.Join(failures, ", "))
+}
+
+// candidateSize is the memory a candidate found by an exhaustive search takes until it's turned into an S-box.
+const candidateSize = 256 + 512
+
+const (
+	confidenceSamples   = 64 // Random linear combinations sampled to count permutation candidates.
+	verificationBatches = 16 // Fresh batches of plaintexts used to check recovered S-boxes.
+)
+
 // RecoverSBoxes implements a specific variant of the Cube attack to remove the trailing S-box layer of the given
 // cipher. It uses the plaintexts generated by generator.
-func RecoverSBoxes(cipher encoding.Block, generator func() [][16]byte) (last encoding.ConcatenatedBlock, rest encoding.Block) {
-	ims := newIncrementalMatrices(16, 256)
+func RecoverSBoxes(cipher encoding.Block, generator func() [][16]byte, opts ...Option) (last encoding.ConcatenatedBlock, rest encoding.Block) {
+	res, err := recoverSBoxes(cipher, generator, newOptions(opts), false)
+	if err != nil {
+		panic(err)
+	}
+
+	return res.Last, res.Rest
+}
+
+// RecoverSBoxesDetailed is RecoverSBoxes, but also reports how confident it is in each S-box it recovers. Checking the
+// S-boxes costs a few more batches of plaintexts.
+//
+// Instead of panicking when some positions can't be recovered, it returns the S-boxes it could recover along with a
+// *RecoveryError describing the rest.
+func RecoverSBoxesDetailed(cipher encoding.Block, generator func() [][16]byte, opts ...Option) (*SBoxRecovery, error) {
+	return recoverSBoxes(cipher, generator, newOptions(opts), true)
+}
+
+func recoverSBoxes(cipher encoding.Block, generator func() [][16]byte, o *options, verify bool) (*SBoxRecovery, error) {
+	o.ledger.begin("S-box layer")
+	orc := newOracle(cipher, o)
+	ims := o.systems
+	if ims == nil {
+		ims = NewIncrementalMatrices(16, 256)
+	}
+	hist, degenerate, dependent := &histogram{}, [16]bool{}, [16]int{}
+	ignored, rowArena := o.ignored(), &arena{}
+	growth := [][16]bool{}
+
+	// waiting returns true while some position that can still be recovered doesn't have enough relations.
+	waiting := func() bool {
+		for _, pos := range ims.Insufficient(o.sufficientRank()) {
+			if !degenerate[pos] && !ignored[pos] {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	batches := 0
+	generators := append([]Generator{generator}, o.escalation.generators...)
+	for stage := 0; stage < len(generators) && waiting(); stage++ {
+		generator = generators[stage]
+		if stage > 0 {
+			min, max := ims.rankRange()
+			o.logger.Info("escalating", "stage", stage, "min_rank", min, "max_rank", max)
+		}
+
+		stalled := 0
+		for attempt := 0; attempt < 2000 && waiting(); attempt++ {
+			rows := votedRows(orc, generator, hist, ims, o.votes, rowArena)
+			for pos := range rows {
+				if ignored[pos] {
+					rowArena.recycle(rows[pos])
+					rows[pos] = rowArena.row()
+				}
+			}
+
+			grown := ims.Add(rows[:])
+			kept := [16]bool{}
+			for _, pos := range grown {
+				kept[pos] = true
+			}
+			for pos, row := range rows {
+				if !kept[pos] {
+					rowArena.recycle(row)
+				}
+			}
+			growth = append(growth, kept)
+			for pos := range dependent {
+				dependent[pos]++
+			}
+			for _, pos := range grown {
+				dependent[pos]--
+				o.metrics.Rank(pos, ims.Rank(pos))
+				o.logger.Debug("rank increased", "position", pos, "rank", ims.Rank(pos))
+				o.emit(RankIncreased{pos, ims.Rank(pos)})
+			}
+			batches++
+			o.emit(BatchCollected{batches, stage, grown})
+			novel := len(grown) > 0
+
+			o.metrics.Batch()
+			if !novel {
+				o.metrics.Retry()
+				stalled++
+			} else {
+				stalled = 0
+			}
 
-	for attempt := 0; attempt < 2000 && !ims.SufficientlyDefined(); attempt++ {
-		pts := generator()
-		cts := make([][16]byte, len(pts))
+			for _, pos := range hist.degenerate() {
+				if !degenerate[pos] {
+					degenerate[pos] = true
+					o.logger.Debug("position isn't bijective", "position", pos, "values", hist.distinct[pos], "samples", hist.samples)
+				}
+			}
+
+			min, max := ims.rankRange()
+			o.logger.Debug("processed batch", "attempt", attempt, "novel", novel, "min_rank", min, "max_rank", max)
+
+			if stage < len(generators)-1 && o.escalation.patience > 0 && stalled >= o.escalation.patience {
+				break
+			}
+		}
+	}
 
-		for i, pt := range pts {
-			cts[i] = cipher.Encode(pt)
+	res := &SBoxRecovery{}
+	for pos := range ims {
+		res.diagnostics[pos] = diagnose(ims.Rank(pos), dependent[pos], o.sufficientRank())
+	}
+
+	failed, skip := &RecoveryError{Samples: hist.samples}, ignored
+	for pos := range ims {
+		if degenerate[pos] && !skip[pos] {
+			failed.add(pos, NotBijective, ims[pos], res.diagnostics[pos], hist.distinct[pos])
+			skip[pos] = true
+		}
+	}
+	for _, pos := range ims.Insufficient(o.sufficientRank()) {
+		if !skip[pos] {
+			failed.add(pos, InsufficientRank, ims[pos], res.diagnostics[pos], hist.distinct[pos])
+			skip[pos] = true
 		}
+	}
 
-		for pos := 0; pos < 16; pos++ {
-			row := gfmatrix.NewRow(256)
+	// Each position's nullspace and search for a permutation vector are independent of every other's, so they run in
+	// parallel, and a position's search starts as soon as its own nullspace is found.
+	bases, vs, found := [16][]gfmatrix.Row{}, [16]gfmatrix.Row{}, [16]bool{}
+	all, counts, searched := [16][]gfmatrix.Row{}, [16]int{}, [16]bool{}
+	o.parallel(len(bases), func(pos int) {
+		if skip[pos] {
+			return
+		}
 
-			for _, ct := range cts {
-				row[ct[pos]] = row[ct[pos]].Add(0x01)
+		bases[pos] = ims[pos].Matrix().NullSpace()
+		if len(bases[pos]) > o.exhaustive {
+			vs[pos], found[pos] = findPermutation(bases[pos], o.search, o.workers)
+			return
+		}
+
+		// Each position keeps its candidates in memory until they'd take more than its share of the budget, and only
+		// counts them from then on.
+		searched[pos] = true
+		keep := true
+		enumeratePermutations(bases[pos], func(v gfmatrix.Row) bool {
+			if counts[pos]++; counts[pos] == 1 {
+				vs[pos], found[pos] = v, true
+			}
+
+			if keep = keep && counts[pos]*candidateSize <= o.memoryBudget/16; keep {
+				all[pos] = append(all[pos], v)
+			} else {
+				all[pos] = nil
 			}
 
-			ims[pos].Add(row)
+			return true
+		})
+	})
+
+	for pos := range bases {
+		res.Last[pos], res.NullSpaces[pos] = encoding.IdentityByte{}, bases[pos]
+
+		if skip[pos] {
+			continue
+		}
+
+		basis, v, ok := bases[pos], vs[pos], found[pos]
+		if !ok {
+			failed.add(pos, NoPermutation, ims[pos], res.diagnostics[pos], hist.distinct[pos])
+			continue
+		}
+
+		res.Recovered[pos] = true
+		res.Last[pos] = newSBox(v, true)
+		if o.reference != nil {
+			res.Last[pos], res.Constants[pos] = splitConstant(res.Last[pos], o.reference.Encode(0))
+		}
+
+		o.emit(PositionSolved{pos, res.Last[pos]})
+
+		for _, cand := range all[pos] {
+			res.Alternatives[pos] = append(res.Alternatives[pos], newSBox(cand, true))
+		}
+
+		if verify && searched[pos] {
+			res.Confidence[pos] = Confidence{
+				NullSpaceDim: len(basis),
+				Candidates:   counts[pos],
+				Samples:      1 << uint(8*len(basis)),
+			}
+		} else if verify {
+			res.Confidence[pos] = Confidence{
+				NullSpaceDim: len(basis),
+				Candidates:   countPermutations(basis, confidenceSamples),
+				Samples:      confidenceSamples,
+			}
 		}
 	}
 
-	if !ims.SufficientlyDefined() {
-		panic("Cube attack failed to find enough linear relations in the S-boxes.")
+	if verify {
+		agree := [16]int{}
+
+		for i := 0; i < verificationBatches; i++ {
+			for pos, row := range batchRows(orc, generator, nil) {
+				if res.Recovered[pos] && row.DotProduct(vs[pos]) == 0 {
+					agree[pos]++
+				}
+			}
+		}
+
+		for pos := range res.Confidence {
+			res.Confidence[pos].Agreement = float64(agree[pos]) / verificationBatches
+			o.logger.Debug("recovered S-box", "position", pos, "confidence", res.Confidence[pos])
+		}
 	}
 
-	for pos, m := range ims.Matrices() {
-		last[pos] = newSBox(findPermutation(m.NullSpace()), true)
+	res.Rest = encoding.ComposedBlocks{cipher, encoding.InverseBlock{res.Last}}
+
+	var err error
+	if len(failed.Positions) > 0 {
+		o.logger.Error("failed to recover S-boxes", "positions", failed.Positions, "ranks", ims.Ranks())
+		failed.Diagnosis = diagnoseFailure(orc, ims, growth, failed)
+		o.logger.Info("diagnosed failure", "hypotheses", failed.Diagnosis.Hypotheses, "inconsistent", failed.Diagnosis.Inconsistent)
+		err = failed
 	}
+	o.emit(AttackFinished{res.Recovered, err})
 
-	return last, encoding.ComposedBlocks{cipher, encoding.InverseBlock{last}}
+	return res, err
 }
@@ -0,0 +1,135 @@
+package spn
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"math"
+
+	"github.com/OpenWhiteBox/primitives/gfmatrix"
+	"github.com/OpenWhiteBox/primitives/number"
+)
+
+// defaultSearchSteps and defaultTemperature are what HillClimbing and SimulatedAnnealing use if they aren't given
+// positive parameters.
+const (
+	defaultSearchSteps = 256
+	defaultTemperature = 2.0
+)
+
+// PermutationSearch is a strategy for finding a permutation vector among the linear combinations of a nullspace's
+// basis, which is how attacks on S-box layers turn a sufficiently defined system into an S-box. See WithSearch.
+type PermutationSearch interface {
+	// Attempt makes one attempt at finding a permutation vector, and returns how many linear combinations it evaluated,
+	// which count against the budget of 4096 combinations the attack spends on each position.
+	Attempt(basis []gfmatrix.Row) (v gfmatrix.Row, ok bool, evaluated int)
+}
+
+// RandomSampling tries one random linear combination per attempt, and is the default. It's the fastest strategy when
+// the nullspace is what the attack expects, since about 29% of its combinations are permutation vectors.
+type RandomSampling struct{}
+
+func (RandomSampling) Attempt(basis []gfmatrix.Row) (gfmatrix.Row, bool, int) {
+	v, ok := randomPermutationVector(basis)
+	return v, ok, 1
+}
+
+// HillClimbing starts from a random linear combination and changes one coefficient at a time, for up to Steps steps,
+// keeping each change that doesn't increase the number of duplicate values in the first 256 entries. Each step costs a
+// full combination where RandomSampling usually gives up after a few dozen entries, and on the nullspaces the attacks
+// end up with, whether a combination is a permutation vector depends on all of its coefficients at once, so it's slower
+// than sampling there. It's meant for searching nullspaces where the number of duplicates is a better guide.
+type HillClimbing struct {
+	Steps int
+}
+
+func (hc HillClimbing) Attempt(basis []gfmatrix.Row) (gfmatrix.Row, bool, int) {
+	return climb(basis, steps(hc.Steps), func(int, int) bool { return false })
+}
+
+// SimulatedAnnealing is HillClimbing, except that it also keeps a change that adds d duplicates with probability
+// exp(-d/T), where T cools linearly from Temperature to zero over the attempt's steps. Accepting worse combinations early
+// on lets it leave local minima that HillClimbing gets stuck in.
+type SimulatedAnnealing struct {
+	Steps       int
+	Temperature float64
+}
+
+func (sa SimulatedAnnealing) Attempt(basis []gfmatrix.Row) (gfmatrix.Row, bool, int) {
+	n, temperature := steps(sa.Steps), sa.Temperature
+	if temperature <= 0 {
+		temperature = defaultTemperature
+	}
+
+	return climb(basis, n, func(step, worse int) bool {
+		t := temperature * float64(n-step) / float64(n)
+		return randomFloat() < math.Exp(-float64(worse)/t)
+	})
+}
+
+// steps returns n, or the default number of steps if n isn't positive.
+func steps(n int) int {
+	if n <= 0 {
+		return defaultSearchSteps
+	}
+
+	return n
+}
+
+// randomFloat returns a uniformly random number in [0, 1).
+func randomFloat() float64 {
+	buf := [8]byte{}
+	rand.Read(buf[:])
+
+	return float64(binary.LittleEndian.Uint64(buf[:])>>11) / (1 << 53)
+}
+
+// duplicates returns the number of the first 256 entries of v that repeat an earlier one. A permutation vector has none.
+func duplicates(v gfmatrix.Row) (dups int) {
+	seen := [256]bool{}
+	for _, x := range v[0:256] {
+		if seen[x] {
+			dups++
+		}
+		seen[x] = true
+	}
+
+	return
+}
+
+// climb searches for a permutation vector by local search over the coefficients of a linear combination of basis,
+// minimizing its number of duplicates. A change that adds duplicates is kept if accept returns true for the step it's
+// made at and how many it adds.
+func climb(basis []gfmatrix.Row, n int, accept func(step, worse int) bool) (gfmatrix.Row, bool, int) {
+	coeffs := make([]byte, len(basis))
+	rand.Read(coeffs)
+
+	v := gfmatrix.NewRow(basis[0].Size())
+	for j, c_j := range coeffs {
+		v = v.Add(basis[j].ScalarMul(number.ByteFieldElem(c_j)))
+	}
+
+	dups, evaluated := duplicates(v), 1
+	for step := 0; step < n && dups > 0; step++ {
+		change := [2]byte{}
+		rand.Read(change[:])
+		j, c := int(change[0])%len(basis), change[1]
+		if c == coeffs[j] {
+			continue
+		}
+
+		// Over GF(2^8), replacing coefficient c_j with c adds (c_j + c) times the j^th basis vector.
+		cand := v.Add(basis[j].ScalarMul(number.ByteFieldElem(coeffs[j] ^ c)))
+		evaluated++
+		if candDups := duplicates(cand); candDups <= dups || accept(step, candDups-dups) {
+			v, dups, coeffs[j] = cand, candDups, c
+		}
+	}
+
+	return v, dups == 0, evaluated
+}
+
+// WithSearch sets the strategy attacks on S-box layers use to find permutation vectors in their nullspaces. It defaults
+// to RandomSampling.
+func WithSearch(s PermutationSearch) Option {
+	return func(o *options) { o.search = s }
+}
@@ -0,0 +1,263 @@
+package spn
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/gfmatrix"
+	"github.com/OpenWhiteBox/primitives/number"
+)
+
+// Query is one query made to the oracle. Decrypted is true if the oracle was asked to decrypt Output into Input, rather
+// than to encrypt Input into Output.
+type Query struct {
+	Input, Output [16]byte
+	Decrypted     bool
+}
+
+// Transcript records every query made to the oracle by the attacks it's given to with WithTranscript. It's safe for
+// concurrent use.
+type Transcript struct {
+	mu      sync.Mutex
+	queries []Query
+}
+
+func (t *Transcript) record(q Query) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.queries = append(t.queries, q)
+}
+
+// Queries returns every query recorded so far, in the order they were made.
+func (t *Transcript) Queries() []Query {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]Query{}, t.queries...)
+}
+
+// WithTranscript records every query the attack makes to the oracle in t.
+func WithTranscript(t *Transcript) Option {
+	return func(o *options) { o.transcript = t }
+}
+
+// WithSystems makes attacks on S-box layers add the relations they find to ims, which must have 16 positions of size
+// 256, instead of starting from empty systems. The attack picks up where the one that built ims left off, and ims holds
+// every relation found when it returns.
+func WithSystems(ims IncrementalMatrices) Option {
+	if len(ims) != 16 {
+		panic("Systems must have one incremental matrix for each of 16 positions!")
+	}
+
+	return func(o *options) { o.systems = ims }
+}
+
+// ErrUnsavableSession is returned by Save when the session's configuration includes something that can't be written
+// out: generators given to WithEscalation, or a PermutationSearch that isn't one of this package's.
+var ErrUnsavableSession = errors.New("session's configuration can't be saved")
+
+// Session is everything needed to continue, fork, or audit an attack on an S-box layer: the queries it made, the seed
+// of any randomness the caller drew the target or its plaintexts from, the systems of relations it accumulated for each
+// position, and its configuration.
+type Session struct {
+	Seed       []byte
+	Transcript *Transcript
+	Systems    IncrementalMatrices
+
+	nullSpaceDim, exhaustive, memoryBudget, votes int
+	positions                                     []int
+	reference                                     encoding.Byte
+	search                                        PermutationSearch
+	escalation                                    escalation
+}
+
+// NewSession returns a session for an attack that's configured with opts. The attack should be given the session's
+// Options, so that its queries and relations are kept in the session.
+func NewSession(seed []byte, opts ...Option) *Session {
+	o := newOptions(opts)
+
+	return &Session{
+		Seed:       seed,
+		Transcript: &Transcript{},
+		Systems:    NewIncrementalMatrices(16, 256),
+
+		nullSpaceDim: o.nullSpaceDim,
+		exhaustive:   o.exhaustive,
+		memoryBudget: o.memoryBudget,
+		votes:        o.votes,
+		positions:    o.positions,
+		reference:    o.reference,
+		search:       o.search,
+		escalation:   o.escalation,
+	}
+}
+
+// Options returns the options that configure an attack to continue the session: the configuration it was created with,
+// along with WithTranscript and WithSystems so that the attack adds to the session.
+func (s *Session) Options() []Option {
+	return []Option{
+		func(o *options) {
+			o.nullSpaceDim, o.exhaustive, o.memoryBudget = s.nullSpaceDim, s.exhaustive, s.memoryBudget
+			o.votes, o.positions, o.reference, o.search, o.escalation = s.votes, s.positions, s.reference, s.search, s.escalation
+		},
+		WithTranscript(s.Transcript),
+		WithSystems(s.Systems),
+	}
+}
+
+// Fork returns a copy of the session that can be continued independently.
+func (s *Session) Fork() *Session {
+	out := *s
+	out.Seed = append([]byte{}, s.Seed...)
+	out.Transcript = &Transcript{queries: s.Transcript.Queries()}
+	out.Systems = s.Systems.Dup()
+
+	return &out
+}
+
+// sessionFile is the serialized form of a Session.
+type sessionFile struct {
+	Seed       []byte
+	Transcript []Query
+	Systems    [][][]byte
+
+	NullSpaceDim, Exhaustive, MemoryBudget, Votes int
+	Positions                                     []int
+	Reference                                     []byte // The reference S-box's table, or nil if there isn't one.
+	Search                                        searchFile
+}
+
+// searchFile is the serialized form of one of this package's PermutationSearches.
+type searchFile struct {
+	Strategy    string
+	Steps       int
+	Temperature float64
+}
+
+// Save writes the session to w. It shouldn't be called while an attack is adding to the session. It returns
+// ErrUnsavableSession, and writes nothing, if the session's configuration can't be saved.
+func (s *Session) Save(w io.Writer) error {
+	f := sessionFile{
+		Seed:         s.Seed,
+		Transcript:   s.Transcript.Queries(),
+		NullSpaceDim: s.nullSpaceDim,
+		Exhaustive:   s.exhaustive,
+		MemoryBudget: s.memoryBudget,
+		Votes:        s.votes,
+		Positions:    s.positions,
+	}
+
+	if len(s.escalation.generators) > 0 {
+		return fmt.Errorf("%w: it escalates to other generators", ErrUnsavableSession)
+	}
+
+	switch search := s.search.(type) {
+	case RandomSampling:
+		f.Search = searchFile{Strategy: "random"}
+	case HillClimbing:
+		f.Search = searchFile{Strategy: "hill climbing", Steps: search.Steps}
+	case SimulatedAnnealing:
+		f.Search = searchFile{Strategy: "simulated annealing", Steps: search.Steps, Temperature: search.Temperature}
+	default:
+		return fmt.Errorf("%w: it searches nullspaces with a %T", ErrUnsavableSession, s.search)
+	}
+
+	if s.reference != nil {
+		f.Reference = make([]byte, 256)
+		for x := range f.Reference {
+			f.Reference[x] = s.reference.Encode(byte(x))
+		}
+	}
+
+	for _, m := range s.Systems.Matrices() {
+		rows := make([][]byte, len(m))
+		for i, row := range m {
+			rows[i] = make([]byte, len(row))
+			for j, x := range row {
+				rows[i][j] = byte(x)
+			}
+		}
+
+		f.Systems = append(f.Systems, rows)
+	}
+
+	return gob.NewEncoder(w).Encode(f)
+}
+
+// LoadSession reads a session written by Save from r.
+func LoadSession(r io.Reader) (*Session, error) {
+	f := sessionFile{}
+	if err := gob.NewDecoder(r).Decode(&f); err != nil {
+		return nil, err
+	}
+
+	s := &Session{
+		Seed:         f.Seed,
+		Transcript:   &Transcript{queries: f.Transcript},
+		Systems:      NewIncrementalMatrices(16, 256),
+		nullSpaceDim: f.NullSpaceDim,
+		exhaustive:   f.Exhaustive,
+		memoryBudget: f.MemoryBudget,
+		votes:        f.Votes,
+		positions:    f.Positions,
+	}
+
+	if err := checkPositions(f.Positions); err != nil {
+		return nil, fmt.Errorf("session targets an invalid position: %w", err)
+	} else if err := checkExhaustive(f.Exhaustive); err != nil {
+		return nil, fmt.Errorf("session has an invalid configuration: %w", err)
+	}
+
+	switch f.Search.Strategy {
+	case "", "random": // Sessions saved before the search was saved used the default.
+		s.search = RandomSampling{}
+	case "hill climbing":
+		s.search = HillClimbing{Steps: f.Search.Steps}
+	case "simulated annealing":
+		s.search = SimulatedAnnealing{Steps: f.Search.Steps, Temperature: f.Search.Temperature}
+	default:
+		return nil, fmt.Errorf("session has an unknown search strategy %q", f.Search.Strategy)
+	}
+
+	if f.Reference != nil {
+		if len(f.Reference) != 256 {
+			return nil, errors.New("session's reference S-box doesn't have 256 entries")
+		}
+
+		reference, seen := encoding.SBox{}, [256]bool{}
+		for x, y := range f.Reference {
+			if seen[y] {
+				return nil, errors.New("session's reference S-box isn't a permutation")
+			}
+			seen[y] = true
+			reference.EncKey[x], reference.DecKey[y] = y, byte(x)
+		}
+		s.reference = reference
+	}
+
+	if len(f.Systems) > len(s.Systems) {
+		return nil, errors.New("session has systems for more than 16 positions")
+	}
+
+	for pos, rows := range f.Systems {
+		for _, raw := range rows {
+			if len(raw) > 256 {
+				return nil, errors.New("session has a relation longer than 256 entries")
+			}
+
+			row := gfmatrix.NewRow(256)
+			for j, x := range raw {
+				row[j] = number.ByteFieldElem(x)
+			}
+
+			s.Systems[pos].Add(row)
+		}
+	}
+
+	return s, nil
+}
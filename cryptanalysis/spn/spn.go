@@ -48,40 +48,133 @@ func (e Encoding) Decode(in [16]byte) (out [16]byte) {
 	panic("cryptanalysis/spn.Encoding.Decode should never be called!")
 }
 
+// CipherBlock implements crypto/cipher.Block over an encoding.Block, so that recovered decompositions, what's left of a
+// cipher after an attack, or a Decryptor can be used with Go's block cipher modes. An imported white-box implementation
+// that only encrypts can be wrapped as CipherBlock{Encoding{wb}}, though its Decrypt then panics.
+type CipherBlock struct{ encoding.Block }
+
+// BlockSize returns the block size of the cipher.
+func (cb CipherBlock) BlockSize() int { return 16 }
+
+// Encrypt encrypts the first block in src into dst. Dst and src may point at the same memory.
+func (cb CipherBlock) Encrypt(dst, src []byte) {
+	if len(src) < 16 || len(dst) < 16 {
+		panic("cryptanalysis/spn.CipherBlock: input not full block")
+	}
+
+	in := [16]byte{}
+	copy(in[:], src)
+
+	out := cb.Block.Encode(in)
+	copy(dst, out[:])
+}
+
+// Decrypt decrypts the first block in src into dst. Dst and src may point at the same memory.
+func (cb CipherBlock) Decrypt(dst, src []byte) {
+	if len(src) < 16 || len(dst) < 16 {
+		panic("cryptanalysis/spn.CipherBlock: input not full block")
+	}
+
+	in := [16]byte{}
+	copy(in[:], src)
+
+	out := cb.Block.Decode(in)
+	copy(dst, out[:])
+}
+
 // DecomposeSPN takes a Construction with a specified structure as input and outputs a functionally identical
-// constructions/spn.Construction, with which you can Encrypt, Decrypt, inspect internal constants, etc.
-func DecomposeSPN(constr Construction, structure spn.Structure) (out spn.Construction) {
+// constructions/spn.Construction, with which you can Encrypt, Decrypt, inspect internal constants, etc. It returns an
+// error if any layer can't be recovered, which usually means the Construction doesn't have the given structure.
+func DecomposeSPN(constr Construction, structure spn.Structure, opts ...Option) (out spn.Construction, err error) {
 	cipher := Encoding{constr}
-	return decomposeSPN(cipher, structure)
+	return decomposeSPN(cipher, structure, opts)
 }
 
-func decomposeSPN(cipher encoding.Block, structure spn.Structure) (out spn.Construction) {
+func decomposeSPN(cipher encoding.Block, structure spn.Structure, opts []Option) (out spn.Construction, err error) {
+	// Each case removes the trailing layer and recurses on what's left.
+	var (
+		last      encoding.Block
+		rest      encoding.Block
+		remaining spn.Structure
+	)
+
 	switch structure {
 	case spn.AS:
-		last, rest := RecoverAffine(cipher, trivialSubspaces)
-		first := encoding.DecomposeConcatenatedBlock(rest)
-		return spn.Construction(encoding.ComposedBlocks{first, last})
+		if last, rest, err = RecoverAffine(cipher, trivialSubspaces, opts...); err != nil {
+			return nil, err
+		}
+
+		o := newOptions(opts)
+		o.ledger.begin("leading S-box layer")
+
+		first := encoding.DecomposeConcatenatedBlock(newOracle(rest, o))
+		return spn.Construction(encoding.ComposedBlocks{first, last}), nil
 	case spn.SA:
-		last, rest := RecoverSBoxes(cipher, BalancedPlaintexts(4))
-		first, _ := encoding.DecomposeBlockAffine(rest)
-		return spn.Construction(encoding.ComposedBlocks{first, last})
+		if last, rest, err = recoverSBoxLayer(cipher, BalancedPlaintexts(4), opts); err != nil {
+			return nil, err
+		}
+
+		o := newOptions(opts)
+		o.ledger.begin("leading affine layer")
+
+		first, ok := encoding.DecomposeBlockAffine(newOracle(rest, o))
+		if !ok {
+			return nil, ErrSingularLayer
+		}
+		return spn.Construction(encoding.ComposedBlocks{first, last}), nil
 	case spn.ASA:
-		last, rest := RecoverAffine(cipher, lowRankDetectionWith(nextByAddition))
-		return append(decomposeSPN(rest, spn.SA), last)
+		asa, err := DecomposeASA(cipher, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		return asa.Construction(), nil
+	default:
+		last, rest, remaining, err = peelLayer(cipher, structure, opts)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	out, err = decomposeSPN(rest, remaining, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(out, last), nil
+}
+
+// peelLayer removes the trailing layer of a cipher whose structure has at least three layers, other than ASA, and
+// returns it along with the rest of the cipher and the rest's structure.
+func peelLayer(cipher encoding.Block, structure spn.Structure, opts []Option) (last, rest encoding.Block, remaining spn.Structure, err error) {
+	switch structure {
 	case spn.SAS:
-		last, rest := RecoverSBoxes(cipher, DualPlaintexts(4))
-		return append(decomposeSPN(rest, spn.AS), last)
+		last, rest, err = recoverSBoxLayer(cipher, DualPlaintexts(4), opts)
+		remaining = spn.AS
 	case spn.ASAS:
-		last, rest := RecoverAffine(cipher, lowRankDetectionWith(nextByToggle))
-		return append(decomposeSPN(rest, spn.SAS), last)
+		last, rest, err = RecoverAffine(cipher, lowRankDetectionWith(nextByToggle), opts...)
+		remaining = spn.SAS
 	case spn.SASA:
-		last, rest := RecoverSBoxes(cipher, PermutationPlaintexts(256))
-		return append(decomposeSPN(rest, spn.ASA), last)
+		last, rest, err = recoverSBoxLayer(cipher, PermutationPlaintexts(256), opts)
+		remaining = spn.ASA
 	// case spn.ASASA:
 	case spn.SASAS:
-		last, rest := RecoverSBoxes(cipher, PermutationPlaintexts(256))
-		return append(decomposeSPN(rest, spn.ASAS), last)
+		last, rest, err = recoverSBoxLayer(cipher, PermutationPlaintexts(256), opts)
+		remaining = spn.ASAS
 	default:
 		panic("Unknown SPN structure!")
 	}
+
+	return
+}
+
+// recoverSBoxLayer is RecoverSBoxes, but returns an error instead of panicking.
+func recoverSBoxLayer(cipher encoding.Block, generator func() [][16]byte, opts []Option) (last, rest encoding.Block, err error) {
+	res, err := recoverSBoxes(cipher, generator, newOptions(opts), false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return res.Last, res.Rest, nil
 }
@@ -1,19 +1,45 @@
 package spn
 
 import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"crypto/rand"
 
 	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/gfmatrix"
+	"github.com/OpenWhiteBox/primitives/matrix"
+	"github.com/OpenWhiteBox/primitives/number"
 
 	"github.com/OpenWhiteBox/Generic/constructions/spn"
 )
 
 func ExampleDecomposeSPN() {
 	constr1 := spn.NewSPN(rand.Reader, spn.SAS)
-	constr2 := DecomposeSPN(constr1, spn.SAS)
+	constr2, err := DecomposeSPN(constr1, spn.SAS)
+	if err != nil {
+		panic(err)
+	}
 
 	ok := encoding.ProbablyEquivalentBlocks(
 		Encoding{constr1},
@@ -27,7 +53,10 @@ func ExampleDecomposeSPN() {
 
 func TestDecomposeAS(t *testing.T) {
 	constr1 := spn.NewSPN(rand.Reader, spn.AS)
-	constr2 := DecomposeSPN(constr1, spn.AS)
+	constr2, err := DecomposeSPN(constr1, spn.AS)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	ok := encoding.ProbablyEquivalentBlocks(
 		Encoding{constr1},
@@ -41,7 +70,10 @@ func TestDecomposeAS(t *testing.T) {
 
 func TestDecomposeSA(t *testing.T) {
 	constr1 := spn.NewSPN(rand.Reader, spn.SA)
-	constr2 := DecomposeSPN(constr1, spn.SA)
+	constr2, err := DecomposeSPN(constr1, spn.SA)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	ok := encoding.ProbablyEquivalentBlocks(
 		Encoding{constr1},
@@ -55,7 +87,10 @@ func TestDecomposeSA(t *testing.T) {
 
 func TestDecomposeASA(t *testing.T) {
 	constr1 := spn.NewSPN(rand.Reader, spn.ASA)
-	constr2 := DecomposeSPN(constr1, spn.ASA)
+	constr2, err := DecomposeSPN(constr1, spn.ASA)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	ok := encoding.ProbablyEquivalentBlocks(
 		Encoding{constr1},
@@ -67,9 +102,393 @@ func TestDecomposeASA(t *testing.T) {
 	}
 }
 
+func TestDecomposeASALayers(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.ASA)
+
+	asa, err := DecomposeASA(Encoding{constr})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cipher := encoding.ComposedBlocks{asa.Inner, asa.SBoxes, asa.Outer}
+	if !encoding.ProbablyEquivalentBlocks(cipher, Encoding{constr}) {
+		t.Fatal("Recovered layers aren't equivalent to the cipher!")
+	}
+}
+
+func TestClassifyAffine(t *testing.T) {
+	diagonal, column := matrix.GenerateIdentity(128), matrix.GenerateIdentity(128)
+	for pos := 0; pos < 16; pos++ {
+		sub := matrix.GenerateRandom(rand.Reader, 8)
+		for i := 0; i < 8; i++ {
+			diagonal[8*pos+i][pos] = sub[i][0]
+		}
+	}
+	for col := 0; col < 4; col++ {
+		sub := matrix.GenerateRandom(rand.Reader, 32)
+		for i := 0; i < 32; i++ {
+			copy(column[32*col+i][4*col:], sub[i])
+		}
+	}
+
+	c := [16]byte{}
+	rand.Read(c[:])
+
+	cases := []struct {
+		aff       encoding.BlockAffine
+		structure AffineStructure
+	}{
+		{encoding.NewBlockAffine(diagonal, c), DiagonalAffine},
+		{encoding.NewBlockAffine(column, c), ColumnAffine},
+		{encoding.NewBlockAffine(matrix.GenerateRandom(rand.Reader, 128), c), DenseAffine},
+	}
+
+	for _, cs := range cases {
+		structure, repr := ClassifyAffine(cs.aff)
+		if structure != cs.structure {
+			t.Fatalf("Classified %v layer as %v.", cs.structure, structure)
+		} else if !encoding.ProbablyEquivalentBlocks(repr, cs.aff) {
+			t.Fatalf("Representation of %v layer isn't equivalent to it.", structure)
+		}
+
+		x := [16]byte{}
+		rand.Read(x[:])
+		if repr.Decode(repr.Encode(x)) != x {
+			t.Fatalf("Representation of %v layer doesn't invert.", structure)
+		}
+	}
+}
+
+func TestAttack(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.AS)
+
+	attacks := []Attack{
+		DecomposeAttack{Structure: spn.AS},
+		AffineAttack{Generator: TrivialSubspaceGenerator},
+		TrailingLayerAttack{},
+	}
+
+	for _, attack := range attacks {
+		if attack.Name() == "" || attack.EstimatedQueries() <= 0 {
+			t.Fatalf("Attack %q has no estimate.", attack.Name())
+		}
+
+		queries := new(int64)
+		res, err := attack.Run(context.Background(), countQueries{Encoding{constr}, queries})
+		if err != nil {
+			t.Fatalf("%v: %v", attack.Name(), err)
+		}
+
+		total := 0
+		for _, phase := range res.Phases {
+			if phase.Name == "" || phase.Data.Adaptive != 0 || phase.Data.Decryptions != 0 {
+				t.Fatalf("%v: implausible phase %+v", attack.Name(), phase)
+			}
+			total += phase.Data.Total()
+		}
+		if int64(total) != *queries {
+			t.Fatalf("%v: phases account for %v queries, not %v.", attack.Name(), total, *queries)
+		}
+
+		cipher := encoding.ComposedBlocks{res.Rest, encoding.ComposedBlocks(res.Layers)}
+		if !encoding.ProbablyEquivalentBlocks(cipher, Encoding{constr}) {
+			t.Fatalf("%v: recovered layers and what's left aren't equivalent to the cipher!", attack.Name())
+		}
+	}
+
+	// An attack stops as soon as its context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &countingMetrics{}
+	cipher := encoding.ComposedBlocks{Encoding{spn.NewSPN(rand.Reader, spn.SA)}, cancelAfter{cancel, new(int64)}}
+
+	if _, err := (SBoxAttack{Generator: BalancedGenerator, Options: []Option{WithMetrics(m)}}).Run(ctx, cipher); err != context.Canceled {
+		t.Fatalf("Expected the attack to be canceled, got: %v", err)
+	} else if m.queries > 101 {
+		t.Fatalf("Attack made %v queries after being canceled.", m.queries-100)
+	}
+
+	// What's left of the cipher keeps working once the attack's context is done.
+	constr = spn.NewSPN(rand.Reader, spn.SA)
+	ctx, cancel = context.WithCancel(context.Background())
+	res, err := (SBoxAttack{Generator: BalancedGenerator}).Run(ctx, Encoding{constr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if !encoding.ProbablyEquivalentBlocks(encoding.ComposedBlocks{res.Rest, encoding.ComposedBlocks(res.Layers)}, Encoding{constr}) {
+		t.Fatal("What's left after the context is done isn't the rest of the cipher!")
+	}
+
+	// Queries made from a worker goroutine stop the attack too.
+	ctx, cancel = context.WithCancel(context.Background())
+	cipher = encoding.ComposedBlocks{encoding.ComposedBlocks(spn.NewSPN(rand.Reader, spn.SASA)), cancelAfter{cancel, new(int64)}}
+	_, err = runWithContext(ctx, cipher, []Option{WithWorkers(2)}, func(cipher encoding.Block, opts []Option) (Result, error) {
+		constr, err := DecomposeSPNConcurrently(cipher, spn.SASA, opts...)
+		return Result{Layers: constr, Rest: encoding.IdentityBlock{}}, err
+	})
+	if err != context.Canceled {
+		t.Fatalf("Expected the concurrent decomposition to be canceled, got: %v", err)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	names := map[string]bool{}
+	for _, r := range Applicable(spn.SAS, ChosenPlaintext) {
+		names[r.Name] = true
+
+		if attack := r.New(); attack.Name() != r.Name {
+			t.Fatalf("Registration %q creates attack %q.", r.Name, attack.Name())
+		}
+	}
+
+	for _, name := range []string{"SAS decomposition", "S-box layer recovery (dual)", "trailing layer recovery"} {
+		if !names[name] {
+			t.Fatalf("Attack %q isn't applicable to SAS.", name)
+		}
+	}
+	if names["ASA decomposition"] {
+		t.Fatal("ASA decomposition is applicable to SAS!")
+	}
+
+	if rs := Applicable(UnknownStructure, ChosenPlaintext); len(rs) != 1 || rs[0].Name != "trailing layer recovery" {
+		t.Fatalf("Expected only trailing layer recovery against an unknown structure, got %v attacks.", len(rs))
+	}
+
+	// Without chosen plaintexts, only the leading layer can be attacked.
+	for _, r := range Applicable(spn.SAS, ChosenCiphertext) {
+		if r.Requires&ChosenPlaintext != 0 || r.Name != (LeadingSBoxAttack{Generator: DualGenerator}).Name() && r.Name != (LeadingSBoxAttack{Generator: PermutationGenerator}).Name() {
+			t.Fatalf("Attack %q is applicable to SAS without chosen plaintexts.", r.Name)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Registering an attack twice didn't panic!")
+		}
+	}()
+	Register(Registration{Name: "SAS decomposition"})
+}
+
+// countQueries counts the queries made to a cipher.
+type countQueries struct {
+	encoding.Block
+	queries *int64
+}
+
+func (c countQueries) Encode(in [16]byte) [16]byte {
+	atomic.AddInt64(c.queries, 1)
+	return c.Block.Encode(in)
+}
+
+func (c countQueries) Decode(in [16]byte) [16]byte {
+	atomic.AddInt64(c.queries, 1)
+	return c.Block.Decode(in)
+}
+
+// cancelAfter cancels a context on the 100th query.
+type cancelAfter struct {
+	cancel  context.CancelFunc
+	queries *int64
+}
+
+func (c cancelAfter) Encode(in [16]byte) [16]byte {
+	if atomic.AddInt64(c.queries, 1) == 100 {
+		c.cancel()
+	}
+	return in
+}
+
+func (c cancelAfter) Decode(in [16]byte) [16]byte { return in }
+
+// failingAttack is an attack that fails straight away.
+type failingAttack struct{}
+
+func (failingAttack) Name() string                                             { return "failing" }
+func (failingAttack) EstimatedQueries() int                                    { return 1 }
+func (failingAttack) EstimatedComplexity(blockWidth, sboxWidth int) Complexity { return Complexity{} }
+
+func (failingAttack) Run(ctx context.Context, cipher encoding.Block) (Result, error) {
+	return Result{}, ErrSingularLayer
+}
+
+func TestRace(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+
+	winner, res, err := RaceApplicable(context.Background(), Encoding{constr}, spn.SA, ChosenPlaintext)
+	if err != nil {
+		t.Fatal(err)
+	} else if !VerifyResult(Encoding{constr}, res) {
+		t.Fatalf("%v won with a result that doesn't verify!", winner.Name())
+	}
+
+	winner, _, err = Race(context.Background(), Encoding{constr}, []Attack{failingAttack{}, SBoxAttack{Generator: BalancedGenerator}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if winner.Name() != (SBoxAttack{Generator: BalancedGenerator}).Name() {
+		t.Fatalf("Expected the S-box attack to win, not %v.", winner.Name())
+	}
+
+	if _, _, err := Race(context.Background(), Encoding{constr}, []Attack{failingAttack{}}, nil); !errors.Is(err, ErrSingularLayer) {
+		t.Fatalf("Expected the failing attack's error, got: %v", err)
+	}
+
+	// Cached queries are only made once, in either direction.
+	queries := int64(0)
+	cached := NewCachedOracle(countQueries{Encoding{constr}, &queries})
+	pt := [16]byte{1}
+	if cached.Decode(cached.Encode(pt)) != pt || queries != 1 || cached.Queries() != 1 {
+		t.Fatalf("Expected one query through the cache, got %v.", queries)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+	if err := os.WriteFile(filepath.Join(dir, "target"), constr.Serialize(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{
+		Oracle:  OracleConfig{Type: "construction", Path: filepath.Join(dir, "target"), Structure: "SA"},
+		Attacks: []AttackConfig{{Name: "SA decomposition"}},
+		Layers:  filepath.Join(dir, "layers"),
+		Report:  filepath.Join(dir, "report.json"),
+	}
+	data, _ := json.Marshal(cfg)
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := Run(filepath.Join(dir, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(a.Attacks) != 1 || len(a.Phases) == 0 {
+		t.Fatalf("Implausible assessment: %+v", a)
+	}
+
+	layers, err := os.ReadFile(cfg.Layers)
+	if err != nil {
+		t.Fatal(err)
+	} else if !encoding.ProbablyEquivalentBlocks(Encoding{spn.Parse(layers, spn.SA)}, Encoding{constr}) {
+		t.Fatal("Written layers aren't equivalent to the cipher!")
+	}
+
+	report := Assessment{}
+	if data, err := os.ReadFile(cfg.Report); err != nil {
+		t.Fatal(err)
+	} else if err := json.Unmarshal(data, &report); err != nil || !reflect.DeepEqual(report.Phases, a.Phases) {
+		t.Fatalf("Report doesn't match the assessment: %v", err)
+	}
+
+	cfg.Attacks = []AttackConfig{{Name: "no such attack"}}
+	if _, err := cfg.Run(context.Background()); err == nil {
+		t.Fatal("Running an unknown attack didn't fail!")
+	}
+
+	cfg.Attacks = []AttackConfig{{Name: "SA decomposition", Positions: []int{3, 16}}}
+	if _, err := cfg.Run(context.Background()); err == nil {
+		t.Fatal("Running an attack on position 16 didn't fail!")
+	}
+
+	// 2^64 linear combinations of a nullspace of dimension 8 can't be counted.
+	cfg.Attacks = []AttackConfig{{Name: "SA decomposition", Exhaustive: 8}}
+	if _, err := cfg.Run(context.Background()); err == nil {
+		t.Fatal("Running an exhaustive search of dimension 8 didn't fail!")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("WithExhaustiveSearch accepted dimension 8.")
+			}
+		}()
+		WithExhaustiveSearch(8)
+	}()
+
+	// The trailing S-box layer of SAS is removed, and then the leading one, which goes before what's left.
+	constr = spn.NewSPN(rand.Reader, spn.SAS)
+	if err := os.WriteFile(filepath.Join(dir, "target"), constr.Serialize(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg = Config{
+		Oracle: OracleConfig{Type: "construction", Path: filepath.Join(dir, "target"), Structure: "SAS"},
+		Attacks: []AttackConfig{
+			{Name: SBoxAttack{Generator: DualGenerator}.Name()},
+			{Name: LeadingSBoxAttack{Generator: BalancedGenerator}.Name()},
+		},
+	}
+
+	a, err = cfg.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if len(a.Leading) != 1 || len(a.Layers) != 1 {
+		t.Fatalf("Recovered %v leading and %v trailing layers, not 1 and 1.", len(a.Leading), len(a.Layers))
+	}
+
+	reassembled := encoding.ComposedBlocks{a.Leading[0], a.Rest, a.Layers[0]}
+	if !encoding.ProbablyEquivalentBlocks(reassembled, Encoding{constr}) {
+		t.Fatal("Leading layers, rest, and trailing layers don't reassemble into the cipher!")
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+	queries := int64(0)
+	cipher := countQueries{Encoding{constr}, &queries}
+
+	p := Pipeline{Dir: t.TempDir(), Stages: []Stage{
+		{
+			Name:  "check",
+			Needs: []string{"sboxes", "affine"},
+			Run: func(ctx context.Context, in Artifacts) ([]byte, error) {
+				last, _ := UnmarshalLayer(in["sboxes"])
+				first, _ := UnmarshalLayer(in["affine"])
+				if !encoding.ProbablyEquivalentBlocks(encoding.ComposedBlocks{first, last}, Encoding{constr}) {
+					return nil, errors.New("layers aren't equivalent to the cipher")
+				}
+				return []byte("ok"), nil
+			},
+		},
+		LayerStage("affine", cipher, []string{"sboxes"}, func(ctx context.Context, rest encoding.Block) (encoding.Block, error) {
+			if affine, ok := encoding.DecomposeBlockAffine(rest); ok {
+				return affine, nil
+			}
+			return nil, ErrSingularLayer
+		}),
+		LayerStage("sboxes", cipher, nil, func(ctx context.Context, rest encoding.Block) (encoding.Block, error) {
+			res, err := RecoverSBoxesDetailed(rest, BalancedPlaintexts(4))
+			if err != nil {
+				return nil, err
+			}
+			return res.Last, nil
+		}),
+	}}
+
+	if out, err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if string(out["check"]) != "ok" {
+		t.Fatalf("Unexpected artifact %q.", out["check"])
+	}
+
+	// The second run reads every artifact from the cache.
+	made := queries
+	if _, err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if queries != made {
+		t.Fatalf("Cached pipeline made %v queries.", queries-made)
+	}
+
+	p.Stages = append(p.Stages, Stage{Name: "a", Needs: []string{"b"}}, Stage{Name: "b", Needs: []string{"a"}})
+	if _, err := p.Run(context.Background()); err != ErrPipelineCycle {
+		t.Fatalf("Expected a cycle, got: %v", err)
+	}
+}
+
 func TestDecomposeSAS(t *testing.T) {
 	constr1 := spn.NewSPN(rand.Reader, spn.SAS)
-	constr2 := DecomposeSPN(constr1, spn.SAS)
+	constr2, err := DecomposeSPN(constr1, spn.SAS)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	ok := encoding.ProbablyEquivalentBlocks(
 		Encoding{constr1},
@@ -83,7 +502,10 @@ func TestDecomposeSAS(t *testing.T) {
 
 func TestDecomposeASAS(t *testing.T) {
 	constr1 := spn.NewSPN(rand.Reader, spn.ASAS)
-	constr2 := DecomposeSPN(constr1, spn.ASAS)
+	constr2, err := DecomposeSPN(constr1, spn.ASAS)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	ok := encoding.ProbablyEquivalentBlocks(
 		Encoding{constr1},
@@ -97,7 +519,10 @@ func TestDecomposeASAS(t *testing.T) {
 
 func TestDecomposeSASA(t *testing.T) {
 	constr1 := spn.NewSPN(rand.Reader, spn.SASA)
-	constr2 := DecomposeSPN(constr1, spn.SASA)
+	constr2, err := DecomposeSPN(constr1, spn.SASA)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	ok := encoding.ProbablyEquivalentBlocks(
 		Encoding{constr1},
@@ -115,7 +540,10 @@ func TestDecomposeASASA(t *testing.T) {
 
 func TestDecomposeSASAS(t *testing.T) {
 	constr1 := spn.NewSPN(rand.Reader, spn.SASAS)
-	constr2 := DecomposeSPN(constr1, spn.SASAS)
+	constr2, err := DecomposeSPN(constr1, spn.SASAS)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	ok := encoding.ProbablyEquivalentBlocks(
 		Encoding{constr1},
@@ -126,3 +554,2701 @@ func TestDecomposeSASAS(t *testing.T) {
 		t.Fatal("Incorrectly decomposed SASAS structure!")
 	}
 }
+
+func TestIdentifySBox(t *testing.T) {
+	duals := AESDuals()
+	if len(duals) != 480 {
+		t.Fatalf("Found %v representations of the AES S-box and its inverse, not 480!", len(duals))
+	}
+
+	known := duals[len(duals)/3]
+	aff := encoding.NewByteAffine(matrix.GenerateRandom(rand.Reader, 8), 0x5a)
+	s := encoding.ComposedBytes{aff, known.SBox}
+
+	dual, ok := IdentifySBox(s, duals)
+	if !ok {
+		t.Fatal("Failed to identify affine-equivalent AES S-box!")
+	}
+
+	for x := 0; x < 256; x++ {
+		if dual.Out.Encode(dual.Known.SBox.Encode(dual.In.Encode(byte(x)))) != s.Encode(byte(x)) {
+			t.Fatal("Identified S-box isn't equivalent to the original!")
+		}
+	}
+
+	if _, ok := IdentifySBox(encoding.GenerateSBox(rand.Reader), duals); ok {
+		t.Fatal("Identified a random S-box as AES!")
+	}
+
+	// A random S-box has no affine self-equivalences, so an affine transformation on its output can only be identified as
+	// one.
+	random := []KnownSBox{{Name: "Random", SBox: encoding.GenerateSBox(rand.Reader)}}
+	s = encoding.ComposedBytes{random[0].SBox, aff}
+
+	dual, ok = IdentifySBox(s, random)
+	if !ok {
+		t.Fatal("Failed to identify S-box with an affine transformation on its output!")
+	}
+
+	for x := 0; x < 256; x++ {
+		if dual.In.Encode(byte(x)) != byte(x) {
+			t.Fatal("Affine transformation on output identified as one on input!")
+		}
+	}
+
+	for x := 0; x < 256; x++ {
+		if dual.Out.Encode(dual.Known.SBox.Encode(dual.In.Encode(byte(x)))) != s.Encode(byte(x)) {
+			t.Fatal("Identified S-box isn't equivalent to the original!")
+		}
+	}
+}
+
+func TestSBoxIndex(t *testing.T) {
+	duals := AESDuals()
+	idx := NewSBoxIndex(duals)
+	aff := encoding.NewByteAffine(matrix.GenerateRandom(rand.Reader, 8), 0x5a)
+
+	cases := []encoding.Byte{
+		encoding.ComposedBytes{aff, duals[17].SBox},
+		encoding.ComposedBytes{duals[301].SBox, aff},
+		encoding.GenerateSBox(rand.Reader),
+	}
+
+	for i, s := range cases {
+		want, ok1 := IdentifySBox(s, duals)
+		got, ok2 := idx.Identify(s)
+
+		if ok1 != ok2 {
+			t.Fatalf("Case %v: IdentifySBox says %v, but the index says %v.", i, ok1, ok2)
+		} else if !ok1 {
+			continue
+		} else if got.Known.Name != want.Known.Name || got.Known.Polynomial != want.Known.Polynomial {
+			t.Fatalf("Case %v: index identified a different known S-box.", i)
+		}
+
+		for x := 0; x < 256; x++ {
+			if got.In.Encode(byte(x)) != want.In.Encode(byte(x)) || got.Out.Encode(byte(x)) != want.Out.Encode(byte(x)) {
+				t.Fatalf("Case %v: index found different affine transformations.", i)
+			}
+		}
+	}
+}
+
+func TestCollapse(t *testing.T) {
+	known := []KnownSBox{{Name: "Random", SBox: encoding.GenerateSBox(rand.Reader)}}
+	constr := spn.NewSPN(rand.Reader, spn.ASASA)
+
+	// Mask the inputs of the first S-box layer and the outputs of the second.
+	first, second := encoding.ConcatenatedBlock{}, encoding.ConcatenatedBlock{}
+	for pos := 0; pos < 16; pos++ {
+		in := encoding.NewByteAffine(matrix.GenerateRandom(rand.Reader, 8), byte(pos))
+		out := encoding.NewByteAffine(matrix.GenerateRandom(rand.Reader, 8), byte(pos))
+
+		first[pos] = encoding.ComposedBytes{in, known[0].SBox}
+		second[pos] = encoding.ComposedBytes{known[0].SBox, out}
+	}
+	constr[1], constr[3] = first, second
+
+	collapsed, duals := Collapse(constr, known)
+
+	if !encoding.ProbablyEquivalentBlocks(Encoding{collapsed}, Encoding{constr}) {
+		t.Fatal("Collapsed construction isn't equivalent to the original!")
+	} else if len(duals) != 2 || duals[0] == nil || duals[1] == nil {
+		t.Fatal("Failed to collapse both S-box layers!")
+	}
+
+	// The masks should be merged into the existing affine layers, rather than adding new ones.
+	if len(collapsed) != len(constr) {
+		t.Fatalf("Collapsed construction has %v layers, not %v!", len(collapsed), len(constr))
+	}
+
+	for i, layer := range collapsed {
+		switch layer := layer.(type) {
+		case encoding.BlockAffine:
+			if i%2 != 0 {
+				t.Fatalf("Layer %v is an affine layer!", i)
+			}
+
+		case encoding.ConcatenatedBlock:
+			if i%2 != 1 {
+				t.Fatalf("Layer %v is an S-box layer!", i)
+			}
+
+			for pos := 0; pos < 16; pos++ {
+				if layer[pos] != encoding.Byte(known[0].SBox) {
+					t.Fatalf("S-box %v of layer %v isn't the known S-box!", pos, i)
+				}
+			}
+
+		default:
+			t.Fatalf("Layer %v is neither an S-box layer nor an affine layer!", i)
+		}
+	}
+}
+
+func TestRecoverEncodedSBoxes(t *testing.T) {
+	known := []KnownSBox{{Name: "AES", SBox: aesSBox()}}
+
+	// The first half of the positions have an affine encoding after the S-box, and the second half a random one.
+	layer := encoding.ConcatenatedBlock{}
+	for pos := range layer {
+		if pos < 8 {
+			layer[pos] = encoding.ComposedBytes{known[0].SBox, encoding.NewByteAffine(matrix.GenerateRandom(rand.Reader, 8), byte(pos))}
+		} else {
+			layer[pos] = encoding.ComposedBytes{known[0].SBox, encoding.GenerateSBox(rand.Reader)}
+		}
+	}
+	constr := spn.Construction{encoding.NewBlockAffine(matrix.GenerateRandom(rand.Reader, 128), [16]byte{}), layer}
+
+	res, encoded, err := RecoverEncodedSBoxes(Encoding{constr}, BalancedPlaintexts(4), known)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, ok := encoding.DecomposeBlockAffine(res.Rest); !ok {
+		t.Fatal("Removing the compositions didn't leave an affine layer!")
+	}
+
+	for pos, e := range encoded {
+		if e.AffineEncoding() != (pos < 8) {
+			t.Fatalf("Position %v: affine encoding is %v.", pos, e.AffineEncoding())
+		}
+	}
+
+	// Without the attack's ambiguity on the S-box's input, an affine encoding is factored out exactly.
+	aff := encoding.NewByteAffine(matrix.GenerateRandom(rand.Reader, 8), 0x5a)
+	for pos := range layer {
+		layer[pos] = encoding.ComposedBytes{known[0].SBox, aff}
+	}
+
+	e := FactorOutputEncodings(layer, known)[0]
+	if !e.Factored {
+		t.Fatal("Failed to factor an affine encoding!")
+	}
+
+	for x := 0; x < 256; x++ {
+		if e.Dual.Out.Encode(byte(x)) != aff.Encode(byte(x)) {
+			t.Fatal("Factored encoding isn't the one after the S-box!")
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	s := encoding.GenerateSBox(rand.Reader)
+	aff := encoding.NewByteAffine(matrix.GenerateRandom(rand.Reader, 8), 0x5a)
+
+	norm, out := NormalizeOutput(s)
+	norm2, _ := NormalizeOutput(encoding.ComposedBytes{s, aff})
+	if norm != norm2 || norm.EncKey[0] != 0 {
+		t.Fatal("S-boxes differing by an output transformation have different representatives!")
+	}
+
+	for x := 0; x < 256; x++ {
+		if out.Encode(norm.Encode(byte(x))) != s.Encode(byte(x)) {
+			t.Fatal("Normalized S-box and its output transformation aren't equivalent to the S-box!")
+		}
+	}
+
+	norm, in := NormalizeInput(s)
+	norm2, _ = NormalizeInput(encoding.ComposedBytes{aff, s})
+	if norm != norm2 {
+		t.Fatal("S-boxes differing by an input transformation have different representatives!")
+	}
+
+	for x := 0; x < 256; x++ {
+		if norm.Encode(in.Encode(byte(x))) != s.Encode(byte(x)) {
+			t.Fatal("Normalized S-box and its input transformation aren't equivalent to the S-box!")
+		}
+	}
+
+	// The trailing S-boxes of a decomposition are the same as the original's, once both are normalized.
+	constr1 := spn.NewSPN(rand.Reader, spn.SA)
+	constr2, err := DecomposeSPN(constr1, spn.SA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	norm1, norm3 := NormalizeSPN(spn.Construction(constr1)), NormalizeSPN(constr2)
+	if !reflect.DeepEqual(norm1[1], norm3[1]) {
+		t.Fatal("Normalized trailing S-boxes don't match!")
+	} else if !encoding.ProbablyEquivalentBlocks(Encoding{norm3}, Encoding{constr1}) {
+		t.Fatal("Normalized decomposition isn't equivalent to the original!")
+	}
+}
+
+func TestRecoverRoundConstants(t *testing.T) {
+	base := spn.NewSPN(rand.Reader, spn.ASA)
+	constrs := []spn.Construction{}
+
+	for key := 0; key < 8; key++ {
+		constr := spn.Construction{}
+		for _, layer := range base {
+			if aff, ok := layer.(encoding.BlockAffine); ok {
+				// Only the first four bytes are keyed.
+				c := aff.BlockAdditive
+				rand.Read(c[0:4])
+				layer = encoding.BlockAffine{BlockLinear: aff.BlockLinear, BlockAdditive: c}
+			}
+
+			constr = append(constr, layer)
+		}
+
+		constrs = append(constrs, constr)
+	}
+
+	rcs := RecoverRoundConstants(constrs)
+	if len(rcs) != 2 {
+		t.Fatalf("Found %v round constants, not 2!", len(rcs))
+	}
+
+	for _, rc := range rcs {
+		fixed := base[rc.Layer].(encoding.BlockAffine).BlockAdditive
+
+		for pos := 4; pos < 16; pos++ {
+			if rc.KeyMask[pos] != 0 || rc.Fixed[pos] != fixed[pos] {
+				t.Fatal("Fixed round constant identified as key material!")
+			}
+		}
+	}
+}
+
+func TestAlignDecompositions(t *testing.T) {
+	base := spn.NewSPN(rand.Reader, spn.ASA)
+	constrs, keys := []spn.Construction{}, [][2][16]byte{}
+
+	for k := 0; k < 3; k++ {
+		// Key both affine layers in their first four bytes.
+		constr, key := spn.Construction{}, [2][16]byte{}
+		for i, layer := range base {
+			if aff, ok := layer.(encoding.BlockAffine); ok {
+				c := aff.BlockAdditive
+				rand.Read(key[i/2][0:4])
+				for pos := 0; pos < 4; pos++ {
+					c[pos] ^= key[i/2][pos]
+				}
+				layer = encoding.BlockAffine{BlockLinear: aff.BlockLinear, BlockAdditive: c}
+			}
+
+			constr = append(constr, layer)
+		}
+
+		decomposed, err := DecomposeSPN(constr, spn.ASA)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		constrs, keys = append(constrs, decomposed), append(keys, key)
+	}
+
+	aligned := AlignDecompositions(constrs)
+	for k := range aligned {
+		if !encoding.ProbablyEquivalentBlocks(Encoding{aligned[k]}, Encoding{constrs[k]}) {
+			t.Fatal("Aligned decomposition isn't equivalent to the original!")
+		}
+	}
+
+	rcs := RecoverRoundConstants(aligned)
+	if len(rcs) != 2 {
+		t.Fatalf("Found %v round constants, not 2!", len(rcs))
+	}
+
+	// Key material in the first affine layer is moved into the basis of the first decomposition, which keeps bytes
+	// separate but moves them around.
+	keyed := 0
+	for pos := 0; pos < 16; pos++ {
+		if rcs[0].KeyMask[pos] != 0 {
+			keyed++
+		}
+	}
+
+	if keyed != 4 {
+		t.Fatalf("Found key material in %v bytes of the first affine layer, not 4!", keyed)
+	}
+
+	// Key material in the last affine layer is in the same place as in the cipher.
+	last := rcs[1]
+	for pos := 0; pos < 16; pos++ {
+		mask := byte(0)
+		for k := range keys {
+			mask |= keys[k][1][pos] ^ keys[0][1][pos]
+		}
+
+		if last.KeyMask[pos] != mask {
+			t.Fatalf("Found key mask %x in byte %v of the last affine layer, not %x!", last.KeyMask[pos], pos, mask)
+		} else if last.Fixed[pos]&mask != 0 {
+			t.Fatal("Fixed round constant overlaps key material!")
+		}
+
+		for k := range keys {
+			c := aligned[k][last.Layer].(encoding.BlockAffine).BlockAdditive
+
+			if last.Keyed[k][pos]^last.Keyed[0][pos] != (keys[k][1][pos]^keys[0][1][pos])&mask {
+				t.Fatal("Recovered key material doesn't match the key!")
+			} else if last.Fixed[pos]^last.Keyed[k][pos] != c[pos] {
+				t.Fatal("Fixed round constant and key material don't add up to the constant!")
+			}
+		}
+	}
+}
+
+type countingMetrics struct {
+	queries, batches, retries, subspaces int64
+	rank                                 [16]int64
+}
+
+func (m *countingMetrics) Queries(n int)      { atomic.AddInt64(&m.queries, int64(n)) }
+func (m *countingMetrics) Batch()             { atomic.AddInt64(&m.batches, 1) }
+func (m *countingMetrics) Retry()             { atomic.AddInt64(&m.retries, 1) }
+func (m *countingMetrics) Rank(pos, rank int) { atomic.StoreInt64(&m.rank[pos], int64(rank)) }
+func (m *countingMetrics) Subspaces(n int)    { atomic.StoreInt64(&m.subspaces, int64(n)) }
+
+func TestMetrics(t *testing.T) {
+	m := &countingMetrics{}
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+	if _, err := DecomposeSPN(constr, spn.SA, WithMetrics(m)); err != nil {
+		t.Fatal(err)
+	}
+
+	sufficient := int64(newOptions(nil).sufficientRank())
+	if m.queries < 4*m.batches || m.batches < sufficient {
+		t.Fatalf("Implausible metrics: %v queries in %v batches.", m.queries, m.batches)
+	}
+
+	for pos, rank := range m.rank {
+		if rank < sufficient {
+			t.Fatalf("Position %v reported rank %v, but the attack succeeded.", pos, rank)
+		}
+	}
+
+	// Attacks on affine layers report subspaces instead of ranks.
+	m = &countingMetrics{}
+	constr = spn.NewSPN(rand.Reader, spn.AS)
+	if _, _, err := RecoverAffine(Encoding{constr}, trivialSubspaces, WithMetrics(m)); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.subspaces != 16 || m.batches != 16 {
+		t.Fatalf("Reported %v subspaces in %v batches, not 16 in 16.", m.subspaces, m.batches)
+	} else if m.rank != [16]int64{} {
+		t.Fatal("Attack on an affine layer reported ranks!")
+	}
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	m := NewExpvarMetrics("TestExpvarMetrics")
+	m.Queries(3)
+	m.Queries(4)
+	m.Batch()
+	m.Retry()
+	m.Rank(2, 100)
+	m.Rank(2, 120)
+	m.Subspaces(5)
+
+	got := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(expvar.Get("TestExpvarMetrics").String()), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"queries": 7.0, "batches": 1.0, "retries": 1.0, "subspaces": 5.0,
+		"rank": map[string]interface{}{"2": 120.0},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Published %v, not %v.", got, want)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Reusing a name didn't panic!")
+		}
+	}()
+	NewExpvarMetrics("TestExpvarMetrics")
+}
+
+func TestRankTracker(t *testing.T) {
+	rt := &RankTracker{}
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		RecoverSBoxes(Encoding{constr}, BalancedPlaintexts(4), WithMetrics(rt))
+	}()
+
+	// Snapshots taken while the attack runs never go backwards.
+	last := RankState{}
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+
+		state := rt.Snapshot()
+		if state.Queries < last.Queries || state.Batches < last.Batches {
+			t.Fatalf("Snapshot went backwards: %+v after %+v.", state, last)
+		}
+		last = state
+	}
+
+	sufficient := newOptions(nil).sufficientRank()
+	for pos, rank := range rt.Snapshot().Ranks {
+		if rank < sufficient {
+			t.Fatalf("Position %v reported rank %v, but the attack succeeded.", pos, rank)
+		}
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	rt := &RankTracker{}
+	d := NewDashboard(rt)
+	server := httptest.NewServer(http.StripPrefix("/attack", d))
+	defer server.Close()
+
+	get := func(path string) []byte {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	if page := get("/attack/"); !bytes.Contains(page, []byte("<html>")) {
+		t.Fatal("Dashboard's root isn't a page.")
+	}
+
+	state := DashboardState{}
+	if err := json.Unmarshal(get("/attack/state"), &state); err != nil {
+		t.Fatal(err)
+	} else if state.ETA != -1 || state.Sufficient != 247 {
+		t.Fatalf("Dashboard of an attack that hasn't started reported %+v.", state)
+	}
+
+	// Every position halfway there after 100 batches leaves about 100 batches to go.
+	for pos := 0; pos < 16; pos++ {
+		rt.Rank(pos, 123)
+	}
+	for i := 0; i < 100; i++ {
+		rt.Batch()
+		rt.Queries(4)
+	}
+
+	if err := json.Unmarshal(get("/attack/state"), &state); err != nil {
+		t.Fatal(err)
+	} else if state.Queries != 400 || state.ETA <= 0 || state.ETA > 2*state.Elapsed {
+		t.Fatalf("Dashboard of an attack halfway there reported %+v.", state)
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	sboxes := func(structure spn.Structure, generator Generator) func(...Option) {
+		return func(opts ...Option) {
+			RecoverSBoxes(Encoding{spn.NewSPN(rand.Reader, structure)}, generator, opts...)
+		}
+	}
+
+	affine := func(structure spn.Structure, generator func(encoding.Block) ([]matrix.IncrementalMatrix, error)) func(...Option) {
+		return func(opts ...Option) {
+			if _, _, err := RecoverAffine(Encoding{spn.NewSPN(rand.Reader, structure)}, generator, opts...); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	decompose := func(structure spn.Structure) func(...Option) {
+		return func(opts ...Option) {
+			if _, err := DecomposeSPN(spn.NewSPN(rand.Reader, structure), structure, opts...); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	cases := []struct {
+		name      string
+		est       Complexity
+		attack    func(...Option)
+		tolerance float64
+	}{
+		{"Balanced", Estimate(128, 8, BalancedGenerator), sboxes(spn.SA, BalancedPlaintexts(4)), 1.6},
+		{"Permutation", Estimate(128, 8, PermutationGenerator), sboxes(spn.SASA, PermutationPlaintexts(256)), 1.33},
+		{"Trivial", Estimate(128, 8, TrivialSubspaceGenerator), affine(spn.AS, trivialSubspaces), 1.33},
+		{"LowRankAddition", Estimate(128, 8, LowRankAdditionGenerator), affine(spn.ASA, lowRankDetectionWith(nextByAddition)), 2},
+		{"LowRankToggle", Estimate(128, 8, LowRankToggleGenerator), affine(spn.ASAS, lowRankDetectionWith(nextByToggle)), 2},
+		{"SPN(SA)", EstimateSPN(spn.SA), decompose(spn.SA), 1.6},
+		{"SPN(ASA)", EstimateSPN(spn.ASA), decompose(spn.ASA), 2},
+	}
+
+	for _, c := range cases {
+		m := &countingMetrics{}
+		c.attack(WithMetrics(m))
+
+		for _, x := range []struct {
+			what          string
+			est, measured int64
+		}{{"queries", int64(c.est.Queries), m.queries}, {"batches", int64(c.est.Batches), m.batches}} {
+			if ratio := float64(x.measured) / float64(x.est); ratio < 1/c.tolerance || ratio > c.tolerance {
+				t.Errorf("%v: estimated %v %v, but the attack made %v.", c.name, x.est, x.what, x.measured)
+			}
+		}
+	}
+}
+
+func TestEstimateStructure(t *testing.T) {
+	for _, structure := range []spn.Structure{spn.AS, spn.SA, spn.ASA, spn.SAS, spn.ASAS, spn.SASA, spn.SASAS} {
+		full, small := EstimateStructure(128, 8, structure), EstimateStructure(64, 4, structure)
+
+		if full != EstimateSPN(structure) {
+			t.Fatalf("%v: EstimateStructure(128, 8) is %+v, but EstimateSPN is %+v.", structureNames[structure], full, EstimateSPN(structure))
+		} else if full.Time <= 0 || small.Queries >= full.Queries || small.Memory >= full.Memory || small.Time >= full.Time {
+			t.Fatalf("%v: a cipher with 4-bit S-boxes costs %+v, and one with 8-bit S-boxes %+v.", structureNames[structure], small, full)
+		}
+
+		attack := DecomposeAttack{Structure: structure}
+		if attack.EstimatedComplexity(64, 4) != small {
+			t.Fatalf("%v: the attack estimates %+v, not %+v.", structureNames[structure], attack.EstimatedComplexity(64, 4), small)
+		}
+	}
+}
+
+func TestRecoverSBoxesDetailed(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+	res, err := RecoverSBoxesDetailed(Encoding{constr}, BalancedPlaintexts(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for pos, conf := range res.Confidence {
+		if conf.NullSpaceDim != 9 || conf.Candidates == 0 || conf.Agreement != 1 {
+			t.Fatalf("Position %v has unexpectedly low confidence: %+v", pos, conf)
+		} else if len(res.NullSpaces[pos]) != conf.NullSpaceDim {
+			t.Fatalf("Position %v has a nullspace basis of %v vectors, not %v.", pos, len(res.NullSpaces[pos]), conf.NullSpaceDim)
+		}
+	}
+
+	for pos, diag := range res.Diagnostics() {
+		if diag.Rank != 247 || diag.Remaining != 0 {
+			t.Fatalf("Position %v has implausible diagnostics: %+v", pos, diag)
+		}
+	}
+}
+
+func TestTrailingConstants(t *testing.T) {
+	// AES S-boxes after a random affine layer.
+	aes := encoding.ConcatenatedBlock{}
+	for pos := range aes {
+		aes[pos] = aesSBox()
+	}
+	constr := spn.NewCustomSPN(spn.NewSPN(rand.Reader, spn.AS)[1], aes)
+
+	res, err := RecoverSBoxesDetailed(Encoding{constr}, BalancedPlaintexts(4), WithTrailingConstants(aesSBox()))
+	if err != nil {
+		t.Fatal(err)
+	} else if !encoding.ProbablyEquivalentBlocks(encoding.ComposedBlocks{res.Rest, res.Last}, Encoding{constr}) {
+		t.Fatal("Recovered S-boxes and what's left aren't equivalent to the cipher!")
+	}
+
+	// Each S-box is the AES S-box after a linear transformation, with the constant it was after split off.
+	for pos, s := range res.Last {
+		dual, ok := IdentifySBox(s, []KnownSBox{{Name: "AES", SBox: aesSBox()}})
+		if !ok || dual.In.Encode(0) != 0 {
+			t.Fatalf("S-box at position %v isn't the AES S-box after a linear transformation.", pos)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+
+	// Balanced sets of two plaintexts are the same plaintext twice, so every row they give is dependent.
+	res, err := RecoverSBoxesDetailed(Encoding{constr}, BalancedPlaintexts(2))
+	recErr, ok := err.(*RecoveryError)
+	if !ok || len(recErr.Positions) != 16 {
+		t.Fatalf("Expected every position to fail, got: %v", err)
+	}
+
+	for pos, diag := range res.Diagnostics() {
+		if diag != (PositionDiagnostics{Rank: 0, Dependent: 2000, Remaining: -1}) {
+			t.Fatalf("Position %v has implausible diagnostics: %+v", pos, diag)
+		} else if recErr.Diagnostics[pos] != diag {
+			t.Fatalf("Position %v has different diagnostics in the error: %+v", pos, recErr.Diagnostics[pos])
+		}
+	}
+
+	if d := diagnose(200, 100, 247); d.Remaining != 71 {
+		t.Fatalf("Expected 71 more batches, not %v.", d.Remaining)
+	}
+}
+
+func TestRecoverSBoxesByDifferences(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+
+	res, err := RecoverSBoxesByDifferences(Encoding{constr})
+	if err != nil {
+		t.Fatal(err)
+	} else if _, ok := encoding.DecomposeBlockAffine(res.Rest); !ok {
+		t.Fatal("What's left after removing the S-boxes isn't affine!")
+	}
+
+	for pos, conf := range res.Confidence {
+		if conf.NullSpaceDim != 9 {
+			t.Fatalf("Position %v has a nullspace of dimension %v, not 9.", pos, conf.NullSpaceDim)
+		}
+	}
+
+	// Balanced sets of two plaintexts give the Cube attack nothing, so every position falls back to differences.
+	res, err = RecoverSBoxesWithFallback(Encoding{constr}, BalancedPlaintexts(2))
+	if err != nil {
+		t.Fatal(err)
+	} else if !encoding.ProbablyEquivalentBlocks(encoding.ComposedBlocks{res.Rest, res.Last}, Encoding{constr}) {
+		t.Fatal("Recovered S-boxes and what's left aren't equivalent to the cipher!")
+	} else if _, ok := encoding.DecomposeBlockAffine(res.Rest); !ok {
+		t.Fatal("What's left after falling back isn't affine!")
+	}
+}
+
+func TestRecoverSBoxesFromApproximations(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+	known := func() [][16]byte {
+		pt := [16]byte{}
+		rand.Read(pt[:])
+		return [][16]byte{pt}
+	}
+
+	pts, cts := CollectCiphertexts(Encoding{constr}, known, 6000)
+	res, approxs, err := RecoverSBoxesFromApproximations(pts, cts)
+	if err != nil {
+		t.Fatal(err)
+	} else if !encoding.ProbablyEquivalentBlocks(encoding.ComposedBlocks{res.Rest, res.Last}, Encoding{constr}) {
+		t.Fatal("Recovered S-boxes and linear layer aren't equivalent to the cipher!")
+	} else if len(approxs) != 128 {
+		t.Fatalf("Expected 128 approximations, got %v.", len(approxs))
+	}
+
+	for _, a := range approxs {
+		if a.Bias != 0.5 {
+			t.Fatalf("Approximation for position %v has bias %v on the held-out samples.", a.Position, a.Bias)
+		}
+	}
+
+	if _, _, err := RecoverSBoxesFromApproximations(pts[:1000], cts[:1000]); !errors.Is(err, ErrTooFewSamples) {
+		t.Fatalf("Expected too few samples, got: %v", err)
+	}
+}
+
+func TestRecoverSBoxesSquare(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+	queries := int64(0)
+
+	res, err := RecoverSBoxesSquare(countQueries{Encoding{constr}, &queries})
+	if err != nil {
+		t.Fatal(err)
+	} else if queries%256 != 0 || queries > 256*squareStructures {
+		t.Fatalf("Expected whole Λ-sets, got %v queries.", queries)
+	} else if _, ok := encoding.DecomposeBlockAffine(res.Rest); !ok {
+		t.Fatal("What's left after removing the S-boxes isn't affine!")
+	}
+}
+
+// benchmarkQueries runs recovery b.N times against fresh SA ciphers, and reports the average number of queries it made.
+func benchmarkQueries(b *testing.B, recovery func(cipher encoding.Block) error) {
+	queries := int64(0)
+	for i := 0; i < b.N; i++ {
+		if err := recovery(countQueries{Encoding{spn.NewSPN(rand.Reader, spn.SA)}, &queries}); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(queries)/float64(b.N), "queries/op")
+}
+
+func BenchmarkRecoverSBoxes(b *testing.B) {
+	benchmarkQueries(b, func(cipher encoding.Block) error {
+		_, err := RecoverSBoxesDetailed(cipher, BalancedPlaintexts(4))
+		return err
+	})
+}
+
+func BenchmarkRecoverSBoxesSquare(b *testing.B) {
+	benchmarkQueries(b, func(cipher encoding.Block) error {
+		_, err := RecoverSBoxesSquare(cipher)
+		return err
+	})
+}
+
+func TestArena(t *testing.T) {
+	a := &arena{}
+	rows := []gfmatrix.Row{}
+	for i := 0; i < arenaChunk+1; i++ {
+		row := a.row()
+		row[i%256] = 0x01
+		rows = append(rows, row)
+	}
+
+	// Rows don't overlap, even across chunks.
+	for i, row := range rows {
+		set := 0
+		for _, e := range row {
+			if e != 0 {
+				set++
+			}
+		}
+
+		if set != 1 || row[i%256] != 0x01 || cap(row) != 256 {
+			t.Fatalf("Row %v overlaps another or can grow into its neighbor.", i)
+		}
+	}
+
+	// A recycled row comes back as the zero row.
+	a.recycle(rows[3])
+	if row := a.row(); !row.IsZero() {
+		t.Fatal("Recycled row wasn't zeroed.")
+	}
+
+	if row := (*arena)(nil).row(); len(row) != 256 {
+		t.Fatal("Nil arena returned a row of the wrong size.")
+	}
+}
+
+func TestCiphertextRows(t *testing.T) {
+	cts := make([][16]byte, 1000)
+	for i := range cts {
+		rand.Read(cts[i][:])
+		cts[i][0] = byte(i % 3) // Values that appear an even number of times cancel.
+	}
+
+	rows := ciphertextRows(cts)
+	for pos, row := range rows {
+		counts := [256]int{}
+		for _, ct := range cts {
+			counts[ct[pos]]++
+		}
+
+		for v, c := range counts {
+			if row[v] != number.ByteFieldElem(c%2) {
+				t.Fatalf("Position %v has %v for value %v, which appeared %v times.", pos, row[v], v, c)
+			}
+		}
+	}
+}
+
+func BenchmarkCiphertextRows(b *testing.B) {
+	cts := make([][16]byte, 256)
+	for i := range cts {
+		rand.Read(cts[i][:])
+	}
+
+	for i := 0; i < b.N; i++ {
+		ciphertextRows(cts)
+	}
+}
+
+func TestIncrementalMatrices(t *testing.T) {
+	// Splitting the batches of an attack between two systems and merging them gives the system of the whole attack.
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+	whole, a, b := NewIncrementalMatrices(16, 256), NewIncrementalMatrices(16, 256), NewIncrementalMatrices(16, 256)
+
+	for i := 0; i < 2000 && !whole.SufficientlyDefined(247); i++ {
+		rows := batchRows(Encoding{constr}, BalancedPlaintexts(4), nil)
+		whole.Add(rows[:])
+
+		if i%2 == 0 {
+			a.Add(rows[:])
+		} else {
+			b.Add(rows[:])
+		}
+	}
+
+	merged := a.Dup()
+	merged.Merge(b)
+
+	if !reflect.DeepEqual(merged.Ranks(), whole.Ranks()) {
+		t.Fatalf("Merged ranks %v don't match %v.", merged.Ranks(), whole.Ranks())
+	} else if !merged.SufficientlyDefined(247) {
+		t.Fatalf("Merged system is only of rank %v.", merged.Ranks())
+	} else if grown := merged.Merge(a); len(grown) != 0 {
+		t.Fatalf("Merging a subsystem raised the rank of positions %v.", grown)
+	}
+
+	ranks := a.Ranks()
+	if union := Union(a, b); !reflect.DeepEqual(union.Ranks(), merged.Ranks()) {
+		t.Fatalf("Union has ranks %v, not %v.", union.Ranks(), merged.Ranks())
+	} else if !reflect.DeepEqual(a.Ranks(), ranks) {
+		t.Fatal("Union modified its arguments!")
+	}
+
+	for pos, basis := range merged.NullSpaces() {
+		if len(basis) != 9 {
+			t.Fatalf("Position %v has a nullspace of dimension %v.", pos, len(basis))
+		}
+	}
+}
+
+// transcriptOf returns the ciphertexts of the given number of batches of plaintexts from generator, in the format
+// RecoverSBoxesFromTranscript reads.
+func transcriptOf(cipher encoding.Block, generator Generator, batches int) (out []byte) {
+	for i := 0; i < batches; i++ {
+		pts := generator()
+
+		out = append(out, byte(len(pts)))
+		for _, pt := range pts {
+			ct := cipher.Encode(pt)
+			out = append(out, ct[:]...)
+		}
+	}
+
+	return
+}
+
+func TestRecoverSBoxesFromTranscript(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+	data := transcriptOf(Encoding{constr}, BalancedPlaintexts(4), 1500)
+
+	last, err := RecoverSBoxesFromTranscript(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rest := encoding.ComposedBlocks{Encoding{constr}, encoding.InverseBlock{last}}
+	if _, ok := encoding.DecomposeBlockAffine(rest); !ok {
+		t.Fatal("Removing the S-boxes recovered from the transcript didn't leave an affine layer!")
+	}
+
+	if _, err := RecoverSBoxesFromTranscript(data[:len(data)-1]); err != ErrMalformedTranscript {
+		t.Fatalf("Expected a truncated transcript to be malformed, got: %v", err)
+	}
+}
+
+func FuzzRecoverSBoxesFromTranscript(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{2, 0})
+	f.Add(transcriptOf(Encoding{spn.NewSPN(rand.Reader, spn.SA)}, BalancedPlaintexts(4), 8))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		RecoverSBoxesFromTranscript(data)
+	})
+}
+
+func FuzzLoadSession(f *testing.F) {
+	buf := &bytes.Buffer{}
+	if err := NewSession([]byte("seed")).Save(buf); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(buf.Bytes())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		LoadSession(bytes.NewReader(data))
+	})
+}
+
+func TestDistributed(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+
+	// Two workers attack their own replica of the cipher and ship what they find to the coordinator.
+	workers := []*Worker{NewWorker(Encoding{constr}, BalancedPlaintexts(4)), NewWorker(Encoding{constr}, BalancedPlaintexts(4))}
+	coord := NewCoordinator()
+
+	for round := 0; round < 100 && !coord.SufficientlyDefined(); round++ {
+		for _, w := range workers {
+			w.Run(10)
+
+			frame := &bytes.Buffer{}
+			if err := w.Ship(frame); err != nil {
+				t.Fatal(err)
+			} else if _, err := coord.Receive(frame); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	last, err := coord.Recover()
+	if err != nil {
+		t.Fatalf("%v: %v", coord, err)
+	}
+
+	rest := encoding.ComposedBlocks{Encoding{constr}, encoding.InverseBlock{last}}
+	if _, ok := encoding.DecomposeBlockAffine(rest); !ok {
+		t.Fatal("Removing the recovered S-boxes didn't leave an affine layer!")
+	}
+}
+
+func TestSession(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+
+	session := NewSession([]byte("seed"), WithExhaustiveSearch(1))
+	if _, err := RecoverSBoxesDetailed(Encoding{constr}, BalancedPlaintexts(4), session.Options()...); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := session.Save(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadSession(buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(loaded.Seed, session.Seed) || loaded.exhaustive != 1 {
+		t.Fatal("Loaded session has a different configuration!")
+	} else if !reflect.DeepEqual(loaded.Transcript.Queries(), session.Transcript.Queries()) {
+		t.Fatal("Loaded session has a different transcript!")
+	} else if !reflect.DeepEqual(loaded.Systems.Ranks(), session.Systems.Ranks()) {
+		t.Fatal("Loaded session has different systems!")
+	}
+
+	for _, q := range loaded.Transcript.Queries() {
+		if (Encoding{constr}).Encode(q.Input) != q.Output {
+			t.Fatal("Transcript doesn't match the cipher!")
+		}
+	}
+
+	// Continuing a fork of the loaded session only needs the batches that verify the S-boxes.
+	fork, m := loaded.Fork(), &countingMetrics{}
+	if _, err := RecoverSBoxesDetailed(Encoding{constr}, BalancedPlaintexts(4), append(fork.Options(), WithMetrics(m))...); err != nil {
+		t.Fatal(err)
+	} else if m.queries != 4*verificationBatches {
+		t.Fatalf("Continuing a finished session made %v queries.", m.queries)
+	} else if len(loaded.Transcript.Queries()) != len(session.Transcript.Queries()) {
+		t.Fatal("Continuing a fork added to the original session!")
+	}
+
+	// Every option a session keeps is saved, or the session can't be.
+	reference := encoding.GenerateSBox(rand.Reader)
+	session = NewSession(nil, WithPositions(3, 7), WithVoting(3), WithTrailingConstants(reference), WithSearch(HillClimbing{Steps: 9}))
+	buf.Reset()
+	if err := session.Save(buf); err != nil {
+		t.Fatal(err)
+	} else if loaded, err = LoadSession(buf); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(loaded.positions, []int{3, 7}) || loaded.votes != 3 || loaded.search != (HillClimbing{Steps: 9}) {
+		t.Fatal("Loaded session has a different configuration!")
+	}
+	for x := 0; x < 256; x++ {
+		if loaded.reference.Encode(byte(x)) != reference.Encode(byte(x)) {
+			t.Fatal("Loaded session has a different reference S-box!")
+		}
+	}
+
+	if err := NewSession(nil, WithEscalation(1, BalancedPlaintexts(8))).Save(buf); !errors.Is(err, ErrUnsavableSession) {
+		t.Fatalf("Saved a session that escalates, got: %v", err)
+	}
+}
+
+func TestCompressedTranscript(t *testing.T) {
+	// Enough batches of an attack's plaintexts to fill more than one chunk.
+	constr, tr := Encoding{spn.NewSPN(rand.Reader, spn.SA)}, &Transcript{}
+	generator := PermutationPlaintexts(256)
+	for tr.queries == nil || len(tr.queries) <= transcriptChunk {
+		for _, pt := range generator() {
+			tr.record(Query{Input: pt, Output: constr.Encode(pt), Decrypted: len(tr.queries)%7 == 0})
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tr.WriteCompressed(buf); err != nil {
+		t.Fatal(err)
+	} else if buf.Len() >= querySize*len(tr.queries) {
+		t.Fatalf("Compressed transcript is %v bytes, more than the %v it started as.", buf.Len(), querySize*len(tr.queries))
+	}
+
+	ct, err := ReadCompressedTranscript(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	} else if ct.Len() != len(tr.queries) {
+		t.Fatalf("Compressed transcript has %v queries, not %v.", ct.Len(), len(tr.queries))
+	}
+
+	for _, i := range []int{len(tr.queries) - 1, 0, transcriptChunk, 12345} {
+		if q, err := ct.Query(i); err != nil {
+			t.Fatal(err)
+		} else if q != tr.queries[i] {
+			t.Fatalf("Query %v is %v, not %v.", i, q, tr.queries[i])
+		}
+	}
+
+	full, err := ct.Transcript()
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(full.Queries(), tr.Queries()) {
+		t.Fatal("Decompressed transcript is different!")
+	}
+
+	data := buf.Bytes()
+	if _, err := ReadCompressedTranscript(bytes.NewReader(data[:len(data)-1]), int64(len(data)-1)); err != ErrMalformedCompressedTranscript {
+		t.Fatalf("Expected a truncated transcript to be malformed, got: %v", err)
+	}
+}
+
+func TestDistinguishers(t *testing.T) {
+	// Random ciphertexts shouldn't be distinguished.
+	pts, cts := make([][16]byte, 4096), make([][16]byte, 4096)
+	for i := range pts {
+		rand.Read(pts[i][:])
+		rand.Read(cts[i][:])
+	}
+
+	in, out := [16]byte{0: 0x01}, [16]byte{3: 0x80}
+	if res := ChiSquareTest(cts, 0); res.Distinguishes(1e-6) {
+		t.Fatalf("Chi-square test distinguished random ciphertexts: %+v", res)
+	} else if res := CollisionTest(cts, 0); res.Distinguishes(1e-6) {
+		t.Fatalf("Collision test distinguished random ciphertexts: %+v", res)
+	} else if res := CorrelationTest(pts, cts, in, out); res.Distinguishes(1e-6) {
+		t.Fatalf("Correlation test distinguished random ciphertexts: %+v", res)
+	}
+
+	// Over a structure that saturates one byte of the plaintext, each byte of an S-box layer either takes every value
+	// once or is constant.
+	sboxes := encoding.ConcatenatedBlock{}
+	for pos := range sboxes {
+		sboxes[pos] = encoding.GenerateSBox(rand.Reader)
+	}
+
+	_, cts = CollectCiphertexts(sboxes, PermutationPlaintexts(256), 1)
+	if res := CollisionTest(cts, 0); !res.Distinguishes(1e-6) {
+		t.Fatalf("Collision test didn't distinguish an S-box layer: %+v", res)
+	}
+
+	// Constant bytes aren't uniform, and the identity is perfectly correlated.
+	pts, cts = CollectCiphertexts(encoding.IdentityBlock{}, PermutationPlaintexts(16), 64)
+	if res := ChiSquareTest(cts, 0); !res.Distinguishes(1e-6) {
+		t.Fatalf("Chi-square test didn't distinguish a constant byte: %+v", res)
+	} else if res := CorrelationTest(pts, cts, in, in); !res.Distinguishes(1e-6) || res.Statistic != 1 {
+		t.Fatalf("Correlation test didn't distinguish the identity: %+v", res)
+	}
+
+	// Q(a, x) for a chi-square distribution with one degree of freedom is erfc(sqrt(x)).
+	for _, x := range []float64{0.1, 1, 5, 20} {
+		if got, want := upperGamma(0.5, x), math.Erfc(math.Sqrt(x)); math.Abs(got-want) > 1e-9*math.Max(want, 1e-300) && math.Abs(got-want) > 1e-12 {
+			t.Fatalf("upperGamma(0.5, %v) = %v, not %v.", x, got, want)
+		}
+	}
+}
+
+func TestAnalyzeFrequencies(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+	r := AnalyzeFrequencies(Encoding{constr}, BalancedPlaintexts(4), 512)
+
+	if r.Samples != 4*512 {
+		t.Fatalf("Report counts %v ciphertexts, not %v.", r.Samples, 4*512)
+	}
+
+	for pos := range r.Counts {
+		total := 0
+		for _, c := range r.Counts[pos] {
+			total += c
+		}
+
+		// Four random values are usually distinct, and two of them colliding still leaves two odd ones.
+		if total != r.Samples {
+			t.Fatalf("Counts of position %v add up to %v.", pos, total)
+		} else if r.Distinct[pos] < 240 {
+			t.Fatalf("Position %v only took %v values.", pos, r.Distinct[pos])
+		} else if r.Odd[pos] < 3 || r.Odd[pos] > 4 {
+			t.Fatalf("Position %v had %v odd values per structure.", pos, r.Odd[pos])
+		}
+	}
+
+	// A truncated position takes few values.
+	truncated := encoding.ComposedBlocks{Encoding{constr}, corruptPositions{}}
+	if r := AnalyzeFrequencies(truncated, BalancedPlaintexts(4), 64); r.Distinct[3] > 16 {
+		t.Fatalf("Truncated position took %v values.", r.Distinct[3])
+	}
+}
+
+func TestEstimateRounds(t *testing.T) {
+	if est := EstimateRounds(encoding.IdentityBlock{}, 7, 10); est != (RoundEstimate{Degree: 1, Exact: true}) {
+		t.Fatalf("Estimated %+v for the identity.", est)
+	}
+
+	sa := spn.NewSPN(rand.Reader, spn.SA)
+	if est := EstimateRounds(Encoding{sa}, 7, 10); est != (RoundEstimate{Degree: 7, Exact: true, Rounds: 1}) {
+		t.Fatalf("Estimated %+v for SA.", est)
+	}
+
+	sas := spn.NewSPN(rand.Reader, spn.SAS)
+	if est := EstimateRounds(Encoding{sas}, 7, 10); est.Exact || est.Rounds < 2 {
+		t.Fatalf("Estimated %+v for SAS.", est)
+	}
+}
+
+func TestProbeWidths(t *testing.T) {
+	as := spn.NewSPN(rand.Reader, spn.AS)
+	if w := ProbeWidths(Encoding{as}); w != (Widths{Block: 128, SBox: 8}) {
+		t.Fatalf("Probed %+v for AS.", w)
+	}
+
+	// Nibble-oriented S-boxes, and a cipher that ignores the second half of its input.
+	nibbles := encoding.ConcatenatedBlock{}
+	for pos := range nibbles {
+		nibbles[pos] = encoding.ConcatenatedByte{encoding.GenerateShuffle(rand.Reader), encoding.GenerateShuffle(rand.Reader)}
+	}
+	aff := as[1].(encoding.BlockAffine)
+
+	if w := ProbeWidths(encoding.ComposedBlocks{nibbles, aff}); w != (Widths{Block: 128, SBox: 4}) {
+		t.Fatalf("Probed %+v for a nibble-oriented AS.", w)
+	} else if w := ProbeWidths(encoding.ComposedBlocks{halfBlock{}, nibbles, aff}); w != (Widths{Block: 64, SBox: 4}) {
+		t.Fatalf("Probed %+v for a 64-bit cipher.", w)
+	}
+
+	// SA's first layer is affine.
+	sa := spn.NewSPN(rand.Reader, spn.SA)
+	if w := ProbeWidths(Encoding{sa}); w.SBox != 0 {
+		t.Fatalf("Probed %+v for SA.", w)
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	as := spn.NewSPN(rand.Reader, spn.AS)
+	if r := Preflight(Encoding{as}); !r.LinearTrailing || r.Rounds.Rounds != 1 {
+		t.Fatalf("Preflight of AS reported:\n%v", r)
+	}
+
+	sa := spn.NewSPN(rand.Reader, spn.SA)
+	if r := Preflight(Encoding{sa}); r.LinearTrailing || r.Widths.SBox != 0 {
+		t.Fatalf("Preflight of SA reported:\n%v", r)
+	}
+
+	// The rank isn't cut short once it's known to be more than 8: every difference goes through an S-box of every byte.
+	if rank := DifferenceRank(Encoding{sa}, 0); rank < 100 {
+		t.Fatalf("Difference rank of SA is %v, not close to 128.", rank)
+	}
+}
+
+// decryptOnly exposes only decryption of a construction.
+type decryptOnly struct{ constr spn.Construction }
+
+func (d decryptOnly) Decrypt(dst, src []byte) { d.constr.Decrypt(dst, src) }
+
+func TestDecomposeSPNByDecryption(t *testing.T) {
+	for _, structure := range []spn.Structure{spn.AS, spn.SA, spn.ASA} {
+		constr := spn.NewSPN(rand.Reader, structure)
+
+		out, err := DecomposeSPNByDecryption(decryptOnly{constr}, structure)
+		if err != nil {
+			t.Fatalf("Structure %v: %v", structure, err)
+		} else if !encoding.ProbablyEquivalentBlocks(encoding.ComposedBlocks(out), encoding.ComposedBlocks(constr)) {
+			t.Fatalf("Decomposition of structure %v by decryption isn't equivalent to the cipher.", structure)
+		}
+	}
+}
+
+func TestEquivalent(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SAS)
+	if ok, _ := Equivalent(encoding.ComposedBlocks(constr), encoding.ComposedBlocks(constr), 0.99, 0.01); !ok {
+		t.Fatal("A cipher isn't equivalent to itself.")
+	}
+
+	// Change one output of one leading S-box, which random inputs only hit once in 256.
+	sboxes := constr[0].(encoding.ConcatenatedBlock)
+	s := sboxes[3].(encoding.SBox)
+	x, y := s.DecKey[0x10], s.DecKey[0x20]
+	s.EncKey[x], s.EncKey[y], s.DecKey[0x10], s.DecKey[0x20] = 0x20, 0x10, y, x
+
+	changed := append(spn.Construction{}, constr...)
+	sboxes[3] = s
+	changed[0] = sboxes
+
+	ok, in := Equivalent(encoding.ComposedBlocks(constr), encoding.ComposedBlocks(changed), 0.99, 0.01)
+	if ok {
+		t.Fatal("Ciphers that differ on one S-box output were found equivalent.")
+	} else if in[3] != x && in[3] != y {
+		t.Fatalf("Counterexample %x doesn't hit the changed S-box outputs.", in)
+	}
+}
+
+func TestDecryptor(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SASAS)
+
+	dec, err := Decryptor(constr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 16; i++ {
+		pt := [16]byte{}
+		rand.Read(pt[:])
+
+		if ct := encoding.ComposedBlocks(constr).Encode(pt); dec.Encode(ct) != pt || dec.Decode(pt) != ct {
+			t.Fatal("Decryptor doesn't invert the decomposition.")
+		}
+	}
+}
+
+func TestCipherBlock(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SAS)
+	dec, err := Decryptor(constr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iv, pt := make([]byte, 16), make([]byte, 64)
+	rand.Read(iv)
+	rand.Read(pt)
+
+	// Encrypt with the construction itself, and decrypt with the Decryptor's inverse.
+	ct, out := make([]byte, len(pt)), make([]byte, len(pt))
+	cipher.NewCBCEncrypter(constr, iv).CryptBlocks(ct, pt)
+	cipher.NewCBCDecrypter(CipherBlock{encoding.InverseBlock{dec}}, iv).CryptBlocks(out, ct)
+
+	if !bytes.Equal(out, pt) {
+		t.Fatal("CipherBlock didn't decrypt what the construction encrypted.")
+	}
+}
+
+func TestDecomposeSPNFromBothEnds(t *testing.T) {
+	for _, structure := range []spn.Structure{spn.SA, spn.SAS, spn.SASA} {
+		constr := spn.NewSPN(rand.Reader, structure)
+
+		out, err := DecomposeSPNFromBothEnds(encoding.ComposedBlocks(constr), structure)
+		if err != nil {
+			t.Fatalf("Structure %v: %v", structure, err)
+		} else if len(out) != len(constr) {
+			t.Fatalf("Structure %v decomposed into %v layers, not %v.", structure, len(out), len(constr))
+		} else if !encoding.ProbablyEquivalentBlocks(encoding.ComposedBlocks(out), encoding.ComposedBlocks(constr)) {
+			t.Fatalf("Decomposition of structure %v from both ends isn't equivalent to the cipher.", structure)
+		}
+	}
+}
+
+func TestDecomposeSPNConcurrently(t *testing.T) {
+	for _, structure := range []spn.Structure{spn.SA, spn.SAS} {
+		constr := spn.NewSPN(rand.Reader, structure)
+
+		out, err := DecomposeSPNConcurrently(encoding.ComposedBlocks(constr), structure)
+		if err != nil {
+			t.Fatalf("Structure %v: %v", structure, err)
+		} else if len(out) != len(constr) {
+			t.Fatalf("Structure %v decomposed into %v layers, not %v.", structure, len(out), len(constr))
+		} else if !encoding.ProbablyEquivalentBlocks(encoding.ComposedBlocks(out), encoding.ComposedBlocks(constr)) {
+			t.Fatalf("Concurrent decomposition of structure %v isn't equivalent to the cipher.", structure)
+		}
+	}
+}
+
+func TestYoyo(t *testing.T) {
+	sas := spn.NewSPN(rand.Reader, spn.SAS)
+	cipher := encoding.ComposedBlocks(sas)
+
+	if !YoyoDistinguisher(cipher, 64) {
+		t.Fatal("Yoyo game didn't distinguish SAS.")
+	} else if sasas := encoding.ComposedBlocks(spn.NewSPN(rand.Reader, spn.SASAS)); YoyoDistinguisher(sasas, 64) {
+		t.Fatal("Yoyo game distinguished SASAS.")
+	}
+
+	first, rest, err := RecoverSBoxesByYoyo(cipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The S-boxes are only recovered up to an affine transformation of their outputs.
+	leading := sas[0].(encoding.ConcatenatedBlock)
+	for pos := range first {
+		got, _ := NormalizeOutput(first[pos])
+		want, _ := NormalizeOutput(leading[pos])
+
+		if got != want {
+			t.Fatalf("S-box at position %v isn't affine-equivalent to the leading S-box.", pos)
+		}
+	}
+
+	if !encoding.ProbablyEquivalentBlocks(encoding.ComposedBlocks{first, rest}, cipher) {
+		t.Fatal("Leading S-boxes and the rest aren't equivalent to the cipher.")
+	}
+}
+
+func TestAESLinearLayer(t *testing.T) {
+	// The MixColumns test vector from FIPS 197, in the first column of a state that ShiftRows leaves alone.
+	in := [16]byte{0: 0xdb, 5: 0x13, 10: 0x53, 15: 0x45}
+	out := AESLinearLayer().Mul(matrix.Row(in[:]))
+
+	if !bytes.Equal(out[:4], []byte{0x8e, 0x4d, 0xa1, 0xbc}) {
+		t.Fatalf("AESLinearLayer gave %x.", out[:4])
+	}
+}
+
+func TestIsAESLike(t *testing.T) {
+	linear := AESLinearLayer()
+	keys := make([][16]byte, 4)
+	for i := range keys {
+		rand.Read(keys[i][:])
+	}
+
+	for rounds := 1; rounds <= 2; rounds++ {
+		aes := encoding.ComposedBlocks(spn.NewRoundSPN(aesSBox(), linear, keys[:rounds+1]))
+		if !IsAESLike(aes, rounds, linear) {
+			t.Fatalf("%v rounds of an AES-like cipher weren't recognized.", rounds)
+		}
+
+		other := encoding.ComposedBlocks(spn.NewRoundSPN(aesSBox(), matrix.GenerateRandom(rand.Reader, 128), keys[:rounds+1]))
+		if rounds == 2 && IsAESLike(other, rounds, linear) {
+			t.Fatal("Two rounds with a random linear layer were recognized as AES-like.")
+		}
+	}
+
+	// Three rounds don't keep the mixture's sum zero.
+	aes := encoding.ComposedBlocks(spn.NewRoundSPN(aesSBox(), linear, keys))
+	if IsAESLike(aes, 1, linear) {
+		t.Fatal("Three rounds were recognized as one.")
+	}
+}
+
+func TestSubspaceTrail(t *testing.T) {
+	linear := AESLinearLayer()
+
+	// One byte spreads to a column and then to the whole state, and a diagonal lands in a single column.
+	if trail := SubspaceTrail(linear, 0x0001, 2); !reflect.DeepEqual(trail, []uint16{0x0001, 0x000f, 0xffff}) {
+		t.Fatalf("Trail from one byte is %04x.", trail)
+	} else if trail := SubspaceTrail(linear, aesDiagonal(1), 1); trail[1] != 0x00f0 {
+		t.Fatalf("Trail from the second diagonal is %04x.", trail)
+	}
+
+	keys := [][16]byte{{}, {}}
+	rand.Read(keys[1][:])
+	round := encoding.ComposedBlocks(spn.NewRoundSPN(aesSBox(), linear, keys))
+
+	if trail := SubspaceTrail(linear, aesDiagonal(2), 2); !VerifySubspaceTrail(round, trail, 64) {
+		t.Fatal("Subspace trail of AES didn't hold.")
+	} else if VerifySubspaceTrail(round, []uint16{aesDiagonal(2), 0x00f0}, 64) {
+		t.Fatal("Wrong subspace trail held.")
+	}
+}
+
+func TestFindInvariantSubspaces(t *testing.T) {
+	aes := encoding.ConcatenatedBlock{}
+	nibbles := encoding.ConcatenatedBlock{}
+	nibble := encoding.ConcatenatedByte{encoding.GenerateShuffle(rand.Reader), encoding.GenerateShuffle(rand.Reader)}
+	for pos := range aes {
+		aes[pos], nibbles[pos] = aesSBox(), nibble
+	}
+
+	if found := SBoxInvariantSubspaces(aesSBox()); len(found) != 0 {
+		t.Fatalf("Found invariant subspaces %x of the AES S-box.", found)
+	} else if found := SBoxInvariantSubspaces(nibble); len(found) != 2 {
+		t.Fatalf("Found invariant subspaces %x of an S-box built from nibbles.", found)
+	} else if found := FindInvariantSubspaces(aes, AESLinearLayer()); len(found) != 0 {
+		t.Fatalf("Found invariant subspaces %+v of AES.", found)
+	}
+
+	// Rotating the bytes of the state keeps the nibbles apart.
+	rotate := blockMatrix(func(in [16]byte) (out [16]byte) {
+		copy(out[1:], in[:15])
+		out[0] = in[15]
+		return
+	})
+
+	found := FindInvariantSubspaces(nibbles, rotate)
+	if len(found) != 2 {
+		t.Fatalf("Found %v invariant subspaces, not 2.", len(found))
+	}
+
+	for _, u := range found {
+		if u.Positions != 0xffff || u.Dim != 64 {
+			t.Fatalf("Invariant subspace %+v doesn't cover one nibble of every byte.", u)
+		}
+	}
+
+	// AES's linear layer mixes the nibbles.
+	if found := FindInvariantSubspaces(nibbles, AESLinearLayer()); len(found) != 0 {
+		t.Fatalf("Found invariant subspaces %+v with AES's linear layer.", found)
+	}
+}
+
+func TestFindNonlinearInvariants(t *testing.T) {
+	// An S-box that keeps x0 x1 + x2 fixed, by permuting the inputs where it's 0 and where it's 1 separately.
+	q := func(x byte) byte { return (x & (x >> 1) & 1) ^ (x>>2)&1 }
+	classes := [2][]byte{}
+	for x := 0; x < 256; x++ {
+		classes[q(byte(x))] = append(classes[q(byte(x))], byte(x))
+	}
+
+	s := encoding.SBox{}
+	for _, class := range classes {
+		shuffled := append([]byte{}, class...)
+		for i := len(shuffled) - 1; i > 0; i-- {
+			r := [1]byte{}
+			rand.Read(r[:])
+
+			j := int(r[0]) % (i + 1)
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		}
+
+		for i, x := range class {
+			s.EncKey[x], s.DecKey[shuffled[i]] = shuffled[i], x
+		}
+	}
+
+	sboxes, aes := encoding.ConcatenatedBlock{}, encoding.ConcatenatedBlock{}
+	for pos := range sboxes {
+		sboxes[pos], aes[pos] = s, aesSBox()
+	}
+
+	rotate := blockMatrix(func(in [16]byte) (out [16]byte) {
+		copy(out[1:], in[:15])
+		out[0] = in[15]
+		return
+	})
+
+	found := FindNonlinearInvariants(sboxes, rotate)
+	if len(found) == 0 {
+		t.Fatal("Didn't find the nonlinear invariant.")
+	}
+
+	round := encoding.ComposedBlocks{sboxes, encoding.NewBlockLinear(rotate)}
+	for _, g := range found {
+		x := [16]byte{}
+		rand.Read(x[:])
+
+		if c := g.Eval(round.Encode(x)) ^ g.Eval(x); c != g.Eval(round.Encode([16]byte{}))^g.Eval([16]byte{}) {
+			t.Fatal("Invariant isn't invariant.")
+		} else if g.WeakKeyDim == 0 || g.WeakKeyDim == 128 {
+			t.Fatalf("Invariant has %v dimensions of weak keys.", g.WeakKeyDim)
+		}
+	}
+
+	if found := FindNonlinearInvariants(aes, AESLinearLayer()); len(found) != 0 {
+		t.Fatalf("Found %v nonlinear invariants of AES.", len(found))
+	}
+}
+
+// newSecretSBoxAES returns four rounds of AES with the given S-box and random round keys, where the last round skips
+// MixColumns.
+func newSecretSBoxAES(s encoding.SBox) encoding.ComposedBlocks {
+	sboxes := encoding.ConcatenatedBlock{}
+	for pos := range sboxes {
+		sboxes[pos] = s
+	}
+
+	keyed := func(m matrix.Matrix) encoding.BlockAffine {
+		k := [16]byte{}
+		rand.Read(k[:])
+		return encoding.BlockAffine{BlockLinear: encoding.NewBlockLinear(m), BlockAdditive: encoding.BlockAdditive(k)}
+	}
+
+	shiftRows := blockMatrix(func(in [16]byte) (out [16]byte) {
+		for i := range out {
+			out[i] = in[aesShiftRows(i)]
+		}
+		return
+	})
+
+	out := encoding.ComposedBlocks{keyed(matrix.GenerateIdentity(128))}
+	for round := 0; round < 3; round++ {
+		out = append(out, sboxes, keyed(AESLinearLayer()))
+	}
+
+	return append(out, sboxes, keyed(shiftRows))
+}
+
+func TestRecoverSecretSBox(t *testing.T) {
+	secret := encoding.GenerateSBox(rand.Reader)
+	want, _ := NormalizeInput(secret)
+
+	// Position 0 is left out of the second attack, so the S-box comes from position 5.
+	for _, opts := range [][]Option{nil, {WithPositions(5)}} {
+		found, err := RecoverSecretSBox(newSecretSBoxAES(secret), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// found is secret(A(x)) + k, so one of the constants makes it affine-equivalent to secret on the input side.
+		equivalent := false
+		for k := 0; k < 256 && !equivalent; k++ {
+			unmasked := encoding.SBox{}
+			for x := 0; x < 256; x++ {
+				y := found.Encode(byte(x)) ^ byte(k)
+				unmasked.EncKey[x], unmasked.DecKey[y] = y, byte(x)
+			}
+
+			got, _ := NormalizeInput(unmasked)
+			equivalent = got == want
+		}
+
+		if !equivalent {
+			t.Fatal("Recovered S-box isn't affine-equivalent to the secret S-box.")
+		}
+	}
+}
+
+func TestRecoverMixColumns(t *testing.T) {
+	secret := MixColumnsRound{SBox: aesSBox()}
+	rand.Read(secret.FirstKey[:])
+	rand.Read(secret.LastKey[:])
+	for i := range secret.Mix {
+		for j := range secret.Mix[i] {
+			for secret.Mix[i][j] == 0 {
+				rand.Read(secret.Mix[i][j : j+1])
+			}
+		}
+	}
+
+	found, err := RecoverMixColumns(secret, aesSBox())
+	if err != nil {
+		t.Fatal(err)
+	} else if found.Mix != secret.Mix || found.FirstKey != secret.FirstKey || found.LastKey != secret.LastKey {
+		t.Fatalf("Recovered %+v, not %+v.", found, secret)
+	}
+
+	// A cipher with another S-box isn't explained by any matrix.
+	other := secret
+	other.SBox = encoding.GenerateSBox(rand.Reader)
+	if _, err := RecoverMixColumns(other, aesSBox()); err != ErrNotAESShaped {
+		t.Fatalf("Attack with the wrong S-box returned %v.", err)
+	}
+}
+
+func TestDetectTKlog(t *testing.T) {
+	// Build a TKlog in the representation given by x^8 + x^4 + x^3 + x^2 + 1, where 2 generates the multiplicative
+	// group: GF(2^4) goes onto the high nibbles, and coset i onto the nonzero low nibbles under high nibble i-1.
+	const poly = 0x11d
+	s := encoding.SBox{}
+
+	x := byte(1)
+	for k := 0; k < 255; k++ {
+		i, j := k%17, byte(k/17)
+
+		y := (j + 1) << 4
+		if i > 0 {
+			y = byte(i-1)<<4 | (j + 1)
+		}
+		s.EncKey[x], s.DecKey[y] = y, x
+
+		x = gfMul(x, 2, poly)
+	}
+
+	wrapped := encoding.ComposedBytes{s, encoding.NewByteAffine(matrix.GenerateRandom(rand.Reader, 8), 0x5a)}
+
+	found, ok := DetectTKlog(wrapped)
+	if !ok {
+		t.Fatal("Didn't detect a TKlog under an affine transformation.")
+	} else if len(found.Subspace) != 4 || len(found.Subfield) != 4 {
+		t.Fatalf("Detected subspaces of the wrong dimension: %+v", found)
+	}
+
+	for k := 1; k < 17; k++ {
+		if found.Coset(gfPow(found.Generator, k, found.Polynomial)) != k {
+			t.Fatalf("Generator^%v isn't in coset %v.", k, k)
+		}
+	}
+
+	if _, ok := DetectTKlog(aesSBox()); ok {
+		t.Fatal("Detected a TKlog in the AES S-box.")
+	} else if _, ok := DetectTKlog(encoding.GenerateSBox(rand.Reader)); ok {
+		t.Fatal("Detected a TKlog in a random S-box.")
+	}
+}
+
+func TestClassifySBox(t *testing.T) {
+	rounds := [3]encoding.Shuffle{}
+	for i := range rounds {
+		rounds[i] = encoding.GenerateShuffle(rand.Reader)
+	}
+
+	power, feistel := encoding.SBox{}, encoding.SBox{}
+	for x := 0; x < 256; x++ {
+		y := gfPow(byte(x), 7, aesPolynomial)
+		power.EncKey[x], power.DecKey[y] = y, byte(x)
+
+		l, r := byte(x>>4), byte(x&0x0f)
+		for _, f := range rounds {
+			l, r = r, l^f.Encode(r)
+		}
+		feistel.EncKey[x], feistel.DecKey[l<<4|r] = l<<4|r, byte(x)
+	}
+
+	affine := func(s encoding.Byte) encoding.Byte {
+		return encoding.ComposedBytes{
+			encoding.NewByteAffine(matrix.GenerateRandom(rand.Reader, 8), 0x11),
+			s,
+			encoding.NewByteAffine(matrix.GenerateRandom(rand.Reader, 8), 0x22),
+		}
+	}
+
+	for _, c := range []struct {
+		name string
+		sbox encoding.Byte
+		want Provenance
+	}{
+		{"AES", affine(aesSBox()), Inversion},
+		{"x^7", affine(power), PowerMap},
+		{"Feistel", affine(feistel), SmallNetwork},
+		{"random", encoding.GenerateSBox(rand.Reader), Random},
+	} {
+		if got, f := ClassifySBox(c.sbox); got != c.want {
+			t.Fatalf("Classified the %v S-box as %v, not %v: %v", c.name, got, c.want, f)
+		}
+	}
+}
+
+func TestRecoverLeadingLayers(t *testing.T) {
+	// AS decrypts as SA, so its leading S-box layer falls to balanced plaintexts.
+	as := encoding.ComposedBlocks(spn.NewSPN(rand.Reader, spn.AS))
+	first, rest := RecoverLeadingSBoxes(as, BalancedPlaintexts(4))
+
+	aff, ok := encoding.DecomposeBlockAffine(encoding.InverseBlock{encoding.InverseBlock{rest}})
+	if !ok {
+		t.Fatal("Removing the leading S-boxes didn't leave an affine layer!")
+	} else if !encoding.ProbablyEquivalentBlocks(encoding.ComposedBlocks{first, aff}, as) {
+		t.Fatal("Leading S-boxes and the rest aren't equivalent to the cipher.")
+	}
+
+	// The same, as an Attack.
+	res, err := (LeadingSBoxAttack{Generator: BalancedGenerator}).Run(context.Background(), as)
+	if err != nil {
+		t.Fatal(err)
+	} else if !encoding.ProbablyEquivalentBlocks(encoding.InverseBlock{encoding.ComposedBlocks{res.Layers[0], res.Rest}}, encoding.InverseBlock{as}) {
+		t.Fatal("Leading S-boxes and the rest aren't equivalent to the cipher.")
+	} else if d := res.Phases[0].Data; len(res.Phases) != 1 || d.Decryptions == 0 || d.Chosen != 0 {
+		t.Fatalf("Attack through decryption recorded %+v.", res.Phases)
+	}
+
+	// SA decrypts as AS.
+	sa := encoding.ComposedBlocks(spn.NewSPN(rand.Reader, spn.SA))
+	lead, rest, err := RecoverLeadingAffine(sa, trivialSubspaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sboxes := encoding.DecomposeConcatenatedBlock(encoding.InverseBlock{encoding.InverseBlock{rest}})
+	if !encoding.ProbablyEquivalentBlocks(encoding.ComposedBlocks{lead, sboxes}, sa) {
+		t.Fatal("Leading affine layer and the rest aren't equivalent to the cipher.")
+	}
+}
+
+// halfBlock clears the second half of its input.
+type halfBlock struct{}
+
+func (halfBlock) Encode(in [16]byte) [16]byte {
+	copy(in[8:], make([]byte, 8))
+	return in
+}
+
+func (halfBlock) Decode(in [16]byte) [16]byte { return in }
+
+func TestNullSpaceDim(t *testing.T) {
+	for degree, dim := range []int{1, 9, 37, 93, 163, 219, 247, 255} {
+		if NullSpaceDim(degree) != dim {
+			t.Fatalf("NullSpaceDim(%v) = %v, not %v.", degree, NullSpaceDim(degree), dim)
+		}
+	}
+}
+
+func TestRandomPermutationVector(t *testing.T) {
+	// Combinations a*x + b of the identity and the constant vector are permutation vectors unless a is zero.
+	identity, ones := gfmatrix.NewRow(257), gfmatrix.NewRow(257)
+	for i := 0; i < 256; i++ {
+		identity[i], ones[i] = number.ByteFieldElem(i), 1
+	}
+	identity[256], ones[256] = 1, 1
+
+	found := 0
+	for i := 0; i < 256; i++ {
+		if v, ok := randomPermutationVector([]gfmatrix.Row{identity, ones}); ok {
+			found++
+			if !v[:256].IsPermutation() || len(v) != 257 || v[256] != v[1] {
+				t.Fatalf("Returned %v, which isn't a combination of the basis that's a permutation.", v)
+			}
+		}
+	}
+
+	if found < 240 {
+		t.Fatalf("Found %v of 256 permutation vectors, not about 255.", found)
+	} else if _, ok := randomPermutationVector([]gfmatrix.Row{ones}); ok {
+		t.Fatal("Found a permutation vector in the span of the constant vector.")
+	}
+}
+
+func TestPermutationSearch(t *testing.T) {
+	// The nullspace of an S-box's linear relations is spanned by the constant vector and its output bits.
+	sbox, ones := encoding.GenerateSBox(rand.Reader), gfmatrix.NewRow(256)
+	for x := range ones {
+		ones[x] = 1
+	}
+
+	basis := []gfmatrix.Row{ones}
+	for bit := uint(0); bit < 8; bit++ {
+		v := gfmatrix.NewRow(256)
+		for x := 0; x < 256; x++ {
+			v[x] = number.ByteFieldElem(sbox.Encode(byte(x)) >> bit & 1)
+		}
+		basis = append(basis, v)
+	}
+
+	for _, search := range []PermutationSearch{RandomSampling{}, HillClimbing{}, SimulatedAnnealing{}} {
+		if _, _, evaluated := search.Attempt(basis); evaluated < 1 || evaluated > 257 {
+			t.Fatalf("%T evaluated %v combinations in one attempt.", search, evaluated)
+		}
+
+		v, ok := findPermutation(basis, search, 1)
+		if !ok {
+			t.Fatalf("%T didn't find a permutation vector in a nullspace of dimension %v.", search, len(basis))
+		} else if !v.IsPermutation() {
+			t.Fatalf("%T returned %v, which isn't a permutation vector.", search, v)
+		}
+	}
+
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+	for _, search := range []PermutationSearch{HillClimbing{}, SimulatedAnnealing{Steps: 64, Temperature: 1}} {
+		if _, err := RecoverSBoxesDetailed(Encoding{constr}, BalancedPlaintexts(4), WithSearch(search)); err != nil {
+			t.Fatalf("%T: %v", search, err)
+		}
+	}
+}
+
+func TestEnumeratePermutations(t *testing.T) {
+	// a*S(x) + b is a permutation exactly when a is non-zero, so the span of S and the constant vector holds 255*256 of
+	// them.
+	sbox := encoding.GenerateSBox(rand.Reader)
+	v, ones := gfmatrix.NewRow(256), gfmatrix.NewRow(256)
+	for x := 0; x < 256; x++ {
+		v[x], ones[x] = number.ByteFieldElem(sbox.Encode(byte(x))), 0x01
+	}
+
+	count, seen := 0, false
+	enumeratePermutations([]gfmatrix.Row{ones.Add(v), v.ScalarMul(0x03)}, func(cand gfmatrix.Row) bool {
+		if !cand.IsPermutation() {
+			t.Fatal("Enumerated a vector that isn't a permutation!")
+		}
+
+		count++
+		seen = seen || reflect.DeepEqual(cand, v)
+		return true
+	})
+
+	if count != 255*256 {
+		t.Fatalf("Enumerated %v permutation vectors, not %v.", count, 255*256)
+	} else if !seen {
+		t.Fatal("Missed the S-box itself!")
+	}
+}
+
+func TestRecoverSBoxesPartial(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+
+	// Position 3 of the ciphertext only takes 16 values, so it isn't the output of an S-box. Position 5 is replaced by
+	// random garbage, so it gets too many relations and no S-box satisfies all of them.
+	m := &countingMetrics{}
+	cipher := encoding.ComposedBlocks{Encoding{constr}, corruptPositions{}}
+	res, err := RecoverSBoxesDetailed(cipher, BalancedPlaintexts(4), WithMetrics(m))
+
+	recErr, ok := err.(*RecoveryError)
+	if !ok || len(recErr.Positions) != 2 {
+		t.Fatalf("Expected positions 3 and 5 to fail, got: %v", err)
+	} else if m.batches >= 2000 {
+		t.Fatal("Attack kept waiting for the rank of a truncated position to grow!")
+	}
+
+	want := map[int]FailureReason{3: NotBijective, 5: NoPermutation}
+	for i, pos := range recErr.Positions {
+		if reason, ok := want[pos]; !ok || recErr.Reasons[i] != reason {
+			t.Fatalf("Position %v failed for the wrong reason: %v", pos, recErr.Reasons[i])
+		} else if pos == 3 && recErr.Values[i] > 16 {
+			t.Fatalf("Position 3 took %v values, but it's truncated to 4 bits.", recErr.Values[i])
+		}
+	}
+
+	for pos, ok := range res.Recovered {
+		if ok == (pos == 3 || pos == 5) {
+			t.Fatalf("Position %v was wrongly reported as recovered or not.", pos)
+		}
+	}
+}
+
+func TestWithPositions(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+
+	// Positions 3 and 5 can't be recovered, but they aren't targeted.
+	cipher := encoding.ComposedBlocks{Encoding{constr}, corruptPositions{}}
+	res, err := RecoverSBoxesDetailed(cipher, BalancedPlaintexts(4), WithPositions(0, 1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for pos, ok := range res.Recovered {
+		if ok != (pos < 3) {
+			t.Fatalf("Position %v was wrongly reported as recovered or not.", pos)
+		} else if _, identity := res.Last[pos].(encoding.IdentityByte); !ok && !identity {
+			t.Fatalf("Position %v wasn't targeted, but doesn't hold the identity.", pos)
+		}
+	}
+
+	for _, positions := range [][]int{{0, 16}, {-1}, {4, 4}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("WithPositions accepted %v.", positions)
+				}
+			}()
+			WithPositions(positions...)
+		}()
+	}
+}
+
+func TestRecoverTruncated(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+	visible := []int{2, 7, 11}
+
+	cipher := Truncated{
+		Cipher: func(in [16]byte) []byte {
+			out := Encoding{constr}.Encode(in)
+			return []byte{out[2], out[7], out[11]}
+		},
+		Positions: visible,
+	}
+
+	res, err := RecoverTruncated(cipher, BalancedPlaintexts(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pos := range visible {
+		if !res.Recovered[pos] || res.Confidence[pos].Agreement != 1 {
+			t.Fatalf("Visible position %v wasn't recovered: %+v", pos, res.Confidence[pos])
+		}
+	}
+
+	cipher.Positions = []int{2, 7, 16}
+	if _, err := RecoverTruncated(cipher, BalancedPlaintexts(4)); err == nil {
+		t.Fatal("Attacked a truncated oracle with position 16 visible.")
+	}
+}
+
+// flakyCipher corrupts every 97th ciphertext.
+type flakyCipher struct {
+	encoding.Block
+	queries *int64
+}
+
+func (fc flakyCipher) Encode(in [16]byte) [16]byte {
+	out := fc.Block.Encode(in)
+	if n := atomic.AddInt64(fc.queries, 1); n%97 == 0 {
+		for pos := range out {
+			out[pos] ^= byte(n)
+		}
+	}
+
+	return out
+}
+
+func TestWithVoting(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+	cipher := flakyCipher{Encoding{constr}, new(int64)}
+
+	res, err := RecoverSBoxesDetailed(cipher, BalancedPlaintexts(4), WithVoting(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for pos, ok := range res.Recovered {
+		if !ok {
+			t.Fatalf("Position %v wasn't recovered.", pos)
+		}
+	}
+
+	// Without voting, the corrupted ciphertexts poison every position's system.
+	if _, err := RecoverSBoxesDetailed(cipher, BalancedPlaintexts(4)); err == nil {
+		t.Fatal("Attack without voting succeeded despite corrupted ciphertexts!")
+	}
+}
+
+func TestDiagnosis(t *testing.T) {
+	cases := []struct {
+		name      string
+		structure spn.Structure
+		cipher    func(encoding.Block) encoding.Block
+		generator Generator
+		cause     Cause
+	}{
+		{"degree", spn.SA, func(c encoding.Block) encoding.Block { return c }, BalancedPlaintexts(2), WrongGeneratorDegree},
+		{"linear", spn.AS, func(c encoding.Block) encoding.Block { return c }, BalancedPlaintexts(4), LinearTrailingLayer},
+		{"noise", spn.SA, func(c encoding.Block) encoding.Block { return flakyCipher{c, new(int64)} }, BalancedPlaintexts(4), NoisyOracle},
+	}
+
+	for _, c := range cases {
+		constr := spn.NewSPN(rand.Reader, c.structure)
+		_, err := RecoverSBoxesDetailed(c.cipher(Encoding{constr}), c.generator)
+
+		recErr := &RecoveryError{}
+		if !errors.As(err, &recErr) {
+			t.Fatalf("%v: attack returned %v, not a *RecoveryError", c.name, err)
+		} else if recErr.Diagnosis == nil || len(recErr.Diagnosis.Hypotheses) == 0 {
+			t.Fatalf("%v: failure wasn't diagnosed", c.name)
+		} else if top := recErr.Diagnosis.Hypotheses[0].Cause; top != c.cause {
+			t.Fatalf("%v: most likely cause was %v, not %v: %v", c.name, top, c.cause, recErr.Diagnosis.Hypotheses)
+		}
+
+		if c.cause == NoisyOracle && recErr.Diagnosis.Inconsistent == 0 {
+			t.Fatalf("%v: no inconsistent queries found", c.name)
+		} else if c.cause != NoisyOracle && recErr.Diagnosis.Inconsistent != 0 {
+			t.Fatalf("%v: %v inconsistent queries found from a consistent oracle", c.name, recErr.Diagnosis.Inconsistent)
+		}
+	}
+}
+
+// maskedCipher adds a random mask to about a quarter of its outputs.
+type maskedCipher struct{ encoding.Block }
+
+func (mc maskedCipher) Encode(in [16]byte) [16]byte {
+	out, mask := mc.Block.Encode(in), [17]byte{}
+	if rand.Read(mask[:]); mask[16] < 64 {
+		encoding.XOR(out[:], out[:], mask[:16])
+	}
+
+	return out
+}
+
+func TestConsensus(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+	cipher := Consensus{maskedCipher{Encoding{constr}}, 15}
+
+	for i := 0; i < 256; i++ {
+		pt := [16]byte{}
+		rand.Read(pt[:])
+
+		if cipher.Encode(pt) != (Encoding{constr}).Encode(pt) {
+			t.Fatal("Consensus of masked outputs isn't the cipher's output!")
+		}
+	}
+}
+
+func TestStatefulOracle(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+	want := func(pt [16]byte) [16]byte { return Encoding{constr}.Encode(pt) }
+
+	// Sessions are numbered by their handshake, and expire after 10 queries.
+	sessions := 0
+	session := &StatefulOracle{
+		Start: func() ([]byte, error) {
+			sessions++
+			return []byte{byte(sessions), 0}, nil
+		},
+		Query: func(state []byte, in [16]byte) ([16]byte, []byte, error) {
+			if state[0] != byte(sessions) || state[1] == 10 {
+				return [16]byte{}, nil, errors.New("session expired")
+			}
+			return want(in), []byte{state[0], state[1] + 1}, nil
+		},
+		Retries: 1,
+	}
+
+	// The counter has to increase with every query.
+	last := int64(-1)
+	counter := NewCounterOracle(func(c uint64, in [16]byte) ([16]byte, error) {
+		if int64(c) <= last {
+			return [16]byte{}, errors.New("replayed counter")
+		}
+		last = int64(c)
+		return want(in), nil
+	}, 0)
+
+	nonces := map[string]bool{}
+	nonce := NewNonceOracle(rand.Reader, 12, func(n []byte, in [16]byte) ([16]byte, error) {
+		if nonces[string(n)] {
+			return [16]byte{}, errors.New("reused nonce")
+		}
+		nonces[string(n)] = true
+		return want(in), nil
+	}, 0)
+
+	for name, cipher := range map[string]encoding.Block{"session": session, "counter": counter, "nonce": nonce} {
+		for i := 0; i < 64; i++ {
+			pt := [16]byte{}
+			rand.Read(pt[:])
+
+			if cipher.Encode(pt) != want(pt) {
+				t.Fatalf("The %v oracle's output isn't the cipher's.", name)
+			}
+		}
+	}
+
+	if sessions != 7 {
+		t.Fatalf("Opened %v sessions for 64 queries, not 7.", sessions)
+	}
+}
+
+func TestFramedOracle(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+
+	// The target is served over a unix socket, found through an assessment's configuration.
+	path := filepath.Join(t.TempDir(), "target")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip("Can't listen on a unix socket:", err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			ServeFramed(conn, encoding.ComposedBlocks(constr))
+			conn.Close()
+		}
+	}()
+
+	cipher, err := OracleConfig{Type: "framed", Network: "unix", Address: path}.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 64; i++ {
+		pt := [16]byte{}
+		rand.Read(pt[:])
+
+		if ct := cipher.Encode(pt); ct != (Encoding{constr}).Encode(pt) || cipher.Decode(ct) != pt {
+			t.Fatal("The framed oracle's output isn't the cipher's.")
+		}
+	}
+
+	// A target that can't decrypt says so, and the oracle panics with its reason.
+	client, server := net.Pipe()
+	go ServeFramed(server, NewCounterOracle(func(c uint64, in [16]byte) ([16]byte, error) { return in, nil }, 0))
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "Decode isn't implemented") {
+			t.Fatalf("Expected the target's panic, got: %v", r)
+		}
+	}()
+	NewFramedOracle(client).Decode([16]byte{})
+}
+
+// fakeToken is a Token holding an AES key, whose sessions expire after three encryptions.
+type fakeToken struct {
+	block    cipher.Block
+	sessions int
+	calls    map[uint]int
+	ready    map[uint]bool
+}
+
+func (ft *fakeToken) OpenSession() (uint, error) {
+	ft.sessions++
+	return uint(ft.sessions), nil
+}
+
+func (ft *fakeToken) CloseSession(session uint) error {
+	delete(ft.calls, session)
+	return nil
+}
+
+func (ft *fakeToken) EncryptInit(session uint) error {
+	ft.ready[session] = true
+	return nil
+}
+
+func (ft *fakeToken) Encrypt(session uint, data []byte) ([]byte, error) {
+	if !ft.ready[session] {
+		return nil, errors.New("CKR_OPERATION_NOT_INITIALIZED")
+	} else if ft.calls[session]++; ft.calls[session] > 3 {
+		return nil, errors.New("CKR_SESSION_CLOSED")
+	}
+	ft.ready[session] = false
+
+	out := make([]byte, len(data))
+	for i := 0; i < len(data); i += 16 {
+		ft.block.Encrypt(out[i:], data[i:])
+	}
+	return out, nil
+}
+
+func TestTokenOracle(t *testing.T) {
+	key := make([]byte, 16)
+	rand.Read(key)
+	block, _ := aes.NewCipher(key)
+
+	token := &fakeToken{block: block, calls: map[uint]int{}, ready: map[uint]bool{}}
+	oracle := &TokenOracle{Token: token, Batch: 8, Retries: 1}
+
+	pts := make([][16]byte, 100)
+	for i := range pts {
+		rand.Read(pts[i][:])
+	}
+
+	// 100 blocks are 13 batches, and each session lasts for three of them.
+	for i, ct := range oracle.EncodeBatch(pts) {
+		want := [16]byte{}
+		if block.Encrypt(want[:], pts[i][:]); ct != want {
+			t.Fatalf("Block %v wasn't encrypted like the token's key would.", i)
+		}
+	}
+	if token.sessions != 5 {
+		t.Fatalf("Opened %v sessions for 13 batches, not 5.", token.sessions)
+	}
+
+	if oracle.Encode(pts[0]) == pts[0] || oracle.Close() != nil {
+		t.Fatal("Single queries don't go through the token.")
+	}
+
+	// Attacks send each set of plaintexts to the token in batches.
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+	token = &fakeToken{block: cipherBlock{Encoding{constr}}, calls: map[uint]int{}, ready: map[uint]bool{}}
+	oracle = &TokenOracle{Token: token, Batch: 256, Retries: 1}
+
+	res, err := (SBoxAttack{Generator: BalancedGenerator}).Run(context.Background(), oracle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := 0
+	for _, phase := range res.Phases {
+		queries += phase.Data.Total()
+	}
+	// Each set of BalancedPlaintexts(4) is 4 plaintexts, so querying them one at a time would take 4 times as many calls.
+	if batches := 3 * (token.sessions - 1); batches > queries/2 {
+		t.Fatalf("Made at least %v calls to the token for %v queries.", batches, queries)
+	} else if !encoding.ProbablyEquivalentBlocks(encoding.ComposedBlocks{res.Rest, encoding.ComposedBlocks(res.Layers)}, Encoding{constr}) {
+		t.Fatal("Recovered layers and what's left aren't equivalent to the cipher on the token!")
+	}
+}
+
+// cipherBlock is an encoding.Block as a crypto/cipher.Block.
+type cipherBlock struct{ encoding.Block }
+
+func (cb cipherBlock) BlockSize() int { return 16 }
+
+func (cb cipherBlock) Encrypt(dst, src []byte) {
+	in := [16]byte{}
+	copy(in[:], src)
+	out := cb.Encode(in)
+	copy(dst, out[:])
+}
+
+func (cb cipherBlock) Decrypt(dst, src []byte) {
+	in := [16]byte{}
+	copy(in[:], src)
+	out := cb.Decode(in)
+	copy(dst, out[:])
+}
+
+func TestTargetedPermutationPlaintexts(t *testing.T) {
+	// Each output byte of the diffusion layer depends on its own input byte and the next one.
+	diffusion := matrix.GenerateIdentity(128)
+	for i := 0; i < 128; i++ {
+		diffusion[i][(i/8+1)%16] = 0xff
+	}
+
+	if active := influence(diffusion, []int{3, 9}); !reflect.DeepEqual(active, []int{3, 4, 9, 10}) {
+		t.Fatalf("Positions 3 and 9 are influenced by %v, not [3 4 9 10].", active)
+	}
+
+	generator := TargetedPermutationPlaintexts(256, diffusion, 3, 9)
+	for i := 0; i < 64; i++ {
+		pts := generator()
+
+		varying := []int{}
+		for pos := 0; pos < 16; pos++ {
+			seen := map[byte]bool{}
+			for _, pt := range pts {
+				seen[pt[pos]] = true
+			}
+
+			if len(seen) == 256 {
+				varying = append(varying, pos)
+			} else if len(seen) != 1 {
+				t.Fatalf("Position %v takes %v values.", pos, len(seen))
+			}
+		}
+
+		if len(varying) != 1 || (varying[0] != 3 && varying[0] != 4 && varying[0] != 9 && varying[0] != 10) {
+			t.Fatalf("Saturated positions %v, which don't reach the targets.", varying)
+		}
+	}
+}
+
+func TestValidateGenerator(t *testing.T) {
+	cases := []struct {
+		name      string
+		generator Generator
+		structure spn.Structure
+		err       error
+	}{
+		{"Balanced", BalancedPlaintexts(4), spn.SA, nil},
+		{"Dual", DualPlaintexts(4), spn.SAS, nil},
+		{"Permutation", PermutationPlaintexts(256), spn.SASA, nil},
+		{"Shallow", BalancedPlaintexts(4), spn.SASA, ErrBrokenGenerator},
+		{"Pairs", BalancedPlaintexts(2), spn.SA, ErrUninformativeGenerator},
+		{"Affine", BalancedPlaintexts(4), spn.AS, ErrNoTrailingSBoxes},
+	}
+
+	for _, c := range cases {
+		if err := ValidateGenerator(c.generator, c.structure); !errors.Is(err, c.err) {
+			t.Fatalf("%v: validation returned %v, not %v.", c.name, err, c.err)
+		}
+	}
+}
+
+func TestInvertAESKeySchedule(t *testing.T) {
+	// Keys and last words of their schedules from Appendix A of FIPS 197.
+	vectors := []struct{ key, last string }{
+		{"2b7e151628aed2a6abf7158809cf4f3c", "d014f9a8c9ee2589e13f0cc8b6630ca6"},
+		{"8e73b0f7da0e6452c810f32b809079e562f8ead2522c6b7b", "e98ba06f448c773c8ecc720401002202"},
+		{"603deb1015ca71be2b73aef0857d77811f352c073b6108d72d9810a30914dff4", "fe4890d1e6188d0b046df344706c631e"},
+	}
+
+	for _, v := range vectors {
+		key, _ := hex.DecodeString(v.key)
+		roundKeys := expandAESKey(key)
+
+		if last := hex.EncodeToString(roundKeys[len(roundKeys)-1][:]); last != v.last {
+			t.Fatalf("Last round key of %v is %v, not %v.", v.key, last, v.last)
+		}
+
+		n := (len(key) + 15) / 16
+		recovered, err := InvertAESKeySchedule(len(key), roundKeys[len(roundKeys)-n:])
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(recovered, key) {
+			t.Fatalf("Recovered key %x, not %v.", recovered, v.key)
+		}
+
+		// One round key fewer is too few, except for AES-128.
+		if _, err := InvertAESKeySchedule(len(key), roundKeys[len(roundKeys)-n+1:]); n > 1 && err == nil {
+			t.Fatal("Inverted the key schedule from too few round keys!")
+		}
+	}
+}
+
+func TestLinearKeySchedule(t *testing.T) {
+	ks := LinearKeySchedule{}
+	for i := 0; i < 3; i++ {
+		c := [16]byte{}
+		rand.Read(c[:])
+
+		ks.Rounds, ks.Constants = append(ks.Rounds, matrix.GenerateRandom(rand.Reader, 128)), append(ks.Constants, c)
+	}
+
+	key := matrix.NewRow(128)
+	rand.Read(key)
+	roundKeys := ks.Expand(key)
+
+	full, half := [16]byte{}, [16]byte{}
+	for i := range full {
+		full[i] = 0xff
+		if i%2 == 0 {
+			half[i] = 0xff
+		}
+	}
+
+	// Round 1 is known twice, and the second copy has a bit flipped.
+	wrong := roundKeys[1]
+	wrong[7] ^= 0x10
+	known := []RoundKey{{2, roundKeys[2], half}, {1, roundKeys[1], half}, {1, wrong, full}, {0, roundKeys[0], full}}
+
+	recovered, inconsistent := ks.Reconstruct(known)
+	if !reflect.DeepEqual(inconsistent, []int{2}) {
+		t.Fatalf("Flagged %v as inconsistent, not [2].", inconsistent)
+	} else if !bytes.Equal(recovered, key) {
+		t.Fatalf("Recovered master key %x, not %x.", recovered, key)
+	}
+
+	// Half of one round key leaves the master key ambiguous, but the reconstruction still agrees with it.
+	recovered, inconsistent = ks.Reconstruct(known[:1])
+	if len(inconsistent) != 0 {
+		t.Fatalf("Flagged %v as inconsistent.", inconsistent)
+	}
+	got := ks.Expand(recovered)[2]
+	for i := range got {
+		if got[i]&half[i] != roundKeys[2][i]&half[i] {
+			t.Fatal("Reconstructed schedule doesn't agree with the known bits of the round key.")
+		}
+	}
+}
+
+func TestFactorization(t *testing.T) {
+	// The last 32 rows are sums of earlier ones, so the matrix has rank 96.
+	m := matrix.GenerateRandom(rand.Reader, 128)
+	for i := 96; i < 128; i++ {
+		m[i] = m[i-96].Add(m[i-95])
+	}
+
+	f := Factor(m)
+	if f.Rank() != 96 {
+		t.Fatalf("Factored matrix has rank %v, not 96.", f.Rank())
+	}
+
+	for trial := 0; trial < 50; trial++ {
+		x := matrix.NewRow(128)
+		rand.Read(x)
+		b := m.Mul(x)
+
+		sol, ok := f.Solve(b)
+		if !ok {
+			t.Fatal("Failed to solve a consistent system.")
+		} else if !bytes.Equal(m.Mul(sol), b) {
+			t.Fatal("Solution doesn't satisfy the system.")
+		}
+	}
+
+	// Flipping a bit of b in a dependent row makes the system inconsistent.
+	b := m.Mul(matrix.NewRow(128)).SetBit(100, true)
+	if _, ok := f.Solve(b); ok {
+		t.Fatal("Solved an inconsistent system.")
+	}
+
+	basis := f.NullSpace()
+	if len(basis) != 32 {
+		t.Fatalf("Nullspace has dimension %v, not 32.", len(basis))
+	}
+	for _, v := range basis {
+		if !m.Mul(v).IsZero() {
+			t.Fatal("Nullspace vector isn't in the nullspace.")
+		}
+	}
+}
+
+func TestAES(t *testing.T) {
+	// The example vector of FIPS-197, appendix C.1.
+	key, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	pt, ct := [16]byte{}, [16]byte{}
+	hex.Decode(pt[:], []byte("00112233445566778899aabbccddeeff"))
+	hex.Decode(ct[:], []byte("69c4e0d86a7b0430d8cdb78070b4c55a"))
+
+	target, err := NewAES(key)
+	if err != nil {
+		t.Fatal(err)
+	} else if target.Encode(pt) != ct || target.Decode(ct) != pt {
+		t.Fatal("AES doesn't match the FIPS-197 example.")
+	}
+
+	if _, err := NewAES(key[:15]); err == nil {
+		t.Fatal("Accepted a 15-byte key!")
+	}
+}
+
+func TestRecoverAESKey(t *testing.T) {
+	key := make([]byte, 16)
+	rand.Read(key)
+
+	target, err := NewAES(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The last round's S-boxes as they'd be recovered: up to an affine transformation on their input, with the last round
+	// key added to their output.
+	roundKeys, last := expandAESKey(key), encoding.ConcatenatedBlock{}
+	for pos := range last {
+		last[pos] = encoding.ComposedBytes{
+			encoding.NewByteAffine(matrix.GenerateRandom(rand.Reader, 8), byte(pos)),
+			aesSBox(),
+			encoding.NewByteAffine(matrix.GenerateIdentity(8), roundKeys[10][pos]),
+		}
+	}
+
+	recovered, err := RecoverAESKey(target, last)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(recovered, key) {
+		t.Fatalf("Recovered key %x, not %x.", recovered, key)
+	}
+
+	// A wrong byte of the last round key gives a key that doesn't encrypt like the cipher.
+	last[3] = encoding.ComposedBytes{last[3], encoding.NewByteAffine(matrix.GenerateIdentity(8), 1)}
+	if _, err := RecoverAESKey(target, last); err != ErrWrongKey {
+		t.Fatalf("Expected the wrong key to be caught, got: %v", err)
+	}
+
+	// Random S-boxes aren't AES's.
+	last[5] = encoding.GenerateSBox(rand.Reader)
+	if _, err := RecoverAESKey(target, last); err != ErrNoLastRoundKey {
+		t.Fatalf("Expected no last round key, got: %v", err)
+	}
+}
+
+func TestDetectTrailingLayer(t *testing.T) {
+	cases := []struct {
+		cipher encoding.Block
+		kind   LayerType
+	}{
+		{encoding.IdentityBlock{}, NoLayer},
+		{spn.NewSPN(rand.Reader, spn.SA)[1], SBoxLayer},
+		{spn.NewSPN(rand.Reader, spn.SA)[0], AffineLayer},
+	}
+
+	for _, structure := range []spn.Structure{spn.AS, spn.SA, spn.ASA, spn.SAS, spn.ASAS, spn.SASA, spn.SASAS} {
+		kind := AffineLayer
+		if structure == spn.SA || structure == spn.SAS || structure == spn.SASA || structure == spn.SASAS {
+			kind = SBoxLayer
+		}
+
+		cases = append(cases, struct {
+			cipher encoding.Block
+			kind   LayerType
+		}{Encoding{spn.NewSPN(rand.Reader, structure)}, kind})
+	}
+
+	for i, c := range cases {
+		if kind := DetectTrailingLayer(c.cipher); kind != c.kind {
+			t.Fatalf("Case %v: detected %v trailing layer, not %v.", i, kind, c.kind)
+		}
+	}
+
+	// A byte whose differences cancel out still isn't one the toggled byte never reaches.
+	if kind := DetectTrailingLayer(cancelingDifferences{}); kind != AffineLayer {
+		t.Fatalf("Detected %v trailing layer in a cipher whose differences cancel, not affine.", kind)
+	}
+}
+
+// cancelingDifferences is a cipher whose first byte differs between the pairs DetectTrailingLayer compares only when the
+// byte it toggles is 5 or 6, and by the same amount both times, so that its differences cancel out if they're XORed
+// together. Every other byte mixes the toggled byte with the rest of the input through an S-box.
+type cancelingDifferences struct{}
+
+func (cancelingDifferences) Encode(in [16]byte) (out [16]byte) {
+	h := byte(0)
+	for _, x := range in[1:] {
+		h ^= x
+	}
+
+	if in[0] == 5 || in[0] == 6 {
+		out[0] = h
+	}
+	for pos := 1; pos < 16; pos++ {
+		out[pos] = aesSBox().Encode(in[0]^h^byte(pos)) & 0x7f
+	}
+
+	return
+}
+
+func (cancelingDifferences) Decode(in [16]byte) [16]byte {
+	panic("cancelingDifferences isn't invertible!")
+}
+
+func TestRecoverTrailingMixing(t *testing.T) {
+	// An AS cipher whose affine layer is a random 16-by-16 matrix over GF(2^8), plus a constant.
+	mixing := gfmatrix.GenerateRandom(rand.Reader, 16)
+	images := matrix.Matrix{}
+	for col := 0; col < 16; col++ {
+		for bit := uint(0); bit < 8; bit++ {
+			e := gfmatrix.NewRow(16)
+			e[col] = number.ByteFieldElem(1 << bit)
+			images = append(images, rowOf(mixing.Mul(e)))
+		}
+	}
+
+	c := [16]byte{}
+	rand.Read(c[:])
+	constr := spn.NewSPN(rand.Reader, spn.AS)
+	constr[1] = encoding.NewBlockAffine(images.Transpose(), c)
+
+	recovered, _, rest, err := RecoverTrailingMixing(Encoding{constr}, trivialSubspaces)
+	if err != nil {
+		t.Fatal(err)
+	} else if !isByteWise(rest) {
+		t.Fatal("Removing the mixing layer didn't leave an S-box layer!")
+	}
+
+	// The mixing layer's columns are recovered up to a scalar each, in the order of the S-boxes.
+	for col := 0; col < 16; col++ {
+		first := 0
+		for mixing[first][col].IsZero() {
+			first++
+		}
+
+		scale := recovered[first][col].Mul(mixing[first][col].Invert())
+		for row := 0; row < 16; row++ {
+			if mixing[row][col].Mul(scale) != recovered[row][col] {
+				t.Fatalf("Column %v isn't a multiple of the original.", col)
+			}
+		}
+	}
+
+	// A layer that's only affine over GF(2) isn't mixing.
+	if _, _, _, err := RecoverTrailingMixing(Encoding{spn.NewSPN(rand.Reader, spn.AS)}, trivialSubspaces); err != ErrNotByteLinear {
+		t.Fatalf("Expected a random affine layer not to be linear over GF(2^8), got: %v", err)
+	}
+}
+
+func TestRecoverTrailingLayer(t *testing.T) {
+	for _, structure := range []spn.Structure{spn.AS, spn.ASA} {
+		cipher := Encoding{spn.NewSPN(rand.Reader, structure)}
+
+		kind, last, rest, err := RecoverTrailingLayer(cipher)
+		if err != nil {
+			t.Fatal(err)
+		} else if kind != AffineLayer {
+			t.Fatalf("Detected %v trailing layer, not affine.", kind)
+		} else if _, ok := last.(encoding.BlockAffine); !ok {
+			t.Fatal("Removed a layer that isn't affine!")
+		} else if !encoding.ProbablyEquivalentBlocks(encoding.ComposedBlocks{rest, last}, cipher) {
+			t.Fatal("Removed layer and what's left aren't equivalent to the cipher!")
+		}
+	}
+}
+
+func TestEscalation(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+
+	// Balanced sets of two plaintexts are the same plaintext twice, so they never give any relations.
+	res, err := RecoverSBoxesDetailed(Encoding{constr}, BalancedPlaintexts(2), WithEscalation(8, BalancedPlaintexts(4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for pos, conf := range res.Confidence {
+		if conf.Agreement != 1 {
+			t.Fatalf("S-box %v is only consistent with %v of fresh batches.", pos, conf.Agreement)
+		}
+	}
+}
+
+// corruptPositions truncates position 3 of its input to 4 bits and replaces position 5 with random garbage.
+type corruptPositions struct{}
+
+func (corruptPositions) Encode(in [16]byte) [16]byte {
+	in[3] &= 0x0f
+	rand.Read(in[5:6])
+	return in
+}
+
+func (corruptPositions) Decode(in [16]byte) [16]byte { return in }
+
+// recordingHandler is a slog.Handler that keeps every record it's given.
+type recordingHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *recordingHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *recordingHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.records = append(h.records, r.Clone())
+	return nil
+}
+
+// count returns the number of records with the given level and message.
+func (h *recordingHandler) count(level slog.Level, msg string) (n int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, r := range h.records {
+		if r.Level == level && r.Message == msg {
+			n++
+		}
+	}
+
+	return
+}
+
+func TestLogger(t *testing.T) {
+	h := &recordingHandler{}
+	if _, err := DecomposeSPN(spn.NewSPN(rand.Reader, spn.SA), spn.SA, WithLogger(slog.New(h))); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.count(slog.LevelDebug, "processed batch") < newOptions(nil).sufficientRank() || h.count(slog.LevelDebug, "rank increased") == 0 {
+		t.Fatal("Successful attack didn't log its progress!")
+	} else if len(h.records) != h.count(slog.LevelDebug, "processed batch")+h.count(slog.LevelDebug, "rank increased") {
+		t.Fatal("Successful attack logged something other than its progress!")
+	}
+
+	// The differences of the outputs of the identity span a single dimension, so low rank detection never succeeds.
+	h = &recordingHandler{}
+	_, _, err := RecoverAffine(encoding.IdentityBlock{}, lowRankDetectionWith(nextByAddition), WithLogger(slog.New(h)))
+	if !errors.Is(err, ErrNotEnoughSubspaces) {
+		t.Fatalf("Expected ErrNotEnoughSubspaces, got: %v", err)
+	} else if h.count(slog.LevelError, "failed to recover enough subspaces") != 1 {
+		t.Fatal("Failed attack on an affine layer didn't log an error!")
+	}
+
+	h = &recordingHandler{}
+	cipher := encoding.ComposedBlocks{Encoding{spn.NewSPN(rand.Reader, spn.SA)}, corruptPositions{}}
+	if _, err := RecoverSBoxesDetailed(cipher, BalancedPlaintexts(4), WithLogger(slog.New(h))); err == nil {
+		t.Fatal("Attack on a corrupted cipher succeeded!")
+	} else if h.count(slog.LevelError, "failed to recover S-boxes") != 1 {
+		t.Fatal("Failed attack on an S-box layer didn't log an error!")
+	}
+}
+
+func TestEvents(t *testing.T) {
+	events := make(chan Event, 1<<16)
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+	if _, err := RecoverSBoxesDetailed(Encoding{constr}, BalancedPlaintexts(4), WithEvents(func(e Event) { events <- e })); err != nil {
+		t.Fatal(err)
+	}
+	close(events)
+
+	batches, ranks, solved, finished := 0, [16]int{}, [16]bool{}, 0
+	for e := range events {
+		switch e := e.(type) {
+		case BatchCollected:
+			if batches++; e.Batch != batches {
+				t.Fatalf("Batch %v was numbered %v.", batches, e.Batch)
+			}
+		case RankIncreased:
+			if e.Rank <= ranks[e.Position] {
+				t.Fatalf("Rank of position %v fell from %v to %v.", e.Position, ranks[e.Position], e.Rank)
+			}
+			ranks[e.Position] = e.Rank
+		case PositionSolved:
+			solved[e.Position] = true
+		case AttackFinished:
+			if finished++; e.Err != nil || e.Recovered != solved {
+				t.Fatalf("Attack finished with %+v, but solved %v.", e, solved)
+			}
+		}
+	}
+
+	if finished != 1 || batches < newOptions(nil).sufficientRank() {
+		t.Fatalf("Attack finished %v times after %v batches.", finished, batches)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	constr := spn.NewSPN(rand.Reader, spn.SA)
+
+	m, queries, ranks, solved := &countingMetrics{}, int64(0), [16]int{}, [16]encoding.Byte{}
+	res, err := RecoverSBoxesDetailed(Encoding{constr}, BalancedPlaintexts(4), WithMetrics(m), WithHooks(Hooks{
+		OnQuery: func(q Query) {
+			if (Encoding{constr}).Encode(q.Input) != q.Output || q.Decrypted {
+				t.Error("Hook saw a query that doesn't match the cipher.")
+			}
+			atomic.AddInt64(&queries, 1)
+		},
+		OnRank:  func(pos, rank int) { ranks[pos] = rank },
+		OnSolve: func(pos int, sbox encoding.Byte) { solved[pos] = sbox },
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if queries != m.queries {
+		t.Fatalf("Hook saw %v queries, but the attack made %v.", queries, m.queries)
+	}
+	for pos := range ranks {
+		if ranks[pos] < newOptions(nil).sufficientRank() {
+			t.Fatalf("Hook last saw position %v at rank %v.", pos, ranks[pos])
+		} else if solved[pos] != res.Last[pos] {
+			t.Fatalf("Hook saw a different S-box at position %v than the attack returned.", pos)
+		}
+	}
+}
+
+func TestWithWorkers(t *testing.T) {
+	// Every index is visited once, however many workers there are.
+	for _, workers := range []int{0, 1, 3, 64} {
+		visits := make([]int64, 40)
+		newOptions([]Option{WithWorkers(workers)}).parallel(len(visits), func(i int) { atomic.AddInt64(&visits[i], 1) })
+
+		for i, v := range visits {
+			if v != 1 {
+				t.Fatalf("With %v workers, index %v was visited %v times.", workers, i, v)
+			}
+		}
+	}
+
+	// Attacks work the same without any goroutines of their own.
+	constr := spn.NewSPN(rand.Reader, spn.SASA)
+	decomp, err := DecomposeSPNConcurrently(encoding.ComposedBlocks(constr), spn.SASA, WithWorkers(1))
+	if err != nil {
+		t.Fatal(err)
+	} else if !encoding.ProbablyEquivalentBlocks(Encoding{decomp}, Encoding{constr}) {
+		t.Fatal("Serial decomposition isn't equivalent to the cipher!")
+	}
+}
@@ -0,0 +1,104 @@
+package spn
+
+import (
+	"crypto/rand"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/gfmatrix"
+)
+
+const (
+	squareStructures = 32      // Λ-sets RecoverSBoxesSquare queries before giving up.
+	squareRelations  = 2 * 256 // Relations drawn from each Λ-set.
+)
+
+// LambdaSet returns the 256 plaintexts that take every value at position active and are constant elsewhere. The i^th
+// plaintext has i at the active position.
+func LambdaSet(active int, constant [16]byte) (out [][16]byte) {
+	for i := 0; i < 256; i++ {
+		pt := constant
+		pt[active] = byte(i)
+		out = append(out, pt)
+	}
+
+	return
+}
+
+// squareRows returns a random relation of each position's system from the ciphertexts of a Λ-set. Any four plaintexts
+// whose active bytes sum to zero sum to zero after an affine layer, so the inverse S-box sums to zero over their
+// ciphertexts.
+func squareRows(cts [][16]byte) (rows [16]gfmatrix.Row) {
+	abc := [3]byte{}
+	rand.Read(abc[:])
+	quad := [4]byte{abc[0], abc[1], abc[2], abc[0] ^ abc[1] ^ abc[2]}
+
+	for pos := range rows {
+		rows[pos] = gfmatrix.NewRow(256)
+		for _, i := range quad {
+			y := cts[i][pos]
+			rows[pos][y] = rows[pos][y].Add(1)
+		}
+	}
+
+	return
+}
+
+// RecoverSBoxesSquare removes the trailing S-box layer of a cipher with structure SA, like RecoverSBoxes, but from
+// Λ-sets instead of balanced sets of four random plaintexts. Every four plaintexts of a Λ-set whose active bytes sum to
+// zero are a balanced set, so one Λ-set of 2^8 chosen plaintexts gives each position as many relations as it needs,
+// unless the affine layer maps the active byte onto fewer than 256 values of the position. For a random affine layer
+// that happens at about 70% of positions, so later Λ-sets move the active byte to cover them.
+//
+// Against random SA ciphers it needs about as many queries as RecoverSBoxesDetailed (see BenchmarkRecoverSBoxes), but
+// in a handful of structures that only vary one byte, for oracles that only accept plaintexts like those.
+func RecoverSBoxesSquare(cipher encoding.Block, opts ...Option) (*SBoxRecovery, error) {
+	o := newOptions(opts)
+	o.ledger.begin("S-box layer by Λ-sets")
+	orc := newOracle(cipher, o)
+	ims, hist, dependent := NewIncrementalMatrices(16, 256), &histogram{}, [16]int{}
+	ignored := o.ignored()
+
+	waiting := func() bool {
+		for _, pos := range ims.Insufficient(o.sufficientRank()) {
+			if !ignored[pos] {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for s := 0; s < squareStructures && waiting(); s++ {
+		constant := [16]byte{}
+		rand.Read(constant[:])
+
+		cts := make([][16]byte, 0, 256)
+		for _, pt := range LambdaSet(s%16, constant) {
+			ct := orc.Encode(pt)
+			hist.add(ct)
+			cts = append(cts, ct)
+		}
+		o.metrics.Batch()
+
+		for r := 0; r < squareRelations && waiting(); r++ {
+			rows := squareRows(cts)
+			for pos := range rows {
+				if ignored[pos] || ims.Rank(pos) >= o.sufficientRank() {
+					rows[pos] = gfmatrix.NewRow(256)
+				}
+			}
+
+			grown := ims.Add(rows[:])
+			for pos := range dependent {
+				dependent[pos]++
+			}
+			for _, pos := range grown {
+				dependent[pos]--
+				o.metrics.Rank(pos, ims.Rank(pos))
+				o.emit(RankIncreased{pos, ims.Rank(pos)})
+			}
+		}
+	}
+
+	return solveRelations(cipher, ims, hist, dependent, o.sufficientRank(), o)
+}
@@ -0,0 +1,109 @@
+package spn
+
+import (
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/matrix"
+)
+
+// AffineStructure is how much an affine layer mixes the bytes of its input.
+type AffineStructure int
+
+const (
+	DiagonalAffine AffineStructure = iota // Each output byte depends only on the same input byte.
+	ColumnAffine                          // Each 32-bit column of the output depends only on the same input column.
+	DenseAffine                           // Output bytes depend on input bytes in any other pattern.
+)
+
+func (s AffineStructure) String() string {
+	switch s {
+	case DiagonalAffine:
+		return "diagonal"
+	case ColumnAffine:
+		return "column"
+	case DenseAffine:
+		return "dense"
+	default:
+		return "unknown"
+	}
+}
+
+// ColumnBlockAffine is an affine layer that acts on each 32-bit column of the state separately, like AES's MixColumns
+// followed by AddRoundKey.
+type ColumnBlockAffine struct {
+	Forwards, Backwards [4]matrix.Matrix // 32-by-32 linear part of each column.
+	Constant            [16]byte
+}
+
+func (ca ColumnBlockAffine) Encode(in [16]byte) (out [16]byte) {
+	for col := 0; col < 4; col++ {
+		copy(out[4*col:], ca.Forwards[col].Mul(matrix.Row(in[4*col:4*col+4])))
+	}
+	encoding.XOR(out[:], out[:], ca.Constant[:])
+
+	return
+}
+
+func (ca ColumnBlockAffine) Decode(in [16]byte) (out [16]byte) {
+	encoding.XOR(in[:], in[:], ca.Constant[:])
+	for col := 0; col < 4; col++ {
+		copy(out[4*col:], ca.Backwards[col].Mul(matrix.Row(in[4*col:4*col+4])))
+	}
+
+	return
+}
+
+// subMatrix returns the block of a 128-by-128 matrix that sends the given input bytes to the given output bytes.
+func subMatrix(m matrix.Matrix, out, in, size int) matrix.Matrix {
+	sub := matrix.Matrix{}
+	for row := 8 * out; row < 8*(out+size); row++ {
+		sub = append(sub, m[row][in:in+size].Dup())
+	}
+
+	return sub
+}
+
+// ClassifyAffine returns the structure of an affine layer, along with the most specific representation of it: an
+// encoding.ConcatenatedBlock of encoding.ByteAffines for diagonal layers, a ColumnBlockAffine for column layers, and the
+// layer itself for dense ones. Attacks on what's around the layer differ for each, since diagonal layers can be merged
+// into neighboring S-boxes and column layers keep the columns of the state independent.
+func ClassifyAffine(aff encoding.BlockAffine) (AffineStructure, encoding.Block) {
+	m := aff.BlockLinear.Forwards
+
+	// mixes returns true if some output byte depends on an input byte that isn't in the same group of the given size.
+	mixes := func(size int) bool {
+		for out := 0; out < 16; out++ {
+			for in := 0; in < 16; in++ {
+				if out/size == in/size {
+					continue
+				}
+
+				for row := 8 * out; row < 8*out+8; row++ {
+					if m[row][in] != 0 {
+						return true
+					}
+				}
+			}
+		}
+
+		return false
+	}
+
+	if !mixes(1) {
+		out := encoding.ConcatenatedBlock{}
+		for pos := 0; pos < 16; pos++ {
+			out[pos] = encoding.NewByteAffine(subMatrix(m, pos, pos, 1), aff.BlockAdditive[pos])
+		}
+
+		return DiagonalAffine, out
+	} else if !mixes(4) {
+		out := ColumnBlockAffine{Constant: aff.BlockAdditive}
+		for col := 0; col < 4; col++ {
+			out.Forwards[col] = subMatrix(m, 4*col, 4*col, 4)
+			out.Backwards[col], _ = out.Forwards[col].Invert()
+		}
+
+		return ColumnAffine, out
+	}
+
+	return DenseAffine, aff
+}
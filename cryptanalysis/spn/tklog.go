@@ -0,0 +1,110 @@
+package spn
+
+import (
+	"github.com/OpenWhiteBox/primitives/encoding"
+)
+
+// TKlog describes an S-box with the structure Perrin found in π, the S-box shared by Streebog and Kuznyechik. Write
+// GF(2^8) with Polynomial and split its nonzero elements into the 17 multiplicative cosets Generator^i · GF(2^4)*. The
+// S-box maps GF(2^4) onto an affine subspace S(0) + W, and every other coset into an additive coset Offsets[i] + V of a
+// second subspace V. Both W and V have dimension four.
+//
+// "Partitions in the S-Box of Streebog and Kuznyechik" by Léo Perrin, https://eprint.iacr.org/2019/092.pdf
+type TKlog struct {
+	Polynomial uint16
+	Generator  byte
+
+	Subfield []byte   // A basis of W.
+	Subspace []byte   // A basis of V.
+	Offsets  [17]byte // Offsets[0] is S(0) and Offsets[i] the coset of V, reduced by its basis, that coset i maps into.
+}
+
+// Coset returns the index of the multiplicative coset that x is in, or zero if x is in GF(2^4).
+func (t TKlog) Coset(x byte) int {
+	if x == 0 {
+		return 0
+	}
+
+	y := byte(1)
+	for i := 0; i < 255; i++ {
+		if y == x {
+			return i % 17
+		}
+		y = gfMul(y, t.Generator, t.Polynomial)
+	}
+
+	return 0
+}
+
+// gfPow raises a to the power e in GF(2)[x]/poly.
+func gfPow(a byte, e int, poly uint16) byte {
+	out := byte(1)
+	for ; e > 0; e-- {
+		out = gfMul(out, a, poly)
+	}
+
+	return out
+}
+
+// DetectTKlog checks whether s has the TKlog structure in any polynomial representation of GF(2^8). The structure is
+// only about the images of sets, so it survives any affine transformation on the S-box's output--like the one leading
+// S-boxes are recovered up to. An affine transformation on its input only survives if it's an isomorphism between
+// representations, so trailing S-boxes should be tested through their inverse or after Collapse.
+func DetectTKlog(s encoding.Byte) (TKlog, bool) {
+	for _, poly := range irreduciblePolynomials() {
+		if t, ok := detectTKlog(s, poly); ok {
+			return t, true
+		}
+	}
+
+	return TKlog{}, false
+}
+
+// detectTKlog checks for the TKlog structure in the representation of GF(2^8) given by poly.
+func detectTKlog(s encoding.Byte, poly uint16) (TKlog, bool) {
+	// The multiplicative group has order 255 = 3 * 5 * 17; find an element of full order.
+	g := byte(2)
+	for ; g != 0; g++ {
+		if gfPow(g, 85, poly) != 1 && gfPow(g, 51, poly) != 1 && gfPow(g, 15, poly) != 1 {
+			break
+		}
+	}
+
+	spans, first, seen := [17]byteSpan{}, [17]byte{}, [17]bool{}
+	first[0], seen[0] = s.Encode(0), true
+
+	x := byte(1)
+	for k := 0; k < 255; k++ {
+		i, y := k%17, s.Encode(x)
+
+		if !seen[i] {
+			first[i], seen[i] = y, true
+		} else {
+			spans[i].add(y ^ first[i])
+		}
+
+		x = gfMul(x, g, poly)
+	}
+
+	subfield, subspace := spans[0].canonical(), spans[1].canonical()
+	if len(subfield.basis()) != 4 || len(subspace.basis()) != 4 {
+		return TKlog{}, false
+	}
+
+	out := TKlog{
+		Polynomial: poly,
+		Generator:  g,
+		Subfield:   subfield.basis(),
+		Subspace:   subspace.basis(),
+	}
+	out.Offsets[0] = first[0]
+
+	for i := 1; i < 17; i++ {
+		if spans[i].canonical() != subspace {
+			return TKlog{}, false
+		}
+		out.Offsets[i] = subspace.reduce(first[i])
+	}
+
+	return out, true
+}
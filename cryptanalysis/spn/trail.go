@@ -0,0 +1,75 @@
+package spn
+
+import (
+	"crypto/rand"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/matrix"
+)
+
+// spread returns the bytes of the output of a linear layer that depend on any of the bytes set in mask of its input.
+// The layer is given as a 128-by-128 matrix over GF(2).
+func spread(linear matrix.Matrix, mask uint16) (out uint16) {
+	for i, row := range linear {
+		for pos := 0; pos < 16; pos++ {
+			if (mask>>uint(pos))&1 == 1 && row[pos] != 0 {
+				out |= 1 << uint(i/8)
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// SubspaceTrail computes a subspace trail of the given number of rounds through an SPN, where each round applies a
+// layer of 8-bit S-boxes, the given linear layer, and a key. A trail is a sequence of subspaces such that each round maps
+// every coset of one subspace into a coset of the next, whatever the S-boxes and keys are. The subspaces here are
+// spanned by whole bytes of the state, and are given as masks of the bytes that span them: the first is start, and each
+// one after it is spanned by every byte the previous one reaches through the linear layer.
+//
+// The trail is useful for as many rounds as it takes to reach the whole state (0xffff). Against AES, one byte spreads to
+// a column in one round and to the whole state in two.
+//
+// "Subspace Trail Cryptanalysis and its Applications to AES" by Lorenzo Grassi, Christian Rechberger, and Sondre
+// Rønjom, https://eprint.iacr.org/2016/592.pdf
+func SubspaceTrail(linear matrix.Matrix, start uint16, rounds int) []uint16 {
+	trail := []uint16{start}
+
+	for r := 0; r < rounds; r++ {
+		trail = append(trail, spread(linear, trail[r]))
+	}
+
+	return trail
+}
+
+// VerifySubspaceTrail checks a subspace trail against an implementation of one round of the SPN it was computed for. It
+// starts from samples pairs of states in the same coset of the trail's first subspace, and returns false if, after each
+// round, the difference of any pair isn't in the next subspace.
+func VerifySubspaceTrail(round encoding.Block, trail []uint16, samples int, opts ...Option) bool {
+	orc := newOracle(round, newOptions(opts))
+
+	for i := 0; i < samples; i++ {
+		x, y := [16]byte{}, [16]byte{}
+		rand.Read(x[:])
+		rand.Read(y[:])
+
+		for pos := 0; pos < 16; pos++ {
+			if (trail[0]>>uint(pos))&1 == 0 {
+				y[pos] = x[pos]
+			}
+		}
+
+		for _, mask := range trail[1:] {
+			x, y = orc.Encode(x), orc.Encode(y)
+
+			for pos := 0; pos < 16; pos++ {
+				if (mask>>uint(pos))&1 == 0 && x[pos] != y[pos] {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
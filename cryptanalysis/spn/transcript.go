@@ -0,0 +1,211 @@
+package spn
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	transcriptMagic = "SPNT"
+	transcriptChunk = 1 << 16 // Queries per compressed chunk.
+	querySize       = 16 + 16 + 1
+)
+
+// ErrMalformedCompressedTranscript is returned when reading a compressed transcript that wasn't written by
+// Transcript.WriteCompressed, or that's been truncated.
+var ErrMalformedCompressedTranscript = errors.New("malformed compressed transcript")
+
+// chunkIndex locates one compressed chunk of a transcript.
+type chunkIndex struct {
+	Offset  uint64
+	Length  uint32
+	Queries uint32
+}
+
+// WriteCompressed writes every query recorded so far to w, in chunks of up to 65,536 queries that are each compressed
+// with DEFLATE on their own. An index of the chunks follows them, so that ReadCompressedTranscript can fetch any query
+// by decompressing only the chunk it's in. Ciphertexts don't compress, but structured plaintexts like those of
+// PermutationPlaintexts mostly repeat the one before them, so their transcripts shrink by about a third.
+func (t *Transcript) WriteCompressed(w io.Writer) error {
+	queries := t.Queries()
+
+	cw := &countingWriter{w: w}
+	if _, err := cw.Write([]byte(transcriptMagic)); err != nil {
+		return err
+	}
+
+	index := []chunkIndex{}
+	for start := 0; start < len(queries); start += transcriptChunk {
+		chunk := queries[start:min(start+transcriptChunk, len(queries))]
+
+		raw := make([]byte, 0, querySize*len(chunk))
+		for _, q := range chunk {
+			raw = appendQuery(raw, q)
+		}
+
+		buf := &bytes.Buffer{}
+		fw, _ := flate.NewWriter(buf, flate.BestSpeed)
+		fw.Write(raw)
+		fw.Close()
+
+		index = append(index, chunkIndex{uint64(cw.n), uint32(buf.Len()), uint32(len(chunk))})
+		if _, err := cw.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	// The index is followed by its own offset and the number of chunks, so a reader finds it from the end.
+	indexOffset := uint64(cw.n)
+	if err := binary.Write(cw, binary.BigEndian, index); err != nil {
+		return err
+	}
+
+	return binary.Write(cw, binary.BigEndian, struct {
+		Offset uint64
+		Chunks uint32
+	}{indexOffset, uint32(len(index))})
+}
+
+// appendQuery appends the fixed-size encoding of q to buf.
+func appendQuery(buf []byte, q Query) []byte {
+	buf = append(buf, q.Input[:]...)
+	buf = append(buf, q.Output[:]...)
+	if q.Decrypted {
+		return append(buf, 1)
+	}
+
+	return append(buf, 0)
+}
+
+// countingWriter counts the bytes written through it, to find the offset of each chunk.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+
+	return n, err
+}
+
+// CompressedTranscript reads a transcript written by Transcript.WriteCompressed, one chunk at a time. It keeps the
+// last chunk it decompressed, so reading queries in order decompresses each chunk once. It isn't safe for concurrent
+// use.
+type CompressedTranscript struct {
+	r      io.ReaderAt
+	index  []chunkIndex
+	starts []int // The index of the first query of each chunk.
+	n      int
+
+	cached int // The chunk held in chunk, or -1.
+	chunk  []Query
+}
+
+// ReadCompressedTranscript reads the index of the compressed transcript of the given size in r. Queries are read from
+// r as they're asked for.
+func ReadCompressedTranscript(r io.ReaderAt, size int64) (*CompressedTranscript, error) {
+	const trailer = 8 + 4
+
+	magic := make([]byte, len(transcriptMagic))
+	if size < int64(len(transcriptMagic)+trailer) {
+		return nil, ErrMalformedCompressedTranscript
+	} else if _, err := r.ReadAt(magic, 0); err != nil {
+		return nil, err
+	} else if string(magic) != transcriptMagic {
+		return nil, ErrMalformedCompressedTranscript
+	}
+
+	end := make([]byte, trailer)
+	if _, err := r.ReadAt(end, size-trailer); err != nil {
+		return nil, err
+	}
+	indexOffset, chunks := binary.BigEndian.Uint64(end), binary.BigEndian.Uint32(end[8:])
+
+	indexSize := uint64(chunks) * uint64(binary.Size(chunkIndex{}))
+	if indexOffset < uint64(len(transcriptMagic)) || indexOffset+indexSize != uint64(size-trailer) {
+		return nil, ErrMalformedCompressedTranscript
+	}
+
+	ct := &CompressedTranscript{r: r, index: make([]chunkIndex, chunks), cached: -1}
+	if err := binary.Read(io.NewSectionReader(r, int64(indexOffset), int64(indexSize)), binary.BigEndian, ct.index); err != nil {
+		return nil, err
+	}
+
+	for i, c := range ct.index {
+		if c.Offset+uint64(c.Length) > indexOffset || c.Queries == 0 || c.Queries > transcriptChunk {
+			return nil, ErrMalformedCompressedTranscript
+		} else if i < len(ct.index)-1 && c.Queries != transcriptChunk {
+			return nil, ErrMalformedCompressedTranscript
+		}
+
+		ct.starts = append(ct.starts, ct.n)
+		ct.n += int(c.Queries)
+	}
+
+	return ct, nil
+}
+
+// Len returns the number of queries in the transcript.
+func (ct *CompressedTranscript) Len() int {
+	return ct.n
+}
+
+// Query returns the i^th query of the transcript.
+func (ct *CompressedTranscript) Query(i int) (Query, error) {
+	if i < 0 || i >= ct.n {
+		return Query{}, errors.New("query is out of range")
+	}
+
+	// Chunks are full except for the last, so the chunk a query is in is found by division.
+	c := i / transcriptChunk
+	if err := ct.load(c); err != nil {
+		return Query{}, err
+	}
+
+	return ct.chunk[i-ct.starts[c]], nil
+}
+
+// load decompresses chunk c, unless it's the one already held.
+func (ct *CompressedTranscript) load(c int) error {
+	if ct.cached == c {
+		return nil
+	}
+
+	entry := ct.index[c]
+	fr := flate.NewReader(io.NewSectionReader(ct.r, int64(entry.Offset), int64(entry.Length)))
+	defer fr.Close()
+
+	raw := make([]byte, querySize*int(entry.Queries))
+	if _, err := io.ReadFull(fr, raw); err != nil {
+		return ErrMalformedCompressedTranscript
+	}
+
+	ct.chunk = make([]Query, entry.Queries)
+	for k := range ct.chunk {
+		q := raw[querySize*k:]
+		copy(ct.chunk[k].Input[:], q[:16])
+		copy(ct.chunk[k].Output[:], q[16:32])
+		ct.chunk[k].Decrypted = q[32] == 1
+	}
+	ct.cached = c
+
+	return nil
+}
+
+// Transcript decompresses every query into a Transcript, to continue recording into or to replay.
+func (ct *CompressedTranscript) Transcript() (*Transcript, error) {
+	queries := make([]Query, 0, ct.n)
+	for c := range ct.index {
+		if err := ct.load(c); err != nil {
+			return nil, err
+		}
+		queries = append(queries, ct.chunk...)
+	}
+
+	return &Transcript{queries: queries}, nil
+}
@@ -0,0 +1,43 @@
+package spn
+
+import (
+	"fmt"
+)
+
+// Truncated is an oracle that only returns some bytes of the ciphertext. Positions[i] is the position in the full
+// ciphertext of byte i of what Cipher returns. As an encoding.Block, it puts the bytes it's given back in their positions
+// and leaves every other position zero.
+type Truncated struct {
+	Cipher    func(in [16]byte) []byte
+	Positions []int
+}
+
+// Encode implements encoding.Block.
+func (t Truncated) Encode(in [16]byte) (out [16]byte) {
+	for i, b := range t.Cipher(in) {
+		out[t.Positions[i]] = b
+	}
+
+	return
+}
+
+// Decode panics, because truncated ciphertexts can't be decrypted.
+func (t Truncated) Decode(in [16]byte) [16]byte {
+	panic("cryptanalysis/spn.Truncated.Decode isn't implemented!")
+}
+
+// RecoverTruncated recovers the trailing S-boxes at the visible positions of a truncated oracle, exactly like
+// RecoverSBoxesDetailed with WithPositions. The relations for a position only involve its own output byte, so each
+// visible S-box costs the same as it would if the whole ciphertext were returned.
+//
+// Nothing can be learned about the S-boxes at hidden positions from this layer: composing any of them with an arbitrary
+// permutation gives an oracle that returns exactly the same truncated ciphertexts. They hold the identity in what's
+// recovered, and what's left of the cipher is only meaningful at the visible positions. It returns an error if a
+// visible position is outside [0, 16) or given twice.
+func RecoverTruncated(cipher Truncated, generator func() [][16]byte, opts ...Option) (*SBoxRecovery, error) {
+	if err := checkPositions(cipher.Positions); err != nil {
+		return nil, fmt.Errorf("truncated oracle: %w", err)
+	}
+
+	return recoverSBoxes(cipher, generator, newOptions(append(opts, WithPositions(cipher.Positions...))), true)
+}
@@ -0,0 +1,78 @@
+package spn
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+
+	"github.com/OpenWhiteBox/Generic/constructions/spn"
+)
+
+// validationCiphers and validationSets are how many random SPNs ValidateGenerator draws, and how many sets of
+// plaintexts it checks against each.
+const (
+	validationCiphers = 4
+	validationSets    = 16
+)
+
+// ErrNoTrailingSBoxes is returned by ValidateGenerator for a structure that doesn't end in an S-box layer, which the
+// Cube attack can't be run against.
+var ErrNoTrailingSBoxes = errors.New("structure doesn't end in an S-box layer")
+
+// ErrBrokenGenerator is returned by ValidateGenerator when the inputs of the trailing S-box layer don't sum to zero over
+// some set of plaintexts, so the relations the set gives are wrong.
+var ErrBrokenGenerator = errors.New("generator's sums don't vanish before the trailing S-box layer")
+
+// ErrUninformativeGenerator is returned by ValidateGenerator when the sets of plaintexts never give a relation at some
+// position, so its system can't grow.
+var ErrUninformativeGenerator = errors.New("generator gives no relations")
+
+// ValidateGenerator checks a generator against an ideal model of the cipher before any queries are made to the real
+// one: it draws random SPNs with the given structure, where the input of the trailing S-box layer is known, and checks
+// that it sums to zero over every set of plaintexts the generator returns. It returns an error wrapping
+// ErrBrokenGenerator if a sum doesn't vanish, and one wrapping ErrUninformativeGenerator if a position never gets a
+// nonzero relation, as when every plaintext in a set comes in pairs. A generator that passes can still be too weak
+// against a real cipher of a different shape, but one that fails would only waste the attack's queries.
+func ValidateGenerator(generator Generator, structure spn.Structure) error {
+	informed := [16]bool{}
+
+	for i := 0; i < validationCiphers; i++ {
+		constr := spn.NewSPN(rand.Reader, structure)
+		if _, ok := constr[len(constr)-1].(encoding.ConcatenatedBlock); !ok {
+			return ErrNoTrailingSBoxes
+		}
+		inner := encoding.ComposedBlocks(constr[:len(constr)-1])
+		cipher := Encoding{constr}
+
+		for set := 0; set < validationSets; set++ {
+			pts := generator()
+
+			sum, cts := [16]byte{}, make([][16]byte, len(pts))
+			for j, pt := range pts {
+				x := inner.Encode(pt)
+				encoding.XOR(sum[:], sum[:], x[:])
+				cts[j] = cipher.Encode(pt)
+			}
+
+			if sum != [16]byte{} {
+				return fmt.Errorf("%w: a set of %v plaintexts sums to %x", ErrBrokenGenerator, len(pts), sum)
+			}
+
+			for pos, row := range ciphertextRows(cts) {
+				if !row.IsZero() {
+					informed[pos] = true
+				}
+			}
+		}
+	}
+
+	for pos, ok := range informed {
+		if !ok {
+			return fmt.Errorf("%w: none at position %v in %v sets", ErrUninformativeGenerator, pos, validationCiphers*validationSets)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package spn
+
+import (
+	"errors"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/gfmatrix"
+	"github.com/OpenWhiteBox/primitives/matrix"
+	"github.com/OpenWhiteBox/primitives/number"
+)
+
+// ErrNotByteLinear is returned by RecoverTrailingMixing when the trailing layer is affine over GF(2), but not linear over
+// GF(2^8).
+var ErrNotByteLinear = errors.New("trailing layer isn't linear over GF(2^8)")
+
+// bytesOf returns the 16 bytes of a 128-bit row as a vector over GF(2^8).
+func bytesOf(r matrix.Row) gfmatrix.Row {
+	out := gfmatrix.NewRow(16)
+	for i := range out {
+		out[i] = number.ByteFieldElem(r[i])
+	}
+
+	return out
+}
+
+// rowOf is the inverse of bytesOf.
+func rowOf(v gfmatrix.Row) matrix.Row {
+	out := matrix.NewRow(128)
+	for i, x := range v {
+		out[i] = byte(x)
+	}
+
+	return out
+}
+
+// RecoverTrailingMixing removes a trailing layer that mixes all 16 bytes of the state linearly over GF(2^8), like
+// MixColumns does four bytes at a time, from a cipher whose last S-box layer comes right before it. The subspaces found
+// by generator are intersected into the span of each column of the layer, as in RecoverAffine, so it goes through the
+// same generators: trivialSubspaces for AS, and Low Rank Detection under deeper structures.
+//
+// A layer that's linear over GF(2^8) sends the S-box at each position into a line {c · v : c in GF(2^8)} for some
+// vector v, the position's column. Each span is checked to be such a line, in the representation of GF(2^8) that
+// number.ByteFieldElem uses, and ErrNotByteLinear is returned if one isn't; RecoverAffine recovers layers that are only
+// affine over GF(2). A column is only known up to a scalar, which the S-box before it absorbs, so each is scaled to
+// start with a one. The mixing matrix is returned along with the layer as an encoding, and the rest of the cipher.
+func RecoverTrailingMixing(cipher encoding.Block, generator func(encoding.Block) ([]matrix.IncrementalMatrix, error), opts ...Option) (mixing gfmatrix.Matrix, last encoding.BlockAffine, rest encoding.Block, err error) {
+	o := newOptions(opts)
+	o.ledger.begin("mixing layer")
+
+	subspaces, err := generator(newOracle(cipher, o))
+	if err != nil {
+		return nil, last, nil, err
+	}
+
+	mixing = gfmatrix.GenerateEmpty(16, 16)
+	m := matrix.Matrix{}
+
+	for col, span := range columnSpans(subspaces) {
+		if span.Len() != 8 {
+			return nil, last, nil, ErrNotByteLinear
+		}
+
+		v := bytesOf(span.Row(1))
+		for _, x := range v {
+			if !x.IsZero() {
+				v = v.ScalarMul(x.Invert())
+				break
+			}
+		}
+
+		// The images of the bits of the S-box's output are x^k · v, which must all lie in the span.
+		for bit := uint(0); bit < 8; bit++ {
+			image := rowOf(v.ScalarMul(number.ByteFieldElem(1 << bit)))
+			if !span.IsIn(image) {
+				return nil, last, nil, ErrNotByteLinear
+			}
+			m = append(m, image)
+		}
+
+		for row := range mixing {
+			mixing[row][col] = v[row]
+		}
+	}
+
+	if _, ok := m.Transpose().Invert(); !ok {
+		return nil, last, nil, ErrSingularLayer
+	}
+
+	last = encoding.NewBlockAffine(m.Transpose(), [16]byte{})
+	return mixing, last, encoding.ComposedBlocks{cipher, encoding.InverseBlock{last}}, nil
+}
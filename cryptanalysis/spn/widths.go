@@ -0,0 +1,97 @@
+package spn
+
+import (
+	"crypto/rand"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/matrix"
+)
+
+// Widths are the dimensions of an unknown cipher, in bits.
+type Widths struct {
+	// Block is the number of bits of the input the cipher actually uses, in whole bytes. Ciphers with a block smaller
+	// than 128 bits are padded into the 16 bytes of an encoding.Block, and ignore the rest.
+	Block int
+
+	// SBox is the width of the S-boxes of the cipher's first layer: 4 if the state is nibble-oriented and 8 if it's
+	// byte-oriented. It's 0 if the first layer doesn't look like an S-box layer of either width followed by an affine
+	// layer.
+	SBox int
+}
+
+// influences returns true if changing the byte at pos of the input seems to change the cipher's output.
+func influences(cipher encoding.Block, pos int) bool {
+	for i := 0; i < detectionSamples; i++ {
+		x := [16]byte{}
+		rand.Read(x[:])
+
+		y := x
+		y[pos] ^= 0x01 << uint(i%8)
+
+		if cipher.Encode(x) != cipher.Encode(y) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// saturationSpan saturates the bits [offset, offset+width) of the input, with the other bits fixed at random, and
+// returns the dimension of the space spanned by the differences of the ciphertexts. If those bits all go into the same
+// S-box of a first S-box layer and an affine layer follows it, the dimension is at most width. It stops querying as soon
+// as the dimension is more than limit, so the dimension it returns is only exact if it's at most limit.
+func saturationSpan(cipher encoding.Block, offset, width, limit int) int {
+	base := [16]byte{}
+	rand.Read(base[:])
+
+	span := matrix.NewIncrementalMatrix(128)
+	first := cipher.Encode(base)
+
+	for x := 1; x < 1<<uint(width); x++ {
+		pt := base
+		for bit := 0; bit < width; bit++ {
+			if (x>>uint(bit))&1 == 1 {
+				pt[(offset+bit)/8] ^= 1 << uint((offset+bit)%8)
+			}
+		}
+
+		ct := cipher.Encode(pt)
+		encoding.XOR(ct[:], ct[:], first[:])
+		span.Add(matrix.Row(ct[:]))
+
+		if span.Len() > limit {
+			break
+		}
+	}
+
+	return span.Len()
+}
+
+// ProbeWidths infers the block and S-box widths of an unknown cipher. The block width is found from which bytes of the
+// input influence the output. The S-box width is found by saturating aligned groups of input bits: if each group fits
+// in one S-box of a leading S-box layer followed by an affine layer, the ciphertexts it gives lie in an affine subspace
+// of the group's dimension, while a group that only covers part of an S-box spreads out further. To probe the last
+// layer of a cipher instead of the first, probe its inverse with encoding.InverseBlock.
+func ProbeWidths(cipher encoding.Block, opts ...Option) (w Widths) {
+	orc := newOracle(cipher, newOptions(opts))
+
+	for pos := 0; pos < 16; pos++ {
+		if influences(orc, pos) {
+			w.Block = 8 * (pos + 1)
+		}
+	}
+
+	for _, width := range []int{4, 8} {
+		fits := w.Block > 0
+		for offset := 0; offset < w.Block && fits; offset += width {
+			fits = saturationSpan(orc, offset, width, width) <= width
+		}
+
+		if fits {
+			w.SBox = width
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,156 @@
+package spn
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/OpenWhiteBox/primitives/encoding"
+	"github.com/OpenWhiteBox/primitives/gfmatrix"
+)
+
+// swapBytes exchanges the bytes of a and b at the positions set in mask.
+func swapBytes(a, b [16]byte, mask uint16) ([16]byte, [16]byte) {
+	for pos := 0; pos < 16; pos++ {
+		if (mask>>uint(pos))&1 == 1 {
+			a[pos], b[pos] = b[pos], a[pos]
+		}
+	}
+
+	return a, b
+}
+
+// zeroPattern returns a mask with the positions where a and b are equal set.
+func zeroPattern(a, b [16]byte) (mask uint16) {
+	for pos := range a {
+		if a[pos] == b[pos] {
+			mask |= 1 << uint(pos)
+		}
+	}
+
+	return
+}
+
+// Yoyo plays one round of the yoyo game: it encrypts the pair of plaintexts, exchanges the bytes of their ciphertexts
+// at the positions set in mask, and decrypts the result. Exchanging bytes keeps the difference of the ciphertexts the
+// same, and through an S-box layer and an affine layer it keeps the difference of the internal states the same too.
+//
+// Against SAS, the new pair of plaintexts is zero in exactly the same bytes of its difference as the original pair,
+// because the difference of the inputs of the inner affine layer doesn't change and the leading S-box layer keeps
+// which bytes of it are zero.
+//
+// "Yoyo Tricks with AES" by Sondre Rønjom, Navid Ghaedi Bardeh, and Tor Helleseth,
+// https://eprint.iacr.org/2017/983.pdf
+func Yoyo(cipher encoding.Block, p0, p1 [16]byte, mask uint16) ([16]byte, [16]byte) {
+	c0, c1 := swapBytes(cipher.Encode(p0), cipher.Encode(p1), mask)
+	return cipher.Decode(c0), cipher.Decode(c1)
+}
+
+// yoyoMask returns a random mask that swaps some but not all bytes. Swapping none or all of them gives back the same
+// pair.
+func yoyoMask() uint16 {
+	for {
+		buf := [2]byte{}
+		rand.Read(buf[:])
+
+		if mask := uint16(buf[0]) | uint16(buf[1])<<8; mask != 0 && mask != 0xffff {
+			return mask
+		}
+	}
+}
+
+// YoyoDistinguisher plays trials rounds of the yoyo game with pairs of plaintexts that differ in one byte, and returns
+// true if every round keeps the pattern of zero bytes in their difference, as it always does for SAS and almost never
+// for a random permutation. The cipher's Encode and Decode must both work.
+func YoyoDistinguisher(cipher encoding.Block, trials int, opts ...Option) bool {
+	orc := newOracle(cipher, newOptions(opts))
+
+	for i := 0; i < trials; i++ {
+		p0 := [16]byte{}
+		rand.Read(p0[:])
+		p1 := p0
+		p1[i%16] ^= 1 + byte(i/16)%255
+
+		if q0, q1 := Yoyo(orc, p0, p1, yoyoMask()); zeroPattern(q0, q1) != zeroPattern(p0, p1) {
+			return false
+		}
+	}
+
+	return true
+}
+
+const (
+	yoyoStarts = 32  // Pairs of plaintexts RecoverSBoxesByYoyo starts from at each position, at most.
+	yoyoRounds = 256 // Rounds of the yoyo game it plays with each of them.
+)
+
+// RecoverSBoxesByYoyo removes the leading S-box layer of a cipher with the structure SAS, using the yoyo game instead
+// of chosen plaintexts for a Cube attack. It needs both the cipher's Encode and Decode, and about 3,500 queries for
+// each position, which is more than DecomposeSPN needs against SAS, but every query is one of an adaptively chosen
+// pair.
+//
+// For each position, it starts from a pair of plaintexts that differ only there. Every pair the yoyo game turns it into
+// differs only there too, and the outputs of the leading S-box at that position differ by the same amount as they did
+// for the original pair. Two of these pairs, (a, b) and (c, d), give the relation S(a) + S(b) + S(c) + S(d) = 0, which
+// is the same kind of relation RecoverSBoxes finds. Only 128 pairs of inputs have a given difference of outputs, so it
+// moves on to a new starting pair with another difference every yoyoRounds rounds. The S-boxes are recovered up to an
+// affine transformation of their outputs, which rest absorbs. The cipher is rest after first.
+func RecoverSBoxesByYoyo(cipher encoding.Block, opts ...Option) (first encoding.ConcatenatedBlock, rest encoding.Block, err error) {
+	o := newOptions(opts)
+	orc := newOracle(cipher, o)
+	ims := NewIncrementalMatrices(16, 256)
+	failed := &RecoveryError{}
+
+	for pos := 0; pos < 16; pos++ {
+		for start := 0; start < yoyoStarts && ims.Rank(pos) < o.sufficientRank(); start++ {
+			p0 := [16]byte{}
+			rand.Read(p0[:])
+			p1 := p0
+			p1[pos] ^= 1 + byte(start)
+
+			c0, c1 := orc.Encode(p0), orc.Encode(p1)
+
+			for round := 0; round < yoyoRounds && ims.Rank(pos) < o.sufficientRank(); round++ {
+				d0, d1 := swapBytes(c0, c1, yoyoMask())
+				q0, q1 := orc.Decode(d0), orc.Decode(d1)
+
+				if zeroPattern(q0, q1) != zeroPattern(p0, p1) {
+					return first, nil, fmt.Errorf("yoyo game broke the zero pattern at position %v, so the cipher isn't SAS", pos)
+				}
+
+				row := gfmatrix.NewRow(256)
+				for _, x := range []byte{p0[pos], p1[pos], q0[pos], q1[pos]} {
+					row[x] = row[x].Add(0x01)
+				}
+
+				if ims[pos].Add(row) {
+					o.metrics.Rank(pos, ims.Rank(pos))
+					o.emit(RankIncreased{pos, ims.Rank(pos)})
+				}
+				o.metrics.Batch()
+			}
+		}
+
+		first[pos] = encoding.IdentityByte{}
+		diag := diagnose(ims.Rank(pos), 0, o.sufficientRank())
+
+		if ims.Rank(pos) < o.sufficientRank() {
+			failed.add(pos, InsufficientRank, ims[pos], diag, 0)
+			continue
+		}
+
+		v, ok := findPermutation(ims[pos].Matrix().NullSpace(), o.search, o.workers)
+		if !ok {
+			failed.add(pos, NoPermutation, ims[pos], diag, 0)
+			continue
+		}
+
+		first[pos] = newSBox(v, false)
+	}
+
+	rest = encoding.ComposedBlocks{encoding.InverseBlock{first}, cipher}
+	if len(failed.Positions) > 0 {
+		return first, rest, failed
+	}
+
+	return first, rest, nil
+}
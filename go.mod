@@ -0,0 +1,3 @@
+module github.com/OpenWhiteBox/Generic
+
+go 1.21
@@ -0,0 +1,39 @@
+// Package aes holds the parts of AES that constructions and attacks in this repository build on: multiplication in its
+// representation of GF(2^8), and its S-box.
+package aes
+
+// Mul multiplies a and b in AES's representation of GF(2^8), modulo x^8 + x^4 + x^3 + x + 1.
+func Mul(a, b byte) (out byte) {
+	for ; b > 0; b >>= 1 {
+		if b&1 == 1 {
+			out ^= a
+		}
+
+		if a&0x80 != 0 {
+			a = a<<1 ^ 0x1b
+		} else {
+			a <<= 1
+		}
+	}
+
+	return
+}
+
+// SBox returns the AES S-box: inversion in GF(2^8) followed by AES's affine transformation.
+func SBox() (out [256]byte) {
+	rotl := func(x byte, n uint) byte { return x<<n | x>>(8-n) }
+
+	for x := 0; x < 256; x++ {
+		inv := byte(0)
+		for y := 1; y < 256 && x != 0; y++ {
+			if Mul(byte(x), byte(y)) == 1 {
+				inv = byte(y)
+				break
+			}
+		}
+
+		out[x] = inv ^ rotl(inv, 1) ^ rotl(inv, 2) ^ rotl(inv, 3) ^ rotl(inv, 4) ^ 0x63
+	}
+
+	return
+}